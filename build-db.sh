@@ -15,19 +15,15 @@ echo -e "${BLUE}========================================${NC}"
 echo -e "${BLUE}SQLite3 Database Initialization${NC}"
 echo -e "${BLUE}========================================${NC}"
 
-# Check if sqlite3 is installed
-if ! command -v sqlite3 &> /dev/null; then
-    echo -e "${RED}Error: sqlite3 is not installed${NC}"
-    echo "Please install it first:"
-    echo "  Ubuntu/Debian: sudo apt-get install sqlite3"
-    echo "  macOS: brew install sqlite3"
+# Check if go is installed (migrations are applied by the server binary)
+if ! command -v go &> /dev/null; then
+    echo -e "${RED}Error: go is not installed${NC}"
     exit 1
 fi
 
 # Database path
 DB_DIR="./server/database"
 DB_PATH="${DB_DIR}/secure_notes.db"
-MIGRATIONS_DIR="./server/migrations"
 
 # Create database directory if not exists
 mkdir -p "$DB_DIR"
@@ -40,23 +36,17 @@ fi
 
 echo -e "${GREEN}Creating new database at: ${DB_PATH}${NC}"
 
-# Run schema migration
-echo -e "${BLUE}Running schema migration (001_init_schema.sql)...${NC}"
-if [ -f "${MIGRATIONS_DIR}/001_init_schema.sql" ]; then
-    sqlite3 "$DB_PATH" < "${MIGRATIONS_DIR}/001_init_schema.sql"
-    echo -e "${GREEN}✓ Schema created successfully${NC}"
-else
-    echo -e "${RED}Error: ${MIGRATIONS_DIR}/001_init_schema.sql not found${NC}"
-    exit 1
-fi
+# Run all migrations including seed data (development only)
+# To upgrade an existing database without wiping it, run instead:
+#   cd server && DB_PATH=database/secure_notes.db go run ./cmd migrate up
+echo -e "${BLUE}Running migrations (schema + seed data)...${NC}"
+(cd server && DB_PATH="database/secure_notes.db" go run ./cmd migrate up -seed)
+echo -e "${GREEN}✓ Migrations applied successfully${NC}"
+(cd server && DB_PATH="database/secure_notes.db" go run ./cmd migrate status)
 
-# Run seed data (optional)
-echo -e "${BLUE}Running seed data (002_seed_data.sql)...${NC}"
-if [ -f "${MIGRATIONS_DIR}/002_seed_data.sql" ]; then
-    sqlite3 "$DB_PATH" < "${MIGRATIONS_DIR}/002_seed_data.sql"
-    echo -e "${GREEN}✓ Seed data inserted successfully${NC}"
-else
-    echo -e "${BLUE}⚠ No seed data file found (skipped)${NC}"
+if ! command -v sqlite3 &> /dev/null; then
+    echo -e "${BLUE}⚠ sqlite3 not installed, skipping verification${NC}"
+    exit 0
 fi
 
 # Verify database
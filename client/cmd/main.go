@@ -15,6 +15,8 @@ func main() {
 		if !loggedIn {
 			fmt.Println("1. Register")
 			fmt.Println("2. Login")
+			fmt.Println("3. Recover Account")
+			fmt.Println("9. Open Share URL")
 			fmt.Println("0. Exit")
 			fmt.Print("Choose option: ")
 
@@ -25,10 +27,17 @@ func main() {
 			case 1:
 				clientinternal.Register()
 				clientinternal.LogInfo("Register selected")
+				loggedIn = clientinternal.IsLoggedIn()
 			case 2:
 				clientinternal.Login()
 				clientinternal.LogInfo("Login selected")
 				loggedIn = clientinternal.IsLoggedIn()
+			case 3:
+				clientinternal.RecoverAccount()
+				clientinternal.LogInfo("Recover account selected")
+			case 9:
+				clientinternal.OpenShareURL()
+				clientinternal.LogInfo("Open share URL selected")
 			case 0:
 				os.Exit(0)
 			default:
@@ -40,6 +49,19 @@ func main() {
 			fmt.Println("5. Share Note")
 			fmt.Println("6. Create Temp URL")
 			fmt.Println("7. Logout")
+			fmt.Println("8. Download Note")
+			fmt.Println("9. Open Share URL")
+			fmt.Println("10. Edit Note")
+			fmt.Println("11. Show History")
+			fmt.Println("12. Search Notes")
+			fmt.Println("13. Folders")
+			fmt.Println("14. Organize Note")
+			fmt.Println("15. Delete Note")
+			fmt.Println("16. Trash")
+			fmt.Println("17. Storage Usage")
+			fmt.Println("18. Two-Factor Auth")
+			fmt.Println("19. Change Password")
+			fmt.Println("20. Recovery Key")
 			fmt.Println("0. Exit")
 			fmt.Print("Choose option: ")
 
@@ -63,6 +85,45 @@ func main() {
 				clientinternal.Logout()
 				clientinternal.LogInfo("Logout selected")
 				loggedIn = clientinternal.IsLoggedIn()
+			case 8:
+				clientinternal.DownloadNote()
+				clientinternal.LogInfo("Download note selected")
+			case 9:
+				clientinternal.OpenShareURL()
+				clientinternal.LogInfo("Open share URL selected")
+			case 10:
+				clientinternal.EditNote()
+				clientinternal.LogInfo("Edit note selected")
+			case 11:
+				clientinternal.ShowHistory()
+				clientinternal.LogInfo("Show history selected")
+			case 12:
+				clientinternal.SearchNotes()
+				clientinternal.LogInfo("Search notes selected")
+			case 13:
+				clientinternal.ManageFolders()
+				clientinternal.LogInfo("Folders selected")
+			case 14:
+				clientinternal.OrganizeNote()
+				clientinternal.LogInfo("Organize note selected")
+			case 15:
+				clientinternal.DeleteNote()
+				clientinternal.LogInfo("Delete note selected")
+			case 16:
+				clientinternal.ShowTrash()
+				clientinternal.LogInfo("Trash selected")
+			case 17:
+				clientinternal.ShowUsage()
+				clientinternal.LogInfo("Storage usage selected")
+			case 18:
+				clientinternal.ManageTwoFactor()
+				clientinternal.LogInfo("Two-factor auth selected")
+			case 19:
+				clientinternal.ChangePassword()
+				clientinternal.LogInfo("Change password selected")
+			case 20:
+				clientinternal.ManageRecoveryKey()
+				clientinternal.LogInfo("Recovery key selected")
 			case 0:
 				os.Exit(0)
 			default:
@@ -1,3 +1,7 @@
 module secure-notes-client
 
-go 1.21
+go 1.23.0
+
+require golang.org/x/crypto v0.40.0
+
+require golang.org/x/sys v0.35.0 // indirect
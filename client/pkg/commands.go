@@ -3,14 +3,18 @@ package serverpkg
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 // Register prompts and calls server register endpoint
@@ -28,19 +32,64 @@ func Register() {
 		fmt.Println("invalid username:", err)
 		return
 	}
-	if err := ValidateInput(password); err != nil {
+	if err := ValidatePassword(password); err != nil {
 		fmt.Println("invalid password:", err)
 		return
 	}
 
-	payload := map[string]string{"username": username, "password": password}
-	b, status, err := postJSON("/api/register", payload, false)
+	// Only the login key reaches the server, never the password (see keys.go)
+	salt, err := GenerateSalt()
+	if err != nil {
+		LogError("generate kdf salt failed", err)
+		return
+	}
+	loginKey, kMaster, err := deriveAccountKeys(password, salt, authVersionLoginKey)
 	if err != nil {
+		LogError("derive account keys failed", err)
+		return
+	}
+	defer ZeroizeKey(loginKey)
+	loginKeyB64 := base64.StdEncoding.EncodeToString(loginKey)
+	b, status, err := postJSON("/api/register", map[string]string{
+		"username":  username,
+		"login_key": loginKeyB64,
+		"kdf_salt":  base64.StdEncoding.EncodeToString(salt),
+	}, false)
+	if err != nil {
+		ZeroizeKey(kMaster)
 		LogError("register request failed", err)
 		return
 	}
 	LogInfo(fmt.Sprintf("register status: %d", status))
 	fmt.Println(string(b))
+	if status != http.StatusCreated {
+		ZeroizeKey(kMaster)
+		return
+	}
+	rememberLoginKey(username)
+
+	fmt.Print("Create a recovery key to reset a forgotten password without losing notes? [Y/n]: ")
+	answer, _ := reader.ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(answer)); a == "n" || a == "no" {
+		ZeroizeKey(kMaster)
+		return
+	}
+	// The recovery key is created by a logged in session: log in first
+	b, status, err = postJSON("/api/login", map[string]string{
+		"username":  username,
+		"login_key": loginKeyB64,
+	}, false)
+	if err != nil || status != http.StatusOK || !saveLogin(username, kMaster, authVersionLoginKey, b) {
+		ZeroizeKey(kMaster)
+		LogError("login after register failed", err)
+		fmt.Println("could not log in; create the recovery key later from the Recovery Key menu")
+		return
+	}
+	fmt.Println("Logged in as", username)
+	if err := createRecoveryKey(password); err != nil {
+		LogError("create recovery key failed", err)
+		fmt.Println("error:", err)
+	}
 }
 
 // Login prompts and calls server login endpoint and stores access token
@@ -63,23 +112,86 @@ func Login() {
 		return
 	}
 
-	payload := map[string]string{"username": username, "password": password}
+	salt, authVersion, err := fetchSalt(username)
+	if err != nil {
+		LogError("login request failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	loginKey, kMaster, err := deriveAccountKeys(password, salt, authVersion)
+	if err != nil {
+		LogError("derive account keys failed", err)
+		return
+	}
+	defer ZeroizeKey(loginKey)
+	payload := map[string]string{"username": username}
+	if authVersion == authVersionPassword {
+		payload["password"] = password
+	} else {
+		payload["login_key"] = base64.StdEncoding.EncodeToString(loginKey)
+	}
 	b, status, err := postJSON("/api/login", payload, false)
 	if err != nil {
+		ZeroizeKey(kMaster)
 		LogError("login request failed", err)
 		return
 	}
+	// Accounts with 2FA get a challenge first
+	if status == 200 {
+		if b, status, err = completeMFA(reader, payload, b); err != nil {
+			ZeroizeKey(kMaster)
+			LogError("two-factor login failed", err)
+			return
+		}
+	}
 	if status != 200 && status != 201 {
+		ZeroizeKey(kMaster)
 		LogInfo(fmt.Sprintf("login failed: %d", status))
+		if msg, ok := lockoutMessage(status, b); ok {
+			fmt.Println("login failed:", msg)
+			return
+		}
 		fmt.Println(string(b))
 		return
 	}
+	if !saveLogin(username, kMaster, authVersion, b) {
+		ZeroizeKey(kMaster)
+		return
+	}
+	fmt.Println(string(b))
+
+	if authVersion == authVersionPassword {
+		upgradeLogin(password)
+	}
+}
+
+// upgradeLogin switches an account that still logs in with its password to
+// a login key: a password change to the same password, re-wrapping the
+// keyring under the new K_Master. It is tried again at the next login if it
+// fails.
+func upgradeLogin(password string) {
+	fmt.Println("Switching the account to a login key; the password will no longer be sent to the server")
+	b, status, err := changePassword(password, password)
+	if err == nil && status == http.StatusOK {
+		LogInfo("account switched to a login key")
+		fmt.Println("Done")
+		return
+	}
+	LogError(fmt.Sprintf("switch to a login key failed: %d %s", status, string(b)), err)
+	fmt.Println("warning: could not switch to a login key, it will be retried at the next login")
+}
+
+// saveLogin stores the tokens of a successful login of username from the
+// response b, keeps kMaster in RAM and publishes the DH key on the first
+// login of username on this device.
+// It returns false if the response could not be used.
+func saveLogin(username string, kMaster []byte, authVersion int, b []byte) bool {
 	var resp map[string]any
 	if err := json.Unmarshal(b, &resp); err != nil {
 		LogError("invalid login response", err)
-		return
+		return false
 	}
-	var tokens Tokens
+	tokens := Tokens{Username: username, AuthVersion: authVersion}
 	if raw, ok := resp["access_token"]; ok {
 		if tok, ok := raw.(string); ok && tok != "" {
 			tokens.AccessToken = tok
@@ -90,17 +202,38 @@ func Login() {
 			tokens.RefreshToken = tok
 		}
 	}
+	if raw, ok := resp["kdf_salt"]; ok {
+		if salt, ok := raw.(string); ok && salt != "" {
+			tokens.KdfSalt = salt
+		}
+	}
+	if raw, ok := resp["search_key_enc"]; ok {
+		if enc, ok := raw.(string); ok {
+			tokens.SearchKeyEnc = enc
+		}
+	}
 	if tokens.AccessToken != "" || tokens.RefreshToken != "" {
 		if err := SaveTokens(tokens); err != nil {
 			LogError("failed to save tokens", err)
-			return
+			return false
 		}
 		LogInfo("tokens saved")
+		// K_Master stays in RAM only
+		useMasterKey(kMaster)
+		if authVersion == authVersionLoginKey {
+			rememberLoginKey(username)
+		}
+		// First login of this account on this device: generate DH keypair and publish public key
+		if err := EnsureDHKeyPair(username); err != nil {
+			LogError("failed to publish DH key", err)
+			fmt.Println("warning: could not publish DH public key:", err)
+		}
 	}
-	fmt.Println(string(b))
+	return true
 }
 
-// UploadNote uploads a file to /api/notes using multipart form
+// UploadNote encrypts a file client-side and uploads it in chunks via
+// /api/uploads. An interrupted upload of the same file can be resumed.
 func UploadNote() {
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Print("File path: ")
@@ -110,84 +243,953 @@ func UploadNote() {
 		LogInfo("no file provided")
 		return
 	}
-	// Basic file validation: existence and size limit (50 MB)
 	fi, err := os.Stat(path)
 	if err != nil {
 		LogError("stat file", err)
+		fmt.Println("error:", err)
 		return
 	}
-	const maxSize = 50 * 1024 * 1024 // 50 MB
-	if fi.Size() > maxSize {
-		LogInfo("file too large (max 50 MB)")
+	if fi.IsDir() {
+		fmt.Println("error: not a regular file")
 		return
 	}
 
-	file, err := os.Open(path)
+	// A previous attempt left ciphertext behind: resume unless the file changed
+	st := loadUploadState(path)
+	if st != nil {
+		if st.matches(fi) {
+			fmt.Print("Resume interrupted upload of this file? [Y/n]: ")
+			answer, _ := reader.ReadString('\n')
+			if a := strings.ToLower(strings.TrimSpace(answer)); a == "n" || a == "no" {
+				st.abort()
+				st = nil
+			}
+		} else {
+			st.abort()
+			st = nil
+		}
+	}
+
+	if st == nil {
+		// optional title (defaults to file name)
+		fmt.Print("Title (optional): ")
+		title, _ := reader.ReadString('\n')
+		title = strings.TrimSpace(title)
+		if title == "" {
+			title = filepath.Base(path)
+		}
+		fmt.Print("Tags / keywords (comma separated, optional): ")
+		keywords, _ := reader.ReadString('\n')
+		keywords = strings.TrimSpace(keywords)
+
+		kMaster, err := getMasterKey()
+		if err != nil {
+			LogError("master key unavailable", err)
+			fmt.Println("error:", err)
+			return
+		}
+		st, err = prepareUpload(kMaster, title, keywords, path, fi)
+		if err != nil {
+			LogError("encrypt note", err)
+			fmt.Println("error:", err)
+			return
+		}
+	}
+
+	respBody, err := runUpload(st)
 	if err != nil {
-		LogError("open file", err)
+		LogError("upload failed", err)
+		fmt.Println("error:", err)
+		fmt.Println("Upload interrupted; choose Upload Note with the same file to resume.")
+		return
+	}
+	fmt.Println(string(respBody))
+}
+
+// DownloadNote fetches a note, unwraps its key and decrypts it to a file.
+// The ciphertext is streamed to "<output>.part" so an interrupted download
+// continues where it stopped.
+func DownloadNote() {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Note ID: ")
+	noteID, _ := reader.ReadString('\n')
+	noteID = strings.TrimSpace(noteID)
+	if noteID == "" {
+		LogInfo("note ID required")
 		return
 	}
-	defer file.Close()
 
-	var buf bytes.Buffer
-	mw := multipart.NewWriter(&buf)
-	fw, err := mw.CreateFormFile("file", filepath.Base(path))
+	n, err := fetchNoteMeta(noteID)
+	if err != nil {
+		LogError("download failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	kNote, err := noteKey(n)
 	if err != nil {
-		LogError("create form file", err)
+		LogError("unwrap note key", err)
+		fmt.Println("error: unwrap note key:", err)
 		return
 	}
-	if _, err := io.Copy(fw, file); err != nil {
-		LogError("copy file", err)
+	defer ZeroizeKey(kNote)
+	title := noteTitle(n, kNote)
+
+	defaultPath := filepath.Base(title)
+	if defaultPath == "" || defaultPath == "." || defaultPath == "/" {
+		defaultPath = noteID
+	}
+	fmt.Printf("Output path [%s]: ", defaultPath)
+	out, _ := reader.ReadString('\n')
+	out = strings.TrimSpace(out)
+	if out == "" {
+		out = defaultPath
+	}
+
+	partPath := out + ".part"
+	if err := downloadContent(noteContentURL(noteID), partPath); err != nil {
+		LogError("download failed", err)
+		fmt.Println("error:", err)
+		fmt.Println("Download interrupted; choose Download Note with the same output path to resume.")
+		return
+	}
+	size, err := decryptToFile(kNote, n.IVMeta, partPath, out)
+	if err != nil {
+		LogError("decrypt note", err)
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Printf("Note %q decrypted to %s (%d bytes)\n", title, out, size)
+}
+
+// EditNote encrypts new content for an existing note under its current
+// K_Note and uploads it with PUT /api/notes/:id. The server keeps the
+// previous ciphertext as a version (see ShowHistory). The update only
+// applies to the version that was read; if another device changed the note
+// meanwhile, the user can keep both copies, overwrite, or cancel.
+func EditNote() {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Note ID: ")
+	noteID, _ := reader.ReadString('\n')
+	noteID = strings.TrimSpace(noteID)
+	if noteID == "" {
+		LogInfo("note ID required")
 		return
 	}
-	// optional title
-	fmt.Print("Title (optional): ")
+
+	n, err := fetchNoteMeta(noteID)
+	if err != nil {
+		LogError("fetch note", err)
+		fmt.Println("error:", err)
+		return
+	}
+	if n.Shared {
+		fmt.Println("error: only the owner can edit a note")
+		return
+	}
+	kNote, err := noteKey(n)
+	if err != nil {
+		LogError("unwrap note key", err)
+		fmt.Println("error: unwrap note key:", err)
+		return
+	}
+	defer ZeroizeKey(kNote)
+	current := noteTitle(n, kNote)
+	currentKeywords := noteKeywords(n.IVMeta, kNote)
+
+	fmt.Print("New content file path: ")
+	path, _ := reader.ReadString('\n')
+	path = strings.TrimSpace(path)
+	if path == "" {
+		LogInfo("no file provided")
+		return
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		LogError("open file", err)
+		fmt.Println("error:", err)
+		return
+	}
+	defer src.Close()
+
+	fmt.Printf("Title [%s]: ", current)
 	title, _ := reader.ReadString('\n')
 	title = strings.TrimSpace(title)
-	if title != "" {
-		_ = mw.WriteField("title", title)
+	if title == "" {
+		title = current
+	}
+	// The search index is always rebuilt from the title and the keywords, so
+	// a renamed note is found by its new title only
+	fmt.Printf("Tags / keywords [%s] (comma separated; \"-\" = none): ", currentKeywords)
+	keywords, _ := reader.ReadString('\n')
+	keywords = strings.TrimSpace(keywords)
+	switch keywords {
+	case "":
+		keywords = currentKeywords
+	case "-":
+		keywords = ""
 	}
-	mw.Close()
 
-	respBody, status, err := doRequest(http.MethodPost, apiURL()+"/api/notes", &buf, mw.FormDataContentType(), true)
+	kMaster, err := getMasterKey()
+	if err != nil {
+		LogError("master key unavailable", err)
+		fmt.Println("error:", err)
+		return
+	}
+	var contentEnc bytes.Buffer
+	payload, err := encryptNoteWithKey(kMaster, kNote, title, keywords, &contentEnc, src)
+	if err != nil {
+		LogError("encrypt note", err)
+		fmt.Println("error:", err)
+		return
+	}
+
+	req := updateNoteRequest{
+		notePayload: *payload,
+		ContentEnc:  base64.StdEncoding.EncodeToString(contentEnc.Bytes()),
+	}
+	noteURL := apiURL() + "/api/notes/" + url.PathEscape(noteID)
+	b, status, err := conditionalNoteRequest(http.MethodPut, noteURL, n.Version, req)
+	var conflict *noteConflictError
+	if errors.As(err, &conflict) {
+		fmt.Printf("Note was changed on another device since version %d (now version %d).\n", n.Version, conflict.Current)
+		fmt.Print("[k]eep both copies, [o]verwrite, or [c]ancel? ")
+		choice, _ := reader.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(choice)) {
+		case "k", "keep":
+			keepConflictedCopy(kMaster, title, keywords, path)
+			return
+		case "o", "overwrite":
+			b, status, err = conditionalNoteRequest(http.MethodPut, noteURL, conflict.Current, req)
+		default:
+			LogInfo("edit cancelled")
+			return
+		}
+	}
+	if err != nil {
+		LogError("edit note failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	LogInfo(fmt.Sprintf("edit note status: %d", status))
+	fmt.Println(string(b))
+}
+
+// keepConflictedCopy uploads the edited file as a separate note (under a
+// fresh K_Note) so neither the local edit nor the server version is lost.
+func keepConflictedCopy(kMaster []byte, title, keywords string, path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		LogError("stat file", err)
+		fmt.Println("error:", err)
+		return
+	}
+	title += " (conflicted copy)"
+	st, err := prepareUpload(kMaster, title, keywords, path, fi)
+	if err != nil {
+		LogError("encrypt note", err)
+		fmt.Println("error:", err)
+		return
+	}
+	b, err := runUpload(st)
 	if err != nil {
 		LogError("upload failed", err)
+		fmt.Println("error:", err)
+		fmt.Println("Upload interrupted; choose Upload Note with the same file to resume.")
 		return
 	}
-	LogInfo(fmt.Sprintf("upload status: %d", status))
-	fmt.Println(string(respBody))
+	fmt.Println("Your edit was saved as a new note:")
+	fmt.Println(string(b))
+}
+
+// ShowHistory lists the previous versions of a note with their decrypted
+// titles, decrypts a chosen version to a file and optionally restores it.
+func ShowHistory() {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Note ID: ")
+	noteID, _ := reader.ReadString('\n')
+	noteID = strings.TrimSpace(noteID)
+	if noteID == "" {
+		LogInfo("note ID required")
+		return
+	}
+
+	h, err := fetchNoteHistory(noteID)
+	if err != nil {
+		LogError("list versions failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Printf("Current version: %d\n", h.CurrentVersion)
+	if len(h.Versions) == 0 {
+		fmt.Println("No previous versions")
+		return
+	}
+	for _, v := range h.Versions {
+		title := ""
+		if kNote, err := noteKey(v.asNote()); err == nil {
+			title = noteTitle(v.asNote(), kNote)
+			ZeroizeKey(kNote)
+		}
+		fmt.Printf("  v%d  %s  %d bytes  %q\n", v.Version, v.CreatedAt, v.Size, title)
+	}
+
+	fmt.Print("Version to open (empty = done): ")
+	answer, _ := reader.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(answer, "v"))
+	if err != nil || version < 1 {
+		fmt.Println("invalid version:", answer)
+		return
+	}
+
+	v, err := fetchNoteVersion(noteID, version)
+	if err != nil {
+		LogError("get version failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	kNote, err := noteKey(v.asNote())
+	if err != nil {
+		LogError("unwrap note key", err)
+		fmt.Println("error: unwrap note key:", err)
+		return
+	}
+	defer ZeroizeKey(kNote)
+	title := noteTitle(v.asNote(), kNote)
+
+	defaultPath := filepath.Base(title)
+	if defaultPath == "" || defaultPath == "." || defaultPath == "/" {
+		defaultPath = noteID
+	}
+	defaultPath = fmt.Sprintf("%s.v%d", defaultPath, version)
+	fmt.Printf("Output path [%s]: ", defaultPath)
+	out, _ := reader.ReadString('\n')
+	out = strings.TrimSpace(out)
+	if out == "" {
+		out = defaultPath
+	}
+
+	partPath := out + ".part"
+	if err := downloadContent(versionContentURL(noteID, version), partPath); err != nil {
+		LogError("download failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	size, err := decryptToFile(kNote, v.IVMeta, partPath, out)
+	if err != nil {
+		LogError("decrypt note", err)
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Printf("Version %d of %q decrypted to %s (%d bytes)\n", version, title, out, size)
+
+	if version == h.CurrentVersion {
+		return
+	}
+	fmt.Print("Restore this version? [y/N]: ")
+	confirm, _ := reader.ReadString('\n')
+	if !strings.EqualFold(strings.TrimSpace(confirm), "y") {
+		return
+	}
+	restoreURL := fmt.Sprintf("%s/api/notes/%s/versions/%d/restore", apiURL(), url.PathEscape(noteID), version)
+	b, status, err := conditionalNoteRequest(http.MethodPost, restoreURL, h.CurrentVersion, nil)
+	var conflict *noteConflictError
+	if errors.As(err, &conflict) {
+		fmt.Printf("Note was changed on another device (now version %d); open the history again to restore.\n", conflict.Current)
+		return
+	}
+	if err != nil {
+		LogError("restore failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	LogInfo(fmt.Sprintf("restore status: %d", status))
+	fmt.Println(string(b))
 }
 
-// ListNotes retrieves notes for the authenticated user
+// SearchNotes looks notes up by keywords through the blind index and shows
+// their decrypted titles.
+func SearchNotes() {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Search keywords: ")
+	query, _ := reader.ReadString('\n')
+
+	kMaster, err := getMasterKey()
+	if err != nil {
+		LogError("master key unavailable", err)
+		fmt.Println("error:", err)
+		return
+	}
+	tokens, err := searchTokens(kMaster, query)
+	if err != nil {
+		LogError("search tokens", err)
+		fmt.Println("error:", err)
+		return
+	}
+	if len(tokens) == 0 {
+		fmt.Println("no keywords given")
+		return
+	}
+
+	notes, err := searchNotes(tokens)
+	if err != nil {
+		LogError("search failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	if len(notes) == 0 {
+		fmt.Println("No matching notes")
+		return
+	}
+	for _, entry := range notes {
+		title := ""
+		if n, err := fetchNoteMeta(entry.ID); err == nil {
+			if kNote, err := noteKey(n); err == nil {
+				title = noteTitle(n, kNote)
+				ZeroizeKey(kNote)
+			}
+		}
+		fmt.Printf("  %s  %s  %q\n", entry.ID, entry.CreatedAt, title)
+	}
+}
+
+// notesPerPage is the page size ListNotes and ManageFolders ask for.
+const notesPerPage = 20
+
+// ListNotes shows the notes the user owns or that are shared with them as a
+// paged table with decrypted titles.
 func ListNotes() {
-	b, status, err := doRequest(http.MethodGet, apiURL()+"/api/notes", nil, "", true)
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Sort by [created/updated/size] (default created): ")
+	sortBy, _ := reader.ReadString('\n')
+	fmt.Print("Show [all/owned/shared] (default all): ")
+	scope, _ := reader.ReadString('\n')
+
+	q := url.Values{}
+	q.Set("limit", strconv.Itoa(notesPerPage))
+	if v := strings.ToLower(strings.TrimSpace(sortBy)); v != "" {
+		q.Set("sort", v)
+	}
+	q.Set("scope", "all")
+	if v := strings.ToLower(strings.TrimSpace(scope)); v != "" {
+		q.Set("scope", v)
+	}
+
+	showNotePages(reader, q)
+}
+
+// showNotePages prints GET /api/notes?q page by page until the user quits.
+func showNotePages(reader *bufio.Reader, q url.Values) {
+	for page := 1; ; page++ {
+		p, err := fetchNoteList(q)
+		if err != nil {
+			LogError("list notes failed", err)
+			fmt.Println("error:", err)
+			return
+		}
+		if page == 1 {
+			fmt.Printf("%d note(s), %s total\n", p.Total, formatSize(p.TotalSize))
+		}
+		printNoteTable(p.Notes)
+
+		if p.NextCursor == "" {
+			return
+		}
+		fmt.Print("[n]ext page / [q]uit: ")
+		choice, _ := reader.ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(choice), "n") {
+			return
+		}
+		q.Set("cursor", p.NextCursor)
+	}
+}
+
+// printNoteTable prints one page of notes; titles are decrypted one note at a
+// time since the list does not carry the wrapped keys.
+func printNoteTable(notes []noteListEntry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tSIZE\tUPDATED\tOWNER")
+	for _, entry := range notes {
+		title := "?"
+		if n, err := fetchNoteMeta(entry.ID); err == nil {
+			if kNote, err := noteKey(n); err == nil {
+				title = noteTitle(n, kNote)
+				ZeroizeKey(kNote)
+			}
+		}
+		owner := "me"
+		if entry.Shared {
+			owner = entry.Owner + " (shared)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.ID, title, formatSize(entry.Size), entry.UpdatedAt, owner)
+	}
+	w.Flush()
+}
+
+// formatSize renders a byte count as B, KB, MB or GB.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, suffix := float64(n)/unit, "KB"
+	for _, s := range []string{"MB", "GB"} {
+		if v < unit {
+			break
+		}
+		v, suffix = v/unit, s
+	}
+	return fmt.Sprintf("%.1f %s", v, suffix)
+}
+
+// ManageFolders shows the folder tree and creates, renames, moves, deletes,
+// lists or shares a folder.
+func ManageFolders() {
+	reader := bufio.NewReader(os.Stdin)
+	kMaster, err := getMasterKey()
 	if err != nil {
-		LogError("list notes failed", err)
+		LogError("master key unavailable", err)
+		fmt.Println("error:", err)
 		return
 	}
-	LogInfo(fmt.Sprintf("list notes status: %d", status))
+	folders, err := fetchFolders()
+	if err != nil {
+		LogError("list folders failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	paths := folderPaths(kMaster, folders)
+	fmt.Println("Folders:")
+	fmt.Println("  /")
+	for _, id := range sortedFolderIDs(paths) {
+		fmt.Printf("  %s  (%s)\n", paths[id], id)
+	}
+
+	fmt.Print("[c]reate, [r]ename, [m]ove, [d]elete, [l]ist notes, [s]hare (empty = back): ")
+	action, _ := reader.ReadString('\n')
+	action = strings.ToLower(strings.TrimSpace(action))
+	if action == "" {
+		return
+	}
+
+	// askFolder reads a folder path or id; empty input is the root when allowed
+	askFolder := func(prompt string, allowRoot bool) (string, bool) {
+		fmt.Print(prompt)
+		input, _ := reader.ReadString('\n')
+		input = strings.TrimSpace(input)
+		if input == "" && allowRoot {
+			input = "/"
+		}
+		id, err := resolveFolder(paths, input)
+		if err != nil || (id == "" && !allowRoot) {
+			fmt.Println("error:", errFolderNotFound)
+			return "", false
+		}
+		return id, true
+	}
+	askName := func() (string, bool) {
+		fmt.Print("Name: ")
+		name, _ := reader.ReadString('\n')
+		name = strings.TrimSpace(name)
+		if name == "" || strings.Contains(name, "/") {
+			fmt.Println("folder name must be non-empty and must not contain '/'")
+			return "", false
+		}
+		enc, err := encryptName(kMaster, name)
+		if err != nil {
+			fmt.Println("error:", err)
+			return "", false
+		}
+		return enc, true
+	}
+
+	var b []byte
+	var status int
+	switch action {
+	case "c":
+		parentID, ok := askFolder("Parent folder (path or ID, empty = /): ", true)
+		if !ok {
+			return
+		}
+		name, ok := askName()
+		if !ok {
+			return
+		}
+		b, status, err = postJSON("/api/folders", map[string]string{"name": name, "parent_id": parentID}, true)
+	case "r":
+		id, ok := askFolder("Folder (path or ID): ", false)
+		if !ok {
+			return
+		}
+		name, ok := askName()
+		if !ok {
+			return
+		}
+		b, status, err = sendJSON(http.MethodPatch, "/api/folders/"+url.PathEscape(id), map[string]string{"name": name}, true)
+	case "m":
+		id, ok := askFolder("Folder (path or ID): ", false)
+		if !ok {
+			return
+		}
+		parentID, ok := askFolder("New parent (path or ID, empty = /): ", true)
+		if !ok {
+			return
+		}
+		b, status, err = sendJSON(http.MethodPatch, "/api/folders/"+url.PathEscape(id), map[string]string{"parent_id": parentID}, true)
+	case "d":
+		id, ok := askFolder("Folder (path or ID): ", false)
+		if !ok {
+			return
+		}
+		fmt.Printf("Delete %s? Its notes and subfolders move to the parent folder. (y/N): ", paths[id])
+		confirm, _ := reader.ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(confirm), "y") {
+			return
+		}
+		b, status, err = doRequest(http.MethodDelete, apiURL()+"/api/folders/"+url.PathEscape(id), nil, "", true)
+	case "l":
+		id, ok := askFolder("Folder (path or ID, empty = /): ", true)
+		if !ok {
+			return
+		}
+		fmt.Print("Include subfolders? (y/N): ")
+		recursive, _ := reader.ReadString('\n')
+		q := url.Values{}
+		q.Set("limit", strconv.Itoa(notesPerPage))
+		q.Set("folder", id)
+		if id == "" {
+			q.Set("folder", "root")
+		}
+		if strings.EqualFold(strings.TrimSpace(recursive), "y") {
+			q.Set("recursive", "true")
+		}
+		showNotePages(reader, q)
+		return
+	case "s":
+		id, ok := askFolder("Folder (path or ID): ", false)
+		if !ok {
+			return
+		}
+		fmt.Print("Recipient (username or ID): ")
+		recipient, _ := reader.ReadString('\n')
+		info, recipientPub, err := FetchDHPublicKey(strings.TrimSpace(recipient))
+		if err != nil {
+			LogError("fetch recipient key", err)
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Printf("Recipient %s key fingerprint: %s\n", info.Username, info.Fingerprint)
+		fmt.Print("Confirm fingerprint with recipient. Continue? (y/N): ")
+		confirm, _ := reader.ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(confirm), "y") {
+			LogInfo("share cancelled")
+			return
+		}
+		count, err := shareFolder(id, info.UserID, recipientPub)
+		if err != nil {
+			LogError("share folder failed", err)
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Printf("Shared %s (%d note(s)) with %s. Notes added later need sharing the folder again.\n", paths[id], count, info.Username)
+		return
+	default:
+		fmt.Println("Invalid option")
+		return
+	}
+	if err != nil {
+		LogError("folder request failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	LogInfo(fmt.Sprintf("folder status: %d", status))
 	fmt.Println(string(b))
 }
 
-// ShareNote creates a share for a note
+// OrganizeNote moves a note to a folder and replaces its tags.
+func OrganizeNote() {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Note ID: ")
+	noteID, _ := reader.ReadString('\n')
+	noteID = strings.TrimSpace(noteID)
+	if noteID == "" {
+		LogInfo("note ID is required")
+		return
+	}
+
+	kMaster, err := getMasterKey()
+	if err != nil {
+		LogError("master key unavailable", err)
+		fmt.Println("error:", err)
+		return
+	}
+	n, err := fetchNoteMeta(noteID)
+	if err != nil {
+		LogError("fetch note", err)
+		fmt.Println("error:", err)
+		return
+	}
+	if n.Shared {
+		fmt.Println("only the owner can organize a note")
+		return
+	}
+	folders, err := fetchFolders()
+	if err != nil {
+		LogError("list folders failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	tags, err := fetchTags()
+	if err != nil {
+		LogError("list tags failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	paths := folderPaths(kMaster, folders)
+	current := paths[n.FolderID]
+	if current == "" {
+		current = "/"
+	}
+	fmt.Printf("Folder: %s\nTags: %s\n", current, strings.Join(tagLabels(kMaster, tags, n.Tags), ", "))
+
+	fmt.Print("Move to folder (path or ID, empty = keep): ")
+	folderInput, _ := reader.ReadString('\n')
+	if folderInput = strings.TrimSpace(folderInput); folderInput != "" {
+		folderID, err := resolveFolder(paths, folderInput)
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		b, status, err := sendJSON(http.MethodPut, "/api/notes/"+url.PathEscape(noteID)+"/folder", map[string]string{"folder_id": folderID}, true)
+		if err != nil {
+			LogError("move note failed", err)
+			fmt.Println("error:", err)
+			return
+		}
+		LogInfo(fmt.Sprintf("move note status: %d", status))
+		fmt.Println(string(b))
+	}
+
+	fmt.Print("Tags (comma separated, replaces current; '-' = none, empty = keep): ")
+	tagInput, _ := reader.ReadString('\n')
+	if tagInput = strings.TrimSpace(tagInput); tagInput != "" {
+		tagIDs := []string{}
+		if tagInput != "-" {
+			if tagIDs, err = resolveTags(kMaster, tags, splitLabels(tagInput)); err != nil {
+				LogError("resolve tags failed", err)
+				fmt.Println("error:", err)
+				return
+			}
+		}
+		b, status, err := sendJSON(http.MethodPut, "/api/notes/"+url.PathEscape(noteID)+"/tags", map[string][]string{"tag_ids": tagIDs}, true)
+		if err != nil {
+			LogError("set tags failed", err)
+			fmt.Println("error:", err)
+			return
+		}
+		LogInfo(fmt.Sprintf("set tags status: %d", status))
+		fmt.Println(string(b))
+	}
+}
+
+// DeleteNote moves a note to the trash after showing its title; it can be
+// restored from Trash until the server purges it.
+func DeleteNote() {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Note ID: ")
+	noteID, _ := reader.ReadString('\n')
+	noteID = strings.TrimSpace(noteID)
+	if noteID == "" {
+		LogInfo("note ID required")
+		return
+	}
+
+	n, err := fetchNoteMeta(noteID)
+	if err != nil {
+		LogError("fetch note", err)
+		fmt.Println("error:", err)
+		return
+	}
+	if n.Shared {
+		fmt.Println("error: only the owner can delete a note")
+		return
+	}
+	title := "?"
+	if kNote, err := noteKey(n); err == nil {
+		title = noteTitle(n, kNote)
+		ZeroizeKey(kNote)
+	}
+	fmt.Printf("Move %q (version %d) to the trash? [y/N]: ", title, n.Version)
+	confirm, _ := reader.ReadString('\n')
+	if !strings.EqualFold(strings.TrimSpace(confirm), "y") {
+		return
+	}
+
+	purgeAt, err := trashNote(noteID, n.Version)
+	var conflict *noteConflictError
+	if errors.As(err, &conflict) {
+		fmt.Printf("Note was changed on another device (now version %d); review it before deleting.\n", conflict.Current)
+		return
+	}
+	if err != nil {
+		LogError("delete note failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Printf("Moved to trash; it will be deleted permanently at %s\n", purgeAt)
+}
+
+// ShowTrash lists the notes in the trash and restores or permanently
+// deletes one of them.
+func ShowTrash() {
+	reader := bufio.NewReader(os.Stdin)
+	notes, err := fetchTrash()
+	if err != nil {
+		LogError("list trash failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	if len(notes) == 0 {
+		fmt.Println("Trash is empty")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tSIZE\tDELETED\tPURGE AT")
+	for _, e := range notes {
+		title := "?"
+		if kNote, err := noteKey(e.asNote()); err == nil {
+			title = noteTitle(e.asNote(), kNote)
+			ZeroizeKey(kNote)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.ID, title, formatSize(e.Size), e.DeletedAt, e.PurgeAt)
+	}
+	w.Flush()
+
+	fmt.Print("Note ID (empty = done): ")
+	noteID, _ := reader.ReadString('\n')
+	noteID = strings.TrimSpace(noteID)
+	if noteID == "" {
+		return
+	}
+	fmt.Print("[r]estore / [d]elete forever: ")
+	action, _ := reader.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(action)) {
+	case "r":
+		if err := restoreNote(noteID); err != nil {
+			LogError("restore failed", err)
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Println("Note restored")
+	case "d":
+		fmt.Print("This cannot be undone. Type 'delete' to confirm: ")
+		confirm, _ := reader.ReadString('\n')
+		if strings.TrimSpace(confirm) != "delete" {
+			fmt.Println("Cancelled")
+			return
+		}
+		if err := purgeNote(noteID); err != nil {
+			LogError("permanent delete failed", err)
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Println("Note deleted permanently")
+	default:
+		fmt.Println("Invalid option")
+	}
+}
+
+// ShowUsage prints the storage used by the account and its quota.
+func ShowUsage() {
+	u, err := fetchUsage()
+	if err != nil {
+		LogError("fetch usage failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	if u.QuotaBytes == nil {
+		fmt.Printf("Used: %s (no quota)\n", formatSize(u.UsedBytes))
+	} else {
+		fmt.Printf("Used: %s of %s (%.1f%%), %s left\n", formatSize(u.UsedBytes), formatSize(*u.QuotaBytes),
+			float64(u.UsedBytes)*100/float64(*u.QuotaBytes), formatSize(*u.RemainingBytes))
+	}
+	fmt.Printf("  notes:       %s (%d notes, %d in trash)\n", formatSize(u.Breakdown.Notes), u.NoteCount, u.TrashedNoteCount)
+	fmt.Printf("  history:     %s\n", formatSize(u.Breakdown.Versions))
+	fmt.Printf("  share links: %s (%d active)\n", formatSize(u.Breakdown.ShareLinks), u.ActiveLinks)
+	if u.Breakdown.PendingUploads > 0 {
+		fmt.Printf("  unfinished uploads reserve %s\n", formatSize(u.Breakdown.PendingUploads))
+	}
+}
+
+// ShareNote shares a note with another user by re-wrapping K_Note under a DH session key
 func ShareNote() {
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Print("Note ID: ")
 	noteID, _ := reader.ReadString('\n')
 	noteID = strings.TrimSpace(noteID)
-	fmt.Print("Recipient ID: ")
-	recID, _ := reader.ReadString('\n')
-	recID = strings.TrimSpace(recID)
-	if noteID == "" || recID == "" {
+	fmt.Print("Recipient (username or ID): ")
+	recipient, _ := reader.ReadString('\n')
+	recipient = strings.TrimSpace(recipient)
+	if noteID == "" || recipient == "" {
 		LogInfo("note ID and recipient are required")
 		return
 	}
-	fmt.Print("Expiry (e.g. 24h) or empty: ")
-	expiry, _ := reader.ReadString('\n')
-	expiry = strings.TrimSpace(expiry)
-	payload := map[string]string{"recipient_id": recID}
-	if expiry != "" {
-		payload["expiry"] = expiry
+
+	info, recipientPub, err := FetchDHPublicKey(recipient)
+	if err != nil {
+		LogError("fetch recipient key", err)
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Printf("Recipient %s key fingerprint: %s\n", info.Username, info.Fingerprint)
+	fmt.Print("Confirm fingerprint with recipient. Continue? (y/N): ")
+	confirm, _ := reader.ReadString('\n')
+	if !strings.EqualFold(strings.TrimSpace(confirm), "y") {
+		LogInfo("share cancelled")
+		return
+	}
+
+	n, err := fetchNoteMeta(noteID)
+	if err != nil {
+		LogError("fetch note", err)
+		fmt.Println("error:", err)
+		return
+	}
+	kNote, err := noteKey(n)
+	if err != nil {
+		LogError("unwrap note key", err)
+		fmt.Println("error:", err)
+		return
+	}
+	defer ZeroizeKey(kNote)
+
+	kp, err := LoadDHKeyPair()
+	if err != nil {
+		LogError("load DH keypair", err)
+		fmt.Println("error:", err)
+		return
+	}
+	kSession, err := dhSessionKey(recipientPub, kp)
+	if err != nil {
+		LogError("derive session key", err)
+		fmt.Println("error:", err)
+		return
+	}
+	defer ZeroizeKey(kSession)
+	wrapped, err := EncryptFile(kSession, kNote)
+	if err != nil {
+		LogError("wrap note key", err)
+		return
+	}
+
+	payload := map[string]string{
+		"shared_to_user_id": info.UserID,
+		"aes_key_encrypted": base64.StdEncoding.EncodeToString(wrapped),
+		"sender_public_key": EncodeDHPublicKey(kp.Public),
 	}
 	path := "/api/notes/" + noteID + "/share"
 	b, status, err := postJSON(path, payload, true)
@@ -199,7 +1201,8 @@ func ShareNote() {
 	fmt.Println(string(b))
 }
 
-// CreateTempURL creates a temporary share URL for a note (server-dependent)
+// CreateTempURL re-encrypts a note under a fresh K_Session and creates a
+// share link whose URL carries the key in its fragment (zero-knowledge)
 func CreateTempURL() {
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Print("Note ID: ")
@@ -212,28 +1215,188 @@ func CreateTempURL() {
 	fmt.Print("Expiry (e.g. 1h) or empty: ")
 	expiry, _ := reader.ReadString('\n')
 	expiry = strings.TrimSpace(expiry)
-	payload := map[string]string{"note_id": noteID}
+	var meta shareLinkMetadata
 	if expiry != "" {
-		payload["expiry"] = expiry
+		d, err := time.ParseDuration(expiry)
+		if err != nil || d <= 0 {
+			fmt.Println("invalid expiry:", expiry)
+			return
+		}
+		meta.ExpiresIn = int(d.Seconds())
+	}
+	fmt.Print("Max views (empty = unlimited): ")
+	maxViews, _ := reader.ReadString('\n')
+	maxViews = strings.TrimSpace(maxViews)
+	if maxViews != "" {
+		n, err := strconv.Atoi(maxViews)
+		if err != nil || n < 0 {
+			fmt.Println("invalid max views:", maxViews)
+			return
+		}
+		meta.MaxViews = n
+	}
+	fmt.Print("Access password (optional): ")
+	password, _ := reader.ReadString('\n')
+	password = strings.TrimSpace(password)
+	if password != "" {
+		meta.HasPassword = true
+		meta.AccessHash = hashSharePassword(password)
+	}
+
+	// Decrypt locally, then re-encrypt with a key only the URL holder gets
+	n, err := fetchNote(noteID)
+	if err != nil {
+		LogError("fetch note", err)
+		fmt.Println("error:", err)
+		return
+	}
+	content, _, err := decryptNote(n)
+	if err != nil {
+		LogError("decrypt note", err)
+		fmt.Println("error:", err)
+		return
+	}
+	kSession, err := GenerateAESKey()
+	if err != nil {
+		LogError("generate session key", err)
+		return
+	}
+	defer ZeroizeKey(kSession)
+	contentEnc, err := EncryptFile(kSession, content)
+	ZeroizeKey(content)
+	if err != nil {
+		LogError("encrypt share content", err)
+		return
 	}
-	b, status, err := postJSON("/api/share/create", payload, true)
+
+	// note_id lets the server stop serving the link while the note is in the trash
+	payload := map[string]any{
+		"content_enc": base64.StdEncoding.EncodeToString(contentEnc),
+		"note_id":     noteID,
+		"metadata":    meta,
+	}
+	b, status, err := postJSON("/api/share", payload, true)
 	if err != nil {
 		LogError("create temp url failed", err)
 		return
 	}
 	LogInfo(fmt.Sprintf("create temp url status: %d", status))
-	fmt.Println(string(b))
+	if status != http.StatusCreated {
+		fmt.Println(string(b))
+		return
+	}
+	var resp struct {
+		ShareID   string  `json:"share_id"`
+		ExpiresAt *string `json:"expires_at"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		LogError("invalid create share response", err)
+		return
+	}
+	fmt.Println("Share URL:", buildShareURL(apiURL(), resp.ShareID, kSession))
+	if resp.ExpiresAt != nil {
+		fmt.Println("Expires at:", *resp.ExpiresAt)
+	}
+}
+
+// OpenShareURL downloads and decrypts the content behind a share URL
+func OpenShareURL() {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Share URL: ")
+	raw, _ := reader.ReadString('\n')
+	base, shareID, kSession, err := parseShareURL(raw)
+	if err != nil {
+		fmt.Println("invalid share URL:", err)
+		return
+	}
+	defer ZeroizeKey(kSession)
+
+	b, status, err := getPublic(base+"/api/share/"+url.PathEscape(shareID)+"/info", nil)
+	if err != nil {
+		LogError("share info failed", err)
+		return
+	}
+	if status != http.StatusOK {
+		fmt.Println(string(b))
+		return
+	}
+	var info shareInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		LogError("invalid share info", err)
+		return
+	}
+	if !info.IsActive {
+		fmt.Println("link has expired or been revoked")
+		return
+	}
+
+	headers := map[string]string{}
+	if info.RequiresPassword {
+		fmt.Print("Password: ")
+		password, _ := reader.ReadString('\n')
+		headers["X-Access-Pass-Hash"] = hashSharePassword(strings.TrimSpace(password))
+	}
+	b, status, err = getPublic(base+"/api/share/"+url.PathEscape(shareID), headers)
+	if err != nil {
+		LogError("open share failed", err)
+		return
+	}
+	if msg, ok := lockoutMessage(status, b); ok {
+		fmt.Println("open share failed:", msg)
+		return
+	}
+	if status != http.StatusOK {
+		fmt.Println(string(b))
+		return
+	}
+	var resp struct {
+		ContentEnc string `json:"content_enc"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		LogError("invalid share response", err)
+		return
+	}
+	contentEnc, err := base64.StdEncoding.DecodeString(resp.ContentEnc)
+	if err != nil {
+		LogError("decode share content", err)
+		return
+	}
+	content, err := DecryptFile(kSession, contentEnc)
+	if err != nil {
+		fmt.Println("error: content was tampered with or key is wrong")
+		return
+	}
+	defer ZeroizeKey(content)
+
+	fmt.Printf("Output path [%s]: ", shareID)
+	out, _ := reader.ReadString('\n')
+	out = strings.TrimSpace(out)
+	if out == "" {
+		out = shareID
+	}
+	if err := os.WriteFile(out, content, 0600); err != nil {
+		LogError("write file", err)
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Printf("Shared content decrypted to %s (%d bytes)\n", out, len(content))
 }
 
 // Logout calls server logout endpoint and clears local token
 func Logout() {
-	b, status, err := doRequest(http.MethodPost, apiURL()+"/api/auth/logout", nil, "", true)
+	// Send refresh token so the server revokes it together with the access token
+	var payload map[string]string
+	if t, err := LoadTokens(); err == nil && t.RefreshToken != "" {
+		payload = map[string]string{"refresh_token": t.RefreshToken}
+	}
+	b, status, err := postJSON("/api/logout", payload, true)
 	if err != nil {
 		LogError("logout request failed", err)
 		return
 	}
 	LogInfo(fmt.Sprintf("logout status: %d", status))
 	fmt.Println(string(b))
+	ClearMasterKey()
 	// Remove saved token regardless of server response
 	if err := os.Remove(tokenPath()); err != nil {
 		// If file not found, ignore
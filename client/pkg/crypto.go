@@ -30,13 +30,6 @@ func GenerateAESKey() ([]byte, error) {
     }
 	return key, err
 }
-func GenerateIV() ([]byte, error){
-    iv := make([]byte, 12) 
-    if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-        return nil, fmt.Errorf("failed to generate IV: %w", err)
-    }
-    return iv, nil
-}
 // EncryptFile encrypts plaintext using AES-256-GCM
 func EncryptFile(aesKey []byte, plaintext []byte) ([]byte, error) {
 	block, err := aes.NewCipher(aesKey) // Tao cipher block tu aesKey
@@ -243,7 +236,8 @@ func VerifyKeyFingerprint(publicKey *big.Int) string {
 // KEY DERIVATION (Argon2id for K_Master)
 // ============================================================
 
-// DeriveKeyFromPassword uses Argon2id to derive K_Master from password
+// DeriveKeyFromPassword stretches the password with Argon2id; the login key
+// and K_Master are derived from the result (see deriveAccountKeys)
 func DeriveKeyFromPassword(password string, salt []byte) ([]byte, error) {
 	// TODO: Use Argon2id with strong parameters:
 	//   key := argon2.IDKey(
@@ -0,0 +1,273 @@
+package serverpkg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ============================================================
+// FOLDERS & TAGS
+// ============================================================
+//
+// Folder names and tag labels are encrypted with K_Master (they belong to
+// the owner only); the server just stores the tree and which notes sit where.
+// Sharing a folder wraps the K_Note of every note in it for the recipient,
+// exactly like ShareNote does for a single note.
+
+// folderEntry is one element of GET /api/folders; Name is encrypted.
+type folderEntry struct {
+	ID        string `json:"id"`
+	ParentID  string `json:"parent_id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// tagEntry is one element of GET /api/tags; Label is encrypted.
+type tagEntry struct {
+	ID        string `json:"id"`
+	Label     string `json:"label"`
+	CreatedAt string `json:"created_at"`
+}
+
+// encryptName encrypts a folder name or tag label with K_Master.
+func encryptName(kMaster []byte, name string) (string, error) {
+	enc, err := EncryptFile(kMaster, []byte(name))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(enc), nil
+}
+
+// decryptName decrypts a folder name or tag label ("?" if it cannot).
+func decryptName(kMaster []byte, enc string) string {
+	raw, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return "?"
+	}
+	name, err := DecryptFile(kMaster, raw)
+	if err != nil {
+		return "?"
+	}
+	return string(name)
+}
+
+// getJSON fetches path and decodes the JSON response into v.
+func getJSON(path string, v any) error {
+	b, status, err := doRequest(http.MethodGet, apiURL()+path, nil, "", true)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("GET %s failed: %d %s", path, status, string(b))
+	}
+	return json.Unmarshal(b, v)
+}
+
+func fetchFolders() ([]folderEntry, error) {
+	var folders []folderEntry
+	err := getJSON("/api/folders", &folders)
+	return folders, err
+}
+
+func fetchTags() ([]tagEntry, error) {
+	var tags []tagEntry
+	err := getJSON("/api/tags", &tags)
+	return tags, err
+}
+
+// folderPaths maps every folder id to its decrypted path, e.g. "/Work/Plans".
+func folderPaths(kMaster []byte, folders []folderEntry) map[string]string {
+	byID := map[string]folderEntry{}
+	for _, f := range folders {
+		byID[f.ID] = f
+	}
+	paths := map[string]string{}
+	var pathOf func(id string, depth int) string
+	pathOf = func(id string, depth int) string {
+		if p, ok := paths[id]; ok {
+			return p
+		}
+		f, ok := byID[id]
+		if !ok || depth > len(folders) {
+			return ""
+		}
+		p := pathOf(f.ParentID, depth+1) + "/" + decryptName(kMaster, f.Name)
+		paths[id] = p
+		return p
+	}
+	for _, f := range folders {
+		pathOf(f.ID, 0)
+	}
+	return paths
+}
+
+// sortedFolderIDs returns the folder ids ordered by path.
+func sortedFolderIDs(paths map[string]string) []string {
+	ids := make([]string, 0, len(paths))
+	for id := range paths {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return paths[ids[i]] < paths[ids[j]] })
+	return ids
+}
+
+// errFolderNotFound is returned when a path or id matches no folder.
+var errFolderNotFound = errors.New("folder not found")
+
+// resolveFolder turns user input (a folder id, or a path like "/Work/Plans"
+// or "Work/Plans") into a folder id; "/" is the root folder ("").
+func resolveFolder(paths map[string]string, input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "/" {
+		return "", nil
+	}
+	if _, ok := paths[input]; ok {
+		return input, nil
+	}
+	want := "/" + strings.Trim(input, "/")
+	for id, p := range paths {
+		if strings.EqualFold(p, want) {
+			return id, nil
+		}
+	}
+	return "", errFolderNotFound
+}
+
+// tagLabels decrypts the labels of the tags in tagIDs.
+func tagLabels(kMaster []byte, tags []tagEntry, tagIDs []string) []string {
+	labels := make([]string, 0, len(tagIDs))
+	for _, id := range tagIDs {
+		for _, t := range tags {
+			if t.ID == id {
+				labels = append(labels, decryptName(kMaster, t.Label))
+			}
+		}
+	}
+	return labels
+}
+
+// resolveTags returns the ids of the tags with the given labels, creating
+// the tags that do not exist yet.
+func resolveTags(kMaster []byte, tags []tagEntry, labels []string) ([]string, error) {
+	existing := map[string]string{}
+	for _, t := range tags {
+		existing[strings.ToLower(decryptName(kMaster, t.Label))] = t.ID
+	}
+	var ids []string
+	for _, label := range labels {
+		if id, ok := existing[strings.ToLower(label)]; ok {
+			ids = append(ids, id)
+			continue
+		}
+		labelEnc, err := encryptName(kMaster, label)
+		if err != nil {
+			return nil, err
+		}
+		b, status, err := postJSON("/api/tags", map[string]string{"label": labelEnc}, true)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusCreated {
+			return nil, fmt.Errorf("create tag failed: %d %s", status, string(b))
+		}
+		var t tagEntry
+		if err := json.Unmarshal(b, &t); err != nil {
+			return nil, err
+		}
+		existing[strings.ToLower(label)] = t.ID
+		ids = append(ids, t.ID)
+	}
+	return ids, nil
+}
+
+// splitLabels splits a comma separated list into trimmed, non-empty labels.
+func splitLabels(raw string) []string {
+	var labels []string
+	for _, l := range strings.Split(raw, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
+
+// folderNoteIDs lists the ids of the notes in a folder and its subfolders.
+func folderNoteIDs(folderID string) ([]string, error) {
+	q := url.Values{}
+	q.Set("folder", folderID)
+	q.Set("recursive", "true")
+	q.Set("limit", fmt.Sprint(maxNotePageSize))
+	var ids []string
+	for {
+		page, err := fetchNoteList(q)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range page.Notes {
+			ids = append(ids, n.ID)
+		}
+		if page.NextCursor == "" {
+			return ids, nil
+		}
+		q.Set("cursor", page.NextCursor)
+	}
+}
+
+// shareFolder wraps the K_Note of every note in the folder under the DH
+// session key shared with the recipient and sends them in one request.
+func shareFolder(folderID string, recipientID string, recipientPub *big.Int) (int, error) {
+	noteIDs, err := folderNoteIDs(folderID)
+	if err != nil {
+		return 0, err
+	}
+
+	kp, err := LoadDHKeyPair()
+	if err != nil {
+		return 0, fmt.Errorf("load DH keypair: %w", err)
+	}
+	kSession, err := dhSessionKey(recipientPub, kp)
+	if err != nil {
+		return 0, err
+	}
+	defer ZeroizeKey(kSession)
+
+	keys := map[string]string{}
+	for _, id := range noteIDs {
+		n, err := fetchNoteMeta(id)
+		if err != nil {
+			return 0, err
+		}
+		kNote, err := noteKey(n)
+		if err != nil {
+			return 0, fmt.Errorf("unwrap key of note %s: %w", id, err)
+		}
+		wrapped, err := EncryptFile(kSession, kNote)
+		ZeroizeKey(kNote)
+		if err != nil {
+			return 0, err
+		}
+		keys[id] = base64.StdEncoding.EncodeToString(wrapped)
+	}
+
+	payload := map[string]any{
+		"shared_to_user_id": recipientID,
+		"sender_public_key": EncodeDHPublicKey(kp.Public),
+		"keys":              keys,
+	}
+	b, status, err := postJSON("/api/folders/"+url.PathEscape(folderID)+"/share", payload, true)
+	if err != nil {
+		return 0, err
+	}
+	if status != http.StatusOK {
+		return 0, fmt.Errorf("share folder failed: %d %s", status, string(b))
+	}
+	return len(keys), nil
+}
@@ -3,6 +3,8 @@ package serverpkg
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
@@ -59,6 +61,15 @@ func IsLoggedIn() bool {
 type Tokens struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token,omitempty"`
+	// Username is the logged in account, which owns the local DH keypair.
+	Username string `json:"username,omitempty"`
+	// KdfSalt is the base64 salt used to re-derive K_Master after a restart.
+	KdfSalt string `json:"kdf_salt,omitempty"`
+	// AuthVersion tells how K_Master derives from the password (see keys.go).
+	AuthVersion int `json:"auth_version,omitempty"`
+	// SearchKeyEnc is K_Search wrapped with K_Master, set once the password
+	// was changed (K_Search then no longer derives from K_Master).
+	SearchKeyEnc string `json:"search_key_enc,omitempty"`
 }
 
 // SaveTokens writes access and refresh tokens to disk as JSON (0600).
@@ -89,36 +100,194 @@ func LoadTokens() (Tokens, error) {
 }
 
 // doRequest performs an HTTP request and returns the response body and status code.
+// Authenticated requests that fail with 401 "token expired" are retried once
+// after rotating the tokens via POST /api/refresh. TLS trust (CA bundle, SPKI
+// pins, client certificate) comes from the environment, see tls.go.
 func doRequest(method, url string, body io.Reader, contentType string, withAuth bool) ([]byte, int, error) {
-	client := &http.Client{Timeout: 15 * time.Second}
-	req, err := http.NewRequest(method, url, body)
+	// Buffer the body so the request can be replayed after a refresh
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = io.ReadAll(body)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return doRequestHeaders(method, url, payload, contentType, withAuth, nil)
+}
+
+// doRequestHeaders is doRequest with extra request headers.
+func doRequestHeaders(method, url string, payload []byte, contentType string, withAuth bool, headers map[string]string) ([]byte, int, error) {
+	b, status, err := sendRequest(method, url, payload, contentType, withAuth, headers)
+	if err != nil || !withAuth || status != http.StatusUnauthorized || !isTokenExpired(b) {
+		return b, status, err
+	}
+	if err := refreshTokens(); err != nil {
+		LogError("token refresh failed", err)
+		return b, status, nil
+	}
+	return sendRequest(method, url, payload, contentType, withAuth, headers)
+}
+
+func sendRequest(method, url string, payload []byte, contentType string, withAuth bool, headers map[string]string) ([]byte, int, error) {
+	client, err := httpClient()
+	if err != nil {
+		return nil, 0, err
+	}
+	// Large bodies (upload chunks) must not be cut off by the 15s API timeout
+	if len(payload) > 1<<20 {
+		client, err = transferClient()
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	req, err := newRequest(method, url, payload, contentType, withAuth, headers)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, 0, err
 	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	return b, resp.StatusCode, err
+}
+
+func newRequest(method, url string, payload []byte, contentType string, withAuth bool, headers map[string]string) (*http.Request, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
 	if contentType != "" {
 		req.Header.Set("Content-Type", contentType)
 	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
 	if withAuth {
 		tok, err := loadToken()
 		if err == nil && tok != "" {
 			req.Header.Set("Authorization", "Bearer "+tok)
 		}
 	}
+	return req, nil
+}
 
-	resp, err := client.Do(req)
+// openStream performs an authenticated GET and returns the response with an
+// unread body, for downloads too large to buffer. The caller closes the body.
+// Like doRequest, an expired access token is refreshed once.
+func openStream(url string, headers map[string]string) (*http.Response, error) {
+	client, err := transferClient()
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	b, err := io.ReadAll(resp.Body)
-	return b, resp.StatusCode, err
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest(http.MethodGet, url, nil, "", true, headers)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !isTokenExpired(b) {
+			return nil, fmt.Errorf("request failed: %d %s", resp.StatusCode, string(b))
+		}
+		if err := refreshTokens(); err != nil {
+			return nil, fmt.Errorf("token refresh failed: %w", err)
+		}
+	}
+}
+
+// isTokenExpired reports whether a 401 body is the server's "token expired" error.
+func isTokenExpired(b []byte) bool {
+	var resp struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return false
+	}
+	return resp.Error == "token expired"
+}
+
+// refreshTokens exchanges the stored refresh token for a new token pair.
+func refreshTokens() error {
+	t, err := LoadTokens()
+	if err != nil {
+		return err
+	}
+	if t.RefreshToken == "" {
+		return errors.New("no refresh token stored")
+	}
+	reqBody, err := json.Marshal(map[string]string{"refresh_token": t.RefreshToken})
+	if err != nil {
+		return err
+	}
+	b, status, err := sendRequest(http.MethodPost, apiURL()+"/api/refresh", reqBody, "application/json", false, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("refresh failed: %d %s", status, string(b))
+	}
+	var resp Tokens
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return err
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" {
+		return errors.New("refresh response missing tokens")
+	}
+	t.AccessToken = resp.AccessToken
+	t.RefreshToken = resp.RefreshToken
+	return SaveTokens(t)
+}
+
+// getPublic performs an unauthenticated GET with extra headers (e.g. share links).
+func getPublic(url string, headers map[string]string) ([]byte, int, error) {
+	return sendRequest(http.MethodGet, url, nil, "", false, headers)
 }
 
 // postJSON helper
 func postJSON(path string, payload interface{}, withAuth bool) ([]byte, int, error) {
+	return sendJSON(http.MethodPost, path, payload, withAuth)
+}
+
+// sendJSON sends payload as JSON with the given method (PUT, PATCH, ...).
+func sendJSON(method, path string, payload interface{}, withAuth bool) ([]byte, int, error) {
 	b, err := json.Marshal(payload)
 	if err != nil {
 		return nil, 0, err
 	}
-	return doRequest(http.MethodPost, apiURL()+path, bytes.NewReader(b), "application/json", withAuth)
+	return doRequest(method, apiURL()+path, bytes.NewReader(b), "application/json", withAuth)
+}
+
+// lockoutMessage describes a 423/429 answer from the server (too many wrong
+// passwords or codes for the account, share link or address); ok is false for
+// any other response.
+func lockoutMessage(status int, b []byte) (msg string, ok bool) {
+	if status != http.StatusLocked && status != http.StatusTooManyRequests {
+		return "", false
+	}
+	var resp struct {
+		Error      string `json:"error"`
+		RetryAfter int64  `json:"retry_after"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil || resp.Error == "" {
+		return "", false
+	}
+	if resp.RetryAfter > 0 {
+		return fmt.Sprintf("%s (retry in %s)", resp.Error, time.Duration(resp.RetryAfter)*time.Second), true
+	}
+	return resp.Error, true
 }
@@ -0,0 +1,113 @@
+package serverpkg
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeAuthServer serves /api/notes (valid only with validToken) and
+// /api/refresh (which hands out issuedToken).
+type fakeAuthServer struct {
+	validToken  string
+	issuedToken string
+	refreshes   atomic.Int32
+}
+
+func (f *fakeAuthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/api/refresh":
+		f.refreshes.Add(1)
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken != "refresh-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"error":"Invalid refresh token"}`)
+			return
+		}
+		json.NewEncoder(w).Encode(Tokens{AccessToken: f.issuedToken, RefreshToken: "refresh-2"})
+	case "/api/notes":
+		if r.Header.Get("Authorization") != "Bearer "+f.validToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"error":"token expired"}`)
+			return
+		}
+		io.WriteString(w, `{"notes":[]}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func setupTokenTest(t *testing.T, f *fakeAuthServer) {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	t.Setenv("API_URL", srv.URL)
+	t.Setenv("TOKEN_PATH", filepath.Join(t.TempDir(), "token"))
+	if err := SaveTokens(Tokens{AccessToken: "expired", RefreshToken: "refresh-1", KdfSalt: "salt"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDoRequestRefreshesExpiredToken(t *testing.T) {
+	f := &fakeAuthServer{validToken: "fresh", issuedToken: "fresh"}
+	setupTokenTest(t, f)
+
+	b, status, err := doRequest(http.MethodGet, apiURL()+"/api/notes", nil, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (body %s)", status, b)
+	}
+	if n := f.refreshes.Load(); n != 1 {
+		t.Fatalf("refreshes = %d, want 1", n)
+	}
+	tok, err := LoadTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "fresh" || tok.RefreshToken != "refresh-2" || tok.KdfSalt != "salt" {
+		t.Fatalf("stored tokens = %+v", tok)
+	}
+}
+
+func TestDoRequestRefreshesOnlyOnce(t *testing.T) {
+	// The refreshed token is still rejected: the 401 is returned, no second refresh
+	f := &fakeAuthServer{validToken: "fresh", issuedToken: "also-expired"}
+	setupTokenTest(t, f)
+
+	_, status, err := doRequest(http.MethodGet, apiURL()+"/api/notes", nil, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", status)
+	}
+	if n := f.refreshes.Load(); n != 1 {
+		t.Fatalf("refreshes = %d, want 1", n)
+	}
+}
+
+func TestOpenStreamRefreshesExpiredToken(t *testing.T) {
+	f := &fakeAuthServer{validToken: "fresh", issuedToken: "fresh"}
+	setupTokenTest(t, f)
+
+	resp, err := openStream(apiURL()+"/api/notes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if n := f.refreshes.Load(); n != 1 {
+		t.Fatalf("refreshes = %d, want 1", n)
+	}
+}
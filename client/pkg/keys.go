@@ -0,0 +1,463 @@
+package serverpkg
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// The password is stretched once with Argon2id(password, kdf_salt); HKDF then
+// derives the login key, the only value sent to the server to log in, and
+// K_Master, which never leaves the client. The server therefore cannot
+// compute K_Master from what it receives.
+const (
+	loginKeyInfo  = "Secure-Notes-Login-Key"
+	masterKeyInfo = "Secure-Notes-Master-Key"
+
+	// authVersionPassword accounts predate the login key: they still log in
+	// with the password and their K_Master is the Argon2id output itself.
+	// The client switches them to authVersionLoginKey right after login.
+	authVersionPassword = 0
+	authVersionLoginKey = 1
+)
+
+// masterKey holds K_Master in RAM only; it is never written to disk.
+var masterKey []byte
+
+// deriveAccountKeys derives the login key and K_Master from the password.
+// Accounts on authVersionPassword get no login key.
+func deriveAccountKeys(password string, salt []byte, authVersion int) (loginKey, kMaster []byte, err error) {
+	stretched, err := DeriveKeyFromPassword(password, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if authVersion == authVersionPassword {
+		return nil, stretched, nil
+	}
+	defer ZeroizeKey(stretched)
+	derive := func(info string) ([]byte, error) {
+		out := make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, stretched, nil, []byte(info)), out); err != nil {
+			return nil, fmt.Errorf("derive account key: %w", err)
+		}
+		return out, nil
+	}
+	if loginKey, err = derive(loginKeyInfo); err != nil {
+		return nil, nil, err
+	}
+	if kMaster, err = derive(masterKeyInfo); err != nil {
+		ZeroizeKey(loginKey)
+		return nil, nil, err
+	}
+	return loginKey, kMaster, nil
+}
+
+// fetchSalt returns the kdf salt and auth version of an account
+// (GET /api/salt); unknown usernames get a decoy salt.
+func fetchSalt(username string) ([]byte, int, error) {
+	b, status, err := getPublic(apiURL()+"/api/salt?username="+url.QueryEscape(username), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if status != http.StatusOK {
+		return nil, 0, fmt.Errorf("fetch kdf salt failed: %d %s", status, string(b))
+	}
+	var resp struct {
+		KdfSalt     string `json:"kdf_salt"`
+		AuthVersion int    `json:"auth_version"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, 0, err
+	}
+	// Once an account logged in with a login key, the server never asks for
+	// its password again; a response saying otherwise tries to make the
+	// client send the password in clear.
+	if resp.AuthVersion == authVersionPassword && usesLoginKey(username) {
+		return nil, 0, errLoginDowngrade
+	}
+	salt, err := base64.StdEncoding.DecodeString(resp.KdfSalt)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid kdf salt: %w", err)
+	}
+	return salt, resp.AuthVersion, nil
+}
+
+// errLoginDowngrade is returned when the server asks for the password of an
+// account already known to use a login key.
+var errLoginDowngrade = errors.New("server asked for the password of an account that uses a login key; refusing to send it (the server may be impersonated)")
+
+// loginKeyAccountsPath is the file listing the accounts that logged in on
+// this device with a login key. It outlives logout.
+func loginKeyAccountsPath() string {
+	if v := os.Getenv("LOGIN_KEY_ACCOUNTS_PATH"); v != "" {
+		return v
+	}
+	return ".client_login_key_accounts"
+}
+
+func readLoginKeyAccounts() map[string]bool {
+	accounts := map[string]bool{}
+	b, err := os.ReadFile(loginKeyAccountsPath())
+	if err != nil {
+		return accounts
+	}
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return accounts
+	}
+	for _, n := range names {
+		accounts[n] = true
+	}
+	return accounts
+}
+
+// usesLoginKey reports whether username logged in with a login key on this
+// device before.
+func usesLoginKey(username string) bool {
+	return readLoginKeyAccounts()[username]
+}
+
+// rememberLoginKey records that username logs in with a login key.
+func rememberLoginKey(username string) {
+	accounts := readLoginKeyAccounts()
+	if username == "" || accounts[username] {
+		return
+	}
+	accounts[username] = true
+	names := make([]string, 0, len(accounts))
+	for n := range accounts {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	b, err := json.Marshal(names)
+	if err == nil {
+		err = os.WriteFile(loginKeyAccountsPath(), b, 0600)
+	}
+	if err != nil {
+		LogError("save login key accounts", err)
+	}
+}
+
+// loginKeyFor derives the login key of the logged in account, which the
+// server asks for before sensitive changes.
+func loginKeyFor(password string) (string, error) {
+	t, err := LoadTokens()
+	if err != nil {
+		return "", err
+	}
+	if t.AuthVersion != authVersionLoginKey {
+		return "", errors.New("account still uses the old password scheme, log in again to switch it to a login key")
+	}
+	salt, err := base64.StdEncoding.DecodeString(t.KdfSalt)
+	if err != nil || len(salt) == 0 {
+		return "", errors.New("kdf salt unknown, please login again")
+	}
+	loginKey, kMaster, err := deriveAccountKeys(password, salt, t.AuthVersion)
+	if err != nil {
+		return "", err
+	}
+	ZeroizeKey(kMaster)
+	defer ZeroizeKey(loginKey)
+	return base64.StdEncoding.EncodeToString(loginKey), nil
+}
+
+// SetMasterKey derives K_Master from the password and the server-provided KDF salt.
+func SetMasterKey(password string, kdfSalt string, authVersion int) error {
+	salt, err := base64.StdEncoding.DecodeString(kdfSalt)
+	if err != nil {
+		return fmt.Errorf("invalid kdf salt: %w", err)
+	}
+	loginKey, key, err := deriveAccountKeys(password, salt, authVersion)
+	if err != nil {
+		return err
+	}
+	ZeroizeKey(loginKey)
+	useMasterKey(key)
+	return nil
+}
+
+// useMasterKey replaces K_Master with an already derived key.
+func useMasterKey(key []byte) {
+	ClearMasterKey()
+	masterKey = key
+}
+
+// ClearMasterKey zeroizes K_Master (called on logout).
+func ClearMasterKey() {
+	if masterKey != nil {
+		ZeroizeKey(masterKey)
+		masterKey = nil
+	}
+}
+
+// getMasterKey returns K_Master, prompting for the password again if the
+// client was restarted since login.
+func getMasterKey() ([]byte, error) {
+	if masterKey != nil {
+		return masterKey, nil
+	}
+	t, err := LoadTokens()
+	if err != nil {
+		return nil, err
+	}
+	if t.KdfSalt == "" {
+		return nil, errors.New("kdf salt unknown, please login again")
+	}
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Password (to unlock notes): ")
+	password, _ := reader.ReadString('\n')
+	password = strings.TrimSpace(password)
+	if err := SetMasterKey(password, t.KdfSalt, t.AuthVersion); err != nil {
+		return nil, err
+	}
+	return masterKey, nil
+}
+
+// dhKeyPath returns the keypair file of username. Each account logged in on
+// this device keeps its own file, so switching accounts never reuses or
+// overwrites another account's key.
+func dhKeyPath(username string) string {
+	return legacyDHKeyPath() + "." + username
+}
+
+// legacyDHKeyPath is the single, account-less keypair file written by older
+// clients.
+func legacyDHKeyPath() string {
+	if v := os.Getenv("DH_KEY_PATH"); v != "" {
+		return v
+	}
+	return ".client_dh_key"
+}
+
+// storedDHKey is the on-disk (0600) representation of the user's DH keypair.
+type storedDHKey struct {
+	// Username is the account the keypair was generated for.
+	Username   string `json:"username"`
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+	Uploaded   bool   `json:"uploaded"`
+}
+
+// EncodeDHPublicKey converts a DH public key to the base64 form used by the API.
+func EncodeDHPublicKey(pub *big.Int) string {
+	return base64.StdEncoding.EncodeToString(pub.Bytes())
+}
+
+// DecodeDHPublicKey parses a base64 DH public key returned by the API.
+func DecodeDHPublicKey(s string) (*big.Int, error) {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key encoding: %w", err)
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+func saveDHKey(k storedDHKey) error {
+	b, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dhKeyPath(k.Username), b, 0600)
+}
+
+func readDHKeyFile(path string) (storedDHKey, error) {
+	var k storedDHKey
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return k, err
+	}
+	err = json.Unmarshal(b, &k)
+	return k, err
+}
+
+// readDHKey reads the keypair of username, refusing a file that was
+// generated for another account.
+func readDHKey(username string) (storedDHKey, error) {
+	k, err := readDHKeyFile(dhKeyPath(username))
+	if err != nil {
+		return k, err
+	}
+	if k.Username != username {
+		return k, fmt.Errorf("DH keypair file belongs to %q, not %q", k.Username, username)
+	}
+	return k, nil
+}
+
+// currentUsername returns the account the stored tokens belong to.
+func currentUsername() (string, error) {
+	t, err := LoadTokens()
+	if err != nil {
+		return "", err
+	}
+	if t.Username == "" {
+		return "", errors.New("logged in account unknown, please login again")
+	}
+	return t.Username, nil
+}
+
+// LoadDHKeyPair reads the local DH keypair of the logged in account.
+func LoadDHKeyPair() (*DHKeyPair, error) {
+	username, err := currentUsername()
+	if err != nil {
+		return nil, err
+	}
+	k, err := readDHKey(username)
+	if err != nil {
+		return nil, err
+	}
+	rawPriv, err := base64.StdEncoding.DecodeString(k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	priv := new(big.Int).SetBytes(rawPriv)
+	pub, err := DecodeDHPublicKey(k.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &DHKeyPair{Private: priv, Public: pub}, nil
+}
+
+// adoptLegacyDHKey moves the keypair of an older client, which did not
+// record its account, to username's file if it is the key username has
+// published. A key published by another account is left alone.
+func adoptLegacyDHKey(username string) (storedDHKey, bool) {
+	k, err := readDHKeyFile(legacyDHKeyPath())
+	if err != nil || k.Username != "" || !k.Uploaded {
+		return storedDHKey{}, false
+	}
+	info, _, err := FetchDHPublicKey(username)
+	if err != nil || info.Username != username || info.PublicKey != k.PublicKey {
+		return storedDHKey{}, false
+	}
+	k.Username = username
+	if err := saveDHKey(k); err != nil {
+		LogError("adopt legacy DH keypair", err)
+		return storedDHKey{}, false
+	}
+	if err := os.Remove(legacyDHKeyPath()); err != nil {
+		LogError("remove legacy DH keypair", err)
+	}
+	LogInfo("legacy DH keypair adopted for " + username)
+	return k, true
+}
+
+// EnsureDHKeyPair generates a DH keypair on the first login of username on
+// this device and publishes the public key to POST /api/keys/dh. A pending
+// upload is retried on next login.
+func EnsureDHKeyPair(username string) error {
+	k, err := readDHKeyFile(dhKeyPath(username))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// A missing file or one generated for another account gets a new keypair
+	if err != nil || k.Username != username {
+		var ok bool
+		if k, ok = adoptLegacyDHKey(username); !ok {
+			params, err := GenerateDHParameters()
+			if err != nil {
+				return err
+			}
+			kp, err := GenerateDHKeyPair(params)
+			if err != nil {
+				return err
+			}
+			k = storedDHKey{
+				Username:   username,
+				PrivateKey: base64.StdEncoding.EncodeToString(kp.Private.Bytes()),
+				PublicKey:  EncodeDHPublicKey(kp.Public),
+			}
+			if err := saveDHKey(k); err != nil {
+				return err
+			}
+			LogInfo("DH keypair generated for " + username)
+		}
+	}
+	if k.Uploaded {
+		return nil
+	}
+
+	b, status, err := postJSON("/api/keys/dh", map[string]string{"public_key": k.PublicKey}, true)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("publish DH key failed: %d %s", status, string(b))
+	}
+	var resp struct {
+		Fingerprint string `json:"fingerprint"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return err
+	}
+	pub, _ := DecodeDHPublicKey(k.PublicKey)
+	if resp.Fingerprint != VerifyKeyFingerprint(pub) {
+		return errors.New("server returned mismatched key fingerprint")
+	}
+	k.Uploaded = true
+	if err := saveDHKey(k); err != nil {
+		return err
+	}
+	fmt.Println("DH public key published, fingerprint:", resp.Fingerprint)
+	return nil
+}
+
+// DHPublicKeyInfo is a directory entry returned by GET /api/keys/dh.
+type DHPublicKeyInfo struct {
+	UserID      string `json:"user_id"`
+	Username    string `json:"username"`
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// FetchDHPublicKey looks up a user's DH public key by username, falling back
+// to user id, and checks the server fingerprint against the key itself.
+func FetchDHPublicKey(user string) (*DHPublicKeyInfo, *big.Int, error) {
+	b, status, err := doRequest(http.MethodGet, apiURL()+"/api/keys/dh?username="+url.QueryEscape(user), nil, "", true)
+	if err == nil && status == http.StatusNotFound {
+		b, status, err = doRequest(http.MethodGet, apiURL()+"/api/keys/dh/"+url.PathEscape(user), nil, "", true)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if status != http.StatusOK {
+		return nil, nil, fmt.Errorf("fetch DH key failed: %d %s", status, string(b))
+	}
+	var info DHPublicKeyInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, nil, err
+	}
+	pub, err := DecodeDHPublicKey(info.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if VerifyKeyFingerprint(pub) != info.Fingerprint {
+		return nil, nil, errors.New("public key does not match its fingerprint")
+	}
+	return &info, pub, nil
+}
+
+// dhSessionKey computes K_Session = HKDF(theirPublic^myPrivate mod p).
+func dhSessionKey(theirPublic *big.Int, kp *DHKeyPair) ([]byte, error) {
+	params, err := GenerateDHParameters()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := ComputeSharedSecret(theirPublic, kp.Private, params)
+	if err != nil {
+		return nil, err
+	}
+	return DeriveSessionKey(secret)
+}
@@ -0,0 +1,144 @@
+package serverpkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeKeyServer accepts POST /api/keys/dh and remembers the published keys.
+type fakeKeyServer struct {
+	published []string
+}
+
+func (f *fakeKeyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/api/keys/dh" {
+		http.NotFound(w, r)
+		return
+	}
+	var req struct {
+		PublicKey string `json:"public_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pub, err := DecodeDHPublicKey(req.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.published = append(f.published, req.PublicKey)
+	json.NewEncoder(w).Encode(map[string]string{"fingerprint": VerifyKeyFingerprint(pub)})
+}
+
+func TestEnsureDHKeyPairPerAccount(t *testing.T) {
+	f := &fakeKeyServer{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	dir := t.TempDir()
+	t.Setenv("API_URL", srv.URL)
+	t.Setenv("TOKEN_PATH", filepath.Join(dir, "token"))
+	t.Setenv("DH_KEY_PATH", filepath.Join(dir, "dh_key"))
+
+	login := func(username string) *DHKeyPair {
+		t.Helper()
+		if err := SaveTokens(Tokens{AccessToken: "tok", Username: username}); err != nil {
+			t.Fatal(err)
+		}
+		if err := EnsureDHKeyPair(username); err != nil {
+			t.Fatal(err)
+		}
+		kp, err := LoadDHKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kp
+	}
+
+	alice := login("alice")
+	bob := login("bob")
+	if alice.Private.Cmp(bob.Private) == 0 {
+		t.Fatal("second account reused the first account's DH keypair")
+	}
+	if len(f.published) != 2 {
+		t.Fatalf("published %d keys, want 2", len(f.published))
+	}
+
+	// Logging back in as alice keeps her keypair and publishes nothing
+	if again := login("alice"); again.Private.Cmp(alice.Private) != 0 {
+		t.Fatal("alice's DH keypair changed")
+	}
+	if len(f.published) != 2 {
+		t.Fatalf("published %d keys, want 2", len(f.published))
+	}
+}
+
+func TestEnsureDHKeyPairReplacesForeignKey(t *testing.T) {
+	f := &fakeKeyServer{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	dir := t.TempDir()
+	t.Setenv("API_URL", srv.URL)
+	t.Setenv("TOKEN_PATH", filepath.Join(dir, "token"))
+	t.Setenv("DH_KEY_PATH", filepath.Join(dir, "dh_key"))
+	if err := SaveTokens(Tokens{AccessToken: "tok", Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// bob's file holds a keypair generated for alice
+	foreign, _ := json.Marshal(storedDHKey{Username: "alice", PrivateKey: "AQ==", PublicKey: "Ag==", Uploaded: true})
+	if err := os.WriteFile(dhKeyPath("bob"), foreign, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDHKeyPair(); err == nil {
+		t.Fatal("LoadDHKeyPair accepted another account's keypair")
+	}
+	if err := EnsureDHKeyPair("bob"); err != nil {
+		t.Fatal(err)
+	}
+	k, err := readDHKey("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.PublicKey == "Ag==" || !k.Uploaded || len(f.published) != 1 || f.published[0] != k.PublicKey {
+		t.Fatalf("keypair not regenerated and published: %+v, published %v", k, f.published)
+	}
+}
+
+func TestFetchSaltRefusesDowngrade(t *testing.T) {
+	authVersion := authVersionLoginKey
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"username":     r.URL.Query().Get("username"),
+			"kdf_salt":     "c2FsdHNhbHRzYWx0c2FsdA==",
+			"auth_version": authVersion,
+		})
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	t.Setenv("API_URL", srv.URL)
+	t.Setenv("LOGIN_KEY_ACCOUNTS_PATH", filepath.Join(dir, "accounts"))
+
+	// Legacy accounts not seen with a login key still get the password path
+	authVersion = authVersionPassword
+	if _, v, err := fetchSalt("alice"); err != nil || v != authVersionPassword {
+		t.Fatalf("fetchSalt = %d, %v", v, err)
+	}
+
+	rememberLoginKey("alice")
+	if _, v, err := fetchSalt("alice"); err != errLoginDowngrade {
+		t.Fatalf("fetchSalt = %d, %v, want %v", v, err, errLoginDowngrade)
+	}
+	if _, _, err := fetchSalt("bob"); err != nil {
+		t.Fatalf("other account refused: %v", err)
+	}
+
+	authVersion = authVersionLoginKey
+	if _, v, err := fetchSalt("alice"); err != nil || v != authVersionLoginKey {
+		t.Fatalf("fetchSalt = %d, %v", v, err)
+	}
+}
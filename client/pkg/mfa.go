@@ -0,0 +1,158 @@
+package serverpkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ============================================================
+// TWO-FACTOR AUTHENTICATION (TOTP)
+// ============================================================
+//
+// With 2FA enabled, /api/login answers with a short-lived mfa_token instead
+// of tokens; /api/login/mfa trades it and a code from the authenticator app
+// (or a one-time recovery code) for the usual login response. A challenge is
+// good for one attempt only, so a wrong code means logging in again.
+
+// maxMFAAttempts is how many codes Login asks for before giving up.
+const maxMFAAttempts = 3
+
+// twoFactorStatus is the body of GET /api/me/2fa.
+type twoFactorStatus struct {
+	Enabled           bool   `json:"enabled"`
+	Pending           bool   `json:"pending"`
+	EnabledAt         string `json:"enabled_at"`
+	RecoveryCodesLeft int    `json:"recovery_codes_left"`
+}
+
+// completeMFA returns the login response b unchanged unless it is an MFA
+// challenge; then it prompts for a code and returns the response of
+// /api/login/mfa, logging in again with payload to get a fresh challenge
+// after a wrong code.
+func completeMFA(reader *bufio.Reader, payload map[string]string, b []byte) ([]byte, int, error) {
+	status := http.StatusOK
+	for attempt := 1; ; attempt++ {
+		var challenge struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+		}
+		if err := json.Unmarshal(b, &challenge); err != nil || !challenge.MFARequired {
+			return b, status, nil
+		}
+
+		fmt.Print("Authentication code (or recovery code): ")
+		code, _ := reader.ReadString('\n')
+		var err error
+		b, status, err = postJSON("/api/login/mfa", map[string]string{
+			"mfa_token": challenge.MFAToken,
+			"code":      strings.TrimSpace(code),
+		}, false)
+		if err != nil || status != http.StatusUnauthorized || attempt == maxMFAAttempts {
+			return b, status, err
+		}
+
+		fmt.Println("Invalid code, try again")
+		b, status, err = postJSON("/api/login", payload, false)
+		if err != nil || status != http.StatusOK {
+			return b, status, err
+		}
+	}
+}
+
+// ManageTwoFactor shows whether 2FA is on and enables it (printing the
+// otpauth URI and the recovery codes) or disables it.
+func ManageTwoFactor() {
+	reader := bufio.NewReader(os.Stdin)
+	var st twoFactorStatus
+	if err := getJSON("/api/me/2fa", &st); err != nil {
+		LogError("fetch 2FA status failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+
+	if st.Enabled {
+		fmt.Printf("Two-factor authentication is enabled since %s (%d recovery codes left)\n", st.EnabledAt, st.RecoveryCodesLeft)
+		fmt.Print("Disable it? [y/N]: ")
+		answer, _ := reader.ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			return
+		}
+		fmt.Print("Password: ")
+		password, _ := reader.ReadString('\n')
+		loginKey, err := loginKeyFor(strings.TrimSpace(password))
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Print("Authentication code (or recovery code): ")
+		code, _ := reader.ReadString('\n')
+		b, status, err := sendJSON(http.MethodDelete, "/api/me/2fa", map[string]string{
+			"login_key": loginKey,
+			"code":      strings.TrimSpace(code),
+		}, true)
+		if err != nil || status != http.StatusOK {
+			LogError("disable 2FA failed", err)
+			fmt.Printf("disable failed: %d %s\n", status, string(b))
+			return
+		}
+		fmt.Println("Two-factor authentication disabled")
+		return
+	}
+
+	fmt.Println("Two-factor authentication is disabled")
+	fmt.Print("Enable it? [y/N]: ")
+	answer, _ := reader.ReadString('\n')
+	if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		return
+	}
+	fmt.Print("Password: ")
+	password, _ := reader.ReadString('\n')
+	loginKey, err := loginKeyFor(strings.TrimSpace(password))
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	b, status, err := postJSON("/api/me/2fa/enroll", map[string]string{"login_key": loginKey}, true)
+	if err != nil || status != http.StatusOK {
+		LogError("enroll 2FA failed", err)
+		fmt.Printf("enroll failed: %d %s\n", status, string(b))
+		return
+	}
+	var enroll struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+	if err := json.Unmarshal(b, &enroll); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Println("Add this account to your authenticator app (or turn the URI into a QR code):")
+	fmt.Println("  secret:", enroll.Secret)
+	fmt.Println("  uri:   ", enroll.OTPAuthURI)
+
+	fmt.Print("Code shown by the app: ")
+	code, _ := reader.ReadString('\n')
+	b, status, err = postJSON("/api/me/2fa/verify", map[string]string{"code": strings.TrimSpace(code)}, true)
+	if err != nil || status != http.StatusOK {
+		LogError("verify 2FA failed", err)
+		fmt.Printf("verify failed: %d %s\n", status, string(b))
+		fmt.Println("Two-factor authentication was not enabled, enroll again")
+		return
+	}
+	var verified struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	if err := json.Unmarshal(b, &verified); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Println("Two-factor authentication enabled.")
+	fmt.Println("Recovery codes (each works once if you lose the app; they are not shown again):")
+	for _, c := range verified.RecoveryCodes {
+		fmt.Println("  " + c)
+	}
+}
@@ -0,0 +1,437 @@
+package serverpkg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// noteIVMeta mirrors the iv_meta JSON stored with every note. The IVs are
+// also prefixed to each ciphertext, so this is informational only, except
+// Alg which tells whether content uses the chunked format (StreamAlg) or a
+// single AES-GCM message (older notes), and Keywords which holds the
+// search keywords encrypted with K_Note so that edits can rebuild the index.
+type noteIVMeta struct {
+	Alg      string `json:"alg"`
+	IVFile   string `json:"iv_file"`
+	IVTitle  string `json:"iv_title"`
+	IVKey    string `json:"iv_key"`
+	Keywords string `json:"keywords,omitempty"`
+}
+
+// notePayload is the note metadata sent when creating an upload session
+// (POST /api/uploads); the content itself is uploaded in chunks.
+type notePayload struct {
+	Title  string `json:"title"`
+	KeyEnc string `json:"key_enc"`
+	IVMeta string `json:"iv_meta"`
+
+	// SearchTokens is the blind index (see search.go), built from the title
+	// and the keywords stored in iv_meta. It is always sent so that an edit
+	// replaces the tokens the server has, even with an empty list.
+	SearchTokens []string `json:"search_tokens"`
+}
+
+// noteResponse is returned by GET /api/notes/:id, either for the owner
+// (key_enc) or for a recipient of a share (wrapped_key + sender_public_key).
+type noteResponse struct {
+	Title           string `json:"title"`
+	ContentEnc      string `json:"content_enc"`
+	KeyEnc          string `json:"key_enc"`
+	IVMeta          string `json:"iv_meta"`
+	Version         int    `json:"version"`
+	WrappedKey      string `json:"wrapped_key"`
+	SenderPublicKey string `json:"sender_public_key"`
+	Shared          bool   `json:"shared"`
+	// Only for the owner
+	FolderID string   `json:"folder_id"`
+	Tags     []string `json:"tags"`
+}
+
+func ivOf(ciphertext []byte) string {
+	if len(ciphertext) < 12 {
+		return ""
+	}
+	return hex.EncodeToString(ciphertext[:12])
+}
+
+// headerWriter passes writes through and keeps the stream header.
+type headerWriter struct {
+	w      io.Writer
+	header []byte
+}
+
+func (h *headerWriter) Write(p []byte) (int, error) {
+	if need := streamHeaderLen - len(h.header); need > 0 {
+		h.header = append(h.header, p[:min(need, len(p))]...)
+	}
+	return h.w.Write(p)
+}
+
+// encryptNoteStream encrypts src into dst, the title and the keywords under a
+// fresh K_Note, wraps K_Note with K_Master and computes the search tokens.
+// Memory use does not depend on the file size.
+func encryptNoteStream(kMaster []byte, title, keywords string, dst io.Writer, src io.Reader) (*notePayload, error) {
+	kNote, err := GenerateAESKey()
+	if err != nil {
+		return nil, err
+	}
+	defer ZeroizeKey(kNote)
+	return encryptNoteWithKey(kMaster, kNote, title, keywords, dst, src)
+}
+
+// encryptNoteWithKey is encryptNoteStream with an existing K_Note. Edits keep
+// the note key so that users the note was shared with can still read it.
+func encryptNoteWithKey(kMaster []byte, kNote []byte, title, keywords string, dst io.Writer, src io.Reader) (*notePayload, error) {
+	hw := &headerWriter{w: dst}
+	if _, err := EncryptStream(hw, src, kNote); err != nil {
+		return nil, err
+	}
+	titleEnc, err := EncryptFile(kNote, []byte(title))
+	if err != nil {
+		return nil, err
+	}
+	keyEnc, err := EncryptFile(kMaster, kNote)
+	if err != nil {
+		return nil, err
+	}
+	ivMeta := noteIVMeta{
+		Alg:     StreamAlg,
+		IVFile:  hex.EncodeToString(streamNoncePrefix(hw.header)),
+		IVTitle: ivOf(titleEnc),
+		IVKey:   ivOf(keyEnc),
+	}
+	if keywords != "" {
+		keywordsEnc, err := EncryptFile(kNote, []byte(keywords))
+		if err != nil {
+			return nil, err
+		}
+		ivMeta.Keywords = base64.StdEncoding.EncodeToString(keywordsEnc)
+	}
+	meta, err := json.Marshal(ivMeta)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := searchTokens(kMaster, title, keywords)
+	if err != nil {
+		return nil, err
+	}
+	return &notePayload{
+		Title:        base64.StdEncoding.EncodeToString(titleEnc),
+		KeyEnc:       base64.StdEncoding.EncodeToString(keyEnc),
+		IVMeta:       string(meta),
+		SearchTokens: tokens,
+	}, nil
+}
+
+// updateNoteRequest is the body of PUT /api/notes/:id.
+type updateNoteRequest struct {
+	notePayload
+	ContentEnc string `json:"content_enc"`
+}
+
+// noteConflictError is returned when a note changed on the server since it
+// was read (412 Precondition Failed on a stale If-Match).
+type noteConflictError struct {
+	Current int
+}
+
+func (e *noteConflictError) Error() string {
+	return fmt.Sprintf("note was modified on the server (now version %d)", e.Current)
+}
+
+// ifMatch returns the If-Match header for a note revision.
+func ifMatch(version int) map[string]string {
+	return map[string]string{"If-Match": strconv.Quote(strconv.Itoa(version))}
+}
+
+// conditionalNoteRequest sends a request that must only apply to the given
+// revision of a note. A 412 response becomes *noteConflictError.
+func conditionalNoteRequest(method, noteURL string, version int, payload any) ([]byte, int, error) {
+	var body []byte
+	contentType := ""
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, 0, err
+		}
+		contentType = "application/json"
+	}
+	b, status, err := doRequestHeaders(method, noteURL, body, contentType, true, ifMatch(version))
+	if err != nil || status != http.StatusPreconditionFailed {
+		return b, status, err
+	}
+	var resp struct {
+		CurrentVersion int `json:"current_version"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return b, status, fmt.Errorf("note was modified: %s", string(b))
+	}
+	return b, status, &noteConflictError{Current: resp.CurrentVersion}
+}
+
+// noteVersion is one entry of GET /api/notes/:id/versions. Title, KeyEnc and
+// IVMeta are those of that version, so it decrypts like a note of its own.
+type noteVersion struct {
+	Version    int    `json:"version"`
+	Title      string `json:"title"`
+	KeyEnc     string `json:"key_enc"`
+	IVMeta     string `json:"iv_meta"`
+	Size       int64  `json:"size"`
+	CreatedAt  string `json:"created_at"`
+	ArchivedAt string `json:"archived_at"`
+}
+
+// asNote returns v as an owned note for noteKey and noteTitle.
+func (v noteVersion) asNote() *noteResponse {
+	return &noteResponse{Title: v.Title, KeyEnc: v.KeyEnc, IVMeta: v.IVMeta}
+}
+
+// noteHistory mirrors GET /api/notes/:id/versions.
+type noteHistory struct {
+	CurrentVersion int           `json:"current_version"`
+	Versions       []noteVersion `json:"versions"`
+}
+
+// fetchNoteHistory lists the previous versions of noteID, newest first.
+func fetchNoteHistory(noteID string) (*noteHistory, error) {
+	b, status, err := doRequest(http.MethodGet, apiURL()+"/api/notes/"+url.PathEscape(noteID)+"/versions", nil, "", true)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("list versions failed: %d %s", status, string(b))
+	}
+	var h noteHistory
+	if err := json.Unmarshal(b, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// fetchNoteVersion returns the metadata of one version (without content).
+func fetchNoteVersion(noteID string, version int) (*noteVersion, error) {
+	versionURL := fmt.Sprintf("%s/api/notes/%s/versions/%d?content=false", apiURL(), url.PathEscape(noteID), version)
+	b, status, err := doRequest(http.MethodGet, versionURL, nil, "", true)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("get version failed: %d %s", status, string(b))
+	}
+	var v noteVersion
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// noteContentURL is the raw ciphertext of the current note.
+func noteContentURL(noteID string) string {
+	return apiURL() + "/api/notes/" + url.PathEscape(noteID) + "/content"
+}
+
+// versionContentURL is the raw ciphertext of one version of a note.
+func versionContentURL(noteID string, version int) string {
+	return fmt.Sprintf("%s/api/notes/%s/versions/%d/content", apiURL(), url.PathEscape(noteID), version)
+}
+
+// fetchNote downloads the encrypted note from GET /api/notes/:id.
+func fetchNote(noteID string) (*noteResponse, error) {
+	return getNote(apiURL() + "/api/notes/" + url.PathEscape(noteID))
+}
+
+// fetchNoteMeta is fetchNote without content_enc; the content can then be
+// streamed with downloadContent.
+func fetchNoteMeta(noteID string) (*noteResponse, error) {
+	return getNote(apiURL() + "/api/notes/" + url.PathEscape(noteID) + "?content=false")
+}
+
+// maxNotePageSize is the largest page GET /api/notes returns.
+const maxNotePageSize = 200
+
+// noteListEntry is one element of GET /api/notes.
+type noteListEntry struct {
+	ID        string   `json:"id"`
+	Title     string   `json:"title"`
+	Version   int      `json:"version"`
+	Size      int64    `json:"size"`
+	CreatedAt string   `json:"created_at"`
+	UpdatedAt string   `json:"updated_at"`
+	Shared    bool     `json:"shared"`
+	Owner     string   `json:"owner,omitempty"`
+	FolderID  string   `json:"folder_id"`
+	Tags      []string `json:"tags"`
+}
+
+// noteListPage is one page of GET /api/notes; Total and TotalSize cover
+// every matching note, not just this page.
+type noteListPage struct {
+	Notes      []noteListEntry `json:"notes"`
+	Total      int             `json:"total"`
+	TotalSize  int64           `json:"total_size"`
+	NextCursor string          `json:"next_cursor"`
+}
+
+// fetchNoteList fetches one page of GET /api/notes with the query q
+// (limit, cursor, sort, order, scope, from, to, token).
+func fetchNoteList(q url.Values) (*noteListPage, error) {
+	b, status, err := doRequest(http.MethodGet, apiURL()+"/api/notes?"+q.Encode(), nil, "", true)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("list notes failed: %d %s", status, string(b))
+	}
+	var page noteListPage
+	if err := json.Unmarshal(b, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func getNote(noteURL string) (*noteResponse, error) {
+	b, status, err := doRequest(http.MethodGet, noteURL, nil, "", true)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("get note failed: %d %s", status, string(b))
+	}
+	var n noteResponse
+	if err := json.Unmarshal(b, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// noteKey unwraps K_Note: with K_Master for own notes, or with the DH
+// session key K_Session = HKDF(A^b mod p) for notes shared with us.
+func noteKey(n *noteResponse) ([]byte, error) {
+	if !n.Shared {
+		kMaster, err := getMasterKey()
+		if err != nil {
+			return nil, err
+		}
+		keyEnc, err := base64.StdEncoding.DecodeString(n.KeyEnc)
+		if err != nil {
+			return nil, err
+		}
+		return DecryptFile(kMaster, keyEnc)
+	}
+
+	kp, err := LoadDHKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("load DH keypair: %w", err)
+	}
+	senderPub, err := DecodeDHPublicKey(n.SenderPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	kSession, err := dhSessionKey(senderPub, kp)
+	if err != nil {
+		return nil, err
+	}
+	defer ZeroizeKey(kSession)
+	wrapped, err := base64.StdEncoding.DecodeString(n.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptFile(kSession, wrapped)
+}
+
+// noteAlg returns the content algorithm recorded in iv_meta ("" if unknown).
+func noteAlg(ivMeta string) string {
+	var meta noteIVMeta
+	if err := json.Unmarshal([]byte(ivMeta), &meta); err != nil {
+		return ""
+	}
+	return meta.Alg
+}
+
+// errNoteTampered hides whether the key or the ciphertext was wrong.
+var errNoteTampered = errors.New("note content was tampered with or key is wrong")
+
+// decryptContent decrypts note content from src into dst according to the
+// algorithm in iv_meta. Chunked content is streamed; older single-message
+// content (at most 50 MB) is buffered.
+func decryptContent(kNote []byte, ivMeta string, dst io.Writer, src io.Reader) (int64, error) {
+	if noteAlg(ivMeta) == StreamAlg {
+		n, err := DecryptStream(dst, src, kNote)
+		if errors.Is(err, ErrStreamCorrupt) {
+			return n, errNoteTampered
+		}
+		return n, err
+	}
+	contentEnc, err := io.ReadAll(src)
+	if err != nil {
+		return 0, err
+	}
+	content, err := DecryptFile(kNote, contentEnc)
+	if err != nil {
+		return 0, errNoteTampered
+	}
+	defer ZeroizeKey(content)
+	n, err := dst.Write(content)
+	return int64(n), err
+}
+
+// noteKeywords decrypts the keywords stored in iv_meta ("" for notes
+// uploaded before keywords were stored, or if they cannot be decrypted).
+func noteKeywords(ivMeta string, kNote []byte) string {
+	var meta noteIVMeta
+	if err := json.Unmarshal([]byte(ivMeta), &meta); err != nil || meta.Keywords == "" {
+		return ""
+	}
+	keywordsEnc, err := base64.StdEncoding.DecodeString(meta.Keywords)
+	if err != nil {
+		return ""
+	}
+	k, err := DecryptFile(kNote, keywordsEnc)
+	if err != nil {
+		return ""
+	}
+	return string(k)
+}
+
+// noteTitle decrypts the title of a fetched note ("" if it cannot).
+func noteTitle(n *noteResponse, kNote []byte) string {
+	if n.Title == "" {
+		return ""
+	}
+	titleEnc, err := base64.StdEncoding.DecodeString(n.Title)
+	if err != nil {
+		return ""
+	}
+	t, err := DecryptFile(kNote, titleEnc)
+	if err != nil {
+		return ""
+	}
+	return string(t)
+}
+
+// decryptNote returns the plaintext content and title of a fetched note.
+func decryptNote(n *noteResponse) (content []byte, title string, err error) {
+	kNote, err := noteKey(n)
+	if err != nil {
+		return nil, "", fmt.Errorf("unwrap note key: %w", err)
+	}
+	defer ZeroizeKey(kNote)
+
+	contentEnc, err := base64.StdEncoding.DecodeString(n.ContentEnc)
+	if err != nil {
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	if _, err := decryptContent(kNote, n.IVMeta, &buf, bytes.NewReader(contentEnc)); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), noteTitle(n, kNote), nil
+}
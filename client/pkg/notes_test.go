@@ -0,0 +1,78 @@
+package serverpkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEncryptNoteStoresKeywords(t *testing.T) {
+	t.Setenv("TOKEN_PATH", filepath.Join(t.TempDir(), "token"))
+	kMaster, kNote := streamTestKey(t), streamTestKey(t)
+
+	var dst bytes.Buffer
+	p, err := encryptNoteWithKey(kMaster, kNote, "old title", "alpha, beta", &dst, strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := noteKeywords(p.IVMeta, kNote); got != "alpha, beta" {
+		t.Fatalf("keywords = %q", got)
+	}
+	if strings.Contains(p.IVMeta, "alpha") {
+		t.Fatal("keywords stored in plaintext")
+	}
+	want, err := searchTokens(kMaster, "old title", "alpha, beta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(p.SearchTokens, want) {
+		t.Fatalf("tokens = %v, want %v", p.SearchTokens, want)
+	}
+
+	// A rename rebuilds the index from the new title and the stored keywords
+	edited, err := encryptNoteWithKey(kMaster, kNote, "renamed", noteKeywords(p.IVMeta, kNote), &dst, strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenOf := func(word string) string {
+		tokens, err := searchTokens(kMaster, word)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokens[0]
+	}
+	for _, word := range []string{"renamed", "alpha", "beta"} {
+		if !slices.Contains(edited.SearchTokens, tokenOf(word)) {
+			t.Fatalf("%q is not indexed after rename", word)
+		}
+	}
+	if slices.Contains(edited.SearchTokens, tokenOf("old")) {
+		t.Fatal("old title is still indexed after rename")
+	}
+}
+
+func TestEncryptNoteSendsEmptyTokens(t *testing.T) {
+	t.Setenv("TOKEN_PATH", filepath.Join(t.TempDir(), "token"))
+	kMaster, kNote := streamTestKey(t), streamTestKey(t)
+
+	var dst bytes.Buffer
+	p, err := encryptNoteWithKey(kMaster, kNote, "x", "", &dst, strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := noteKeywords(p.IVMeta, kNote); got != "" {
+		t.Fatalf("keywords = %q, want none", got)
+	}
+	// The server keeps its tokens when search_tokens is missing, so an edit
+	// that leaves no keywords must send an empty list to clear them
+	b, err := json.Marshal(updateNoteRequest{notePayload: *p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"search_tokens":[]`)) {
+		t.Fatalf("request = %s, want an empty search_tokens list", b)
+	}
+}
@@ -0,0 +1,372 @@
+package serverpkg
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ============================================================
+// PASSWORD CHANGE - Re-wrapping the keyring
+// ============================================================
+//
+// K_Master derives from the password, so a new password means a new
+// K_Master. Everything wrapped with the old one (note keys of notes,
+// versions and pending uploads, folder names, tag labels, the recovery key)
+// is fetched from GET /api/me/keyring, decrypted with the old K_Master,
+// encrypted with the new one and sent back with the new login key in a single
+// request. Note keys stay the same, so content and shares are untouched.
+// K_Search is kept too (the server has no keywords to rebuild the index
+// with); it is sent wrapped with the new K_Master as search_key_enc.
+
+// changePasswordRetries is how many times the keyring is fetched again when
+// notes changed while it was being re-wrapped (409).
+const changePasswordRetries = 3
+
+// keyringKey is a K_Note wrapped with K_Master.
+type keyringKey struct {
+	ID     string `json:"id"`
+	KeyEnc string `json:"key_enc"`
+	IVMeta string `json:"iv_meta,omitempty"`
+}
+
+// keyringVersionKey is the K_Note of an old version.
+type keyringVersionKey struct {
+	NoteID  string `json:"note_id"`
+	Version int    `json:"version"`
+	KeyEnc  string `json:"key_enc"`
+	IVMeta  string `json:"iv_meta,omitempty"`
+}
+
+type keyringFolder struct {
+	ID      string `json:"id"`
+	NameEnc string `json:"name_enc"`
+}
+
+type keyringTag struct {
+	ID       string `json:"id"`
+	LabelEnc string `json:"label_enc"`
+}
+
+// keyringRecovery is the recovery key (see recovery.go): K_Master wrapped
+// with the wrap key, and the wrap key wrapped with K_Master.
+type keyringRecovery struct {
+	MasterKeyEnc string `json:"master_key_enc"`
+	KeyEnc       string `json:"key_enc"`
+}
+
+// keyring is the body of GET /api/me/keyring (without kdf_salt) and the
+// keyring field of POST /api/me/password.
+type keyring struct {
+	Notes        []keyringKey        `json:"notes"`
+	Versions     []keyringVersionKey `json:"versions"`
+	Uploads      []keyringKey        `json:"uploads"`
+	Folders      []keyringFolder     `json:"folders"`
+	Tags         []keyringTag        `json:"tags"`
+	SearchKeyEnc string              `json:"search_key_enc,omitempty"`
+	Recovery     *keyringRecovery    `json:"recovery,omitempty"`
+}
+
+// rewrapper re-encrypts values from one K_Master to another.
+type rewrapper struct {
+	oldKey, newKey []byte
+}
+
+// rewrap decrypts a base64 value with the old key and encrypts it with the
+// new one. It also returns the new ciphertext for callers that need its IV.
+func (r rewrapper) rewrap(enc string) (string, []byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return "", nil, err
+	}
+	plain, err := DecryptFile(r.oldKey, raw)
+	if err != nil {
+		return "", nil, err
+	}
+	defer ZeroizeKey(plain)
+	out, err := EncryptFile(r.newKey, plain)
+	if err != nil {
+		return "", nil, err
+	}
+	return base64.StdEncoding.EncodeToString(out), out, nil
+}
+
+// rewrapKey re-wraps a K_Note and updates iv_key in its iv_meta, keeping
+// any other field as is.
+func (r rewrapper) rewrapKey(keyEnc, ivMeta string) (string, string, error) {
+	enc, raw, err := r.rewrap(keyEnc)
+	if err != nil {
+		return "", "", err
+	}
+	meta := map[string]any{}
+	if ivMeta != "" {
+		if err := json.Unmarshal([]byte(ivMeta), &meta); err != nil {
+			// Not JSON: leave it to the server to keep the old value
+			return enc, "", nil
+		}
+	}
+	meta["iv_key"] = ivOf(raw)
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return "", "", err
+	}
+	return enc, string(b), nil
+}
+
+// rewrapKeyring re-wraps every entry of k in place.
+func (r rewrapper) rewrapKeyring(k *keyring) error {
+	var err error
+	for i := range k.Notes {
+		n := &k.Notes[i]
+		if n.KeyEnc, n.IVMeta, err = r.rewrapKey(n.KeyEnc, n.IVMeta); err != nil {
+			return fmt.Errorf("note %s: %w", n.ID, err)
+		}
+	}
+	for i := range k.Versions {
+		v := &k.Versions[i]
+		if v.KeyEnc, v.IVMeta, err = r.rewrapKey(v.KeyEnc, v.IVMeta); err != nil {
+			return fmt.Errorf("note %s version %d: %w", v.NoteID, v.Version, err)
+		}
+	}
+	for i := range k.Uploads {
+		u := &k.Uploads[i]
+		if u.KeyEnc, u.IVMeta, err = r.rewrapKey(u.KeyEnc, u.IVMeta); err != nil {
+			return fmt.Errorf("upload %s: %w", u.ID, err)
+		}
+	}
+	for i := range k.Folders {
+		f := &k.Folders[i]
+		if f.NameEnc, _, err = r.rewrap(f.NameEnc); err != nil {
+			return fmt.Errorf("folder %s: %w", f.ID, err)
+		}
+	}
+	for i := range k.Tags {
+		t := &k.Tags[i]
+		if t.LabelEnc, _, err = r.rewrap(t.LabelEnc); err != nil {
+			return fmt.Errorf("tag %s: %w", t.ID, err)
+		}
+	}
+	if k.SearchKeyEnc, err = r.wrapSearchKey(k.SearchKeyEnc); err != nil {
+		return fmt.Errorf("search key: %w", err)
+	}
+	if k.Recovery != nil {
+		if err := r.rewrapRecovery(k.Recovery); err != nil {
+			return fmt.Errorf("recovery key: %w", err)
+		}
+	}
+	return nil
+}
+
+// wrapSearchKey wraps the current K_Search (see unwrapSearchKey) with the
+// new K_Master.
+func (r rewrapper) wrapSearchKey(enc string) (string, error) {
+	kSearch, err := unwrapSearchKey(r.oldKey, enc)
+	if err != nil {
+		return "", err
+	}
+	defer ZeroizeKey(kSearch)
+	out, err := EncryptFile(r.newKey, kSearch)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// rewrapUploadStates re-wraps the note key of pending uploads saved on disk,
+// so that a later resume sends a key the new password can open.
+func (r rewrapper) rewrapUploadStates() {
+	paths, _ := filepath.Glob(filepath.Join(uploadStateDir(), "*.json"))
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		var st uploadState
+		if err := json.Unmarshal(b, &st); err != nil {
+			continue
+		}
+		keyEnc, ivMeta, err := r.rewrapKey(st.Note.KeyEnc, st.Note.IVMeta)
+		if err != nil {
+			LogError("re-wrap pending upload failed", err)
+			continue
+		}
+		st.Note.KeyEnc = keyEnc
+		if ivMeta != "" {
+			st.Note.IVMeta = ivMeta
+		}
+		if err := st.save(); err != nil {
+			LogError("save pending upload failed", err)
+		}
+	}
+}
+
+// fetchKeyring returns the current keyring and kdf salt.
+func fetchKeyring() (*keyring, string, error) {
+	var resp struct {
+		KdfSalt string `json:"kdf_salt"`
+		keyring
+	}
+	if err := getJSON("/api/me/keyring", &resp); err != nil {
+		return nil, "", err
+	}
+	return &resp.keyring, resp.KdfSalt, nil
+}
+
+// ChangePassword asks for the current and a new password, re-wraps the
+// keyring with the new K_Master and switches this session to it. Other
+// sessions are logged out by the server.
+func ChangePassword() {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Current password: ")
+	oldPassword, _ := reader.ReadString('\n')
+	oldPassword = strings.TrimSpace(oldPassword)
+	fmt.Print("New password: ")
+	newPassword, _ := reader.ReadString('\n')
+	newPassword = strings.TrimSpace(newPassword)
+	fmt.Print("Repeat new password: ")
+	repeat, _ := reader.ReadString('\n')
+	if strings.TrimSpace(repeat) != newPassword {
+		fmt.Println("passwords do not match")
+		return
+	}
+	if newPassword == oldPassword {
+		fmt.Println("the new password must differ from the current one")
+		return
+	}
+	if err := ValidatePassword(newPassword); err != nil {
+		fmt.Println("invalid password:", err)
+		return
+	}
+
+	b, status, err := changePassword(oldPassword, newPassword)
+	if err != nil {
+		LogError("change password failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	if status != http.StatusOK {
+		LogInfo(fmt.Sprintf("change password failed: %d", status))
+		if msg, ok := lockoutMessage(status, b); ok {
+			fmt.Println("change password failed:", msg)
+			return
+		}
+		fmt.Printf("change password failed: %d %s\n", status, string(b))
+		return
+	}
+	fmt.Println("Password changed; other sessions have been logged out")
+}
+
+// changePassword runs the re-wrap and POST /api/me/password, fetching the
+// keyring again after a 409. The account always ends up on a login key, so
+// upgradeLogin uses it with the same password. On success the new K_Master
+// and tokens are in use.
+func changePassword(oldPassword, newPassword string) ([]byte, int, error) {
+	t, err := LoadTokens()
+	if err != nil {
+		return nil, 0, err
+	}
+	newSalt, err := GenerateSalt()
+	if err != nil {
+		return nil, 0, err
+	}
+	newLoginKey, newKey, err := deriveAccountKeys(newPassword, newSalt, authVersionLoginKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer ZeroizeKey(newLoginKey)
+	keepNewKey := false
+	defer func() {
+		if !keepNewKey {
+			ZeroizeKey(newKey)
+		}
+	}()
+
+	var oldLoginKey string
+	fetch := func() (*keyring, []byte, error) {
+		k, kdfSalt, err := fetchKeyring()
+		if err != nil {
+			return nil, nil, err
+		}
+		salt, err := base64.StdEncoding.DecodeString(kdfSalt)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid kdf salt: %w", err)
+		}
+		loginKey, oldKey, err := deriveAccountKeys(oldPassword, salt, t.AuthVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+		oldLoginKey = base64.StdEncoding.EncodeToString(loginKey)
+		ZeroizeKey(loginKey)
+		return k, oldKey, nil
+	}
+	submit := func(k *keyring) ([]byte, int, error) {
+		body := map[string]any{
+			"new_login_key": base64.StdEncoding.EncodeToString(newLoginKey),
+			"kdf_salt":      base64.StdEncoding.EncodeToString(newSalt),
+			"keyring":       k,
+		}
+		// Accounts without a login key prove the old password itself
+		if t.AuthVersion == authVersionPassword {
+			body["old_password"] = oldPassword
+		} else {
+			body["old_login_key"] = oldLoginKey
+		}
+		return postJSON("/api/me/password", body, true)
+	}
+	b, status, err := submitRewrapped(fetch, submit, newKey)
+	if err != nil || status != http.StatusOK {
+		return b, status, err
+	}
+
+	var resp Tokens
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, 0, err
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" {
+		return nil, 0, errors.New("password change response missing tokens")
+	}
+	resp.AuthVersion = authVersionLoginKey
+	if old, err := LoadTokens(); err == nil {
+		resp.Username = old.Username
+	}
+	rememberLoginKey(resp.Username)
+	if err := SaveTokens(resp); err != nil {
+		return nil, 0, err
+	}
+	keepNewKey = true
+	useMasterKey(newKey)
+	return b, status, nil
+}
+
+// submitRewrapped re-wraps the keyring returned by fetch (with the K_Master
+// it is wrapped with) under newKey and sends it with submit, starting over
+// after a 409 up to changePasswordRetries times.
+func submitRewrapped(fetch func() (*keyring, []byte, error), submit func(*keyring) ([]byte, int, error), newKey []byte) ([]byte, int, error) {
+	for attempt := 1; ; attempt++ {
+		k, oldKey, err := fetch()
+		if err != nil {
+			return nil, 0, err
+		}
+		r := rewrapper{oldKey: oldKey, newKey: newKey}
+		if err := r.rewrapKeyring(k); err != nil {
+			ZeroizeKey(oldKey)
+			return nil, 0, fmt.Errorf("cannot decrypt keys with the current password (wrong password?): %w", err)
+		}
+
+		b, status, err := submit(k)
+		if err == nil && status == http.StatusOK {
+			r.rewrapUploadStates()
+		}
+		ZeroizeKey(oldKey)
+		if err != nil || status != http.StatusConflict || attempt == changePasswordRetries {
+			return b, status, err
+		}
+		LogInfo("keyring changed during password change, retrying")
+	}
+}
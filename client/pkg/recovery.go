@@ -0,0 +1,391 @@
+package serverpkg
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// ============================================================
+// RECOVERY KEY - Resetting a forgotten password
+// ============================================================
+//
+// The recovery key is 128 random bits shown once as 17 words (16 key bytes
+// and a checksum byte, one word each). Two keys are derived from it with
+// HKDF: the wrap key, which wraps K_Master, and the auth key, which proves
+// to the server that the user holds the recovery key (the server keeps only
+// its SHA256). The wrap key is also stored wrapped with K_Master so that a
+// password change can re-wrap the new K_Master without asking for the
+// recovery key again.
+//
+// Recovering: POST /api/recovery with the auth key returns K_Master wrapped
+// with the wrap key and the keyring; the client re-wraps everything with the
+// K_Master of a new password, exactly like a password change, and sends it
+// to POST /api/recovery/complete. The recovery key keeps working afterwards.
+
+const (
+	// recoveryKeyLen is the recovery key size in bytes.
+	recoveryKeyLen = 16
+
+	// HKDF info labels of the keys derived from the recovery key.
+	recoveryWrapInfo = "Secure-Notes-Recovery-Wrap"
+	recoveryAuthInfo = "Secure-Notes-Recovery-Auth"
+)
+
+// recoveryWords encodes one byte per word. The words are sorted and their
+// first four letters are unique, so each word may be shortened to those.
+var recoveryWords = [256]string{
+	"acid", "acorn", "actor", "adapt", "admit", "adult", "agent", "album", "alert", "alien", "alley",
+	"amber", "anchor", "angle", "ankle", "apple", "april", "arena", "armor", "arrow", "atlas",
+	"attic", "autumn", "badge", "bagel", "baker", "bamboo", "banana", "banjo", "barn", "basket",
+	"beach", "bean", "beaver", "bell", "berry", "bicycle", "bishop", "blanket", "blossom", "board",
+	"bonus", "border", "bottle", "bounce", "bracket", "branch", "brave", "bread", "breeze", "brick",
+	"bridge", "bronze", "brush", "bubble", "bucket", "budget", "buffalo", "butter", "cabin", "cactus",
+	"camel", "canal", "candle", "canoe", "canvas", "canyon", "carbon", "carpet", "carrot", "castle",
+	"cedar", "cement", "cherry", "chess", "circle", "citrus", "cliff", "clock", "cloud", "clover",
+	"coast", "cobalt", "coconut", "coffee", "comet", "copper", "coral", "cotton", "cousin", "coyote",
+	"crane", "crayon", "cricket", "crystal", "curtain", "cushion", "daisy", "dancer", "delta",
+	"denim", "desert", "diamond", "dinner", "dolphin", "donkey", "dragon", "drum", "eagle", "earth",
+	"echo", "elbow", "ember", "engine", "falcon", "feather", "fence", "fiddle", "finger", "forest",
+	"fossil", "fox", "frost", "galaxy", "garden", "garlic", "giant", "ginger", "glacier", "globe",
+	"gold", "gorilla", "grape", "gravel", "guitar", "hammer", "harbor", "harvest", "hazel", "helmet",
+	"heron", "hollow", "honey", "horizon", "hunter", "igloo", "island", "ivory", "jacket", "jaguar",
+	"jasmine", "jelly", "jigsaw", "jungle", "kettle", "kiwi", "koala", "ladder", "lagoon", "lantern",
+	"laptop", "lemon", "leopard", "lettuce", "lizard", "lobster", "magnet", "mango", "maple",
+	"marble", "meadow", "melon", "mirror", "monkey", "mosaic", "motor", "mountain", "muffin",
+	"museum", "napkin", "nectar", "needle", "nickel", "noodle", "nutmeg", "oasis", "ocean", "olive",
+	"onion", "orange", "orbit", "orchid", "otter", "oyster", "paddle", "panda", "paper", "parrot",
+	"peanut", "pebble", "pencil", "pepper", "piano", "pillow", "pilot", "planet", "plum", "pocket",
+	"potato", "prism", "pumpkin", "puzzle", "quartz", "quiver", "rabbit", "radar", "raven", "ribbon",
+	"river", "robot", "rocket", "saddle", "salmon", "sandal", "saturn", "scarf", "shadow", "silver",
+	"sketch", "sparrow", "spider", "spinach", "stadium", "summer", "sunset", "tablet", "teapot",
+	"thunder", "tiger", "tomato", "trumpet", "tulip", "turtle", "unicorn", "valley", "velvet",
+	"violin", "volcano", "walnut", "walrus", "window", "winter", "wizard", "yogurt", "zebra",
+	"zipper",
+}
+
+// recoveryWordIndex maps every word and its four letter prefix to its byte.
+var recoveryWordIndex = func() map[string]byte {
+	m := make(map[string]byte, 2*len(recoveryWords))
+	for i, w := range recoveryWords {
+		m[w] = byte(i)
+		if len(w) > 4 {
+			m[w[:4]] = byte(i)
+		}
+	}
+	return m
+}()
+
+// encodeRecoveryKey returns the words of key followed by a checksum word.
+func encodeRecoveryKey(key []byte) string {
+	sum := sha256.Sum256(key)
+	words := make([]string, 0, len(key)+1)
+	for _, b := range append(key[:len(key):len(key)], sum[0]) {
+		words = append(words, recoveryWords[b])
+	}
+	return strings.Join(words, " ")
+}
+
+// decodeRecoveryKey parses the words of a recovery key and checks the
+// checksum, which catches most typos and swapped words.
+func decodeRecoveryKey(phrase string) ([]byte, error) {
+	fields := strings.Fields(strings.ToLower(phrase))
+	if len(fields) != recoveryKeyLen+1 {
+		return nil, fmt.Errorf("a recovery key has %d words, got %d", recoveryKeyLen+1, len(fields))
+	}
+	raw := make([]byte, 0, len(fields))
+	for i, f := range fields {
+		b, ok := recoveryWordIndex[f]
+		if !ok {
+			return nil, fmt.Errorf("word %d (%q) is not a recovery key word", i+1, f)
+		}
+		raw = append(raw, b)
+	}
+	key := raw[:recoveryKeyLen]
+	if sum := sha256.Sum256(key); sum[0] != raw[recoveryKeyLen] {
+		return nil, errors.New("recovery key checksum does not match, check the words and their order")
+	}
+	return key, nil
+}
+
+// deriveRecoveryKeys derives the wrap key and the auth key from a recovery key.
+func deriveRecoveryKeys(key []byte) (wrapKey, authKey []byte, err error) {
+	derive := func(info string) ([]byte, error) {
+		out := make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(info)), out); err != nil {
+			return nil, fmt.Errorf("derive recovery key: %w", err)
+		}
+		return out, nil
+	}
+	if wrapKey, err = derive(recoveryWrapInfo); err != nil {
+		return nil, nil, err
+	}
+	if authKey, err = derive(recoveryAuthInfo); err != nil {
+		ZeroizeKey(wrapKey)
+		return nil, nil, err
+	}
+	return wrapKey, authKey, nil
+}
+
+// wrapRecovery wraps kMaster with the wrap key and the wrap key with kMaster.
+func wrapRecovery(wrapKey, kMaster []byte) (*keyringRecovery, error) {
+	masterEnc, err := EncryptFile(wrapKey, kMaster)
+	if err != nil {
+		return nil, err
+	}
+	keyEnc, err := EncryptFile(kMaster, wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	return &keyringRecovery{
+		MasterKeyEnc: base64.StdEncoding.EncodeToString(masterEnc),
+		KeyEnc:       base64.StdEncoding.EncodeToString(keyEnc),
+	}, nil
+}
+
+// rewrapRecovery re-wraps the recovery entry of the keyring for the new K_Master.
+func (r rewrapper) rewrapRecovery(rec *keyringRecovery) error {
+	raw, err := base64.StdEncoding.DecodeString(rec.KeyEnc)
+	if err != nil {
+		return err
+	}
+	wrapKey, err := DecryptFile(r.oldKey, raw)
+	if err != nil {
+		return err
+	}
+	defer ZeroizeKey(wrapKey)
+	next, err := wrapRecovery(wrapKey, r.newKey)
+	if err != nil {
+		return err
+	}
+	*rec = *next
+	return nil
+}
+
+// createRecoveryKey generates a recovery key for the logged in user, stores
+// it on the server (PUT /api/me/recovery) and prints it once.
+func createRecoveryKey(password string) error {
+	kMaster, err := getMasterKey()
+	if err != nil {
+		return err
+	}
+	loginKey, err := loginKeyFor(password)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, recoveryKeyLen)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	defer ZeroizeKey(key)
+	wrapKey, authKey, err := deriveRecoveryKeys(key)
+	if err != nil {
+		return err
+	}
+	defer ZeroizeKey(wrapKey)
+	defer ZeroizeKey(authKey)
+	rec, err := wrapRecovery(wrapKey, kMaster)
+	if err != nil {
+		return err
+	}
+
+	b, status, err := sendJSON(http.MethodPut, "/api/me/recovery", map[string]string{
+		"login_key":      loginKey,
+		"auth_key":       base64.StdEncoding.EncodeToString(authKey),
+		"master_key_enc": rec.MasterKeyEnc,
+		"key_enc":        rec.KeyEnc,
+	}, true)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("save recovery key failed: %d %s", status, string(b))
+	}
+
+	fmt.Println("\nRecovery key (shown only once; write it down and keep it offline):")
+	fmt.Println("  " + encodeRecoveryKey(key))
+	fmt.Println("With it you can set a new password if you forget yours (Recover Account).")
+	fmt.Println("Anyone holding it and your username can do the same.")
+	return nil
+}
+
+// ManageRecoveryKey shows whether a recovery key exists and creates,
+// replaces or removes it.
+func ManageRecoveryKey() {
+	reader := bufio.NewReader(os.Stdin)
+	var st struct {
+		Enabled   bool   `json:"enabled"`
+		CreatedAt string `json:"created_at"`
+	}
+	if err := getJSON("/api/me/recovery", &st); err != nil {
+		LogError("fetch recovery key status failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+
+	action := "c"
+	if st.Enabled {
+		fmt.Println("A recovery key was created on", st.CreatedAt)
+		fmt.Print("[r]eplace it, [d]elete it (empty = back): ")
+		answer, _ := reader.ReadString('\n')
+		action = strings.ToLower(strings.TrimSpace(answer))
+	} else {
+		fmt.Println("No recovery key: a forgotten password means losing every note")
+		fmt.Print("Create one? [y/N]: ")
+		answer, _ := reader.ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			action = ""
+		}
+	}
+	if action != "c" && action != "r" && action != "d" {
+		return
+	}
+
+	fmt.Print("Password: ")
+	password, _ := reader.ReadString('\n')
+	password = strings.TrimSpace(password)
+	if action == "d" {
+		loginKey, err := loginKeyFor(password)
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		b, status, err := sendJSON(http.MethodDelete, "/api/me/recovery", map[string]string{"login_key": loginKey}, true)
+		if err != nil || status != http.StatusOK {
+			LogError("delete recovery key failed", err)
+			fmt.Printf("delete failed: %d %s\n", status, string(b))
+			return
+		}
+		fmt.Println("Recovery key deleted")
+		return
+	}
+	if err := createRecoveryKey(password); err != nil {
+		LogError("create recovery key failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	if action == "r" {
+		fmt.Println("The previous recovery key no longer works")
+	}
+}
+
+// RecoverAccount sets a new password with the recovery key. The user logs
+// in with the new password afterwards.
+func RecoverAccount() {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Username: ")
+	username, _ := reader.ReadString('\n')
+	username = strings.TrimSpace(username)
+	fmt.Print("Recovery key (words separated by spaces): ")
+	phrase, _ := reader.ReadString('\n')
+	key, err := decodeRecoveryKey(phrase)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	wrapKey, authKey, err := deriveRecoveryKeys(key)
+	ZeroizeKey(key)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	defer ZeroizeKey(wrapKey)
+	defer ZeroizeKey(authKey)
+
+	fmt.Print("New password: ")
+	newPassword, _ := reader.ReadString('\n')
+	newPassword = strings.TrimSpace(newPassword)
+	fmt.Print("Repeat new password: ")
+	repeat, _ := reader.ReadString('\n')
+	if strings.TrimSpace(repeat) != newPassword {
+		fmt.Println("passwords do not match")
+		return
+	}
+	if err := ValidatePassword(newPassword); err != nil {
+		fmt.Println("invalid password:", err)
+		return
+	}
+
+	b, status, err := recoverAccount(username, wrapKey, authKey, newPassword)
+	if err != nil {
+		LogError("account recovery failed", err)
+		fmt.Println("error:", err)
+		return
+	}
+	if status != http.StatusOK {
+		LogInfo(fmt.Sprintf("account recovery failed: %d", status))
+		fmt.Printf("recovery failed: %d %s\n", status, string(b))
+		return
+	}
+	rememberLoginKey(username)
+	fmt.Println("Password reset; log in with the new password. Other sessions have been logged out.")
+}
+
+// recoverAccount proves the recovery key, re-wraps the keyring under the
+// K_Master of newPassword and completes the recovery.
+func recoverAccount(username string, wrapKey, authKey []byte, newPassword string) ([]byte, int, error) {
+	newSalt, err := GenerateSalt()
+	if err != nil {
+		return nil, 0, err
+	}
+	newLoginKey, newKey, err := deriveAccountKeys(newPassword, newSalt, authVersionLoginKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer ZeroizeKey(newLoginKey)
+	defer ZeroizeKey(newKey)
+
+	var token string
+	fetch := func() (*keyring, []byte, error) {
+		b, status, err := postJSON("/api/recovery", map[string]string{
+			"username": username,
+			"auth_key": base64.StdEncoding.EncodeToString(authKey),
+		}, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if status != http.StatusOK {
+			if msg, ok := lockoutMessage(status, b); ok {
+				return nil, nil, errors.New(msg)
+			}
+			return nil, nil, fmt.Errorf("recovery failed: %d %s", status, string(b))
+		}
+		var resp struct {
+			RecoveryToken string  `json:"recovery_token"`
+			MasterKeyEnc  string  `json:"master_key_enc"`
+			Keyring       keyring `json:"keyring"`
+		}
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return nil, nil, err
+		}
+		raw, err := base64.StdEncoding.DecodeString(resp.MasterKeyEnc)
+		if err != nil {
+			return nil, nil, err
+		}
+		oldKey, err := DecryptFile(wrapKey, raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("recovery key does not open the account key: %w", err)
+		}
+		token = resp.RecoveryToken
+		return &resp.Keyring, oldKey, nil
+	}
+	submit := func(k *keyring) ([]byte, int, error) {
+		return postJSON("/api/recovery/complete", map[string]any{
+			"recovery_token": token,
+			"new_login_key":  base64.StdEncoding.EncodeToString(newLoginKey),
+			"kdf_salt":       base64.StdEncoding.EncodeToString(newSalt),
+			"keyring":        k,
+		}, false)
+	}
+	return submitRewrapped(fetch, submit, newKey)
+}
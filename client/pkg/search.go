@@ -0,0 +1,128 @@
+package serverpkg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// ============================================================
+// ENCRYPTED SEARCH - Blind index tokens
+// ============================================================
+//
+// Keywords (words of the title and of the tags the user enters) are
+// normalized and turned into tokens = base64url(HMAC-SHA256(K_Search, word)),
+// K_Search = HKDF(K_Master, info="Secure-Notes-Search-Index") (kept, wrapped
+// with the new K_Master, when the password changes). The tokens are
+// uploaded with the note; GET /api/notes?token=... returns the notes that
+// have every token, so the server never sees the words themselves.
+
+// searchKeyInfo is the HKDF info label separating K_Search from other keys.
+const searchKeyInfo = "Secure-Notes-Search-Index"
+
+// minKeywordLen drops one-letter words, which would only add noise.
+const minKeywordLen = 2
+
+// deriveSearchKey derives K_Search from K_Master (like DeriveSessionKey does
+// for the DH shared secret).
+func deriveSearchKey(kMaster []byte) ([]byte, error) {
+	r := hkdf.New(sha256.New, kMaster, nil, []byte(searchKeyInfo))
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, fmt.Errorf("derive search key: %w", err)
+	}
+	return key, nil
+}
+
+// searchKey returns K_Search: unwrapped from the stored search_key_enc once
+// the password was changed, else derived from K_Master.
+func searchKey(kMaster []byte) ([]byte, error) {
+	var enc string
+	if t, err := LoadTokens(); err == nil {
+		enc = t.SearchKeyEnc
+	}
+	return unwrapSearchKey(kMaster, enc)
+}
+
+// unwrapSearchKey returns K_Search from a search_key_enc ("" = derived from K_Master).
+func unwrapSearchKey(kMaster []byte, enc string) ([]byte, error) {
+	if enc == "" {
+		return deriveSearchKey(kMaster)
+	}
+	raw, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search key: %w", err)
+	}
+	key, err := DecryptFile(kMaster, raw)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap search key: %w", err)
+	}
+	return key, nil
+}
+
+// normalizeKeywords lowercases texts and splits them into unique words of
+// letters and digits.
+func normalizeKeywords(texts ...string) []string {
+	seen := map[string]bool{}
+	var words []string
+	for _, text := range texts {
+		fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for _, w := range fields {
+			if len([]rune(w)) < minKeywordLen || seen[w] {
+				continue
+			}
+			seen[w] = true
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// searchTokens returns the blind index tokens for the keywords in texts.
+func searchTokens(kMaster []byte, texts ...string) ([]string, error) {
+	kSearch, err := searchKey(kMaster)
+	if err != nil {
+		return nil, err
+	}
+	defer ZeroizeKey(kSearch)
+
+	words := normalizeKeywords(texts...)
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		mac := hmac.New(sha256.New, kSearch)
+		mac.Write([]byte(w))
+		tokens = append(tokens, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+	}
+	return tokens, nil
+}
+
+// searchNotes lists the notes that have every token.
+func searchNotes(tokens []string) ([]noteListEntry, error) {
+	q := url.Values{}
+	for _, t := range tokens {
+		q.Add("token", t)
+	}
+	q.Set("limit", strconv.Itoa(maxNotePageSize))
+	var notes []noteListEntry
+	for {
+		page, err := fetchNoteList(q)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, page.Notes...)
+		if page.NextCursor == "" {
+			return notes, nil
+		}
+		q.Set("cursor", page.NextCursor)
+	}
+}
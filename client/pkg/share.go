@@ -0,0 +1,67 @@
+package serverpkg
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// shareLinkMetadata is the "metadata" object of POST /api/share.
+type shareLinkMetadata struct {
+	ExpiresIn   int    `json:"expires_in"`
+	MaxViews    int    `json:"max_views"`
+	HasPassword bool   `json:"has_password"`
+	AccessHash  string `json:"access_hash,omitempty"`
+}
+
+// shareInfo is returned by GET /api/share/:id/info.
+type shareInfo struct {
+	IsActive         bool    `json:"is_active"`
+	RequiresPassword bool    `json:"requires_password"`
+	ExpiresAt        *string `json:"expires_at"`
+}
+
+// hashSharePassword returns the SHA256 access hash the server compares
+// against X-Access-Pass-Hash. The raw password never leaves the client.
+func hashSharePassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// buildShareURL puts K_Session in the URL fragment, which browsers and
+// HTTP clients never send to the server.
+func buildShareURL(base string, shareID string, kSession []byte) string {
+	return strings.TrimRight(base, "/") + "/share/" + url.PathEscape(shareID) + "#" + base64.RawURLEncoding.EncodeToString(kSession)
+}
+
+// parseShareURL splits a share URL into server base, share id and K_Session.
+func parseShareURL(raw string) (base string, shareID string, kSession []byte, err error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", "", nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", "", nil, errors.New("share URL must be absolute")
+	}
+	idx := strings.LastIndex(u.Path, "/share/")
+	if idx < 0 {
+		return "", "", nil, errors.New("not a share URL")
+	}
+	shareID = strings.Trim(u.Path[idx+len("/share/"):], "/")
+	if shareID == "" {
+		return "", "", nil, errors.New("share id missing")
+	}
+	if u.Fragment == "" {
+		return "", "", nil, errors.New("decryption key missing from URL fragment")
+	}
+	kSession, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(u.Fragment, "="))
+	if err != nil || len(kSession) != 32 {
+		return "", "", nil, fmt.Errorf("invalid key in URL fragment")
+	}
+	base = u.Scheme + "://" + u.Host + u.Path[:idx]
+	return base, shareID, kSession, nil
+}
@@ -0,0 +1,312 @@
+package serverpkg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ============================================================
+// STREAMING ENCRYPTION - Chunked AES-256-GCM for large files
+// ============================================================
+//
+// Format (all integers big-endian):
+//
+//	header : magic "SNS1" (4) | chunk size (4) | nonce prefix (7)
+//	chunks : AES-GCM(plaintext segment) = segment || tag (16)
+//
+// Every segment except the last holds exactly chunk size bytes of plaintext.
+// The nonce of chunk i is nonce prefix (7) || i (4) || last flag (1), and the
+// header is passed as additional data, so chunks cannot be reordered, moved
+// between files or dropped from the end without Open failing.
+
+// StreamAlg is the iv_meta "alg" value for content in the chunked format.
+const StreamAlg = "AES-256-GCM-STREAM"
+
+// StreamChunkSize is the plaintext segment size used by NewEncryptWriter.
+const StreamChunkSize = 64 * 1024
+
+const (
+	streamMagic          = "SNS1"
+	streamNoncePrefixLen = 7
+	streamHeaderLen      = len(streamMagic) + 4 + streamNoncePrefixLen
+	streamTagLen         = 16
+	streamMaxChunkSize   = 16 * 1024 * 1024
+)
+
+var (
+	// ErrStreamTruncated is returned when the final chunk is missing.
+	ErrStreamTruncated = errors.New("encrypted stream is truncated")
+	// ErrStreamCorrupt is returned when a chunk fails authentication.
+	ErrStreamCorrupt = errors.New("encrypted stream was tampered with or key is wrong")
+)
+
+func newStreamGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create cipher block: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce builds prefix || counter || last.
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamNoncePrefixLen:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter seals plaintext in fixed-size segments as it is written.
+type encryptWriter struct {
+	dst     io.Writer
+	gcm     cipher.AEAD
+	header  []byte
+	prefix  []byte
+	buf     []byte
+	counter uint32
+	closed  bool
+	err     error
+}
+
+// NewEncryptWriter returns a writer that encrypts everything written to it
+// into dst using the chunked format. Close must be called to emit the final
+// chunk; it does not close dst.
+func NewEncryptWriter(dst io.Writer, key []byte) (io.WriteCloser, error) {
+	return newEncryptWriter(dst, key, StreamChunkSize)
+}
+
+func newEncryptWriter(dst io.Writer, key []byte, chunkSize int) (*encryptWriter, error) {
+	if chunkSize <= 0 || chunkSize > streamMaxChunkSize {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	gcm, err := newStreamGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, streamHeaderLen)
+	copy(header, streamMagic)
+	binary.BigEndian.PutUint32(header[len(streamMagic):], uint32(chunkSize))
+	prefix := header[len(streamMagic)+4:]
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := dst.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		dst:    dst,
+		gcm:    gcm,
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+	n := 0
+	for len(p) > 0 {
+		// A full buffer is only sealed once more data arrives, so the
+		// final chunk (sealed by Close) is never empty unless the input is.
+		if len(w.buf) == cap(w.buf) {
+			if err := w.seal(false); err != nil {
+				return n, err
+			}
+		}
+		k := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+func (w *encryptWriter) seal(last bool) error {
+	if w.counter == ^uint32(0) && !last {
+		w.err = errors.New("encrypted stream too large")
+		return w.err
+	}
+	out := w.gcm.Seal(nil, chunkNonce(w.prefix, w.counter, last), w.buf, w.header)
+	if _, err := w.dst.Write(out); err != nil {
+		w.err = err
+		return err
+	}
+	ZeroizeKey(w.buf)
+	w.buf = w.buf[:0]
+	w.counter++
+	return nil
+}
+
+// Close seals the remaining buffered data as the final chunk.
+func (w *encryptWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	return w.seal(true)
+}
+
+// decryptReader opens chunks lazily and serves their plaintext.
+type decryptReader struct {
+	src     *bufio.Reader
+	gcm     cipher.AEAD
+	header  []byte
+	prefix  []byte
+	chunk   []byte
+	out     []byte
+	plain   []byte
+	counter uint32
+	done    bool
+	err     error
+}
+
+// NewDecryptReader returns a reader that decrypts a chunked stream from src.
+// Each chunk is authenticated before any of its plaintext is returned; a
+// missing final chunk yields ErrStreamTruncated instead of io.EOF.
+func NewDecryptReader(src io.Reader, key []byte) (io.Reader, error) {
+	gcm, err := newStreamGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, streamHeaderLen)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, ErrStreamTruncated
+	}
+	if string(header[:len(streamMagic)]) != streamMagic {
+		return nil, errors.New("not an encrypted stream (bad magic)")
+	}
+	chunkSize := binary.BigEndian.Uint32(header[len(streamMagic):])
+	if chunkSize == 0 || chunkSize > streamMaxChunkSize {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	return &decryptReader{
+		src:    bufio.NewReaderSize(src, int(chunkSize)+streamTagLen+1),
+		gcm:    gcm,
+		header: header,
+		prefix: header[len(streamMagic)+4:],
+		chunk:  make([]byte, int(chunkSize)+streamTagLen),
+		out:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next reads and opens one chunk. A chunk is the final one when the
+// underlying stream ends right after it.
+func (r *decryptReader) next() error {
+	n, err := io.ReadFull(r.src, r.chunk)
+	switch {
+	case err == io.EOF:
+		return ErrStreamTruncated
+	case err == io.ErrUnexpectedEOF:
+		if n < streamTagLen {
+			return ErrStreamTruncated
+		}
+	case err != nil:
+		return err
+	}
+	last := n < len(r.chunk)
+	if !last {
+		if _, err := r.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	nonce := chunkNonce(r.prefix, r.counter, last)
+	plain, err := r.gcm.Open(r.out[:0], nonce, r.chunk[:n], r.header)
+	if err != nil {
+		// A valid non-final chunk at the end means the stream was cut at a chunk boundary
+		if last {
+			if _, err := r.gcm.Open(r.out[:0], chunkNonce(r.prefix, r.counter, false), r.chunk[:n], r.header); err == nil {
+				return ErrStreamTruncated
+			}
+		}
+		return ErrStreamCorrupt
+	}
+	r.plain = plain
+	r.counter++
+	r.done = last
+	return nil
+}
+
+// EncryptStream encrypts src into dst in the chunked format with constant memory.
+func EncryptStream(dst io.Writer, src io.Reader, key []byte) (int64, error) {
+	w, err := NewEncryptWriter(dst, key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w, src)
+	if err != nil {
+		return n, err
+	}
+	return n, w.Close()
+}
+
+// DecryptStream decrypts a chunked stream from src into dst. On error, dst
+// may already hold the plaintext of the chunks verified so far.
+func DecryptStream(dst io.Writer, src io.Reader, key []byte) (int64, error) {
+	r, err := NewDecryptReader(src, key)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(dst, r)
+}
+
+// EncryptBytes is EncryptStream for in-memory data.
+func EncryptBytes(key []byte, plaintext []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := EncryptStream(&buf, bytes.NewReader(plaintext), key); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecryptBytes is DecryptStream for in-memory data.
+func DecryptBytes(key []byte, ciphertext []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := DecryptStream(&buf, bytes.NewReader(ciphertext), key); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// streamNoncePrefix returns the hex-encodable nonce prefix of a stream header.
+func streamNoncePrefix(ciphertext []byte) []byte {
+	if len(ciphertext) < streamHeaderLen || string(ciphertext[:len(streamMagic)]) != streamMagic {
+		return nil
+	}
+	return ciphertext[len(streamMagic)+4 : streamHeaderLen]
+}
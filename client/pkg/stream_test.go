@@ -0,0 +1,170 @@
+package serverpkg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func streamTestKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// encryptChunks encrypts plaintext with a small chunk size so tests cover
+// several chunks without large inputs.
+func encryptChunks(t *testing.T, key, plaintext []byte, chunkSize int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newEncryptWriter(&buf, key, chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := streamTestKey(t)
+	for _, size := range []int{0, 1, 15, 16, 17, 64, 1000} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+		ct := encryptChunks(t, key, plaintext, 16)
+		got, err := DecryptBytes(key, ct)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("size %d: plaintext mismatch", size)
+		}
+	}
+}
+
+func TestStreamRoundTripDefaultChunkSize(t *testing.T) {
+	key := streamTestKey(t)
+	plaintext := make([]byte, 3*StreamChunkSize+123)
+	rand.Read(plaintext)
+	ct, err := EncryptBytes(key, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecryptBytes(key, ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatal("plaintext mismatch")
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	key := streamTestKey(t)
+	plaintext := make([]byte, 64)
+	rand.Read(plaintext)
+	ct := encryptChunks(t, key, plaintext, 16)
+	chunk := 16 + streamTagLen
+
+	cases := map[string]int{
+		"header only":        streamHeaderLen,
+		"partial header":     streamHeaderLen - 1,
+		"at chunk boundary":  streamHeaderLen + 2*chunk,
+		"inside final chunk": len(ct) - 1,
+		"tag cut":            streamHeaderLen + 3*chunk + streamTagLen - 1,
+	}
+	for name, n := range cases {
+		_, err := DecryptBytes(key, ct[:n])
+		if !errors.Is(err, ErrStreamTruncated) && !errors.Is(err, ErrStreamCorrupt) {
+			t.Errorf("%s: err = %v, want truncated or corrupt", name, err)
+		}
+		if name == "at chunk boundary" && !errors.Is(err, ErrStreamTruncated) {
+			t.Errorf("%s: err = %v, want %v", name, err, ErrStreamTruncated)
+		}
+	}
+}
+
+func TestStreamTampered(t *testing.T) {
+	key := streamTestKey(t)
+	plaintext := make([]byte, 64)
+	rand.Read(plaintext)
+	ct := encryptChunks(t, key, plaintext, 16)
+	chunk := 16 + streamTagLen
+
+	// A flipped bit in any chunk fails authentication
+	for _, off := range []int{streamHeaderLen, streamHeaderLen + chunk + 5, len(ct) - 1} {
+		bad := bytes.Clone(ct)
+		bad[off] ^= 1
+		if _, err := DecryptBytes(key, bad); !errors.Is(err, ErrStreamCorrupt) {
+			t.Errorf("offset %d: err = %v, want %v", off, err, ErrStreamCorrupt)
+		}
+	}
+
+	// The header is additional data: changing the nonce prefix breaks every chunk
+	bad := bytes.Clone(ct)
+	bad[streamHeaderLen-1] ^= 1
+	if _, err := DecryptBytes(key, bad); !errors.Is(err, ErrStreamCorrupt) {
+		t.Errorf("header: err = %v, want %v", err, ErrStreamCorrupt)
+	}
+
+	// Swapped chunks
+	bad = bytes.Clone(ct)
+	first := bad[streamHeaderLen : streamHeaderLen+chunk]
+	second := bytes.Clone(bad[streamHeaderLen+chunk : streamHeaderLen+2*chunk])
+	copy(bad[streamHeaderLen+chunk:], first)
+	copy(bad[streamHeaderLen:], second)
+	if _, err := DecryptBytes(key, bad); !errors.Is(err, ErrStreamCorrupt) {
+		t.Errorf("swapped: err = %v, want %v", err, ErrStreamCorrupt)
+	}
+
+	// Wrong key
+	if _, err := DecryptBytes(streamTestKey(t), ct); !errors.Is(err, ErrStreamCorrupt) {
+		t.Errorf("wrong key: err = %v, want %v", err, ErrStreamCorrupt)
+	}
+}
+
+func TestStreamNoPlaintextBeforeAuthentication(t *testing.T) {
+	key := streamTestKey(t)
+	plaintext := make([]byte, 48)
+	rand.Read(plaintext)
+	ct := encryptChunks(t, key, plaintext, 16)
+	// Corrupt the second chunk: only the first chunk's plaintext may be returned
+	ct[streamHeaderLen+16+streamTagLen] ^= 1
+
+	r, err := NewDecryptReader(bytes.NewReader(ct), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if !errors.Is(err, ErrStreamCorrupt) {
+		t.Fatalf("err = %v, want %v", err, ErrStreamCorrupt)
+	}
+	if !bytes.Equal(got, plaintext[:16]) {
+		t.Fatalf("read %d bytes before the error, want the 16 of the first chunk", len(got))
+	}
+}
+
+func TestStreamBadHeader(t *testing.T) {
+	key := streamTestKey(t)
+	ct := encryptChunks(t, key, []byte("hello"), 16)
+
+	bad := bytes.Clone(ct)
+	copy(bad, "XXXX")
+	if _, err := DecryptBytes(key, bad); err == nil {
+		t.Error("bad magic accepted")
+	}
+	bad = bytes.Clone(ct)
+	bad[4], bad[5], bad[6], bad[7] = 0, 0, 0, 0
+	if _, err := DecryptBytes(key, bad); err == nil {
+		t.Error("zero chunk size accepted")
+	}
+}
@@ -0,0 +1,155 @@
+package serverpkg
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS settings for talking to an HTTPS server (all optional):
+//   API_CA_FILE      PEM bundle trusted in addition to the system roots
+//                    (use the server's dev certificate when it is self-signed)
+//   API_PIN_SHA256   comma-separated SPKI pins, "sha256/<base64>" as logged by the server;
+//                    the connection is refused unless a certificate in the verified chain matches
+//   API_CLIENT_CERT  client certificate for mutual TLS
+//   API_CLIENT_KEY   private key for API_CLIENT_CERT
+
+var (
+	httpClientOnce   sync.Once
+	sharedClient     *http.Client
+	sharedTransferCl *http.Client
+	httpClientErr    error
+)
+
+// initHTTPClients builds the shared transport from the environment once.
+func initHTTPClients() {
+	httpClientOnce.Do(func() {
+		tlsConfig, err := clientTLSConfig()
+		if err != nil {
+			httpClientErr = err
+			return
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		transport.ResponseHeaderTimeout = 60 * time.Second
+		sharedClient = &http.Client{Timeout: 15 * time.Second, Transport: transport}
+		// Chunk uploads and content downloads may legitimately take longer
+		// than 15s on a slow link, so only the response headers are timed.
+		sharedTransferCl = &http.Client{Transport: transport}
+	})
+}
+
+// httpClient returns the shared HTTP client for regular API calls.
+func httpClient() (*http.Client, error) {
+	initHTTPClients()
+	return sharedClient, httpClientErr
+}
+
+// transferClient returns the shared HTTP client for large request or
+// response bodies; it has no overall timeout.
+func transferClient() (*http.Client, error) {
+	initHTTPClients()
+	return sharedTransferCl, httpClientErr
+}
+
+// clientTLSConfig builds the TLS config from API_CA_FILE, API_PIN_SHA256 and
+// API_CLIENT_CERT/API_CLIENT_KEY.
+func clientTLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile := os.Getenv("API_CA_FILE"); caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pemData, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read API_CA_FILE: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	certFile, keyFile := os.Getenv("API_CLIENT_CERT"), os.Getenv("API_CLIENT_KEY")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if pinsEnv := os.Getenv("API_PIN_SHA256"); pinsEnv != "" {
+		pins, err := parsePins(pinsEnv)
+		if err != nil {
+			return nil, err
+		}
+		// Runs after normal chain verification, so pinning only narrows trust
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPins(cs, pins)
+		}
+	}
+	return cfg, nil
+}
+
+// parsePins decodes "sha256/<base64>" (or bare base64) SPKI hashes.
+func parsePins(s string) ([][]byte, error) {
+	var pins [][]byte
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimPrefix(strings.TrimSpace(p), "sha256/")
+		if p == "" {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(p)
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("invalid SPKI pin %q", p)
+		}
+		pins = append(pins, raw)
+	}
+	if len(pins) == 0 {
+		return nil, errors.New("API_PIN_SHA256 is set but contains no pins")
+	}
+	return pins, nil
+}
+
+// SPKIHash returns the SHA256 of the certificate's SubjectPublicKeyInfo.
+func SPKIHash(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return sum[:]
+}
+
+// verifyPins accepts the connection if any certificate in the verified chains
+// matches a configured pin. Peer certificates outside a verified chain are
+// ignored: the server can send any extra certificate it likes, including the
+// real server's public one.
+func verifyPins(cs tls.ConnectionState, pins [][]byte) error {
+	if len(cs.VerifiedChains) == 0 {
+		return errors.New("certificate pin check needs a verified chain")
+	}
+	for _, chain := range cs.VerifiedChains {
+		for _, cert := range chain {
+			hash := SPKIHash(cert)
+			for _, pin := range pins {
+				if subtle.ConstantTimeCompare(hash, pin) == 1 {
+					return nil
+				}
+			}
+		}
+	}
+	if len(cs.PeerCertificates) > 0 {
+		got := base64.StdEncoding.EncodeToString(SPKIHash(cs.PeerCertificates[0]))
+		return fmt.Errorf("certificate pin mismatch (server presented sha256/%s)", got)
+	}
+	return errors.New("certificate pin mismatch")
+}
@@ -0,0 +1,144 @@
+package serverpkg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a generated certificate with its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate for 127.0.0.1 signed by parent
+// (self-signed when parent is nil).
+func newTestCert(t *testing.T, name string, isCA bool, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// pinOf formats the SPKI pin of c as API_PIN_SHA256 expects it.
+func pinOf(c *testCert) string {
+	return "sha256/" + base64.StdEncoding.EncodeToString(SPKIHash(c.cert))
+}
+
+// startTLSServer serves a TLS endpoint presenting leaf followed by extra.
+func startTLSServer(t *testing.T, leaf *testCert, extra ...*testCert) *httptest.Server {
+	t.Helper()
+	chain := tls.Certificate{Certificate: [][]byte{leaf.cert.Raw}, PrivateKey: leaf.key}
+	for _, c := range extra {
+		chain.Certificate = append(chain.Certificate, c.cert.Raw)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{chain}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// getWithPins fetches url with a client built from API_CA_FILE=ca and
+// API_PIN_SHA256=pin.
+func getWithPins(t *testing.T, url string, ca *testCert, pin string) error {
+	t.Helper()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	if err := os.WriteFile(caFile, pemData, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("API_CA_FILE", caFile)
+	t.Setenv("API_PIN_SHA256", pin)
+	t.Setenv("API_CLIENT_CERT", "")
+	t.Setenv("API_CLIENT_KEY", "")
+
+	cfg, err := clientTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestPinMatchesVerifiedChain(t *testing.T) {
+	ca := newTestCert(t, "test CA", true, nil)
+	leaf := newTestCert(t, "server", false, ca)
+	srv := startTLSServer(t, leaf)
+
+	if err := getWithPins(t, srv.URL, ca, pinOf(leaf)); err != nil {
+		t.Fatalf("leaf pin rejected: %v", err)
+	}
+	if err := getWithPins(t, srv.URL, ca, pinOf(ca)); err != nil {
+		t.Fatalf("CA pin rejected: %v", err)
+	}
+	other := newTestCert(t, "other", false, nil)
+	if err := getWithPins(t, srv.URL, ca, pinOf(other)); err == nil {
+		t.Fatal("connection accepted with a pin matching no certificate")
+	}
+}
+
+func TestPinIgnoresUnverifiedPeerCertificates(t *testing.T) {
+	ca := newTestCert(t, "test CA", true, nil)
+	// The pinned server certificate is public, so anyone holding another
+	// CA-issued certificate for the host can append it to their chain.
+	pinned := newTestCert(t, "real server", false, nil)
+	attacker := newTestCert(t, "attacker", false, ca)
+	srv := startTLSServer(t, attacker, pinned)
+
+	if err := getWithPins(t, srv.URL, ca, pinOf(pinned)); err == nil {
+		t.Fatal("pin matched a certificate outside the verified chain")
+	}
+}
+
+func TestVerifyPinsWithoutVerifiedChain(t *testing.T) {
+	leaf := newTestCert(t, "server", false, nil)
+	cs := tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf.cert}}
+	if err := verifyPins(cs, [][]byte{SPKIHash(leaf.cert)}); err == nil {
+		t.Fatal("pin accepted without a verified chain")
+	}
+}
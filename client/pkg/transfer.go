@@ -0,0 +1,435 @@
+package serverpkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ============================================================
+// RESUMABLE TRANSFERS - Chunked upload and ranged download
+// ============================================================
+//
+// Uploads: the file is encrypted once into a ciphertext file next to a JSON
+// state file under UPLOAD_STATE_DIR (default .client_uploads). Chunks are
+// PUT to /api/uploads/:id/chunks/:index; if the transfer is interrupted,
+// uploading the same (unchanged) file again resumes with the chunks the
+// server is missing.
+//
+// Downloads: ciphertext is fetched from /api/notes/:id/content into
+// "<output>.part". A later attempt continues with Range/If-Range and the
+// finished file is checked against the ETag (SHA256) before decryption.
+
+// uploadChunkSize is the chunk size requested when creating a session.
+const uploadChunkSize = 4 * 1024 * 1024
+
+// chunkRetries is how many times a failed chunk PUT is retried.
+const chunkRetries = 3
+
+func uploadStateDir() string {
+	if v := os.Getenv("UPLOAD_STATE_DIR"); v != "" {
+		return v
+	}
+	return ".client_uploads"
+}
+
+// uploadState is persisted while a chunked upload is in progress.
+type uploadState struct {
+	UploadID   string      `json:"upload_id"`
+	SourcePath string      `json:"source_path"`
+	SourceSize int64       `json:"source_size"`
+	SourceMod  int64       `json:"source_mod"`
+	Note       notePayload `json:"note"`
+	CipherPath string      `json:"cipher_path"`
+	CipherSize int64       `json:"cipher_size"`
+	SHA256     string      `json:"sha256"`
+	ChunkSize  int64       `json:"chunk_size"`
+}
+
+// uploadStatePath returns the state file used for source.
+func uploadStatePath(source string) string {
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		abs = source
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(uploadStateDir(), hex.EncodeToString(sum[:8])+".json")
+}
+
+// loadUploadState returns the pending upload of source, or nil if none.
+func loadUploadState(source string) *uploadState {
+	b, err := os.ReadFile(uploadStatePath(source))
+	if err != nil {
+		return nil
+	}
+	var st uploadState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil
+	}
+	return &st
+}
+
+func (st *uploadState) save() error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(uploadStatePath(st.SourcePath), b, 0600)
+}
+
+// remove deletes the state and ciphertext files.
+func (st *uploadState) remove() {
+	os.Remove(st.CipherPath)
+	os.Remove(uploadStatePath(st.SourcePath))
+}
+
+// matches reports whether the source file is unchanged since encryption.
+func (st *uploadState) matches(fi os.FileInfo) bool {
+	return st.SourceSize == fi.Size() && st.SourceMod == fi.ModTime().UnixNano()
+}
+
+// abort drops the server session (best effort) and the local files.
+func (st *uploadState) abort() {
+	if st.UploadID != "" {
+		doRequest(http.MethodDelete, apiURL()+"/api/uploads/"+url.PathEscape(st.UploadID), nil, "", true)
+	}
+	st.remove()
+}
+
+// prepareUpload encrypts source into a ciphertext file and records its hash.
+// keywords are stored with the note and indexed along with the title.
+func prepareUpload(kMaster []byte, title, keywords string, source string, fi os.FileInfo) (*uploadState, error) {
+	if err := os.MkdirAll(uploadStateDir(), 0700); err != nil {
+		return nil, err
+	}
+	src, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	st := &uploadState{
+		SourcePath: source,
+		SourceSize: fi.Size(),
+		SourceMod:  fi.ModTime().UnixNano(),
+		CipherPath: strings.TrimSuffix(uploadStatePath(source), ".json") + ".enc",
+	}
+	dst, err := os.OpenFile(st.CipherPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	payload, err := encryptNoteStream(kMaster, title, keywords, io.MultiWriter(dst, hasher), src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(st.CipherPath)
+		return nil, err
+	}
+	fi, err = os.Stat(st.CipherPath)
+	if err != nil {
+		return nil, err
+	}
+	st.Note = *payload
+	st.CipherSize = fi.Size()
+	st.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	return st, st.save()
+}
+
+// uploadSessionStatus mirrors GET /api/uploads/:id.
+type uploadSessionStatus struct {
+	UploadID   string `json:"upload_id"`
+	ChunkSize  int64  `json:"chunk_size"`
+	ChunkCount int    `json:"chunk_count"`
+	Received   []struct {
+		Index  int    `json:"index"`
+		SHA256 string `json:"sha256"`
+	} `json:"received"`
+	Missing []int `json:"missing"`
+}
+
+// createUploadSession opens a new server session for st.
+func createUploadSession(st *uploadState) error {
+	req := struct {
+		notePayload
+		TotalSize int64 `json:"total_size"`
+		ChunkSize int64 `json:"chunk_size"`
+	}{st.Note, st.CipherSize, uploadChunkSize}
+
+	b, status, err := postJSON("/api/uploads", req, true)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusBadRequest {
+		// The server may allow smaller chunks than we asked for
+		var limit struct {
+			MaxChunkSize int64 `json:"max_chunk_size"`
+		}
+		if json.Unmarshal(b, &limit) == nil && limit.MaxChunkSize > 0 && limit.MaxChunkSize < req.ChunkSize {
+			req.ChunkSize = limit.MaxChunkSize
+			b, status, err = postJSON("/api/uploads", req, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if status != http.StatusCreated {
+		return fmt.Errorf("create upload failed: %d %s", status, string(b))
+	}
+	var resp struct {
+		UploadID  string `json:"upload_id"`
+		ChunkSize int64  `json:"chunk_size"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return err
+	}
+	st.UploadID = resp.UploadID
+	st.ChunkSize = resp.ChunkSize
+	return st.save()
+}
+
+// sessionStatus fetches the server view of st, creating a new session when
+// there is none or the old one has expired.
+func sessionStatus(st *uploadState) (*uploadSessionStatus, error) {
+	if st.UploadID != "" {
+		b, status, err := doRequest(http.MethodGet, apiURL()+"/api/uploads/"+url.PathEscape(st.UploadID), nil, "", true)
+		if err != nil {
+			return nil, err
+		}
+		switch status {
+		case http.StatusOK:
+			var s uploadSessionStatus
+			if err := json.Unmarshal(b, &s); err != nil {
+				return nil, err
+			}
+			return &s, nil
+		case http.StatusNotFound, http.StatusGone:
+			LogInfo("upload session expired, starting a new one")
+		default:
+			return nil, fmt.Errorf("upload status failed: %d %s", status, string(b))
+		}
+	}
+	if err := createUploadSession(st); err != nil {
+		return nil, err
+	}
+	s := &uploadSessionStatus{UploadID: st.UploadID, ChunkSize: st.ChunkSize}
+	s.ChunkCount = int((st.CipherSize + st.ChunkSize - 1) / st.ChunkSize)
+	for i := 0; i < s.ChunkCount; i++ {
+		s.Missing = append(s.Missing, i)
+	}
+	return s, nil
+}
+
+// readChunk returns chunk index of the ciphertext file.
+func readChunk(f *os.File, st *uploadState, index int) ([]byte, error) {
+	offset := int64(index) * st.ChunkSize
+	size := min(st.ChunkSize, st.CipherSize-offset)
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// putChunk uploads one chunk, retrying transient failures with backoff.
+func putChunk(st *uploadState, index int, data []byte) error {
+	sum := sha256.Sum256(data)
+	headers := map[string]string{"X-Chunk-SHA256": hex.EncodeToString(sum[:])}
+	chunkURL := fmt.Sprintf("%s/api/uploads/%s/chunks/%d", apiURL(), url.PathEscape(st.UploadID), index)
+
+	var lastErr error
+	for attempt := 0; attempt <= chunkRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<(attempt-1)) * time.Second)
+		}
+		b, status, err := doRequestHeaders(http.MethodPut, chunkURL, data, "application/octet-stream", true, headers)
+		switch {
+		case err != nil:
+			lastErr = err
+		case status == http.StatusOK:
+			return nil
+		case status >= 500 || status == http.StatusTooManyRequests:
+			lastErr = fmt.Errorf("chunk %d: %d %s", index, status, string(b))
+		default:
+			// 4xx will not succeed on retry
+			return fmt.Errorf("chunk %d: %d %s", index, status, string(b))
+		}
+	}
+	return lastErr
+}
+
+// runUpload sends every chunk the server does not have (or has with a
+// different hash) and finalizes the session. It returns the finalize
+// response body; on error the state is kept so the upload can be resumed.
+func runUpload(st *uploadState) ([]byte, error) {
+	status, err := sessionStatus(st)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(st.CipherPath)
+	if err != nil {
+		return nil, fmt.Errorf("ciphertext of pending upload is gone: %w", err)
+	}
+	defer f.Close()
+
+	todo := append([]int(nil), status.Missing...)
+	for _, ch := range status.Received {
+		data, err := readChunk(f, st, ch.Index)
+		if err != nil {
+			return nil, err
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != ch.SHA256 {
+			todo = append(todo, ch.Index)
+		}
+	}
+	if done := status.ChunkCount - len(todo); done > 0 {
+		fmt.Printf("Resuming upload: %d/%d chunks already on server\n", done, status.ChunkCount)
+	}
+
+	for i, index := range todo {
+		data, err := readChunk(f, st, index)
+		if err != nil {
+			return nil, err
+		}
+		if err := putChunk(st, index, data); err != nil {
+			fmt.Println()
+			return nil, err
+		}
+		fmt.Printf("\rUploading: %d/%d chunks", status.ChunkCount-len(todo)+i+1, status.ChunkCount)
+	}
+	if len(todo) > 0 {
+		fmt.Println()
+	}
+
+	b, code, err := postJSON("/api/uploads/"+url.PathEscape(st.UploadID)+"/finalize", map[string]string{"sha256": st.SHA256}, true)
+	if err != nil {
+		return nil, err
+	}
+	if code != http.StatusCreated {
+		return nil, fmt.Errorf("finalize failed: %d %s", code, string(b))
+	}
+	st.remove()
+	return b, nil
+}
+
+// downloadContent fetches the ciphertext at contentURL (see noteContentURL,
+// versionContentURL) into partPath, continuing a previous partial download
+// when possible, and verifies it against the ETag (SHA256 of the
+// ciphertext). The ETag is kept in partPath+".etag".
+func downloadContent(contentURL string, partPath string) error {
+	etagPath := partPath + ".etag"
+	headers := map[string]string{}
+	var offset int64
+	if fi, err := os.Stat(partPath); err == nil && fi.Size() > 0 {
+		if etag, err := os.ReadFile(etagPath); err == nil {
+			offset = fi.Size()
+			headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+			// If the note changed since, the server ignores Range and sends it all
+			headers["If-Range"] = string(etag)
+		}
+	}
+
+	resp, err := openStream(contentURL, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	etag := resp.Header.Get("ETag")
+	var f *os.File
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			return fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+		fmt.Printf("Resuming download at byte %d\n", offset)
+		f, err = os.OpenFile(partPath, os.O_WRONLY|os.O_APPEND, 0600)
+	case http.StatusOK:
+		if err := os.WriteFile(etagPath, []byte(etag), 0600); err != nil {
+			return err
+		}
+		f, err = os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Everything was already downloaded
+		etag = headers["If-Range"]
+	default:
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("download failed: %d %s", resp.StatusCode, string(b))
+	}
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		n, err := io.Copy(f, resp.Body)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("download interrupted after %d bytes: %w", offset+n, err)
+		}
+	}
+
+	sum, err := fileSHA256(partPath)
+	if err != nil {
+		return err
+	}
+	if want := strings.Trim(etag, `"`); want != "" && sum != want {
+		os.Remove(partPath)
+		os.Remove(etagPath)
+		return errors.New("downloaded ciphertext does not match server checksum, please retry")
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// decryptToFile decrypts the downloaded ciphertext into out via a temporary
+// file, so a failed authentication never leaves partial plaintext at out.
+func decryptToFile(kNote []byte, ivMeta string, partPath string, out string) (int64, error) {
+	src, err := os.Open(partPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	tmp := out + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := decryptContent(kNote, ivMeta, dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, out); err != nil {
+		return 0, err
+	}
+	os.Remove(partPath)
+	os.Remove(partPath + ".etag")
+	return n, nil
+}
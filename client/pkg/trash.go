@@ -0,0 +1,84 @@
+package serverpkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ============================================================
+// TRASH
+// ============================================================
+//
+// DELETE /api/notes/:id only moves a note to the trash; it can be restored
+// or deleted for good until the server purges it after its retention period.
+
+// trashEntry is one element of GET /api/trash. Title, KeyEnc and IVMeta are
+// those of the note, so it decrypts like an owned note.
+type trashEntry struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	KeyEnc    string `json:"key_enc"`
+	IVMeta    string `json:"iv_meta"`
+	Version   int    `json:"version"`
+	Size      int64  `json:"size"`
+	DeletedAt string `json:"deleted_at"`
+	PurgeAt   string `json:"purge_at"`
+}
+
+// asNote returns e as an owned note for noteKey and noteTitle.
+func (e trashEntry) asNote() *noteResponse {
+	return &noteResponse{Title: e.Title, KeyEnc: e.KeyEnc, IVMeta: e.IVMeta}
+}
+
+// fetchTrash lists the notes in the trash, most recently deleted first.
+func fetchTrash() ([]trashEntry, error) {
+	var resp struct {
+		Notes []trashEntry `json:"notes"`
+	}
+	err := getJSON("/api/trash", &resp)
+	return resp.Notes, err
+}
+
+// trashNote moves a note to the trash; version is the revision the user saw.
+func trashNote(noteID string, version int) (string, error) {
+	b, status, err := conditionalNoteRequest(http.MethodDelete, apiURL()+"/api/notes/"+url.PathEscape(noteID), version, nil)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("delete note failed: %d %s", status, string(b))
+	}
+	var resp struct {
+		PurgeAt string `json:"purge_at"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return "", err
+	}
+	return resp.PurgeAt, nil
+}
+
+// restoreNote takes a note out of the trash.
+func restoreNote(noteID string) error {
+	b, status, err := postJSON("/api/trash/"+url.PathEscape(noteID)+"/restore", nil, true)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("restore failed: %d %s", status, string(b))
+	}
+	return nil
+}
+
+// purgeNote deletes a note in the trash for good.
+func purgeNote(noteID string) error {
+	b, status, err := doRequest(http.MethodDelete, apiURL()+"/api/trash/"+url.PathEscape(noteID), nil, "", true)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("delete failed: %d %s", status, string(b))
+	}
+	return nil
+}
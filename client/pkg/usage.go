@@ -0,0 +1,35 @@
+package serverpkg
+
+// ============================================================
+// STORAGE USAGE
+// ============================================================
+//
+// The server counts the ciphertext of notes (trash included), previous
+// versions and active share links against a per-user quota; uploads and
+// edits that would exceed it fail with 413 or 507.
+
+// usageReport is the body of GET /api/me/usage. Quota and Remaining are nil
+// when the account has no quota.
+type usageReport struct {
+	UsedBytes      int64  `json:"used_bytes"`
+	QuotaBytes     *int64 `json:"quota_bytes"`
+	RemainingBytes *int64 `json:"remaining_bytes"`
+	Breakdown      struct {
+		Notes          int64 `json:"notes"`
+		Versions       int64 `json:"versions"`
+		ShareLinks     int64 `json:"share_links"`
+		PendingUploads int64 `json:"pending_uploads"`
+	} `json:"breakdown"`
+	NoteCount        int `json:"note_count"`
+	TrashedNoteCount int `json:"trashed_note_count"`
+	ActiveLinks      int `json:"active_links"`
+}
+
+// fetchUsage returns the storage used by the logged in user.
+func fetchUsage() (*usageReport, error) {
+	var u usageReport
+	if err := getJSON("/api/me/usage", &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
@@ -1,6 +1,9 @@
 package serverpkg
 
-import "errors"
+import (
+	"errors"
+	"regexp"
+)
 
 // Validate input (username, password, file)
 func ValidateInput(input string) error {
@@ -11,6 +14,21 @@ func ValidateInput(input string) error {
 	return nil
 }
 
+// ValidatePassword enforces the password policy. The server only ever sees
+// the login key derived from the password, so the client is the one to check.
+func ValidatePassword(password string) error {
+	if len(password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+	if !regexp.MustCompile(`[A-Z]`).MatchString(password) ||
+		!regexp.MustCompile(`[a-z]`).MatchString(password) ||
+		!regexp.MustCompile(`[0-9]`).MatchString(password) ||
+		!regexp.MustCompile(`[@#$%^&+=!]`).MatchString(password) {
+		return errors.New("password must contain uppercase, lowercase, numbers and special characters (@#$%^&+=!)")
+	}
+	return nil
+}
+
 // Kiểm tra JWT token
 func VerifyJWT(token string) (userID int64, err error) {
 	// TODO: parse + verify JWT
@@ -0,0 +1,39 @@
+// Command fakes3 chạy một server S3-compatible tối giản (lưu object vào thư mục)
+// để dùng backend blob s3 khi dev/test mà không cần MinIO hay AWS.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	serverpkg "secure-notes-server/pkg"
+)
+
+func envOr(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
+func main() {
+	addr := flag.String("addr", envOr("FAKES3_ADDR", ":9000"), "listen address")
+	dir := flag.String("dir", envOr("FAKES3_DIR", "server/database/fakes3"), "data directory (one subdirectory per bucket)")
+	bucket := flag.String("bucket", envOr("FAKES3_BUCKET", "secure-notes"), "bucket created at startup")
+	accessKey := flag.String("access-key", envOr("FAKES3_ACCESS_KEY", "minioadmin"), "access key accepted in signatures")
+	flag.Parse()
+	// Secret chỉ đọc từ env để không lộ qua danh sách tiến trình
+	secretKey := envOr("FAKES3_SECRET_KEY", "minioadmin")
+
+	if *bucket != "" {
+		if err := os.MkdirAll(filepath.Join(*dir, *bucket), 0700); err != nil {
+			log.Fatal("Failed to create bucket:", err)
+		}
+	}
+
+	log.Printf("fakes3: serving %s on %s (bucket %q)", *dir, *addr, *bucket)
+	log.Fatal(http.ListenAndServe(*addr, serverpkg.NewFakeS3Server(*dir, *accessKey, secretKey)))
+}
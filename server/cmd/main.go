@@ -1,16 +1,28 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
 	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
 	"secure-notes-server/config"
 	serverpkg "secure-notes-server/pkg"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	// 1. Load config
-	cfg, err := config.LoadConfig()
+	// 1. Load config (file + env + flags)
+	cfg, args, err := config.LoadConfig(os.Args[1:])
 	if err != nil {
 		log.Fatal("Failed to load config:", err)
 	}
@@ -22,44 +34,565 @@ func main() {
 	}
 	defer db.Close()
 
+	// Subcommand: migrate up|down|status
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(db, args[1:]); err != nil {
+			log.Fatal("Migration failed:", err)
+		}
+		return
+	}
+
+	// Subcommand: blobs gc|inline|migrate
+	if len(args) > 0 && args[0] == "blobs" {
+		if err := runBlobs(db, cfg, args[1:]); err != nil {
+			log.Fatal("Blobs command failed:", err)
+		}
+		return
+	}
+
+	// Subcommand: quota top|show|set
+	if len(args) > 0 && args[0] == "quota" {
+		if err := runQuota(db, cfg, args[1:]); err != nil {
+			log.Fatal("Quota command failed:", err)
+		}
+		return
+	}
+
+	// Subcommand: lockout audit|clear
+	if len(args) > 0 && args[0] == "lockout" {
+		if err := runLockout(db, args[1:]); err != nil {
+			log.Fatal("Lockout command failed:", err)
+		}
+		return
+	}
+
+	// Secrets và giới hạn chỉ bắt buộc khi chạy server
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid config:\n", err)
+	}
+	err = serverpkg.InitAuth(serverpkg.AuthOptions{
+		JWTSecret:       cfg.JWT.Secret,
+		Pepper:          cfg.Pepper,
+		AccessTokenTTL:  cfg.JWT.AccessTokenTTL.Duration,
+		RefreshTokenTTL: cfg.JWT.RefreshTokenTTL.Duration,
+		ArgonTime:       cfg.Argon2.Time,
+		ArgonMemoryKiB:  cfg.Argon2.MemoryKiB,
+		ArgonThreads:    cfg.Argon2.Threads,
+	})
+	if err != nil {
+		log.Fatal("Failed to init auth:", err)
+	}
+
+	// Apply pending schema migrations (seed data is never applied automatically)
+	if _, err := serverpkg.MigrateUp(db, false); err != nil {
+		log.Fatal("Failed to migrate DB:", err)
+	}
+
+	// Ciphertext nằm trong blob store, database chỉ giữ reference
+	blobs, err := openBlobStore(cfg.Blob)
+	if err != nil {
+		log.Fatal("Failed to open blob store:", err)
+	}
+
+	// Handlers phụ thuộc vào các store thay vì biến global
+	srv := serverpkg.NewSQLiteServer(db, blobs)
+	srv.BlobGCGrace = cfg.Blob.GCGrace.Duration
+	srv.TrashRetention = cfg.Trash.Retention.Duration
+	srv.DefaultQuota = cfg.Quota.DefaultBytes
+	srv.LockoutPolicy = serverpkg.LockoutPolicy{
+		FreeAttempts:  cfg.Lockout.FreeAttempts,
+		BaseDelay:     cfg.Lockout.BaseDelay.Duration,
+		MaxFailures:   cfg.Lockout.MaxFailures,
+		IPMaxFailures: cfg.Lockout.IPMaxFailures,
+		LockDuration:  cfg.Lockout.Duration.Duration,
+		Window:        cfg.Lockout.Window.Duration,
+	}
+	// Chuyển content_enc còn lưu inline (dữ liệu cũ) sang blob store; lần sau không còn gì để chuyển
+	if moved, err := srv.MoveInlineContentToBlobs(context.Background()); err != nil {
+		log.Fatal("Failed to move inline content to blob store:", err)
+	} else if moved > 0 {
+		log.Printf("blobs: moved %d inline content row(s) to the %s blob store", moved, cfg.Blob.Backend)
+	}
+	srv.UploadLimits = serverpkg.UploadLimits{
+		MaxSize:      cfg.Upload.MaxSize,
+		MaxChunkSize: cfg.Upload.MaxChunkSize,
+		SessionTTL:   cfg.Upload.SessionTTL.Duration,
+	}
+
 	// 3. Init Gin router
 	r := gin.Default()
 
-	// Global middleware: logging, CORS, rate limit
+	// Global middleware: logging, CORS, rate limit, body size
 	r.Use(serverpkg.LoggingMiddleware())
-	r.Use(serverpkg.CORSMiddleware())
-	r.Use(serverpkg.RateLimitMiddleware())
+	r.Use(serverpkg.CORSMiddleware(cfg.CORS.AllowedOrigins))
+	r.Use(serverpkg.RateLimitMiddleware(cfg.RateLimit.Requests, cfg.RateLimit.Window.Duration))
+	r.Use(serverpkg.MaxBodySizeMiddleware(cfg.MaxUploadBytes))
+	r.Use(serverpkg.ClientCertMiddleware())
 
 	// 4. Auth routes (register & login are public)
-	r.POST("/api/register", serverpkg.Register)
-	r.POST("/api/login", serverpkg.Login)
+	r.POST("/api/register", srv.Register)
+	r.GET("/api/salt", srv.GetSalt)
+	r.POST("/api/login", srv.Login)
+	r.POST("/api/login/mfa", srv.LoginMFA)
+	r.POST("/api/refresh", srv.Refresh)
+	r.POST("/api/recovery", srv.StartRecovery)
+	r.POST("/api/recovery/complete", srv.CompleteRecovery)
 	// Logout requires valid JWT to blacklist token
-	r.POST("/api/logout", serverpkg.JWTMiddleware(), serverpkg.Logout)
+	r.POST("/api/logout", srv.JWTMiddleware(), srv.Logout)
 
 	// 5. Notes routes - require authentication
 	notes := r.Group("/api/notes")
-	notes.Use(serverpkg.JWTMiddleware())
+	notes.Use(srv.JWTMiddleware())
+	{
+		notes.GET("", srv.ListNotes)
+		notes.POST("", srv.UploadNote)
+		notes.GET("/:id", srv.GetNote)
+		notes.GET("/:id/content", srv.GetNoteContent)
+		notes.PUT("/:id", srv.UpdateNote)
+		notes.DELETE("/:id", srv.DeleteNote)
+		notes.GET("/:id/versions", srv.ListNoteVersions)
+		notes.GET("/:id/versions/:version", srv.GetNoteVersion)
+		notes.GET("/:id/versions/:version/content", srv.GetNoteVersionContent)
+		notes.POST("/:id/versions/:version/restore", srv.RestoreNoteVersion)
+		notes.POST("/:id/share", srv.ShareNote)
+		notes.GET("/:id/share", srv.ListShares)
+		notes.DELETE("/:id/share/:share_id", srv.RevokeShare)
+		notes.PUT("/:id/folder", srv.MoveNote)
+		notes.PUT("/:id/tags", srv.SetNoteTags)
+	}
+
+	// Trash bin - require authentication
+	trash := r.Group("/api/trash")
+	trash.Use(srv.JWTMiddleware())
 	{
-		notes.GET("", serverpkg.ListNotes)
-		notes.POST("", serverpkg.UploadNote)
-		notes.GET("/:id", serverpkg.GetNote)
-		notes.DELETE("/:id", serverpkg.DeleteNote)
-		notes.POST("/:id/share", serverpkg.ShareNote)
-		notes.GET("/:id/share", serverpkg.ListShares)
-		notes.DELETE("/:id/share/:share_id", serverpkg.RevokeShare)
+		trash.GET("", srv.ListTrash)
+		trash.POST("/:id/restore", srv.RestoreTrashedNote)
+		trash.DELETE("/:id", srv.PurgeTrashedNote)
+	}
+
+	// Current account - require authentication
+	me := r.Group("/api/me")
+	me.Use(srv.JWTMiddleware())
+	{
+		me.GET("/usage", srv.GetUsage)
+		me.GET("/2fa", srv.GetTwoFactor)
+		me.POST("/2fa/enroll", srv.EnrollTwoFactor)
+		me.POST("/2fa/verify", srv.VerifyTwoFactor)
+		me.DELETE("/2fa", srv.DisableTwoFactor)
+		me.GET("/keyring", srv.GetKeyring)
+		me.POST("/password", srv.ChangePassword)
+		me.GET("/recovery", srv.GetRecoveryKey)
+		me.PUT("/recovery", srv.SetRecoveryKey)
+		me.DELETE("/recovery", srv.DeleteRecoveryKey)
+	}
+
+	// Folders & tags - require authentication
+	folders := r.Group("/api/folders")
+	folders.Use(srv.JWTMiddleware())
+	{
+		folders.GET("", srv.ListFolders)
+		folders.POST("", srv.CreateFolder)
+		folders.PATCH("/:id", srv.UpdateFolder)
+		folders.DELETE("/:id", srv.DeleteFolder)
+		folders.POST("/:id/share", srv.ShareFolder)
+		folders.GET("/:id/share", srv.ListFolderShares)
+		folders.DELETE("/:id/share/:share_id", srv.RevokeFolderShare)
+	}
+	tags := r.Group("/api/tags")
+	tags.Use(srv.JWTMiddleware())
+	{
+		tags.GET("", srv.ListTags)
+		tags.POST("", srv.CreateTag)
+		tags.PUT("/:id", srv.UpdateTag)
+		tags.DELETE("/:id", srv.DeleteTag)
+	}
+
+	// Resumable chunked upload - require authentication
+	uploads := r.Group("/api/uploads")
+	uploads.Use(srv.JWTMiddleware())
+	{
+		uploads.POST("", srv.CreateUpload)
+		uploads.GET("/:id", srv.GetUpload)
+		uploads.PUT("/:id/chunks/:index", srv.PutUploadChunk)
+		uploads.POST("/:id/finalize", srv.FinalizeUpload)
+		uploads.DELETE("/:id", srv.AbortUpload)
+	}
+
+	// DH public key directory - require authentication
+	keys := r.Group("/api/keys")
+	keys.Use(srv.JWTMiddleware())
+	{
+		keys.POST("/dh", srv.PublishDHKey)
+		keys.GET("/dh", srv.LookupDHKey)
+		keys.GET("/dh/:user_id", srv.GetDHKey)
 	}
 
 	// Share link endpoints
 	// Create and revoke share links require auth
-	r.POST("/api/share", serverpkg.JWTMiddleware(), serverpkg.CreateShareLink)
-	r.DELETE("/api/share/:id", serverpkg.JWTMiddleware(), serverpkg.RevokeShareLink)
+	r.POST("/api/share", srv.JWTMiddleware(), srv.CreateShareLink)
+	r.DELETE("/api/share/:id", srv.JWTMiddleware(), srv.RevokeShareLink)
 	// Public access to share info/content (may be password-protected)
-	r.GET("/api/share/:id/info", serverpkg.GetShareInfo)
-	r.GET("/api/share/:id", serverpkg.GetSharedContent)
+	r.GET("/api/share/:id/info", srv.GetShareInfo)
+	r.GET("/api/share/:id", srv.GetSharedContent)
 
 	// 6. Temp URL access (may be anonymous) - not implemented
 
+	// ctx bị hủy khi nhận SIGINT/SIGTERM: dừng nhận request, scheduler và watcher
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Background maintenance (purge token hết hạn, vô hiệu hóa share link)
+	maintenanceDone := srv.StartMaintenance(ctx, cfg.MaintenanceInterval.Duration)
+
 	// 7. Run server
-	log.Println("Server running on port", cfg.Port)
-	r.Run(":" + cfg.Port)
+	httpServer := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: r,
+	}
+	serveErr := make(chan error, 1)
+	if cfg.TLS.Enabled() {
+		tlsManager, err := setupTLS(ctx, cfg.TLS)
+		if err != nil {
+			log.Fatal("Failed to init TLS:", err)
+		}
+		httpServer.TLSConfig = tlsManager.TLSConfig()
+		log.Println("Server running (HTTPS) on port", cfg.Port)
+		go func() { serveErr <- httpServer.ListenAndServeTLS("", "") }()
+	} else {
+		log.Println("Server running on port", cfg.Port)
+		go func() { serveErr <- httpServer.ListenAndServe() }()
+	}
+
+	select {
+	case err := <-serveErr:
+		log.Fatal("Server stopped:", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// 8. Graceful shutdown: chờ các request đang xử lý hoàn tất (tối đa shutdown_timeout)
+	log.Println("Shutting down, draining in-flight requests...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Println("Forced shutdown:", err)
+	}
+	<-maintenanceDone
+	log.Println("Server stopped")
+}
+
+// setupTLS tạo certificate tự ký (nếu bật), nạp certificate và theo dõi thay đổi:
+// file được kiểm tra mỗi reload_interval, SIGHUP buộc nạp lại ngay
+func setupTLS(ctx context.Context, tlsCfg config.TLSConfig) (*serverpkg.TLSManager, error) {
+	if tlsCfg.SelfSigned {
+		created, err := serverpkg.EnsureSelfSignedCert(tlsCfg.CertFile, tlsCfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			log.Printf("tls: generated self-signed certificate %s (development only)", tlsCfg.CertFile)
+		}
+	}
+
+	manager, err := serverpkg.NewTLSManager(serverpkg.TLSOptions{
+		CertFile:     tlsCfg.CertFile,
+		KeyFile:      tlsCfg.KeyFile,
+		ClientCAFile: tlsCfg.ClientCAFile,
+		ClientAuth:   tlsCfg.ClientAuth,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("tls: certificate SPKI pin %s (client auth: %s)", manager.Pin(), tlsCfg.ClientAuth)
+
+	go manager.WatchFiles(tlsCfg.ReloadInterval.Duration, ctx.Done())
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := manager.Reload(); err != nil {
+				log.Printf("tls: reload on SIGHUP failed, keeping current certificate: %v", err)
+				continue
+			}
+			log.Printf("tls: certificate reloaded on SIGHUP (SPKI pin %s)", manager.Pin())
+		}
+	}()
+	return manager, nil
+}
+
+// openBlobStore tạo blob store theo blob.backend
+func openBlobStore(blobCfg config.BlobConfig) (serverpkg.BlobStore, error) {
+	switch blobCfg.Backend {
+	case config.BlobBackendS3:
+		return serverpkg.NewS3BlobStore(serverpkg.S3Options{
+			Endpoint:  blobCfg.S3.Endpoint,
+			Bucket:    blobCfg.S3.Bucket,
+			Region:    blobCfg.S3.Region,
+			AccessKey: blobCfg.S3.AccessKey,
+			SecretKey: blobCfg.S3.SecretKey,
+			Prefix:    blobCfg.S3.Prefix,
+		})
+	default:
+		return serverpkg.NewFSBlobStore(blobCfg.Dir)
+	}
+}
+
+// runBlobs xử lý `blobs gc`, `blobs migrate` (inline -> blob store) và
+// `blobs inline` (blob store -> inline, chạy trước `migrate down` qua migration 006)
+func runBlobs(db *sql.DB, cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: blobs gc|migrate|inline")
+	}
+	if err := cfg.Blob.Validate(); err != nil {
+		return err
+	}
+	blobs, err := openBlobStore(cfg.Blob)
+	if err != nil {
+		return err
+	}
+	srv := serverpkg.NewSQLiteServer(db, blobs)
+	srv.BlobGCGrace = cfg.Blob.GCGrace.Duration
+	ctx := context.Background()
+
+	switch args[0] {
+	case "gc":
+		n, err := srv.CollectGarbageBlobs(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d unreferenced blob(s) deleted\n", n)
+	case "migrate":
+		n, err := srv.MoveInlineContentToBlobs(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d row(s) moved to the blob store\n", n)
+	case "inline":
+		n, err := srv.MoveBlobContentInline(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d row(s) copied back inline\n", n)
+	default:
+		return fmt.Errorf("unknown blobs command %q (use gc, migrate or inline)", args[0])
+	}
+	return nil
+}
+
+// runQuota xử lý `quota top [-n N]`, `quota show <username>` và
+// `quota set <username> <bytes|default|unlimited>` (bytes có thể dùng hậu tố K, M, G, T)
+func runQuota(db *sql.DB, cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: quota top|show|set")
+	}
+	store := serverpkg.NewSQLiteStore(db)
+	ctx := context.Background()
+
+	switch args[0] {
+	case "top":
+		fs := flag.NewFlagSet("quota top", flag.ExitOnError)
+		n := fs.Int("n", 20, "number of users to list")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		usages, err := store.ListTopUsage(ctx, *n)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "USERNAME\tUSED\tQUOTA\tNOTES\tVERSIONS\tLINKS\tNOTE COUNT\tTRASHED\tACTIVE LINKS")
+		for _, u := range usages {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\n", u.Username,
+				formatBytes(u.Bytes()), formatQuota(u.QuotaBytes, cfg.Quota.DefaultBytes),
+				formatBytes(u.NoteBytes), formatBytes(u.VersionBytes), formatBytes(u.LinkBytes),
+				u.NoteCount, u.TrashedCount, u.ActiveLinks)
+		}
+		return w.Flush()
+	case "show":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: quota show <username>")
+		}
+		user, err := store.GetUserByUsername(ctx, args[1])
+		if err != nil {
+			return fmt.Errorf("user %q: %w", args[1], err)
+		}
+		u, err := store.GetUsage(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("user:            %s (%s)\n", u.Username, u.UserID)
+		fmt.Printf("used:            %s (%d bytes)\n", formatBytes(u.Bytes()), u.Bytes())
+		fmt.Printf("quota:           %s\n", formatQuota(u.QuotaBytes, cfg.Quota.DefaultBytes))
+		fmt.Printf("notes:           %s in %d note(s), %d in trash\n", formatBytes(u.NoteBytes), u.NoteCount, u.TrashedCount)
+		fmt.Printf("versions:        %s\n", formatBytes(u.VersionBytes))
+		fmt.Printf("share links:     %s in %d active link(s)\n", formatBytes(u.LinkBytes), u.ActiveLinks)
+		fmt.Printf("pending uploads: %s\n", formatBytes(u.UploadBytes))
+	case "set":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: quota set <username> <bytes|default|unlimited>")
+		}
+		user, err := store.GetUserByUsername(ctx, args[1])
+		if err != nil {
+			return fmt.Errorf("user %q: %w", args[1], err)
+		}
+		var quota *int64
+		switch args[2] {
+		case "default":
+		case "unlimited":
+			quota = new(int64)
+		default:
+			n, err := parseBytes(args[2])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid quota %q (use a positive size such as 5G, default or unlimited)", args[2])
+			}
+			quota = &n
+		}
+		if err := store.SetUserQuota(ctx, user.ID, quota); err != nil {
+			return err
+		}
+		fmt.Printf("quota of %s set to %s\n", user.Username, formatQuota(quota, cfg.Quota.DefaultBytes))
+	default:
+		return fmt.Errorf("unknown quota command %q (use top, show or set)", args[0])
+	}
+	return nil
+}
+
+// runLockout xử lý `lockout audit [-n N]` (các lần khóa gần nhất) và
+// `lockout clear <account|share_link|ip> <key>` (mở khóa trước thời hạn)
+func runLockout(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: lockout audit|clear")
+	}
+	store := serverpkg.NewSQLiteStore(db)
+	ctx := context.Background()
+
+	switch args[0] {
+	case "audit":
+		fs := flag.NewFlagSet("lockout audit", flag.ExitOnError)
+		n := fs.Int("n", 50, "number of events to list")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		events, err := store.ListAuditEvents(ctx, *n)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tEVENT\tSCOPE\tSUBJECT\tIP\tDETAIL")
+		for _, e := range events {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.CreatedAt, e.Event, e.Scope, e.Subject, e.IP, e.Detail)
+		}
+		return w.Flush()
+	case "clear":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: lockout clear <account|share_link|ip> <key>")
+		}
+		switch args[1] {
+		case serverpkg.AuthScopeAccount, serverpkg.AuthScopeShareLink, serverpkg.AuthScopeIP:
+		default:
+			return fmt.Errorf("unknown scope %q (use account, share_link or ip)", args[1])
+		}
+		if _, err := store.GetAuthFailure(ctx, args[1], args[2]); err != nil {
+			return fmt.Errorf("%s %q has no failed attempts: %w", args[1], args[2], err)
+		}
+		if err := store.ClearAuthFailures(ctx, args[1], args[2]); err != nil {
+			return err
+		}
+		fmt.Printf("%s %s: failed attempts cleared\n", args[1], args[2])
+	default:
+		return fmt.Errorf("unknown lockout command %q (use audit or clear)", args[0])
+	}
+	return nil
+}
+
+// formatQuota mô tả quota của user (nil = quota mặc định, 0 = không giới hạn)
+func formatQuota(quota *int64, defaultBytes int64) string {
+	suffix := ""
+	if quota == nil {
+		quota, suffix = &defaultBytes, " (default)"
+	}
+	if *quota == 0 {
+		return "unlimited" + suffix
+	}
+	return formatBytes(*quota) + suffix
+}
+
+// formatBytes in kích thước theo đơn vị nhị phân (vd: 1.5 GiB)
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// parseBytes đọc số byte, cho phép hậu tố K, M, G, T (bội số 1024)
+func parseBytes(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(s), "B"))
+	shift := 0
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		shift = 10 * (strings.IndexByte("KMGT", s[i]) + 1)
+		s = s[:i]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("size %s is too large", s)
+	}
+	return n << shift, nil
+}
+
+// runMigrate xử lý `migrate up [-seed]`, `migrate down [-steps N]` và `migrate status`
+func runMigrate(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down|status")
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	seed := fs.Bool("seed", false, "also apply development seed data (up only)")
+	steps := fs.Int("steps", 1, "number of migrations to roll back (down only)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := serverpkg.MigrateUp(db, *seed)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d migration(s) applied\n", n)
+	case "down":
+		n, err := serverpkg.MigrateDown(db, *steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d migration(s) rolled back\n", n)
+	case "status":
+		statuses, err := serverpkg.MigrationStatuses(db)
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			state := "pending"
+			switch {
+			case st.Applied:
+				state = "applied " + st.AppliedAt
+			case st.Seed:
+				state = "pending (seed, use: migrate up -seed)"
+			}
+			fmt.Printf("%03d  %-32s %s\n", st.Version, st.Name, state)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q (use up, down or status)", args[0])
+	}
+	return nil
 }
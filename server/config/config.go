@@ -1,25 +1,668 @@
 package config
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
 )
 
-// Config holds minimal server configuration used by main.
-type Config struct {
-	Port   string
-	DBPath string
+// DefaultJWTSecret là secret mẫu cũ; server từ chối khởi động nếu vẫn dùng giá trị này
+const DefaultJWTSecret = "your-secret-key-change-this-in-production"
+
+// minJWTSecretLen: độ dài tối thiểu của JWT secret (HS256)
+const minJWTSecretLen = 32
+
+// Duration cho phép viết thời lượng dạng chuỗi ("15m", "168h") trong file YAML/TOML,
+// biến môi trường và flag
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler (dùng bởi YAML và TOML)
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// Set implements flag.Value
+func (d *Duration) Set(s string) error {
+	return d.UnmarshalText([]byte(s))
+}
+
+// JWTConfig cấu hình ký và thời hạn token
+type JWTConfig struct {
+	Secret          string   `yaml:"secret" toml:"secret"`
+	SecretFile      string   `yaml:"secret_file" toml:"secret_file"`
+	AccessTokenTTL  Duration `yaml:"access_token_ttl" toml:"access_token_ttl"`
+	RefreshTokenTTL Duration `yaml:"refresh_token_ttl" toml:"refresh_token_ttl"`
+}
+
+// Argon2Config là chi phí Argon2id khi băm mật khẩu phía server.
+// Lưu ý: hash không lưu kèm tham số, đổi giá trị sẽ làm các hash cũ không còn khớp.
+type Argon2Config struct {
+	Time      uint32 `yaml:"time" toml:"time"`
+	MemoryKiB uint32 `yaml:"memory_kib" toml:"memory_kib"`
+	Threads   uint8  `yaml:"threads" toml:"threads"`
+}
+
+// RateLimitConfig giới hạn số yêu cầu mỗi IP trong một cửa sổ thời gian
+type RateLimitConfig struct {
+	Requests int      `yaml:"requests" toml:"requests"`
+	Window   Duration `yaml:"window" toml:"window"`
+}
+
+// CORSConfig danh sách origin được phép ("*" = mọi origin)
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+}
+
+// UploadConfig giới hạn của resumable upload (POST /api/uploads)
+type UploadConfig struct {
+	// MaxSize: tổng số byte ciphertext tối đa của một note
+	MaxSize int64 `yaml:"max_size" toml:"max_size"`
+	// MaxChunkSize: chunk lớn nhất client được chọn (phải <= max_upload_bytes)
+	MaxChunkSize int64 `yaml:"max_chunk_size" toml:"max_chunk_size"`
+	// SessionTTL: session chưa finalize sau thời gian này bị xóa
+	SessionTTL Duration `yaml:"session_ttl" toml:"session_ttl"`
+}
+
+// Giá trị hợp lệ của blob.backend
+const (
+	BlobBackendFS = "fs"
+	BlobBackendS3 = "s3"
+)
+
+// BlobConfig nơi lưu ciphertext của note và share link
+type BlobConfig struct {
+	// Backend: fs (thư mục cục bộ) | s3 (S3-compatible: AWS, MinIO, fakes3)
+	Backend string `yaml:"backend" toml:"backend"`
+	// Dir: thư mục gốc của backend fs
+	Dir string `yaml:"dir" toml:"dir"`
+	// GCGrace: blob không còn được tham chiếu chỉ bị xóa khi cũ hơn thời gian này
+	GCGrace Duration `yaml:"gc_grace" toml:"gc_grace"`
+	S3      S3Config `yaml:"s3" toml:"s3"`
+}
+
+// S3Config kết nối tới bucket S3 (path-style)
+type S3Config struct {
+	Endpoint      string `yaml:"endpoint" toml:"endpoint"`
+	Bucket        string `yaml:"bucket" toml:"bucket"`
+	Region        string `yaml:"region" toml:"region"`
+	AccessKey     string `yaml:"access_key" toml:"access_key"`
+	SecretKey     string `yaml:"secret_key" toml:"secret_key"`
+	SecretKeyFile string `yaml:"secret_key_file" toml:"secret_key_file"`
+	// Prefix được thêm trước key của blob trong bucket (vd: "blobs/")
+	Prefix string `yaml:"prefix" toml:"prefix"`
 }
 
-// LoadConfig loads configuration from environment variables with sensible defaults.
-func LoadConfig() (*Config, error) {
-	port := os.Getenv("SERVER_PORT")
-	if port == "" {
-		port = "8080"
+// Validate kiểm tra cấu hình blob store (dùng cả cho lệnh `blobs`)
+func (b BlobConfig) Validate() error {
+	var errs []error
+	switch b.Backend {
+	case BlobBackendFS:
+		if b.Dir == "" {
+			errs = append(errs, errors.New("blob.dir is required for the fs backend"))
+		}
+	case BlobBackendS3:
+		if b.S3.Endpoint == "" || b.S3.Bucket == "" {
+			errs = append(errs, errors.New("blob.s3 endpoint and bucket are required for the s3 backend"))
+		}
+		if b.S3.AccessKey == "" || b.S3.SecretKey == "" {
+			errs = append(errs, errors.New("blob.s3 access_key and secret_key (or secret_key_file) are required"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid blob.backend %q (use fs or s3)", b.Backend))
+	}
+	if b.GCGrace.Duration <= 0 {
+		errs = append(errs, errors.New("blob.gc_grace must be positive"))
 	}
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
+	return errors.Join(errs...)
+}
+
+// TrashConfig thùng rác của note (DELETE /api/notes/:id chỉ chuyển note vào thùng rác)
+type TrashConfig struct {
+	// Retention: note nằm trong thùng rác lâu hơn thời gian này bị maintenance xóa vĩnh viễn
+	Retention Duration `yaml:"retention" toml:"retention"`
+}
+
+// QuotaConfig giới hạn dung lượng ciphertext của mỗi user (note, phiên bản cũ, share link)
+type QuotaConfig struct {
+	// DefaultBytes: quota của user không có quota riêng (0 = không giới hạn).
+	// Quota riêng được đặt bằng lệnh `quota set`.
+	DefaultBytes int64 `yaml:"default_bytes" toml:"default_bytes"`
+}
+
+// LockoutConfig chống đoán mật khẩu khi login (kể cả mã 2FA) và khi mở share link có mật khẩu
+type LockoutConfig struct {
+	// FreeAttempts: số lần sai liên tiếp của một tài khoản / share link chưa phải chờ
+	FreeAttempts int `yaml:"free_attempts" toml:"free_attempts"`
+	// BaseDelay: thời gian chờ sau lần sai thứ free_attempts+1, gấp đôi sau mỗi lần sai tiếp theo
+	BaseDelay Duration `yaml:"base_delay" toml:"base_delay"`
+	// MaxFailures: số lần sai để khóa tài khoản / share link trong Duration
+	MaxFailures int `yaml:"max_failures" toml:"max_failures"`
+	// IPMaxFailures: số lần sai từ một IP (mọi tài khoản và share link) để khóa IP đó
+	IPMaxFailures int      `yaml:"ip_max_failures" toml:"ip_max_failures"`
+	Duration      Duration `yaml:"duration" toml:"duration"`
+	// Window: lần sai cũ hơn thời gian này không còn được tính
+	Window Duration `yaml:"window" toml:"window"`
+}
+
+// Validate kiểm tra các ngưỡng lockout
+func (l LockoutConfig) Validate() error {
+	var errs []error
+	if l.MaxFailures <= 0 || l.IPMaxFailures <= 0 {
+		errs = append(errs, errors.New("lockout max_failures and ip_max_failures must be positive"))
+	}
+	if l.FreeAttempts < 0 || l.FreeAttempts >= l.MaxFailures {
+		errs = append(errs, errors.New("lockout.free_attempts must be between 0 and max_failures-1"))
+	}
+	if l.BaseDelay.Duration <= 0 || l.Duration.Duration <= 0 || l.Window.Duration <= 0 {
+		errs = append(errs, errors.New("lockout base_delay, duration and window must be positive"))
+	} else if l.BaseDelay.Duration > l.Duration.Duration {
+		errs = append(errs, errors.New("lockout.base_delay must not exceed lockout.duration"))
+	}
+	return errors.Join(errs...)
+}
+
+// Giá trị hợp lệ của tls.client_auth
+const (
+	ClientAuthNone          = "none"
+	ClientAuthRequest       = "request"
+	ClientAuthVerifyIfGiven = "verify_if_given"
+	ClientAuthRequire       = "require"
+)
+
+// Đường dẫn mặc định của certificate tự ký khi bật tls.self_signed
+const (
+	DefaultSelfSignedCertFile = "certs/dev-cert.pem"
+	DefaultSelfSignedKeyFile  = "certs/dev-key.pem"
+)
+
+// TLSConfig đường dẫn certificate và private key; để trống để chạy HTTP.
+// Certificate, key và client CA được nạp lại khi file thay đổi hoặc khi nhận SIGHUP.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string `yaml:"key_file" toml:"key_file"`
+	// SelfSigned tự tạo certificate tự ký (chỉ dùng khi dev) nếu file chưa tồn tại
+	SelfSigned bool `yaml:"self_signed" toml:"self_signed"`
+	// ClientCAFile là CA bundle dùng để xác thực client certificate (mTLS)
+	ClientCAFile string `yaml:"client_ca_file" toml:"client_ca_file"`
+	// ClientAuth: none | request | verify_if_given | require
+	ClientAuth string `yaml:"client_auth" toml:"client_auth"`
+	// ReloadInterval: chu kỳ kiểm tra file certificate thay đổi
+	ReloadInterval Duration `yaml:"reload_interval" toml:"reload_interval"`
+}
+
+// Enabled cho biết server có chạy HTTPS hay không
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || t.SelfSigned
+}
+
+// Config holds server configuration used by main.
+// Thứ tự ưu tiên: giá trị mặc định < file cấu hình < biến môi trường < flag.
+type Config struct {
+	Port           string          `yaml:"port" toml:"port"`
+	DBPath         string          `yaml:"db_path" toml:"db_path"`
+	JWT            JWTConfig       `yaml:"jwt" toml:"jwt"`
+	Pepper         string          `yaml:"pepper" toml:"pepper"`
+	PepperFile     string          `yaml:"pepper_file" toml:"pepper_file"`
+	Argon2         Argon2Config    `yaml:"argon2" toml:"argon2"`
+	RateLimit      RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	CORS           CORSConfig      `yaml:"cors" toml:"cors"`
+	MaxUploadBytes int64           `yaml:"max_upload_bytes" toml:"max_upload_bytes"`
+	Upload         UploadConfig    `yaml:"upload" toml:"upload"`
+	Blob           BlobConfig      `yaml:"blob" toml:"blob"`
+	Trash          TrashConfig     `yaml:"trash" toml:"trash"`
+	Quota          QuotaConfig     `yaml:"quota" toml:"quota"`
+	Lockout        LockoutConfig   `yaml:"lockout" toml:"lockout"`
+	TLS            TLSConfig       `yaml:"tls" toml:"tls"`
+	// MaintenanceInterval: chu kỳ dọn token hết hạn và vô hiệu hóa share link (0 = tắt)
+	MaintenanceInterval Duration `yaml:"maintenance_interval" toml:"maintenance_interval"`
+	// ShutdownTimeout: thời gian tối đa chờ request đang xử lý khi tắt server
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+// Default trả về cấu hình mặc định (JWT secret để trống, bắt buộc phải cấu hình)
+func Default() *Config {
+	return &Config{
+		Port: "8080",
 		// Default database path in server/database/ folder
-		dbPath = "server/database/secure_notes.db"
+		DBPath: "server/database/secure_notes.db",
+		JWT: JWTConfig{
+			AccessTokenTTL:  Duration{15 * time.Minute},
+			RefreshTokenTTL: Duration{7 * 24 * time.Hour},
+		},
+		Argon2: Argon2Config{
+			Time:      1,
+			MemoryKiB: 64 * 1024, // 64 MB
+			Threads:   4,
+		},
+		RateLimit: RateLimitConfig{
+			Requests: 100,
+			Window:   Duration{time.Minute},
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+		},
+		// POST /api/notes gửi base64 + JSON (~4/3); file lớn dùng upload chunk
+		MaxUploadBytes: 100 << 20,
+		Upload: UploadConfig{
+			MaxSize:      4 << 30,
+			MaxChunkSize: 8 << 20,
+			SessionTTL:   Duration{24 * time.Hour},
+		},
+		Blob: BlobConfig{
+			Backend: BlobBackendFS,
+			Dir:     "server/database/blobs",
+			GCGrace: Duration{time.Hour},
+			S3:      S3Config{Region: "us-east-1"},
+		},
+		Trash: TrashConfig{
+			Retention: Duration{30 * 24 * time.Hour},
+		},
+		Quota: QuotaConfig{
+			DefaultBytes: 10 << 30,
+		},
+		Lockout: LockoutConfig{
+			FreeAttempts:  3,
+			BaseDelay:     Duration{time.Second},
+			MaxFailures:   10,
+			IPMaxFailures: 100,
+			Duration:      Duration{15 * time.Minute},
+			Window:        Duration{time.Hour},
+		},
+		TLS: TLSConfig{
+			ClientAuth:     ClientAuthNone,
+			ReloadInterval: Duration{30 * time.Second},
+		},
+		MaintenanceInterval: Duration{time.Hour},
+		ShutdownTimeout:     Duration{15 * time.Second},
+	}
+}
+
+// LoadConfig đọc cấu hình từ file (-config hoặc CONFIG_FILE), biến môi trường và flag.
+// args là os.Args[1:]; các tham số còn lại sau flag (vd: "migrate up") được trả về.
+func LoadConfig(args []string) (*Config, []string, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to YAML or TOML config file")
+	var fl Config
+	fs.StringVar(&fl.Port, "port", "", "listen port")
+	fs.StringVar(&fl.DBPath, "db", "", "SQLite database path")
+	fs.StringVar(&fl.JWT.SecretFile, "jwt-secret-file", "", "file containing the JWT signing secret")
+	fs.Var(&fl.JWT.AccessTokenTTL, "access-token-ttl", "access token lifetime (e.g. 15m)")
+	fs.Var(&fl.JWT.RefreshTokenTTL, "refresh-token-ttl", "refresh token lifetime (e.g. 168h)")
+	fs.StringVar(&fl.PepperFile, "pepper-file", "", "file containing the password pepper")
+	argonTime := fs.Uint("argon2-time", 0, "Argon2id iterations")
+	argonMemory := fs.Uint("argon2-memory-kib", 0, "Argon2id memory in KiB")
+	argonThreads := fs.Uint("argon2-threads", 0, "Argon2id parallelism")
+	fs.IntVar(&fl.RateLimit.Requests, "rate-limit", 0, "max requests per IP per window")
+	fs.Var(&fl.RateLimit.Window, "rate-window", "rate limit window (e.g. 1m)")
+	corsOrigins := fs.String("cors-origins", "", "comma-separated allowed CORS origins")
+	fs.Int64Var(&fl.MaxUploadBytes, "max-upload-bytes", 0, "max request body size in bytes")
+	fs.Int64Var(&fl.Upload.MaxSize, "upload-max-size", 0, "max ciphertext size of a chunked upload in bytes")
+	fs.Int64Var(&fl.Upload.MaxChunkSize, "upload-max-chunk-size", 0, "max chunk size of a chunked upload in bytes")
+	fs.Var(&fl.Upload.SessionTTL, "upload-session-ttl", "lifetime of an unfinished upload session (e.g. 24h)")
+	fs.StringVar(&fl.Blob.Backend, "blob-backend", "", "blob storage backend: fs or s3")
+	fs.StringVar(&fl.Blob.Dir, "blob-dir", "", "root directory of the fs blob backend")
+	fs.StringVar(&fl.Blob.S3.Endpoint, "s3-endpoint", "", "S3-compatible endpoint URL")
+	fs.StringVar(&fl.Blob.S3.Bucket, "s3-bucket", "", "S3 bucket for blobs")
+	fs.Var(&fl.Trash.Retention, "trash-retention", "how long deleted notes stay in the trash (e.g. 720h)")
+	// -1 = không truyền flag (0 là giá trị hợp lệ: không giới hạn)
+	fs.Int64Var(&fl.Quota.DefaultBytes, "quota-default-bytes", -1, "default per-user storage quota in bytes (0 = unlimited)")
+	fs.IntVar(&fl.Lockout.MaxFailures, "lockout-max-failures", 0, "failed logins or share link passwords before the account or link is locked")
+	fs.IntVar(&fl.Lockout.IPMaxFailures, "lockout-ip-max-failures", 0, "failed attempts from one IP before the IP is locked")
+	fs.Var(&fl.Lockout.Duration, "lockout-duration", "how long a lockout lasts (e.g. 15m)")
+	fs.StringVar(&fl.TLS.CertFile, "tls-cert", "", "TLS certificate file")
+	fs.StringVar(&fl.TLS.KeyFile, "tls-key", "", "TLS private key file")
+	fs.BoolVar(&fl.TLS.SelfSigned, "tls-self-signed", false, "generate a self-signed certificate for development")
+	fs.StringVar(&fl.TLS.ClientCAFile, "tls-client-ca", "", "CA bundle for verifying client certificates")
+	fs.StringVar(&fl.TLS.ClientAuth, "tls-client-auth", "", "client certificate policy: none, request, verify_if_given, require")
+	fs.Var(&fl.MaintenanceInterval, "maintenance-interval", "interval between cleanup runs (e.g. 1h)")
+	fs.Var(&fl.ShutdownTimeout, "shutdown-timeout", "max time to drain in-flight requests on shutdown")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(cfg, *configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := applyEnv(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	// Flag (chỉ các flag được truyền) ghi đè file và env
+	fl.Argon2 = Argon2Config{Time: uint32(*argonTime), MemoryKiB: uint32(*argonMemory), Threads: uint8(*argonThreads)}
+	if *corsOrigins != "" {
+		fl.CORS.AllowedOrigins = splitList(*corsOrigins)
+	}
+	merge(cfg, &fl)
+
+	if cfg.TLS.SelfSigned {
+		if cfg.TLS.CertFile == "" {
+			cfg.TLS.CertFile = DefaultSelfSignedCertFile
+		}
+		if cfg.TLS.KeyFile == "" {
+			cfg.TLS.KeyFile = DefaultSelfSignedKeyFile
+		}
+	}
+
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// loadFile đọc file YAML (.yaml/.yml) hoặc TOML (.toml) đè lên cfg
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("unsupported config file type %q (use .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv ghi đè cfg bằng các biến môi trường đã đặt
+func applyEnv(cfg *Config) error {
+	var errs []error
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	num := func(key string, bits int, set func(uint64)) {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.ParseUint(v, 10, bits)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			set(n)
+		}
+	}
+	dur := func(key string, dst *Duration) {
+		if v, ok := os.LookupEnv(key); ok {
+			if err := dst.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+		}
+	}
+
+	str("SERVER_PORT", &cfg.Port)
+	str("DB_PATH", &cfg.DBPath)
+	str("JWT_SECRET", &cfg.JWT.Secret)
+	str("JWT_SECRET_FILE", &cfg.JWT.SecretFile)
+	dur("ACCESS_TOKEN_TTL", &cfg.JWT.AccessTokenTTL)
+	dur("REFRESH_TOKEN_TTL", &cfg.JWT.RefreshTokenTTL)
+	str("PASSWORD_PEPPER", &cfg.Pepper)
+	str("PASSWORD_PEPPER_FILE", &cfg.PepperFile)
+	num("ARGON2_TIME", 32, func(n uint64) { cfg.Argon2.Time = uint32(n) })
+	num("ARGON2_MEMORY_KIB", 32, func(n uint64) { cfg.Argon2.MemoryKiB = uint32(n) })
+	num("ARGON2_THREADS", 8, func(n uint64) { cfg.Argon2.Threads = uint8(n) })
+	num("RATE_LIMIT_REQUESTS", 31, func(n uint64) { cfg.RateLimit.Requests = int(n) })
+	dur("RATE_LIMIT_WINDOW", &cfg.RateLimit.Window)
+	if v, ok := os.LookupEnv("CORS_ALLOWED_ORIGINS"); ok {
+		cfg.CORS.AllowedOrigins = splitList(v)
+	}
+	num("MAX_UPLOAD_BYTES", 63, func(n uint64) { cfg.MaxUploadBytes = int64(n) })
+	num("UPLOAD_MAX_SIZE", 63, func(n uint64) { cfg.Upload.MaxSize = int64(n) })
+	num("UPLOAD_MAX_CHUNK_SIZE", 63, func(n uint64) { cfg.Upload.MaxChunkSize = int64(n) })
+	dur("UPLOAD_SESSION_TTL", &cfg.Upload.SessionTTL)
+	str("BLOB_BACKEND", &cfg.Blob.Backend)
+	str("BLOB_DIR", &cfg.Blob.Dir)
+	dur("BLOB_GC_GRACE", &cfg.Blob.GCGrace)
+	str("S3_ENDPOINT", &cfg.Blob.S3.Endpoint)
+	str("S3_BUCKET", &cfg.Blob.S3.Bucket)
+	str("S3_REGION", &cfg.Blob.S3.Region)
+	str("S3_ACCESS_KEY", &cfg.Blob.S3.AccessKey)
+	str("S3_SECRET_KEY", &cfg.Blob.S3.SecretKey)
+	str("S3_SECRET_KEY_FILE", &cfg.Blob.S3.SecretKeyFile)
+	str("S3_PREFIX", &cfg.Blob.S3.Prefix)
+	dur("TRASH_RETENTION", &cfg.Trash.Retention)
+	num("QUOTA_DEFAULT_BYTES", 63, func(n uint64) { cfg.Quota.DefaultBytes = int64(n) })
+	num("LOCKOUT_FREE_ATTEMPTS", 31, func(n uint64) { cfg.Lockout.FreeAttempts = int(n) })
+	dur("LOCKOUT_BASE_DELAY", &cfg.Lockout.BaseDelay)
+	num("LOCKOUT_MAX_FAILURES", 31, func(n uint64) { cfg.Lockout.MaxFailures = int(n) })
+	num("LOCKOUT_IP_MAX_FAILURES", 31, func(n uint64) { cfg.Lockout.IPMaxFailures = int(n) })
+	dur("LOCKOUT_DURATION", &cfg.Lockout.Duration)
+	dur("LOCKOUT_WINDOW", &cfg.Lockout.Window)
+	str("TLS_CERT_FILE", &cfg.TLS.CertFile)
+	str("TLS_KEY_FILE", &cfg.TLS.KeyFile)
+	if v, ok := os.LookupEnv("TLS_SELF_SIGNED"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("TLS_SELF_SIGNED: %w", err))
+		}
+		cfg.TLS.SelfSigned = b
+	}
+	str("TLS_CLIENT_CA_FILE", &cfg.TLS.ClientCAFile)
+	str("TLS_CLIENT_AUTH", &cfg.TLS.ClientAuth)
+	dur("TLS_RELOAD_INTERVAL", &cfg.TLS.ReloadInterval)
+	dur("MAINTENANCE_INTERVAL", &cfg.MaintenanceInterval)
+	dur("SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
+	return errors.Join(errs...)
+}
+
+// merge chép các giá trị khác rỗng của src (từ flag) sang dst
+func merge(dst, src *Config) {
+	setStr := func(d *string, s string) {
+		if s != "" {
+			*d = s
+		}
+	}
+	setDur := func(d *Duration, s Duration) {
+		if s.Duration != 0 {
+			*d = s
+		}
+	}
+	setStr(&dst.Port, src.Port)
+	setStr(&dst.DBPath, src.DBPath)
+	setStr(&dst.JWT.SecretFile, src.JWT.SecretFile)
+	setDur(&dst.JWT.AccessTokenTTL, src.JWT.AccessTokenTTL)
+	setDur(&dst.JWT.RefreshTokenTTL, src.JWT.RefreshTokenTTL)
+	setStr(&dst.PepperFile, src.PepperFile)
+	if src.Argon2.Time != 0 {
+		dst.Argon2.Time = src.Argon2.Time
+	}
+	if src.Argon2.MemoryKiB != 0 {
+		dst.Argon2.MemoryKiB = src.Argon2.MemoryKiB
+	}
+	if src.Argon2.Threads != 0 {
+		dst.Argon2.Threads = src.Argon2.Threads
+	}
+	if src.RateLimit.Requests != 0 {
+		dst.RateLimit.Requests = src.RateLimit.Requests
+	}
+	setDur(&dst.RateLimit.Window, src.RateLimit.Window)
+	if len(src.CORS.AllowedOrigins) > 0 {
+		dst.CORS.AllowedOrigins = src.CORS.AllowedOrigins
+	}
+	if src.MaxUploadBytes != 0 {
+		dst.MaxUploadBytes = src.MaxUploadBytes
+	}
+	if src.Upload.MaxSize != 0 {
+		dst.Upload.MaxSize = src.Upload.MaxSize
+	}
+	if src.Upload.MaxChunkSize != 0 {
+		dst.Upload.MaxChunkSize = src.Upload.MaxChunkSize
+	}
+	setDur(&dst.Upload.SessionTTL, src.Upload.SessionTTL)
+	setStr(&dst.Blob.Backend, src.Blob.Backend)
+	setStr(&dst.Blob.Dir, src.Blob.Dir)
+	setStr(&dst.Blob.S3.Endpoint, src.Blob.S3.Endpoint)
+	setStr(&dst.Blob.S3.Bucket, src.Blob.S3.Bucket)
+	setDur(&dst.Trash.Retention, src.Trash.Retention)
+	if src.Quota.DefaultBytes != -1 {
+		dst.Quota.DefaultBytes = src.Quota.DefaultBytes
+	}
+	if src.Lockout.MaxFailures != 0 {
+		dst.Lockout.MaxFailures = src.Lockout.MaxFailures
+	}
+	if src.Lockout.IPMaxFailures != 0 {
+		dst.Lockout.IPMaxFailures = src.Lockout.IPMaxFailures
+	}
+	setDur(&dst.Lockout.Duration, src.Lockout.Duration)
+	setStr(&dst.TLS.CertFile, src.TLS.CertFile)
+	setStr(&dst.TLS.KeyFile, src.TLS.KeyFile)
+	if src.TLS.SelfSigned {
+		dst.TLS.SelfSigned = true
+	}
+	setStr(&dst.TLS.ClientCAFile, src.TLS.ClientCAFile)
+	setStr(&dst.TLS.ClientAuth, src.TLS.ClientAuth)
+	setDur(&dst.MaintenanceInterval, src.MaintenanceInterval)
+	setDur(&dst.ShutdownTimeout, src.ShutdownTimeout)
+}
+
+// resolveSecrets đọc secret/pepper từ file nếu được cấu hình (file ưu tiên hơn giá trị trực tiếp)
+func (c *Config) resolveSecrets() error {
+	if c.JWT.SecretFile != "" {
+		secret, err := readSecretFile(c.JWT.SecretFile)
+		if err != nil {
+			return fmt.Errorf("jwt secret file: %w", err)
+		}
+		c.JWT.Secret = secret
+	}
+	if c.PepperFile != "" {
+		pepper, err := readSecretFile(c.PepperFile)
+		if err != nil {
+			return fmt.Errorf("pepper file: %w", err)
+		}
+		c.Pepper = pepper
+	}
+	if c.Blob.S3.SecretKeyFile != "" {
+		secret, err := readSecretFile(c.Blob.S3.SecretKeyFile)
+		if err != nil {
+			return fmt.Errorf("s3 secret key file: %w", err)
+		}
+		c.Blob.S3.SecretKey = secret
+	}
+	return nil
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// Validate kiểm tra cấu hình trước khi chạy server.
+// Từ chối khởi động khi JWT secret trống, quá ngắn hoặc là secret mặc định.
+func (c *Config) Validate() error {
+	var errs []error
+	switch {
+	case c.JWT.Secret == "":
+		errs = append(errs, errors.New("JWT secret is not configured (set JWT_SECRET, JWT_SECRET_FILE or jwt.secret_file)"))
+	case c.JWT.Secret == DefaultJWTSecret:
+		errs = append(errs, errors.New("JWT secret is the default placeholder, refusing to start"))
+	case len(c.JWT.Secret) < minJWTSecretLen:
+		errs = append(errs, fmt.Errorf("JWT secret must be at least %d characters", minJWTSecretLen))
+	}
+	if c.Port == "" {
+		errs = append(errs, errors.New("port is required"))
+	}
+	if c.DBPath == "" {
+		errs = append(errs, errors.New("db_path is required"))
+	}
+	if c.JWT.AccessTokenTTL.Duration <= 0 || c.JWT.RefreshTokenTTL.Duration <= 0 {
+		errs = append(errs, errors.New("token TTLs must be positive"))
+	} else if c.JWT.RefreshTokenTTL.Duration <= c.JWT.AccessTokenTTL.Duration {
+		errs = append(errs, errors.New("refresh_token_ttl must be longer than access_token_ttl"))
+	}
+	if c.Argon2.Time == 0 || c.Argon2.Threads == 0 || c.Argon2.MemoryKiB < 8*uint32(c.Argon2.Threads) {
+		errs = append(errs, errors.New("invalid argon2 parameters (time >= 1, threads >= 1, memory_kib >= 8*threads)"))
+	}
+	if c.RateLimit.Requests <= 0 || c.RateLimit.Window.Duration <= 0 {
+		errs = append(errs, errors.New("rate_limit requests and window must be positive"))
+	}
+	if len(c.CORS.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("cors.allowed_origins must not be empty"))
+	}
+	if c.MaxUploadBytes <= 0 {
+		errs = append(errs, errors.New("max_upload_bytes must be positive"))
+	}
+	if c.Upload.MaxSize <= 0 || c.Upload.MaxChunkSize <= 0 || c.Upload.SessionTTL.Duration <= 0 {
+		errs = append(errs, errors.New("upload max_size, max_chunk_size and session_ttl must be positive"))
+	} else if c.Upload.MaxChunkSize > c.MaxUploadBytes {
+		errs = append(errs, errors.New("upload.max_chunk_size must not exceed max_upload_bytes"))
+	}
+	if err := c.Blob.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Trash.Retention.Duration <= 0 {
+		errs = append(errs, errors.New("trash.retention must be positive"))
+	}
+	if c.Quota.DefaultBytes < 0 {
+		errs = append(errs, errors.New("quota.default_bytes must not be negative"))
+	}
+	if err := c.Lockout.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.TLS.Enabled() && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls requires both cert_file and key_file"))
+	}
+	switch c.TLS.ClientAuth {
+	case ClientAuthNone, ClientAuthRequest:
+	case ClientAuthVerifyIfGiven, ClientAuthRequire:
+		if c.TLS.ClientCAFile == "" {
+			errs = append(errs, fmt.Errorf("tls.client_auth %q requires tls.client_ca_file", c.TLS.ClientAuth))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid tls.client_auth %q (use none, request, verify_if_given or require)", c.TLS.ClientAuth))
+	}
+	if c.TLS.ClientAuth != ClientAuthNone && !c.TLS.Enabled() {
+		errs = append(errs, errors.New("tls.client_auth requires TLS to be enabled"))
+	}
+	if c.MaintenanceInterval.Duration < 0 {
+		errs = append(errs, errors.New("maintenance_interval must not be negative"))
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+	if c.TLS.Enabled() && c.TLS.ReloadInterval.Duration < 0 {
+		errs = append(errs, errors.New("tls.reload_interval must not be negative"))
 	}
-	return &Config{Port: port, DBPath: dbPath}, nil
+	return errors.Join(errs...)
 }
@@ -0,0 +1,13 @@
+-- Rollback: drop the initial schema (ALL DATA IS LOST)
+
+DROP VIEW IF EXISTS active_shared_links;
+
+DROP TRIGGER IF EXISTS update_notes_timestamp;
+DROP TRIGGER IF EXISTS update_user_keys_timestamp;
+
+DROP TABLE IF EXISTS token_blacklist;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS shared_links;
+DROP TABLE IF EXISTS notes;
+DROP TABLE IF EXISTS user_keys;
+DROP TABLE IF EXISTS users;
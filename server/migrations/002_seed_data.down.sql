@@ -0,0 +1,35 @@
+-- Rollback: remove development seed data
+
+DELETE FROM token_blacklist WHERE jti IN (
+    'jti-alice-logout-12345678-1234-1234-1234-123456789012',
+    'jti-old-token-87654321-4321-4321-4321-210987654321'
+);
+
+DELETE FROM refresh_tokens WHERE id IN (
+    'g0eebc99-9c0b-4ef8-bb6d-6bb9bd380aaa',
+    'g1ffbc99-9c0b-4ef8-bb6d-6bb9bd380bbb',
+    'g2ggcc99-9c0b-4ef8-bb6d-6bb9bd380ccc'
+);
+
+DELETE FROM shared_links WHERE id IN (
+    'f0eebc999c0b4ef8bb6d6bb9bd380a77',
+    'f1ffbc999c0b4ef8bb6d6bb9bd380a88',
+    'f2ggcc999c0b4ef8bb6d6bb9bd380a99'
+);
+
+DELETE FROM notes WHERE id IN (
+    'd0eebc999c0b4ef8bb6d6bb9bd380a44',
+    'd1ffbc999c0b4ef8bb6d6bb9bd380a55',
+    'e0eebc999c0b4ef8bb6d6bb9bd380a66'
+);
+
+DELETE FROM user_keys WHERE user_id IN (
+    'a0eebc999c0b4ef8bb6d6bb9bd380a11',
+    'b1ffbc999c0b4ef8bb6d6bb9bd380a22'
+);
+
+DELETE FROM users WHERE id IN (
+    'a0eebc999c0b4ef8bb6d6bb9bd380a11',
+    'b1ffbc999c0b4ef8bb6d6bb9bd380a22',
+    'c2ggcc999c0b4ef8bb6d6bb9bd380a33'
+);
@@ -0,0 +1,3 @@
+-- Rollback: remove user-to-user note sharing
+
+DROP TABLE IF EXISTS note_shares;
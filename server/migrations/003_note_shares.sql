@@ -0,0 +1,21 @@
+-- Migration: user-to-user note sharing (E2EE via Diffie-Hellman)
+-- SQLite3 compatible schema (using TEXT for UUID)
+
+-- ============================================================
+-- TABLE 7: note_shares - Notes shared with other users
+-- ============================================================
+CREATE TABLE IF NOT EXISTS note_shares (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    note_id TEXT NOT NULL,
+    recipient_id TEXT NOT NULL,                    -- User được chia sẻ
+    wrapped_key TEXT NOT NULL,                     -- K_Note được mã hóa bởi K_Session = HKDF(DH shared secret)
+    sender_public_key TEXT NOT NULL,               -- Public Key A của người gửi (để người nhận tính S = A^b mod p)
+    created_at TEXT DEFAULT (datetime('now')),
+    revoked_at TEXT,                               -- NULL = còn hiệu lực
+    UNIQUE (note_id, recipient_id),
+    FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE,
+    FOREIGN KEY (recipient_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_note_shares_note_id ON note_shares(note_id);
+CREATE INDEX idx_note_shares_recipient_id ON note_shares(recipient_id);
@@ -0,0 +1,4 @@
+-- Rollback: remove refresh token families
+
+DROP INDEX IF EXISTS idx_refresh_tokens_family_id;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
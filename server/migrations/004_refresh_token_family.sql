@@ -0,0 +1,8 @@
+-- Migration: refresh token rotation & reuse detection
+-- Mỗi lần đăng nhập tạo một "family"; mỗi lần refresh sinh token mới cùng family
+-- và đánh dấu token cũ is_revoked = 1. Nếu token đã revoke bị dùng lại,
+-- toàn bộ family bị thu hồi (dấu hiệu token bị đánh cắp).
+
+ALTER TABLE refresh_tokens ADD COLUMN family_id TEXT;
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
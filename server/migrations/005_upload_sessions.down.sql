@@ -0,0 +1,4 @@
+-- Rollback: remove resumable chunked uploads
+
+DROP TABLE IF EXISTS upload_chunks;
+DROP TABLE IF EXISTS upload_sessions;
@@ -0,0 +1,35 @@
+-- Migration: resumable chunked uploads
+-- Client tạo upload session (metadata của note), PUT từng chunk ciphertext theo số thứ tự,
+-- rồi finalize kèm SHA256 của toàn bộ ciphertext. Session chưa hoàn tất bị xóa khi hết hạn.
+
+-- ============================================================
+-- TABLE 8: upload_sessions - Phiên upload đang dở
+-- ============================================================
+CREATE TABLE IF NOT EXISTS upload_sessions (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    user_id TEXT NOT NULL,
+    title_enc TEXT NOT NULL,                       -- Metadata của note sẽ được tạo khi finalize
+    key_enc TEXT NOT NULL,
+    iv_meta TEXT NOT NULL,
+    total_size INTEGER NOT NULL,                   -- Tổng số byte ciphertext
+    chunk_size INTEGER NOT NULL,                   -- Kích thước mọi chunk (trừ chunk cuối)
+    created_at TEXT DEFAULT (datetime('now')),
+    expires_at TEXT NOT NULL,                      -- Format giống datetime('now') để so sánh trực tiếp
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_upload_sessions_user_id ON upload_sessions(user_id);
+CREATE INDEX idx_upload_sessions_expires_at ON upload_sessions(expires_at);
+
+-- ============================================================
+-- TABLE 9: upload_chunks - Các chunk đã nhận của một session
+-- ============================================================
+CREATE TABLE IF NOT EXISTS upload_chunks (
+    session_id TEXT NOT NULL,
+    chunk_index INTEGER NOT NULL,                  -- Bắt đầu từ 0
+    data BLOB NOT NULL,                            -- Ciphertext thô (không base64)
+    sha256 TEXT NOT NULL,                          -- Hex SHA256 của data, để client đối chiếu khi resume
+    received_at TEXT DEFAULT (datetime('now')),
+    PRIMARY KEY (session_id, chunk_index),
+    FOREIGN KEY (session_id) REFERENCES upload_sessions(id) ON DELETE CASCADE
+);
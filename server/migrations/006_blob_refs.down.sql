@@ -0,0 +1,28 @@
+-- Rollback: remove blob references
+-- Chạy `server blobs inline` TRƯỚC khi rollback, nếu không content_enc của các row
+-- đã chuyển sang blob store sẽ rỗng.
+
+DELETE FROM upload_sessions;
+DROP TABLE IF EXISTS upload_chunks;
+CREATE TABLE IF NOT EXISTS upload_chunks (
+    session_id TEXT NOT NULL,
+    chunk_index INTEGER NOT NULL,                  -- Bắt đầu từ 0
+    data BLOB NOT NULL,                            -- Ciphertext thô (không base64)
+    sha256 TEXT NOT NULL,                          -- Hex SHA256 của data, để client đối chiếu khi resume
+    received_at TEXT DEFAULT (datetime('now')),
+    PRIMARY KEY (session_id, chunk_index),
+    FOREIGN KEY (session_id) REFERENCES upload_sessions(id) ON DELETE CASCADE
+);
+
+DROP TRIGGER IF EXISTS update_notes_timestamp;
+CREATE TRIGGER IF NOT EXISTS update_notes_timestamp
+AFTER UPDATE ON notes
+FOR EACH ROW
+BEGIN
+    UPDATE notes SET updated_at = datetime('now') WHERE id = NEW.id;
+END;
+
+ALTER TABLE shared_links DROP COLUMN content_size;
+ALTER TABLE shared_links DROP COLUMN content_ref;
+ALTER TABLE notes DROP COLUMN content_size;
+ALTER TABLE notes DROP COLUMN content_ref;
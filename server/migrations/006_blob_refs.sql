@@ -0,0 +1,34 @@
+-- Migration: content-addressed blob storage
+-- Ciphertext của note và share link được chuyển ra blob store (thư mục chia shard
+-- theo SHA256 hoặc S3); row chỉ giữ content_ref (hex SHA256) và content_size.
+-- Row cũ có content_ref NULL vẫn đọc từ content_enc cho tới khi server chuyển
+-- chúng sang blob store lúc khởi động (content_enc khi đó được đặt thành '').
+
+ALTER TABLE notes ADD COLUMN content_ref TEXT;
+ALTER TABLE notes ADD COLUMN content_size INTEGER;
+ALTER TABLE shared_links ADD COLUMN content_ref TEXT;
+ALTER TABLE shared_links ADD COLUMN content_size INTEGER;
+
+-- Chuyển content sang blob store không phải là sửa note: trigger chỉ cập nhật
+-- updated_at khi UPDATE có ghi metadata của note (mọi lần sửa nội dung đều ghi key_enc)
+DROP TRIGGER IF EXISTS update_notes_timestamp;
+CREATE TRIGGER IF NOT EXISTS update_notes_timestamp
+AFTER UPDATE OF title_enc, key_enc, iv_meta ON notes
+FOR EACH ROW
+BEGIN
+    UPDATE notes SET updated_at = datetime('now') WHERE id = NEW.id;
+END;
+
+-- Chunk của upload session cũng là blob: sha256 chính là key trong blob store.
+-- Session đang dở bị hủy (dữ liệu chunk không chuyển); client sẽ tạo session mới.
+DELETE FROM upload_sessions;
+DROP TABLE IF EXISTS upload_chunks;
+CREATE TABLE IF NOT EXISTS upload_chunks (
+    session_id TEXT NOT NULL,
+    chunk_index INTEGER NOT NULL,                  -- Bắt đầu từ 0
+    size INTEGER NOT NULL,
+    sha256 TEXT NOT NULL,                          -- Hex SHA256 của chunk = key trong blob store
+    received_at TEXT DEFAULT (datetime('now')),
+    PRIMARY KEY (session_id, chunk_index),
+    FOREIGN KEY (session_id) REFERENCES upload_sessions(id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+-- Rollback: remove note version history (các phiên bản cũ bị xóa)
+
+DROP TABLE IF EXISTS note_versions;
+ALTER TABLE notes DROP COLUMN version;
@@ -0,0 +1,24 @@
+-- Migration: note editing with version history
+-- PUT /api/notes/:id chép trạng thái hiện tại của note sang note_versions rồi ghi
+-- nội dung mới và tăng notes.version. Phiên bản cũ chỉ giữ reference tới blob
+-- (cùng nội dung => cùng blob), nên khôi phục một phiên bản không sao chép ciphertext.
+
+ALTER TABLE notes ADD COLUMN version INTEGER NOT NULL DEFAULT 1;  -- Số phiên bản hiện tại
+
+-- ============================================================
+-- TABLE 10: note_versions - Các phiên bản cũ của note
+-- ============================================================
+CREATE TABLE IF NOT EXISTS note_versions (
+    note_id TEXT NOT NULL,
+    version INTEGER NOT NULL,                      -- Bắt đầu từ 1, tăng dần theo note
+    title_enc TEXT NOT NULL,
+    content_enc TEXT NOT NULL DEFAULT '',          -- Chỉ dùng cho note chưa chuyển sang blob store
+    content_ref TEXT,                              -- Key trong blob store
+    content_size INTEGER,
+    key_enc TEXT NOT NULL,                         -- K_Note của phiên bản này (bọc bởi K_Master)
+    iv_meta TEXT NOT NULL,
+    created_at TEXT,                               -- Thời điểm phiên bản này được ghi (updated_at cũ của note)
+    archived_at TEXT DEFAULT (datetime('now')),    -- Thời điểm bị thay bởi phiên bản mới hơn
+    PRIMARY KEY (note_id, version),
+    FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE
+);
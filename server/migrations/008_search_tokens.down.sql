@@ -0,0 +1,6 @@
+-- Rollback: remove encrypted search index
+
+ALTER TABLE upload_sessions DROP COLUMN search_tokens;
+ALTER TABLE note_versions DROP COLUMN search_tokens;
+DROP INDEX IF EXISTS idx_note_search_tokens_token;
+DROP TABLE IF EXISTS note_search_tokens;
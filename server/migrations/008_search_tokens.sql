@@ -0,0 +1,20 @@
+-- Migration: encrypted search (blind index)
+-- Client gửi token = HMAC(K_Search, từ khóa đã chuẩn hóa) cùng với note, K_Search suy ra
+-- từ K_Master bằng HKDF. Server chỉ so khớp token, không biết từ khóa gốc.
+
+-- ============================================================
+-- TABLE 11: note_search_tokens - Blind index của note (phiên bản hiện tại)
+-- ============================================================
+CREATE TABLE IF NOT EXISTS note_search_tokens (
+    note_id TEXT NOT NULL,
+    token TEXT NOT NULL,                           -- base64url(HMAC-SHA256), server không giải được
+    PRIMARY KEY (note_id, token),
+    FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_search_tokens_token ON note_search_tokens(token);
+
+-- Token của phiên bản cũ (cách nhau bởi dấu cách), khôi phục phiên bản thì khôi phục cả token
+ALTER TABLE note_versions ADD COLUMN search_tokens TEXT NOT NULL DEFAULT '';
+-- Token gửi lúc tạo upload session, gán cho note khi finalize
+ALTER TABLE upload_sessions ADD COLUMN search_tokens TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+-- Rollback: remove folders and tags
+
+DROP TABLE IF EXISTS folder_shares;
+DROP INDEX IF EXISTS idx_note_tags_tag_id;
+DROP TABLE IF EXISTS note_tags;
+DROP INDEX IF EXISTS idx_tags_user_id;
+DROP TABLE IF EXISTS tags;
+DROP INDEX IF EXISTS idx_notes_folder_id;
+ALTER TABLE notes DROP COLUMN folder_id;
+DROP INDEX IF EXISTS idx_folders_user_id;
+DROP TABLE IF EXISTS folders;
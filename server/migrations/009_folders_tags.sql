@@ -0,0 +1,65 @@
+-- Migration: folders and tags
+-- Tên folder và nhãn tag được client mã hóa bằng K_Master giống title của note,
+-- server chỉ lưu cấu trúc cây (parent_id) và quan hệ note - tag.
+
+-- ============================================================
+-- TABLE 12: folders - Thư mục của user (cây theo parent_id)
+-- ============================================================
+CREATE TABLE IF NOT EXISTS folders (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    parent_id TEXT,                                -- NULL = thư mục gốc
+    name_enc TEXT NOT NULL,                        -- Tên đã mã hóa
+    created_at TEXT DEFAULT (datetime('now')),
+    updated_at TEXT DEFAULT (datetime('now')),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (parent_id) REFERENCES folders(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_folders_user_id ON folders(user_id);
+
+ALTER TABLE notes ADD COLUMN folder_id TEXT;  -- folders.id, NULL = thư mục gốc
+
+CREATE INDEX IF NOT EXISTS idx_notes_folder_id ON notes(folder_id);
+
+-- ============================================================
+-- TABLE 13: tags - Nhãn của user
+-- ============================================================
+CREATE TABLE IF NOT EXISTS tags (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    label_enc TEXT NOT NULL,                       -- Nhãn đã mã hóa
+    created_at TEXT DEFAULT (datetime('now')),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_tags_user_id ON tags(user_id);
+
+-- ============================================================
+-- TABLE 14: note_tags - Tag gắn vào note
+-- ============================================================
+CREATE TABLE IF NOT EXISTS note_tags (
+    note_id TEXT NOT NULL,
+    tag_id TEXT NOT NULL,
+    PRIMARY KEY (note_id, tag_id),
+    FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_tags_tag_id ON note_tags(tag_id);
+
+-- ============================================================
+-- TABLE 15: folder_shares - Folder đã share cho user khác
+-- ============================================================
+-- Mỗi note trong folder vẫn được share qua note_shares (K_Note bọc cho người nhận);
+-- bảng này chỉ ghi lại ai đã được share cả folder để liệt kê và thu hồi.
+CREATE TABLE IF NOT EXISTS folder_shares (
+    id TEXT PRIMARY KEY,
+    folder_id TEXT NOT NULL,
+    recipient_id TEXT NOT NULL,
+    created_at TEXT DEFAULT (datetime('now')),
+    revoked_at TEXT,
+    UNIQUE (folder_id, recipient_id),
+    FOREIGN KEY (folder_id) REFERENCES folders(id) ON DELETE CASCADE,
+    FOREIGN KEY (recipient_id) REFERENCES users(id) ON DELETE CASCADE
+);
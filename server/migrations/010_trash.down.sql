@@ -0,0 +1,6 @@
+-- Rollback: remove trash bin
+
+DROP INDEX IF EXISTS idx_shared_links_note_id;
+ALTER TABLE shared_links DROP COLUMN note_id;
+DROP INDEX IF EXISTS idx_notes_deleted_at;
+ALTER TABLE notes DROP COLUMN deleted_at;
@@ -0,0 +1,13 @@
+-- Migration: trash bin (soft delete)
+-- DELETE /api/notes/:id chỉ đánh dấu deleted_at; note nằm trong thùng rác cho tới
+-- khi được khôi phục, bị xóa vĩnh viễn hoặc bị maintenance purge sau trash.retention.
+
+ALTER TABLE notes ADD COLUMN deleted_at TEXT;  -- NULL = chưa bị xóa
+
+CREATE INDEX IF NOT EXISTS idx_notes_deleted_at ON notes(deleted_at);
+
+-- Share link được tạo từ một note (POST /api/share với note_id) ngừng phân giải
+-- khi note nằm trong thùng rác và bị vô hiệu hóa khi note bị xóa vĩnh viễn
+ALTER TABLE shared_links ADD COLUMN note_id TEXT;  -- notes.id, NULL = không gắn với note
+
+CREATE INDEX IF NOT EXISTS idx_shared_links_note_id ON shared_links(note_id);
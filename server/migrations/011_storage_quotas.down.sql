@@ -0,0 +1,3 @@
+-- Rollback: remove per-user storage quotas
+
+ALTER TABLE users DROP COLUMN quota_bytes;
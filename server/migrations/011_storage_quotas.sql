@@ -0,0 +1,5 @@
+-- Migration: per-user storage quotas
+-- Dung lượng đã dùng được tính từ content_size của note, phiên bản cũ và share link
+-- nên không cần bảng riêng; users chỉ lưu quota ghi đè của từng user.
+
+ALTER TABLE users ADD COLUMN quota_bytes INTEGER;  -- NULL = quota mặc định (quota.default_bytes), 0 = không giới hạn
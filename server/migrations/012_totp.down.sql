@@ -0,0 +1,4 @@
+-- Rollback: remove TOTP two-factor authentication
+
+DROP TABLE IF EXISTS totp_recovery_codes;
+DROP TABLE IF EXISTS user_totp;
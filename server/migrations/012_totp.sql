@@ -0,0 +1,27 @@
+-- Migration: TOTP two-factor authentication (RFC 6238)
+-- Secret phải được server đọc để kiểm tra mã nên lưu dạng base32; recovery code
+-- chỉ lưu SHA256. 2FA chỉ có hiệu lực khi enabled = 1 (user đã nhập đúng một mã).
+
+-- ============================================================
+-- TABLE 16: user_totp - Secret TOTP của user
+-- ============================================================
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id TEXT PRIMARY KEY,
+    secret TEXT NOT NULL,                          -- Base32 (không padding)
+    enabled INTEGER NOT NULL DEFAULT 0,            -- 0 = đang chờ xác nhận mã đầu tiên
+    last_step INTEGER NOT NULL DEFAULT 0,          -- Time step của mã cuối cùng được chấp nhận (chống dùng lại mã)
+    created_at TEXT DEFAULT (datetime('now')),
+    enabled_at TEXT,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- ============================================================
+-- TABLE 17: totp_recovery_codes - Mã khôi phục dùng một lần
+-- ============================================================
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+    user_id TEXT NOT NULL,
+    code_hash TEXT NOT NULL,                       -- Hex SHA256(user_id + ":" + mã đã chuẩn hóa)
+    used_at TEXT,                                  -- NULL = chưa dùng
+    PRIMARY KEY (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+-- Rollback: remove failed-attempt tracking and audit log
+
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS auth_failures;
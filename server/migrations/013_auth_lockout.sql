@@ -0,0 +1,34 @@
+-- Migration: Chống đoán mật khẩu (login, mã 2FA, mật khẩu share link)
+-- Mỗi lần xác thực sai tăng bộ đếm theo tài khoản / share link và theo IP. Vượt
+-- ngưỡng thì phải chờ (tăng gấp đôi sau mỗi lần sai) rồi bị khóa tạm thời; mỗi
+-- lần khóa được ghi vào audit_log.
+
+-- ============================================================
+-- TABLE 18: auth_failures - Số lần xác thực sai liên tiếp
+-- ============================================================
+CREATE TABLE IF NOT EXISTS auth_failures (
+    scope TEXT NOT NULL,                           -- 'account' | 'share_link' | 'ip'
+    key TEXT NOT NULL,                             -- Username, share link ID hoặc địa chỉ IP
+    failures INTEGER NOT NULL DEFAULT 0,
+    first_failed_at TEXT NOT NULL,
+    last_failed_at TEXT NOT NULL,
+    locked_until TEXT,                             -- NULL = không bị chặn
+    PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_failures_last ON auth_failures(last_failed_at);
+
+-- ============================================================
+-- TABLE 19: audit_log - Sự kiện bảo mật (hiện tại: khóa tạm thời)
+-- ============================================================
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event TEXT NOT NULL,                           -- 'lockout'
+    scope TEXT NOT NULL,
+    subject TEXT NOT NULL,                         -- Khóa bị khóa (username, share link ID, IP)
+    ip TEXT,                                       -- IP của request gây ra sự kiện
+    detail TEXT,
+    created_at TEXT DEFAULT (datetime('now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log(created_at);
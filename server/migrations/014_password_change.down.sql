@@ -0,0 +1,3 @@
+-- Rollback: remove the wrapped search key (accounts that changed their password lose search)
+
+ALTER TABLE users DROP COLUMN search_key_enc;
@@ -0,0 +1,7 @@
+-- Migration: password change with key re-wrapping
+-- Đổi mật khẩu đổi K_Master: client bọc lại K_Note của mọi note, phiên bản và upload
+-- session, mã hóa lại tên folder / nhãn tag bằng K_Master mới. Token tìm kiếm không
+-- tính lại được (từ khóa không được lưu), nên K_Search cũ được bọc bằng K_Master mới
+-- và lưu ở users.search_key_enc.
+
+ALTER TABLE users ADD COLUMN search_key_enc TEXT;  -- NULL = K_Search = HKDF(K_Master) như trước
@@ -0,0 +1,3 @@
+-- Rollback: remove account recovery keys
+
+DROP TABLE IF EXISTS recovery_keys;
@@ -0,0 +1,17 @@
+-- Migration: Khôi phục tài khoản bằng recovery key
+-- Recovery key do client sinh và chỉ hiện cho user một lần. Từ recovery key client
+-- suy ra (HKDF, label khác nhau) wrap key để bọc K_Master và auth key để chứng minh
+-- đang giữ recovery key; server chỉ lưu SHA256 của auth key nên không mở được K_Master.
+
+-- ============================================================
+-- TABLE 20: recovery_keys - Recovery key của user (tùy chọn)
+-- ============================================================
+CREATE TABLE IF NOT EXISTS recovery_keys (
+    user_id TEXT PRIMARY KEY,
+    verifier TEXT NOT NULL,                        -- Hex SHA256(auth key)
+    master_key_enc TEXT NOT NULL,                  -- K_Master bọc bởi wrap key (base64)
+    key_enc TEXT NOT NULL,                         -- Wrap key bọc bởi K_Master, để bọc lại khi đổi mật khẩu
+    created_at TEXT DEFAULT (datetime('now')),
+    updated_at TEXT DEFAULT (datetime('now')),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+-- Rollback: forget which accounts use a login key (they can no longer log in until reset)
+
+ALTER TABLE users DROP COLUMN auth_version;
@@ -0,0 +1,7 @@
+-- Migration: login key separated from K_Master
+-- Client suy ra login key và K_Master từ mật khẩu bằng HKDF với label khác nhau và
+-- chỉ gửi login key: password_hash là hash của login key, server không còn thấy mật
+-- khẩu nên không tính được K_Master. Tài khoản cũ (auth_version = 0) vẫn đăng nhập
+-- bằng mật khẩu một lần, client chuyển sang login key ngay sau đó (đổi mật khẩu).
+
+ALTER TABLE users ADD COLUMN auth_version INTEGER NOT NULL DEFAULT 0;  -- 0 = hash của mật khẩu, 1 = hash của login key
@@ -0,0 +1,11 @@
+// Package migrations embeds the versioned SQL migration files so the server
+// binary can apply them without shipping the migrations directory.
+//
+// Files are named NNN_name.sql (up) and NNN_name.down.sql (down).
+// Files containing "_seed_" hold development data and are only applied on request.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -6,7 +6,9 @@ import (
 	"encoding/hex"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
+	"context"
+	"errors"
+	"fmt"
     "strings"
 	"time"
 	"regexp"
@@ -16,24 +18,34 @@ import (
 	"github.com/google/uuid"
 	"golang.org/x/crypto/argon2"
 )
+// RegisterRequest: client tự sinh kdf_salt và chỉ gửi login key (loginkey.go)
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	LoginKey string `json:"login_key" binding:"required"` // Base64
+	KdfSalt  string `json:"kdf_salt" binding:"required"`  // Base64
 }
 
 type RegisterResponse struct {
 	UserID string `json:"user_id"`
 }
 
+// LoginRequest: login_key, hoặc password với tài khoản chưa chuyển sang login key
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	LoginKey string `json:"login_key"`
+	Password string `json:"password"`
 }
 
 type LoginResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	KdfSalt      string `json:"kdf_salt,omitempty"`
+	// SearchKeyEnc: K_Search bọc bởi K_Master (chỉ có sau khi user đổi mật khẩu)
+	SearchKeyEnc string `json:"search_key_enc,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
 type LogoutResponse struct {
@@ -44,11 +56,11 @@ type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
+// Các giá trị dưới đây được thiết lập bởi InitAuth từ cấu hình server
 var (
-	db                *sql.DB
-	JWTSecretKey      []byte("your-secret-key-change-this-in-production")
-	argonPepper       string
-	accessTokenExpiry = 15 * time.Minute
+	JWTSecretKey       []byte
+	argonPepper        string
+	accessTokenExpiry  = 15 * time.Minute
 	refreshTokenExpiry = 7 * 24 * time.Hour
 	
 	// Argon2 parameters
@@ -57,33 +69,58 @@ var (
 	argonThreads = uint8(4)
 	argonKeyLen  = uint32(32)
 )
-func InitAuth(database *sql.DB, secretKey, pepper string) error {
-	if database == nil {
-		return fmt.Errorf("database connection is required")
-	}
-	if len(secretKey) < 32 {
+
+// AuthOptions là cấu hình xác thực truyền vào InitAuth
+type AuthOptions struct {
+	JWTSecret       string
+	Pepper          string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	ArgonTime       uint32
+	ArgonMemoryKiB  uint32
+	ArgonThreads    uint8
+}
+
+var (
+	// ErrRefreshTokenInvalid: token không tồn tại hoặc đã bị xóa
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused: token đã bị rotate/revoke nhưng được dùng lại
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// sqliteTimeFormat khớp với định dạng datetime('now') của SQLite (UTC)
+// để có thể so sánh trực tiếp trong câu SQL
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+// InitAuth thiết lập secret ký JWT, pepper, thời hạn token và tham số Argon2.
+// Phải được gọi trước khi server nhận request.
+func InitAuth(opts AuthOptions) error {
+	if len(opts.JWTSecret) < 32 {
 		return fmt.Errorf("JWT secret key must be at least 32 characters")
 	}
-	db = database
-	JWTSecretKey = []byte(secretKey)
-	argonPepper = pepper
-	return nil
-}
-func validatePassword(password string) (bool, string) {
-	if len(password) < 8 {
-		return false, "Password must be at least 8 characters"
+	if opts.AccessTokenTTL <= 0 || opts.RefreshTokenTTL <= 0 {
+		return fmt.Errorf("token TTLs must be positive")
 	}
-	
-	hasUpper := regexp.MustCompile(`[A-Z]`).MatchString(password)
-	hasLower := regexp.MustCompile(`[a-z]`).MatchString(password)
-	hasNumber := regexp.MustCompile(`[0-9]`).MatchString(password)
-	hasSpecial := regexp.MustCompile(`[@#$%^&+=!]`).MatchString(password)
-	
-	if !hasUpper || !hasLower || !hasNumber || !hasSpecial {
-		return false, "Password must contain uppercase, lowercase, numbers and special characters (@#$%^&+=!)"
+	if opts.ArgonTime == 0 || opts.ArgonMemoryKiB == 0 || opts.ArgonThreads == 0 {
+		return fmt.Errorf("invalid argon2 parameters")
 	}
-	
-	return true, ""
+	key, err := deriveDecoyKey([]byte(opts.JWTSecret))
+	if err != nil {
+		return err
+	}
+	JWTSecretKey = []byte(opts.JWTSecret)
+	decoyKey = key
+	argonPepper = opts.Pepper
+	accessTokenExpiry = opts.AccessTokenTTL
+	refreshTokenExpiry = opts.RefreshTokenTTL
+	argonTime = opts.ArgonTime
+	argonMemory = opts.ArgonMemoryKiB
+	argonThreads = opts.ArgonThreads
+	return nil
 }
 func validateUsername(username string) (bool, string) {
 	if len(username) < 3 || len(username) > 50 {
@@ -98,12 +135,12 @@ func validateUsername(username string) (bool, string) {
 }
 
 // GenerateJWT creates access token and refresh token for authenticated user
-func GenerateJWT(userID string, username string) (accessToken string, refreshToken string, err error) {
-	// Create access token (JWT) with 15 minutes expiry
+func (s *Server) GenerateJWT(ctx context.Context, userID string, username string) (accessToken string, refreshToken string, err error) {
+	// Create access token (JWT) with accessTokenExpiry
 	claims := jwt.MapClaims{
 		"user_id":  userID,
 		"username": username,
-		"exp":      time.Now().Add(15 * time.Minute).Unix(),
+		"exp":      time.Now().Add(accessTokenExpiry).Unix(),
 		"iat":      time.Now().Unix(),
 		"jti":      uuid.New().String(), // JWT ID (unique identifier)
 	}
@@ -114,26 +151,39 @@ func GenerateJWT(userID string, username string) (accessToken string, refreshTok
 		return "", "", err
 	}
 
-	// Create refresh token (random 32 bytes)
-	refreshTokenBytes := make([]byte, 32)
-	_, err = rand.Read(refreshTokenBytes)
+	// Create refresh token (random 32 bytes), starting a new token family
+	refreshToken, record, err := generateRefreshToken(userID, uuid.New().String())
 	if err != nil {
 		return "", "", err
 	}
-	refreshToken = base64.URLEncoding.EncodeToString(refreshTokenBytes)
-	// TODO: Store refresh token in database with 7 days expiry
-	tokenHash := sha256.Sum256([]byte(refreshToken))
-	_, err = db.Exec(
-		    `INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
-		     userID, hex.EncodeToString(tokenHash[:]),
-		     time.Now().Add(refreshTokenExpiry)
-		)
-	if err != nil {
+	if err := s.Tokens.CreateRefreshToken(ctx, record); err != nil {
 		return "", "", err
 	}
 	return accessToken, refreshToken, nil
 }
 
+// hashRefreshToken trả về SHA256 (hex) của refresh token, chỉ giá trị này được lưu
+func hashRefreshToken(token string) string {
+	tokenHash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(tokenHash[:])
+}
+
+// generateRefreshToken sinh refresh token ngẫu nhiên và bản ghi tương ứng (hết hạn sau refreshTokenExpiry)
+func generateRefreshToken(userID string, familyID string) (string, *RefreshToken, error) {
+	refreshTokenBytes := make([]byte, 32)
+	if _, err := rand.Read(refreshTokenBytes); err != nil {
+		return "", nil, err
+	}
+	refreshToken := base64.URLEncoding.EncodeToString(refreshTokenBytes)
+	return refreshToken, &RefreshToken{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		TokenHash: hashRefreshToken(refreshToken),
+		FamilyID:  familyID,
+		ExpiresAt: sqliteTime(time.Now().Add(refreshTokenExpiry)),
+	}, nil
+}
+
 // ParseJWT validates and parses a JWT token
 func ParseJWT(tokenString string) (*jwt.Token, jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
@@ -154,35 +204,51 @@ func ParseJWT(tokenString string) (*jwt.Token, jwt.MapClaims, error) {
 	return nil, nil, jwt.ErrTokenInvalidClaims
 }
 
-// RefreshToken generates new access token from valid refresh token
-func RefreshToken(oldRefreshToken string) (newAccessToken string, err error) {
-	// TODO: Query DB: SELECT user_id, username, expires_at FROM refresh_tokens WHERE token_hash = SHA256(?)
-	// TODO: Check if refresh token is expired
-	// TODO: Generate new access token
-	tokenHash := sha256.Sum256([]byte(oldRefreshToken))
-	tokenHashStr := hex.EncodeToString(tokenHash[:])
-
-	var userID string
-	var username string
-	var expiresAt time.Time
-
-	err = db.QueryRow(
-   		`SELECT u.id, u.username, rt.expires_at
-		 FROM refresh_tokens rt
-		 JOIN users u ON rt.user_id = u.id
-		 WHERE rt.token_hash = $1`, tokenHashStr).Scan(&userID, &username, &expiresAt)
+// RefreshToken rotates a valid refresh token: the old token is marked is_revoked
+// and a new access token + refresh token (same family) are issued.
+// Presenting an already revoked token revokes the whole family.
+func (s *Server) RefreshToken(ctx context.Context, oldRefreshToken string) (newAccessToken string, newRefreshToken string, err error) {
+	old, err := s.Tokens.GetRefreshToken(ctx, hashRefreshToken(oldRefreshToken))
 	if err != nil {
-		return "", err
+		if errors.Is(err, ErrNotFound) {
+			return "", "", ErrRefreshTokenInvalid
+		}
+		return "", "", err
+	}
+
+	if old.IsRevoked {
+		// Reuse detection: thu hồi toàn bộ family của user
+		if err := s.Tokens.RevokeRefreshTokenFamily(ctx, old.UserID, old.FamilyID); err != nil {
+			return "", "", err
+		}
+		return "", "", ErrRefreshTokenReused
+	}
+
+	if old.ExpiresAt < sqliteTime(time.Now()) {
+		_ = s.Tokens.DeleteRefreshToken(ctx, old.TokenHash)
+		return "", "", jwt.ErrTokenExpired
 	}
-	if time.Now().After(expiresAt) {
- 		_, _ = db.Exec("DELETE FROM refresh_tokens WHERE token_hash = $1", tokenHashStr)
-		return "", jwt.ErrTokenExpired
-		    
+
+	// Rotate: đánh dấu token cũ đã dùng và lưu token mới cùng family
+	familyID := old.FamilyID
+	if familyID == "" {
+		familyID = uuid.New().String()
 	}
+	newRefreshToken, record, err := generateRefreshToken(old.UserID, familyID)
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.Tokens.RotateRefreshToken(ctx, old.ID, record); err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			_ = s.Tokens.RevokeRefreshTokenFamily(ctx, old.UserID, old.FamilyID)
+		}
+		return "", "", err
+	}
+
 	jti := uuid.New().String()
 	claims := jwt.MapClaims{
-		"user_id":  userID,
-		"username": username,
+		"user_id":  old.UserID,
+		"username": old.Username,
 		"exp":      time.Now().Add(accessTokenExpiry).Unix(),
 		"iat":      time.Now().Unix(),
 		"jti":      jti,
@@ -191,35 +257,28 @@ func RefreshToken(oldRefreshToken string) (newAccessToken string, err error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	newAccessToken, err = token.SignedString(JWTSecretKey)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	return newAccessToken, nil
+	return newAccessToken, newRefreshToken, nil
 }
 
 // BlacklistToken adds access token to blacklist on logout
-func BlacklistToken(jti string, expiresAt time.Time) error {
-	query := `INSERT INTO token_blacklist (jti, expires_at) VALUES ($1, $2) 
-	          ON CONFLICT (jti) DO UPDATE SET expires_at = EXCLUDED.expires_at`
-	_, err := db.Exec(query, jti, expiresAt)
-	return err
+func (s *Server) BlacklistToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return s.Tokens.BlacklistToken(ctx, jti, sqliteTime(expiresAt))
 }
 
 // ValidateToken checks if token is valid and not blacklisted
-func ValidateToken(jti string) (bool,  error) {
-	query := `SELECT EXISTS(SELECT 1 FROM token_blacklist WHERE jti = $1 AND expires_at > NOW())`
-	var exists bool
-	err := db.QueryRow(query, jti).Scan(&exists)
-	return exists, err
+func (s *Server) ValidateToken(ctx context.Context, jti string) (bool, error) {
+	blacklisted, err := s.Tokens.IsTokenBlacklisted(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+	return !blacklisted, nil
 }
 
 // RevokeRefreshToken removes refresh token from database
-func RevokeRefreshToken(token string) error {
-	// TODO: DELETE FROM refresh_tokens WHERE token_hash = SHA256(?)
-	tokenHash := sha256.Sum256([]byte(token))
-	_, err := db.Exec(
-		"DELETE FROM refresh_tokens WHERE token_hash = $1",
-		hex.EncodeToString(tokenHash[:]))
-	return err
+func (s *Server) RevokeRefreshToken(ctx context.Context, token string) error {
+	return s.Tokens.DeleteRefreshToken(ctx, hashRefreshToken(token))
 }
 
 // ============================================================
@@ -268,7 +327,7 @@ func DecodeSalt(saltStr string) ([]byte, error) {
 
 // Register handles user registration
 // POST /api/auth/register
-func Register(c *gin.Context) {
+func (s *Server) Register(c *gin.Context) {
 	// TODO: Parse request body: username, password
 	// TODO: Validate input (length, complexity)
 	// TODO: Check if username exists
@@ -290,39 +349,32 @@ func Register(c *gin.Context) {
 		return
 	}
 	
-	if valid, msg := validatePassword(req.Password); !valid {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: msg})
+	// Độ mạnh mật khẩu do client kiểm tra: server chỉ nhận login key
+	if !validLoginKey(req.LoginKey) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Login key must be base64 of 32 bytes"})
 		return
 	}
-	
-	var exists bool
-	err := db.QueryRow(
-		"SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", 
-		req.Username).Scan(&exists)
-	
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: "Database error",
-		})
+	kdfSalt, err := DecodeSalt(req.KdfSalt)
+	if err != nil || len(kdfSalt) < minKdfSaltLen {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "KDF salt must be base64 of at least 16 random bytes"})
 		return
 	}
 	
-	if exists {
+	_, err = s.Users.GetUserByUsername(c.Request.Context(), req.Username)
+	if err == nil {
 		c.JSON(http.StatusConflict, ErrorResponse{
 			Error: "Username already exists",
 		})
 		return
 	}
-	
-	kdfSalt, err := GenerateSalt()
-	if err != nil {
+	if !errors.Is(err, ErrNotFound) {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: "Failed to generate salt",
+			Error: "Database error",
 		})
 		return
 	}
 	
-	passwordHash, err := HashPassword(req.Password, kdfSalt)
+	passwordHash, err := HashPassword(req.LoginKey, kdfSalt)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error: "Failed to hash password",
@@ -333,14 +385,16 @@ func Register(c *gin.Context) {
 	userID := uuid.New()
 	
 	// Lưu vào database
-	_, err = db.Exec(context.Background(),
-		`INSERT INTO users (id, username, password_hash, kdf_salt) 
-		 VALUES ($1, $2, $3, $4)`,
-		userID, req.Username, passwordHash, EncodeSalt(kdfSalt))
+	err = s.Users.CreateUser(c.Request.Context(), &User{
+		ID:           userID.String(),
+		Username:     req.Username,
+		PasswordHash: passwordHash,
+		KdfSalt:      EncodeSalt(kdfSalt),
+		AuthVersion:  AuthVersionLoginKey,
+	})
 	
 	if err != nil {
-		if strings.Contains(err.Error(), "unique constraint") || 
-		   strings.Contains(err.Error(), "duplicate key") {
+		if errors.Is(err, ErrConflict) {
 			c.JSON(http.StatusConflict, ErrorResponse{
 				Error: "Username already exists",
 			})
@@ -361,7 +415,9 @@ func Register(c *gin.Context) {
 
 // Login handles user authentication
 // POST /api/auth/login
-func Login(c *gin.Context) {
+// Request: { "username": "...", "login_key": "base64" } (tài khoản cũ: { "username": "...", "password": "..." }, xem loginkey.go)
+// Sai quá nhiều lần: 423 (tài khoản bị khóa) hoặc 429 (phải chờ / IP bị khóa), xem lockout.go
+func (s *Server) Login(c *gin.Context) {
 	// TODO: Parse request body: username, password_hash (already hashed on client)
 	// TODO: Query DB: SELECT id, password_hash, kdf_salt FROM users WHERE username = ?
 	// TODO: Verify password hash
@@ -376,18 +432,20 @@ func Login(c *gin.Context) {
 		return
 	}
 	
-	var userID uuid.UUID
-	var username string
-	var passwordHash string
-	var kdfSaltStr string
-	
-	err := db.QueryRow(
-		`SELECT id, username, password_hash, kdf_salt 
-		 FROM users WHERE username = $1`, req.Username).Scan(
-		&userID, &username, &passwordHash, &kdfSaltStr)
-	
+	// Tài khoản hoặc IP đang bị khóa vì sai quá nhiều lần
+	subjects := loginSubjects(c, req.Username)
+	if !s.reserveAuthAttempt(c, subjects) {
+		return
+	}
+	// Lần thử không kết thúc bằng đúng / sai (lỗi server, chờ mã 2FA) được hoàn lại
+	defer s.releaseAuthAttempts(c)
+
+	user, err := s.Users.GetUserByUsername(c.Request.Context(), req.Username)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, ErrNotFound) {
+			// Tốn thời gian như khi sai mật khẩu để không lộ username có tồn tại
+			verifyDummy(req.LoginKey + req.Password)
+			s.recordAuthFailure(c, subjects)
 			c.JSON(http.StatusUnauthorized, ErrorResponse{
 				Error: "Invalid credentials",
 			})
@@ -398,7 +456,9 @@ func Login(c *gin.Context) {
 		})
 		return
 	}
-	kdfSaltBytes, err := DecodeSalt(kdfSaltStr)
+
+	// Tài khoản đã có login key không nhận mật khẩu nữa (và ngược lại)
+	valid, err := verifyCredentials(user, req.Password, req.LoginKey)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error: "Authentication error",
@@ -406,41 +466,100 @@ func Login(c *gin.Context) {
 		return
 	}
 	
-
-	valid, err := VerifyPassword(req.Password, passwordHash, kdfSaltBytes)
+	if !valid {
+		s.recordAuthFailure(c, subjects)
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error: "Invalid credentials",
+		})
+		return
+	}
+	
+	// User đã bật 2FA: chỉ cấp mfa_token, token thật được cấp ở POST /api/login/mfa
+	totp, err := s.enabledTOTP(c.Request.Context(), user.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error: "Authentication error",
 		})
 		return
 	}
-	
-	if !valid {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{
-			Error: "Invalid credentials",
+	if totp != nil {
+		mfaToken, err := generateMFAToken(user.ID, user.Username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "Failed to generate token",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int64(mfaTokenTTL / time.Second),
 		})
 		return
 	}
-	
+
 	// Tạo JWT token
-	accessToken, refreshToken, err := GenerateJWT(userID.String(), username)
+	accessToken, refreshToken, err := s.GenerateJWT(c.Request.Context(), user.ID, user.Username)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error: "Failed to generate token",
 		})
 		return
 	}
+	s.clearAuthFailures(c, subjects)
 
 	c.JSON(http.StatusOK, LoginResponse{
   		AccessToken: accessToken,
 		RefreshToken: refreshToken,
-		KdfSalt: kdfSaltStr,
+		KdfSalt: user.KdfSalt,
+		SearchKeyEnc: user.SearchKeyEnc,
+	})
+}
+
+// Refresh issues a new access token and rotates the refresh token
+// POST /api/refresh
+func (s *Server) Refresh(c *gin.Context) {
+	var req RefreshRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+
+	accessToken, refreshToken, err := s.RefreshToken(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRefreshTokenReused):
+			c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error: "Refresh token reuse detected, all sessions revoked",
+			})
+		case errors.Is(err, jwt.ErrTokenExpired):
+			c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error: "Refresh token expired",
+			})
+		case errors.Is(err, ErrRefreshTokenInvalid):
+			c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error: "Invalid refresh token",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "Failed to refresh token",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, LoginResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
 	})
 }
 
 // Logout handles user logout
 // POST /api/auth/logout
-func Logout(c *gin.Context) {
+func (s *Server) Logout(c *gin.Context) {
 	// TODO: Get JWT from Authorization header
 	// TODO: Parse JWT to get jti
 	// TODO: Blacklist token
@@ -465,7 +584,7 @@ func Logout(c *gin.Context) {
 	tokenString := parts[1]
 	
 	// Parse token để lấy JTI
-	token, claims, err := ParseJWT(tokenString)
+	token, claims, _ := ParseJWT(tokenString)
 	
 	var jti string
 	var expiryTime time.Time
@@ -488,7 +607,7 @@ func Logout(c *gin.Context) {
 			expiryTime = time.Now().Add(accessTokenExpiry)
 		}
 		
-		_ = BlacklistToken(jti, expiryTime)
+		_ = s.BlacklistToken(c.Request.Context(), jti, expiryTime)
 	}
 	
 	// TODO: Revoke refresh token
@@ -498,7 +617,7 @@ func Logout(c *gin.Context) {
 	
 	if c.Request.Body != nil {
 		if err := c.ShouldBindJSON(&refreshTokenReq); err == nil && refreshTokenReq.RefreshToken != "" {
-			_ = RevokeRefreshToken(refreshTokenReq.RefreshToken)
+			_ = s.RevokeRefreshToken(c.Request.Context(), refreshTokenReq.RefreshToken)
 		}
 	}
 	
@@ -509,11 +628,11 @@ func Logout(c *gin.Context) {
 }
 
 // GetSalt returns the KDF salt for a user (used during login)
-// GET /api/auth/salt?username=alice
-func GetSalt(c *gin.Context) {
-	// TODO: Get username from query params
-	// TODO: Query DB: SELECT kdf_salt FROM users WHERE username = ?
-	// TODO: Return salt in JSON
+// GET /api/salt?username=alice
+// Response: { "username": "alice", "kdf_salt": "base64", "auth_version": 1 }
+// Username không tồn tại nhận salt và auth_version giả cố định thay vì 404, để không lộ tài
+// khoản nào có thật (auth_version giả bằng 0 theo đúng tỉ lệ tài khoản cũ, xem decoyAuthVersion).
+func (s *Server) GetSalt(c *gin.Context) {
 	username := c.Query("username")
 	if username == "" {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -521,17 +640,22 @@ func GetSalt(c *gin.Context) {
 		})
 		return
 	}
-	
-	// TODO: Query DB: SELECT kdf_salt FROM users WHERE username = ?
-	var kdfSalt string
-	err := db.QueryRow(
-		"SELECT kdf_salt FROM users WHERE username = $1",
-		username).Scan(&kdfSalt)
-	
+
+	ctx := c.Request.Context()
+	user, err := s.Users.GetUserByUsername(ctx, username)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, ErrorResponse{
-				Error: "User not found",
+		if errors.Is(err, ErrNotFound) {
+			total, legacy, err := s.Users.CountUsers(ctx)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{
+					Error: "Database error",
+				})
+				return
+			}
+			c.JSON(http.StatusOK, SaltResponse{
+				Username:    username,
+				KdfSalt:     decoySalt(username),
+				AuthVersion: decoyAuthVersion(username, total, legacy),
 			})
 			return
 		}
@@ -540,10 +664,10 @@ func GetSalt(c *gin.Context) {
 		})
 		return
 	}
-	
-	// TODO: Return salt in JSON
-	c.JSON(http.StatusOK, gin.H{
-		"username": username,
-		"kdf_salt": kdfSalt,
+
+	c.JSON(http.StatusOK, SaltResponse{
+		Username:    username,
+		KdfSalt:     user.KdfSalt,
+		AuthVersion: user.AuthVersion,
 	})
 }
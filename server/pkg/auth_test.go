@@ -0,0 +1,160 @@
+package serverpkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGenerateJWTUsesAccessTokenExpiry(t *testing.T) {
+	s := NewMemoryServer()
+	saved := accessTokenExpiry
+	accessTokenExpiry = 2 * time.Hour
+	defer func() { accessTokenExpiry = saved }()
+
+	access, _, err := s.GenerateJWT(context.Background(), "user-1", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, claims, err := ParseJWT(access)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, _ := claims["exp"].(float64)
+	want := time.Now().Add(2 * time.Hour).Unix()
+	if d := int64(exp) - want; d < -5 || d > 5 {
+		t.Fatalf("exp = %d, want about %d", int64(exp), want)
+	}
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	ts := newTestServer(t)
+	_, _, refresh := ts.newUser(t, "alice", testLoginKey)
+
+	w := ts.do(http.MethodPost, "/api/refresh", "", RefreshRequest{RefreshToken: refresh})
+	expectStatus(t, w, http.StatusOK)
+	resp := decode(t, w)
+	next, _ := resp["refresh_token"].(string)
+	access, _ := resp["access_token"].(string)
+	if next == "" || next == refresh || access == "" {
+		t.Fatalf("refresh response = %v", resp)
+	}
+
+	// Token mới dùng được, token mới nhất lại rotate tiếp
+	expectStatus(t, ts.do(http.MethodGet, "/api/notes", access, nil), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodPost, "/api/refresh", "", RefreshRequest{RefreshToken: next}), http.StatusOK)
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	ts := newTestServer(t)
+	_, _, refresh := ts.newUser(t, "alice", testLoginKey)
+
+	w := ts.do(http.MethodPost, "/api/refresh", "", RefreshRequest{RefreshToken: refresh})
+	expectStatus(t, w, http.StatusOK)
+	next, _ := decode(t, w)["refresh_token"].(string)
+
+	// Dùng lại token đã rotate => cả family bị thu hồi
+	w = ts.do(http.MethodPost, "/api/refresh", "", RefreshRequest{RefreshToken: refresh})
+	expectStatus(t, w, http.StatusUnauthorized)
+	if got := decode(t, w)["error"]; got != "Refresh token reuse detected, all sessions revoked" {
+		t.Fatalf("error = %q", got)
+	}
+	w = ts.do(http.MethodPost, "/api/refresh", "", RefreshRequest{RefreshToken: next})
+	expectStatus(t, w, http.StatusUnauthorized)
+}
+
+func TestRefreshReuseKeepsOtherFamilies(t *testing.T) {
+	ts := newTestServer(t)
+	userID, _, stolen := ts.newUser(t, "alice", testLoginKey)
+	_, other, err := ts.GenerateJWT(context.Background(), userID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectStatus(t, ts.do(http.MethodPost, "/api/refresh", "", RefreshRequest{RefreshToken: stolen}), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodPost, "/api/refresh", "", RefreshRequest{RefreshToken: stolen}), http.StatusUnauthorized)
+
+	// Phiên đăng nhập khác (family khác) không bị ảnh hưởng
+	expectStatus(t, ts.do(http.MethodPost, "/api/refresh", "", RefreshRequest{RefreshToken: other}), http.StatusOK)
+}
+
+func TestRefreshUnknownToken(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodPost, "/api/refresh", "", RefreshRequest{RefreshToken: "unknown"})
+	expectStatus(t, w, http.StatusUnauthorized)
+	if got := decode(t, w)["error"]; got != "Invalid refresh token" {
+		t.Fatalf("error = %q", got)
+	}
+}
+
+func TestLoginUnknownUserLooksLikeWrongKey(t *testing.T) {
+	ts := newTestServer(t)
+	ts.newUser(t, "alice", testLoginKey)
+	wrongKey := "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+
+	wrong := ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "alice", LoginKey: wrongKey})
+	unknown := ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "nobody", LoginKey: wrongKey})
+	expectStatus(t, wrong, http.StatusUnauthorized)
+	expectStatus(t, unknown, http.StatusUnauthorized)
+	if wrong.Body.String() != unknown.Body.String() {
+		t.Fatalf("responses differ: %s vs %s", wrong.Body.String(), unknown.Body.String())
+	}
+
+	w := ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "alice", LoginKey: testLoginKey})
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestGetSaltDecoyMatchesLegacyShare(t *testing.T) {
+	ts := newTestServer(t)
+	ts.newUser(t, "alice", testLoginKey)
+	salt, err := GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := &User{ID: "legacy-1", Username: "bob", PasswordHash: "x", KdfSalt: EncodeSalt(salt), AuthVersion: AuthVersionPassword}
+	if err := ts.Users.CreateUser(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	getSalt := func(username string) SaltResponse {
+		w := ts.do(http.MethodGet, "/api/salt?username="+username, "", nil)
+		expectStatus(t, w, http.StatusOK)
+		m := decode(t, w)
+		v, _ := m["auth_version"].(float64)
+		s, _ := m["kdf_salt"].(string)
+		return SaltResponse{Username: username, KdfSalt: s, AuthVersion: int(v)}
+	}
+	if got := getSalt("bob").AuthVersion; got != AuthVersionPassword {
+		t.Fatalf("legacy auth_version = %d", got)
+	}
+
+	// Một nửa số tài khoản là tài khoản cũ nên decoy cũng phải có cả hai giá trị
+	counts := map[int]int{}
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("nobody%d", i)
+		first := getSalt(name)
+		if again := getSalt(name); again != first {
+			t.Fatalf("decoy for %s changed: %+v vs %+v", name, first, again)
+		}
+		counts[first.AuthVersion]++
+	}
+	if counts[AuthVersionPassword] < 60 || counts[AuthVersionLoginKey] < 60 {
+		t.Fatalf("decoy auth_version counts = %v, want about half of each", counts)
+	}
+}
+
+func TestDecoyAuthVersionWithoutLegacyAccounts(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		if v := decoyAuthVersion(fmt.Sprintf("nobody%d", i), 10, 0); v != AuthVersionLoginKey {
+			t.Fatalf("auth_version = %d with no legacy accounts", v)
+		}
+	}
+}
+
+func TestDecoyKeyIsNotJWTSecret(t *testing.T) {
+	if len(decoyKey) == 0 || string(decoyKey) == string(JWTSecretKey) {
+		t.Fatal("decoy key must be derived from, not equal to, the JWT secret")
+	}
+}
@@ -0,0 +1,448 @@
+package serverpkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================
+// BLOB STORE - Ciphertext lưu theo địa chỉ nội dung (SHA256)
+// ============================================================
+//
+// Row trong SQLite chỉ giữ content_ref (hex SHA256) và content_size; ciphertext
+// nằm trong BlobStore. Cùng nội dung => cùng key, nên một blob có thể được
+// nhiều row tham chiếu và chỉ bị xóa bởi GC khi không còn row nào trỏ tới.
+
+// BlobInfo mô tả một blob đã lưu
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobStore lưu ciphertext theo SHA256. Open trả về ErrNotFound nếu không có key.
+type BlobStore interface {
+	// Put lưu toàn bộ r và trả về key (hex SHA256 của nội dung).
+	// Put lại nội dung đã có phải làm mới ModTime (xem CollectGarbageBlobs).
+	Put(ctx context.Context, r io.Reader) (BlobInfo, error)
+	// Open trả về reader hỗ trợ Seek (dùng cho Range) và kích thước blob
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, int64, error)
+	// Delete xóa blob; xóa key không tồn tại không phải lỗi
+	Delete(ctx context.Context, key string) error
+	// Walk gọi fn với mọi blob đang lưu
+	Walk(ctx context.Context, fn func(BlobInfo) error) error
+}
+
+// isBlobKey kiểm tra key là hex SHA256 (chặn path traversal qua key)
+func isBlobKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range key {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ============================================================
+// FSBlobStore - thư mục cục bộ, chia shard 2 cấp: ab/cd/abcd...
+// ============================================================
+
+// FSBlobStore lưu blob thành file trong root. File được ghi vào root/.tmp rồi
+// rename sang vị trí cuối, nên reader không bao giờ thấy blob ghi dở.
+type FSBlobStore struct {
+	root string
+}
+
+// NewFSBlobStore tạo (nếu cần) thư mục root và dọn file tạm bị bỏ dở quá 1 ngày
+func NewFSBlobStore(root string) (*FSBlobStore, error) {
+	b := &FSBlobStore{root: root}
+	if err := os.MkdirAll(b.tmpDir(), 0700); err != nil {
+		return nil, err
+	}
+	if entries, err := os.ReadDir(b.tmpDir()); err == nil {
+		for _, e := range entries {
+			if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > 24*time.Hour {
+				os.Remove(filepath.Join(b.tmpDir(), e.Name()))
+			}
+		}
+	}
+	return b, nil
+}
+
+func (b *FSBlobStore) tmpDir() string {
+	return filepath.Join(b.root, ".tmp")
+}
+
+func (b *FSBlobStore) path(key string) string {
+	return filepath.Join(b.root, key[0:2], key[2:4], key)
+}
+
+func (b *FSBlobStore) Put(ctx context.Context, r io.Reader) (BlobInfo, error) {
+	f, err := os.CreateTemp(b.tmpDir(), "put-*")
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	// Sau khi rename thành công, Remove chỉ trả lỗi not exist
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	key := hex.EncodeToString(h.Sum(nil))
+	final := b.path(key)
+	now := time.Now()
+	if _, err := os.Stat(final); err == nil {
+		// Nội dung trùng: giữ file cũ, làm mới mtime để GC không xóa trước khi row mới trỏ tới
+		if err := os.Chtimes(final, now, now); err != nil {
+			return BlobInfo{}, err
+		}
+		return BlobInfo{Key: key, Size: size, ModTime: now}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(final), 0700); err != nil {
+		return BlobInfo{}, err
+	}
+	if err := os.Rename(f.Name(), final); err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Key: key, Size: size, ModTime: now}, nil
+}
+
+func (b *FSBlobStore) Open(ctx context.Context, key string) (io.ReadSeekCloser, int64, error) {
+	if !isBlobKey(key) {
+		return nil, 0, ErrNotFound
+	}
+	f, err := os.Open(b.path(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, 0, ErrNotFound
+		}
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func (b *FSBlobStore) Delete(ctx context.Context, key string) error {
+	if !isBlobKey(key) {
+		return nil
+	}
+	err := os.Remove(b.path(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (b *FSBlobStore) Walk(ctx context.Context, fn func(BlobInfo) error) error {
+	return filepath.WalkDir(b.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == b.tmpDir() {
+				return filepath.SkipDir
+			}
+			return ctx.Err()
+		}
+		if !isBlobKey(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(BlobInfo{Key: d.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
+
+// ============================================================
+// MemoryBlobStore - cho NewMemoryServer
+// ============================================================
+
+// MemoryBlobStore giữ blob trong bộ nhớ
+type MemoryBlobStore struct {
+	mu    sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemoryBlobStore tạo blob store rỗng
+func NewMemoryBlobStore() *MemoryBlobStore {
+	return &MemoryBlobStore{blobs: map[string]memoryBlob{}}
+}
+
+// bytesReadSeekCloser thêm Close rỗng cho bytes.Reader
+type bytesReadSeekCloser struct {
+	*bytes.Reader
+}
+
+func (bytesReadSeekCloser) Close() error { return nil }
+
+func (m *MemoryBlobStore) Put(ctx context.Context, r io.Reader) (BlobInfo, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[key] = memoryBlob{data: data, modTime: now}
+	return BlobInfo{Key: key, Size: int64(len(data)), ModTime: now}, nil
+}
+
+func (m *MemoryBlobStore) Open(ctx context.Context, key string) (io.ReadSeekCloser, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.blobs[key]
+	if !ok {
+		return nil, 0, ErrNotFound
+	}
+	return bytesReadSeekCloser{bytes.NewReader(b.data)}, int64(len(b.data)), nil
+}
+
+func (m *MemoryBlobStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, key)
+	return nil
+}
+
+func (m *MemoryBlobStore) Walk(ctx context.Context, fn func(BlobInfo) error) error {
+	m.mu.RLock()
+	infos := make([]BlobInfo, 0, len(m.blobs))
+	for key, b := range m.blobs {
+		infos = append(infos, BlobInfo{Key: key, Size: int64(len(b.data)), ModTime: b.modTime})
+	}
+	m.mu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ============================================================
+// Content helpers dùng chung cho note, share link và upload
+// ============================================================
+
+// errInvalidContent: content_enc gửi lên không phải base64
+var errInvalidContent = errors.New("content_enc must be base64")
+
+// base64Reader giải mã base64 và đổi mọi lỗi giải mã (ký tự lạ, thiếu padding) thành errInvalidContent
+type base64Reader struct {
+	r io.Reader
+}
+
+func (b base64Reader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = errInvalidContent
+	}
+	return n, err
+}
+
+// putContentBase64 giải mã content_enc (base64) và lưu ciphertext thô vào blob store
+func (s *Server) putContentBase64(ctx context.Context, contentEnc string) (BlobInfo, error) {
+	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(contentEnc))
+	return s.Blobs.Put(ctx, base64Reader{decoder})
+}
+
+// openContent trả về ciphertext thô: từ blob store nếu row có content_ref,
+// ngược lại giải mã content_enc còn lưu inline (dữ liệu trước khi có blob store)
+func (s *Server) openContent(ctx context.Context, ref string, inline string) (io.ReadSeekCloser, int64, error) {
+	if ref != "" {
+		return s.Blobs.Open(ctx, ref)
+	}
+	data, err := base64.StdEncoding.DecodeString(inline)
+	if err != nil {
+		return nil, 0, errInvalidContent
+	}
+	return bytesReadSeekCloser{bytes.NewReader(data)}, int64(len(data)), nil
+}
+
+// contentSize là kích thước ciphertext của row: content_size nếu đã nằm trong
+// blob store, ngược lại ước lượng từ độ dài base64 của content_enc inline
+func contentSize(ref string, inline string, size int64) int64 {
+	if ref != "" {
+		return size
+	}
+	return int64(len(inline)) * 3 / 4
+}
+
+// contentBase64 trả về content_enc dạng base64 cho các API JSON
+func (s *Server) contentBase64(ctx context.Context, ref string, inline string) (string, error) {
+	if ref == "" {
+		return inline, nil
+	}
+	rc, size, err := s.Blobs.Open(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var out strings.Builder
+	out.Grow(base64.StdEncoding.EncodedLen(int(size)))
+	enc := base64.NewEncoder(base64.StdEncoding, &out)
+	if _, err := io.Copy(enc, rc); err != nil {
+		return "", err
+	}
+	enc.Close()
+	return out.String(), nil
+}
+
+// concatBlobs đọc lần lượt các blob như một stream (dùng khi finalize upload).
+// Caller phải Close reader để goroutine dừng nếu bỏ dở giữa chừng.
+func (s *Server) concatBlobs(ctx context.Context, keys []string) *io.PipeReader {
+	pr, pw := io.Pipe()
+	go func() {
+		for _, key := range keys {
+			rc, _, err := s.Blobs.Open(ctx, key)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			_, err = io.Copy(pw, rc)
+			rc.Close()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+	return pr
+}
+
+// ============================================================
+// GC và chuyển dữ liệu inline sang blob store
+// ============================================================
+
+// CollectGarbageBlobs xóa blob không còn row nào tham chiếu.
+// Blob mới hơn BlobGCGrace được giữ lại: row trỏ tới nó có thể chưa kịp ghi.
+func (s *Server) CollectGarbageBlobs(ctx context.Context) (int64, error) {
+	refs, err := s.BlobRefs.ReferencedBlobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-s.BlobGCGrace)
+	var stale []string
+	err = s.Blobs.Walk(ctx, func(info BlobInfo) error {
+		if _, ok := refs[info.Key]; !ok && info.ModTime.Before(cutoff) {
+			stale = append(stale, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for _, key := range stale {
+		if err := s.Blobs.Delete(ctx, key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// MoveInlineContentToBlobs chuyển content_enc còn lưu inline (note, share link)
+// sang blob store. Row có content_enc không phải base64 (vd seed data) được giữ nguyên.
+func (s *Server) MoveInlineContentToBlobs(ctx context.Context) (int64, error) {
+	var moved int64
+	for _, kind := range []string{ContentKindNote, ContentKindShareLink} {
+		after := ""
+		for {
+			rows, err := s.BlobRefs.ListInlineContent(ctx, kind, after, 100)
+			if err != nil {
+				return moved, err
+			}
+			if len(rows) == 0 {
+				break
+			}
+			for _, row := range rows {
+				after = row.ID
+				info, err := s.putContentBase64(ctx, row.ContentEnc)
+				if errors.Is(err, errInvalidContent) {
+					continue
+				}
+				if err != nil {
+					return moved, err
+				}
+				row.ContentEnc, row.Ref, row.Size = "", info.Key, info.Size
+				if err := s.BlobRefs.SetContentRef(ctx, row); err != nil {
+					return moved, err
+				}
+				moved++
+			}
+		}
+	}
+	return moved, nil
+}
+
+// MoveBlobContentInline làm ngược lại MoveInlineContentToBlobs: chép ciphertext
+// từ blob store về content_enc. Chạy trước khi rollback migration blob refs.
+func (s *Server) MoveBlobContentInline(ctx context.Context) (int64, error) {
+	var moved int64
+	for _, kind := range []string{ContentKindNote, ContentKindShareLink} {
+		after := ""
+		for {
+			rows, err := s.BlobRefs.ListBlobContent(ctx, kind, after, 100)
+			if err != nil {
+				return moved, err
+			}
+			if len(rows) == 0 {
+				break
+			}
+			for _, row := range rows {
+				after = row.ID
+				content, err := s.contentBase64(ctx, row.Ref, "")
+				if err != nil {
+					return moved, err
+				}
+				row.ContentEnc, row.Ref, row.Size = content, "", 0
+				if err := s.BlobRefs.SetContentRef(ctx, row); err != nil {
+					return moved, err
+				}
+				moved++
+			}
+		}
+	}
+	return moved, nil
+}
@@ -0,0 +1,411 @@
+package serverpkg
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================
+// S3BlobStore - Backend S3-compatible (AWS S3, MinIO, fake S3 cục bộ)
+// ============================================================
+//
+// Dùng path-style URL (endpoint/bucket/key) và ký request bằng AWS Signature V4.
+// Không phụ thuộc SDK: chỉ cần PUT/GET/HEAD/DELETE object và ListObjectsV2.
+
+// S3Options cấu hình kết nối S3
+type S3Options struct {
+	Endpoint  string // vd: https://s3.us-east-1.amazonaws.com hoặc http://localhost:9000
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Prefix được thêm trước key của blob trong bucket (vd: "blobs/")
+	Prefix string
+}
+
+// S3BlobStore cài đặt BlobStore trên một bucket S3
+type S3BlobStore struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3BlobStore tạo store; không gửi request nào cho tới lần dùng đầu tiên
+func NewS3BlobStore(opts S3Options) (*S3BlobStore, error) {
+	endpoint, err := url.Parse(strings.TrimRight(opts.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", opts.Endpoint)
+	}
+	if opts.Bucket == "" {
+		return nil, errors.New("S3 bucket is required")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	return &S3BlobStore{
+		opts:     opts,
+		endpoint: endpoint,
+		client:   &http.Client{Transport: http.DefaultTransport},
+	}, nil
+}
+
+// s3Error là lỗi HTTP từ S3 (body XML <Error><Code>...)
+type s3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func readS3Error(resp *http.Response) error {
+	e := &s3Error{Status: resp.StatusCode}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	xml.Unmarshal(body, e)
+	if e.Code == "" {
+		e.Code = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+// objectKey dùng cùng cách chia shard với FSBlobStore (ab/cd/abcd...) để có thể
+// chuyển giữa hai backend bằng cách sync thư mục
+func (s *S3BlobStore) objectKey(key string) string {
+	return s.opts.Prefix + key[0:2] + "/" + key[2:4] + "/" + key
+}
+
+// do ký và gửi một request tới bucket. key rỗng nghĩa là thao tác trên bucket.
+func (s *S3BlobStore) do(ctx context.Context, method string, key string, query url.Values, body io.Reader, size int64, payloadHash string, header http.Header) (*http.Response, error) {
+	objectPath := "/" + s.opts.Bucket
+	if key != "" {
+		objectPath += "/" + s.objectKey(key)
+	}
+	u := *s.endpoint
+	u.Path = strings.TrimRight(u.Path, "/") + objectPath
+	u.RawPath = awsURIEncode(u.Path, false)
+	u.RawQuery = canonicalQueryV4(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if payloadHash == "" {
+		payloadHash = emptyPayloadHash
+	}
+	signRequestV4(req, payloadHash, s.opts.AccessKey, s.opts.SecretKey, s.opts.Region, time.Now())
+	return s.client.Do(req)
+}
+
+func (s *S3BlobStore) Put(ctx context.Context, r io.Reader) (BlobInfo, error) {
+	// Cần SHA256 (vừa là key vừa là x-amz-content-sha256) trước khi gửi:
+	// ghi tạm ra file để không giữ cả blob trong bộ nhớ
+	tmp, err := os.CreateTemp("", "s3-put-*")
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return BlobInfo{}, err
+	}
+	key := hex.EncodeToString(h.Sum(nil))
+
+	// Ghi đè object trùng key là vô hại (cùng nội dung) và làm mới LastModified cho GC
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err := s.do(ctx, http.MethodPut, key, nil, tmp, size, key, header)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return BlobInfo{}, readS3Error(resp)
+	}
+	return BlobInfo{Key: key, Size: size, ModTime: time.Now()}, nil
+}
+
+func (s *S3BlobStore) Open(ctx context.Context, key string) (io.ReadSeekCloser, int64, error) {
+	if !isBlobKey(key) {
+		return nil, 0, ErrNotFound
+	}
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, 0, "", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, 0, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, &s3Error{Status: resp.StatusCode, Code: http.StatusText(resp.StatusCode)}
+	}
+	return &s3Object{ctx: ctx, store: s, key: key, size: resp.ContentLength}, resp.ContentLength, nil
+}
+
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	if !isBlobKey(key) {
+		return nil
+	}
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, 0, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return readS3Error(resp)
+	}
+	return nil
+}
+
+// listBucketResult là response của ListObjectsV2
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3BlobStore) Walk(ctx context.Context, fn func(BlobInfo) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.opts.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0, "", nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := readS3Error(resp)
+			resp.Body.Close()
+			return err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3: decode list response: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			key := path.Base(obj.Key)
+			if !isBlobKey(key) || obj.Key != s.objectKey(key) {
+				continue
+			}
+			if err := fn(BlobInfo{Key: key, Size: obj.Size, ModTime: obj.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// s3Object đọc object bằng GET có Range, mở lại request sau mỗi lần Seek
+type s3Object struct {
+	ctx    context.Context
+	store  *S3BlobStore
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		header := http.Header{"Range": {"bytes=" + strconv.FormatInt(o.offset, 10) + "-"}}
+		resp, err := o.store.do(o.ctx, http.MethodGet, o.key, nil, nil, 0, "", header)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent && !(resp.StatusCode == http.StatusOK && o.offset == 0) {
+			err := readS3Error(resp)
+			resp.Body.Close()
+			return 0, err
+		}
+		o.body = resp.Body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	if err == io.EOF && o.offset < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = o.offset + offset
+	case io.SeekEnd:
+		next = o.size + offset
+	default:
+		return 0, errors.New("s3: invalid whence")
+	}
+	if next < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+	if next != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = next
+	return next, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		err := o.body.Close()
+		o.body = nil
+		return err
+	}
+	return nil
+}
+
+// ============================================================
+// AWS Signature Version 4
+// ============================================================
+
+const (
+	amzDateFormat    = "20060102T150405Z"
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// awsURIEncode mã hóa theo quy tắc của SigV4: chỉ giữ A-Z a-z 0-9 - _ . ~
+// (và "/" trong path nếu encodeSlash = false)
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQueryV4 sắp xếp và mã hóa query string theo SigV4 (cũng dùng làm RawQuery)
+func canonicalQueryV4(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// canonicalRequestV4 dựng canonical request từ các header đã ký (tên chữ thường, đã sắp xếp)
+func canonicalRequestV4(method, escapedPath, rawQuery string, header http.Header, host string, signedHeaders []string, payloadHash string) string {
+	query, _ := url.ParseQuery(rawQuery)
+	var b strings.Builder
+	b.WriteString(method + "\n")
+	if escapedPath == "" {
+		escapedPath = "/"
+	}
+	b.WriteString(escapedPath + "\n")
+	b.WriteString(canonicalQueryV4(query) + "\n")
+	for _, name := range signedHeaders {
+		value := host
+		if name != "host" {
+			value = strings.Join(header.Values(name), ",")
+		}
+		b.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(strings.Join(signedHeaders, ";") + "\n")
+	b.WriteString(payloadHash)
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signatureV4 tính chữ ký hex từ canonical request
+func signatureV4(secretKey, region, amzDate, canonicalRequest string) string {
+	date := amzDate[:8]
+	scope := date + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// signRequestV4 thêm x-amz-date, x-amz-content-sha256 và Authorization vào req.
+// Ký host, range và mọi header x-amz-*.
+func signRequestV4(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := []string{"host"}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "content-type" {
+			signed = append(signed, lower)
+		}
+	}
+	sort.Strings(signed)
+
+	canonical := canonicalRequestV4(req.Method, req.URL.EscapedPath(), req.URL.RawQuery, req.Header, req.URL.Host, signed, payloadHash)
+	signature := signatureV4(secretKey, region, amzDate, canonical)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s/%s/s3/aws4_request, SignedHeaders=%s, Signature=%s",
+		accessKey, amzDate[:8], region, strings.Join(signed, ";"), signature))
+}
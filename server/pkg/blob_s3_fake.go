@@ -0,0 +1,255 @@
+package serverpkg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================
+// FAKE S3 - Server S3-compatible tối giản để chạy S3BlobStore cục bộ
+// ============================================================
+//
+// Hỗ trợ đúng phần API mà S3BlobStore dùng: PUT/GET/HEAD/DELETE object,
+// PUT bucket và ListObjectsV2, path-style, kiểm tra chữ ký SigV4.
+// Không dùng cho production: không có multipart, versioning hay ACL.
+
+// FakeS3 lưu object thành file trong dir/<bucket>/<key>
+type FakeS3 struct {
+	dir       string
+	accessKey string
+	secretKey string
+}
+
+// NewFakeS3Server tạo handler; request phải được ký bằng accessKey/secretKey
+func NewFakeS3Server(dir, accessKey, secretKey string) http.Handler {
+	return &FakeS3{dir: dir, accessKey: accessKey, secretKey: secretKey}
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
+
+func (f *FakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f.verify(r); err != nil {
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" || strings.HasPrefix(bucket, ".") || strings.Contains(key, "..") {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", "invalid bucket or key")
+		return
+	}
+	bucketDir := filepath.Join(f.dir, bucket)
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodPut:
+			if err := os.MkdirAll(bucketDir, 0700); err != nil {
+				writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error())
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case http.MethodGet:
+			f.listObjects(w, r, bucketDir)
+		default:
+			writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+		}
+		return
+	}
+
+	if _, err := os.Stat(bucketDir); err != nil {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+	path := filepath.Join(bucketDir, filepath.FromSlash(key))
+
+	switch r.Method {
+	case http.MethodPut:
+		f.putObject(w, r, bucketDir, path)
+	case http.MethodGet, http.MethodHead:
+		file, err := os.Open(path)
+		if err != nil {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", key)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil || info.IsDir() {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", key)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", info.ModTime(), file)
+	case http.MethodDelete:
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+func (f *FakeS3) putObject(w http.ResponseWriter, r *http.Request, bucketDir, path string) {
+	tmpDir := filepath.Join(bucketDir, ".tmp")
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	tmp, err := os.CreateTemp(tmpDir, "put-*")
+	if err != nil {
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), r.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	if want := r.Header.Get("X-Amz-Content-Sha256"); want != "UNSIGNED-PAYLOAD" && want != digest {
+		writeS3Error(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "payload hash mismatch")
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.Header().Set("ETag", `"`+digest+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *FakeS3) listObjects(w http.ResponseWriter, r *http.Request, bucketDir string) {
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "only ListObjectsV2 is supported")
+		return
+	}
+	prefix := query.Get("prefix")
+	after := query.Get("continuation-token")
+	maxKeys := 1000
+	if v, err := strconv.Atoi(query.Get("max-keys")); err == nil && v > 0 && v < maxKeys {
+		maxKeys = v
+	}
+
+	type object struct {
+		Key          string `xml:"Key"`
+		Size         int64  `xml:"Size"`
+		LastModified string `xml:"LastModified"`
+	}
+	var objects []object
+	err := filepath.WalkDir(bucketDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".tmp" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(bucketDir, path)
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || key <= after {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, object{Key: key, Size: info.Size(), LastModified: info.ModTime().UTC().Format(time.RFC3339)})
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Prefix                string   `xml:"Prefix"`
+		KeyCount              int      `xml:"KeyCount"`
+		IsTruncated           bool     `xml:"IsTruncated"`
+		NextContinuationToken string   `xml:"NextContinuationToken,omitempty"`
+		Contents              []object `xml:"Contents"`
+	}{Prefix: prefix}
+	if len(objects) > maxKeys {
+		objects = objects[:maxKeys]
+		result.IsTruncated = true
+		// Token là key cuối của trang (tương đương start-after)
+		result.NextContinuationToken = objects[len(objects)-1].Key
+	}
+	result.Contents = objects
+	result.KeyCount = len(objects)
+
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(result)
+}
+
+// verify kiểm tra header Authorization SigV4 của request
+func (f *FakeS3) verify(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+	rest, ok := strings.CutPrefix(auth, "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return errors.New("missing AWS4-HMAC-SHA256 authorization")
+	}
+	fields := map[string]string{}
+	for _, part := range strings.Split(rest, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		fields[k] = v
+	}
+	scope := strings.Split(fields["Credential"], "/")
+	if len(scope) != 5 || scope[0] != f.accessKey || scope[3] != "s3" || scope[4] != "aws4_request" {
+		return errors.New("invalid credential")
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil || amzDate[:8] != scope[1] {
+		return errors.New("invalid x-amz-date")
+	}
+	if d := time.Since(signedAt); d > 15*time.Minute || d < -15*time.Minute {
+		return errors.New("request time too skewed")
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	canonical := canonicalRequestV4(r.Method, r.URL.EscapedPath(), r.URL.RawQuery, r.Header, r.Host, signed, r.Header.Get("X-Amz-Content-Sha256"))
+	want := signatureV4(f.secretKey, scope[2], amzDate, canonical)
+	if !hmac.Equal([]byte(want), []byte(fields["Signature"])) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package serverpkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testContentRaw là ciphertext thô của testContent
+const testContentRaw = "ciphertext"
+
+// blobKeys trả về key của mọi blob đang lưu
+func blobKeys(t *testing.T, b BlobStore) map[string]bool {
+	t.Helper()
+	keys := map[string]bool{}
+	if err := b.Walk(context.Background(), func(info BlobInfo) error {
+		keys[info.Key] = true
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFSBlobStore(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewFSBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := b.Put(ctx, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != sha256Hex("hello") || info.Size != 5 {
+		t.Fatalf("info = %+v", info)
+	}
+	// Cùng nội dung => cùng key, chỉ một blob
+	if again, err := b.Put(ctx, strings.NewReader("hello")); err != nil || again.Key != info.Key {
+		t.Fatalf("second put = %+v, %v", again, err)
+	}
+	if keys := blobKeys(t, b); len(keys) != 1 || !keys[info.Key] {
+		t.Fatalf("keys = %v", keys)
+	}
+
+	rc, size, err := b.Open(ctx, info.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "hello" || size != 5 {
+		t.Fatalf("open = %q (%d)", data, size)
+	}
+
+	if _, _, err := b.Open(ctx, "../../etc/passwd"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("open of invalid key = %v, want ErrNotFound", err)
+	}
+	if err := b.Delete(ctx, info.Key); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Open(ctx, info.Key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("open after delete = %v, want ErrNotFound", err)
+	}
+	if err := b.Delete(ctx, info.Key); err != nil {
+		t.Fatalf("delete of missing key = %v", err)
+	}
+}
+
+func TestNoteContentInBlobStore(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	first := ts.createNote(t, token)
+	ts.createNote(t, token)
+
+	// Hai note cùng ciphertext dùng chung một blob
+	if keys := blobKeys(t, ts.Blobs); len(keys) != 1 || !keys[sha256Hex(testContentRaw)] {
+		t.Fatalf("blobs = %v", keys)
+	}
+
+	w := ts.do(http.MethodGet, "/api/notes/"+first+"/content", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if w.Body.String() != testContentRaw {
+		t.Fatalf("content = %q", w.Body.String())
+	}
+	if etag := w.Header().Get("ETag"); etag != `"`+sha256Hex(testContentRaw)+`"` {
+		t.Fatalf("ETag = %s", etag)
+	}
+	w = ts.do(http.MethodGet, "/api/notes/"+first+"/content", token, nil, "Range", "bytes=6-")
+	expectStatus(t, w, http.StatusPartialContent)
+	if w.Body.String() != testContentRaw[6:] {
+		t.Fatalf("range content = %q", w.Body.String())
+	}
+}
+
+func TestCollectGarbageBlobs(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestServer(t)
+	ts.BlobGCGrace = 0
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	noteID := ts.createNote(t, token)
+
+	// Blob không được row nào tham chiếu
+	orphan, err := ts.Blobs.Put(ctx, strings.NewReader("orphan"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := ts.CollectGarbageBlobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := blobKeys(t, ts.Blobs)
+	if n != 1 || keys[orphan.Key] || !keys[sha256Hex(testContentRaw)] {
+		t.Fatalf("collected %d, remaining %v", n, keys)
+	}
+
+	// Blob mới hơn BlobGCGrace được giữ lại
+	ts.BlobGCGrace = DefaultBlobGCGrace
+	if _, err := ts.Blobs.Put(ctx, strings.NewReader("orphan")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ts.CollectGarbageBlobs(ctx); err != nil || n != 0 {
+		t.Fatalf("collected %d, %v within grace", n, err)
+	}
+	ts.BlobGCGrace = 0
+
+	// Xóa vĩnh viễn note thì blob của nó bị thu hồi
+	expectStatus(t, ts.do(http.MethodDelete, "/api/notes/"+noteID, token, nil, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodDelete, "/api/trash/"+noteID, token, nil), http.StatusOK)
+	if _, err := ts.CollectGarbageBlobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if keys := blobKeys(t, ts.Blobs); len(keys) != 0 {
+		t.Fatalf("blobs after purge = %v", keys)
+	}
+}
+
+func TestCollectGarbageBlobsKeepsVersions(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestServer(t)
+	ts.BlobGCGrace = 0
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	noteID := ts.createNote(t, token)
+
+	edited := base64.StdEncoding.EncodeToString([]byte("edited"))
+	w := ts.do(http.MethodPut, "/api/notes/"+noteID, token, map[string]any{
+		"title":       "title",
+		"content_enc": edited,
+		"key_enc":     "a2V5",
+		"iv_meta":     "{}",
+	}, "If-Match", `"1"`)
+	expectStatus(t, w, http.StatusOK)
+
+	if _, err := ts.CollectGarbageBlobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Phiên bản cũ vẫn trỏ tới blob ban đầu
+	keys := blobKeys(t, ts.Blobs)
+	if !keys[sha256Hex(testContentRaw)] || !keys[sha256Hex("edited")] {
+		t.Fatalf("blobs = %v", keys)
+	}
+}
+
+func TestMoveInlineContentToBlobs(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestServer(t)
+	userID, token, _ := ts.newUser(t, "alice", testLoginKey)
+	// Row cũ trước khi có blob store: content_enc inline, không có content_ref
+	if err := ts.Notes.CreateNote(ctx, &Note{
+		ID: "legacy-note", UserID: userID, TitleEnc: "title", ContentEnc: testContent, KeyEnc: "a2V5", IVMeta: "{}",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := blobKeys(t, ts.Blobs); len(keys) != 0 {
+		t.Fatalf("blobs before move = %v", keys)
+	}
+
+	moved, err := ts.MoveInlineContentToBlobs(ctx)
+	if err != nil || moved != 1 {
+		t.Fatalf("moved %d, %v", moved, err)
+	}
+	note, err := ts.Notes.GetNote(ctx, "legacy-note")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.ContentEnc != "" || note.ContentRef != sha256Hex(testContentRaw) || note.ContentSize != int64(len(testContentRaw)) {
+		t.Fatalf("note = %+v", note)
+	}
+	w := ts.do(http.MethodGet, "/api/notes/legacy-note/content", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if w.Body.String() != testContentRaw {
+		t.Fatalf("content = %q", w.Body.String())
+	}
+}
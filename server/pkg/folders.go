@@ -0,0 +1,715 @@
+package serverpkg
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ============================================================
+// FOLDER & TAG APIs - Sắp xếp ghi chú
+// ============================================================
+//
+// Tên folder và nhãn tag được client mã hóa bằng K_Master như title của note;
+// server chỉ biết cấu trúc cây và note nào nằm ở đâu, gắn tag nào.
+
+const (
+	// maxEncryptedNameLen giới hạn name/label đã mã hóa (base64)
+	maxEncryptedNameLen = 4096
+	// maxNoteTags: số tag tối đa của một note
+	maxNoteTags = 64
+	// maxQueryTags: số tag tối đa trong một truy vấn GET /api/notes
+	maxQueryTags = 16
+)
+
+// folderJSON là dạng trả về của một folder
+func folderJSON(f *Folder) gin.H {
+	return gin.H{
+		"id":         f.ID,
+		"parent_id":  f.ParentID,
+		"name":       f.NameEnc,
+		"created_at": f.CreatedAt,
+		"updated_at": f.UpdatedAt,
+	}
+}
+
+// validEncryptedName kiểm tra name/label đã mã hóa do client gửi
+func validEncryptedName(name string) bool {
+	return name != "" && len(name) <= maxEncryptedNameLen
+}
+
+// folderSubtree trả về id của folder rootID và mọi folder con cháu của nó trong folders
+func folderSubtree(folders []Folder, rootID string) []string {
+	children := map[string][]string{}
+	for _, f := range folders {
+		children[f.ParentID] = append(children[f.ParentID], f.ID)
+	}
+	ids := []string{rootID}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+	return ids
+}
+
+// loadOwnedFolder lấy folder và kiểm tra user hiện tại là chủ sở hữu.
+// Trả về nil (đã ghi response lỗi) nếu không tìm thấy hoặc không có quyền.
+func (s *Server) loadOwnedFolder(c *gin.Context, folderID string, userID string) *Folder {
+	folder, err := s.Folders.GetFolder(c.Request.Context(), folderID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "folder not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query folder"})
+		}
+		return nil
+	}
+	if folder.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only owner can access folder"})
+		return nil
+	}
+	return folder
+}
+
+// folderNoteIDs trả về id các note trong folder và mọi folder con của nó;
+// includeTrashed để thu hồi share của cả note đang nằm trong thùng rác
+func (s *Server) folderNoteIDs(c *gin.Context, folder *Folder, includeTrashed bool) ([]string, error) {
+	folders, err := s.Folders.ListFolders(c.Request.Context(), folder.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return s.Folders.ListFolderNoteIDs(c.Request.Context(), folderSubtree(folders, folder.ID), includeTrashed)
+}
+
+// checkOwnedParent kiểm tra parent_id của folder ("" = thư mục gốc)
+func (s *Server) checkOwnedParent(c *gin.Context, parentID string, userID string) bool {
+	if parentID == "" {
+		return true
+	}
+	parent, err := s.Folders.GetFolder(c.Request.Context(), parentID)
+	if err != nil || parent.UserID != userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "parent folder not found"})
+		return false
+	}
+	return true
+}
+
+// CreateFolder - Tạo folder
+// POST /api/folders
+// Request: { "name": "Encrypted...", "parent_id": "uuid" } (parent_id rỗng = thư mục gốc)
+// Response: { "id": "...", "parent_id": "...", "name": "...", "created_at": "...", "updated_at": "..." }
+func (s *Server) CreateFolder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		Name     string `json:"name" binding:"required"`
+		ParentID string `json:"parent_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validEncryptedName(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder name"})
+		return
+	}
+	if !s.checkOwnedParent(c, req.ParentID, userID.(string)) {
+		return
+	}
+
+	folder := &Folder{
+		ID:       uuid.New().String(),
+		UserID:   userID.(string),
+		ParentID: req.ParentID,
+		NameEnc:  req.Name,
+	}
+	if err := s.Folders.CreateFolder(c.Request.Context(), folder); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create folder"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, folderJSON(folder))
+}
+
+// ListFolders - Liệt kê mọi folder của user (client tự dựng cây theo parent_id)
+// GET /api/folders
+// Response: [ { "id": "...", "parent_id": "...", "name": "...", "created_at": "...", "updated_at": "..." }, ... ]
+func (s *Server) ListFolders(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	rows, err := s.Folders.ListFolders(c.Request.Context(), userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query folders"})
+		return
+	}
+
+	folders := make([]gin.H, 0, len(rows))
+	for i := range rows {
+		folders = append(folders, folderJSON(&rows[i]))
+	}
+	c.JSON(http.StatusOK, folders)
+}
+
+// UpdateFolder - Đổi tên và/hoặc di chuyển folder
+// PATCH /api/folders/:id
+// Request: { "name": "Encrypted...", "parent_id": "uuid" } (trường nào bỏ trống thì giữ nguyên;
+// parent_id = "" chuyển về thư mục gốc)
+// Response: như CreateFolder
+func (s *Server) UpdateFolder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		Name     *string `json:"name"`
+		ParentID *string `json:"parent_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	folder := s.loadOwnedFolder(c, c.Param("id"), userID.(string))
+	if folder == nil {
+		return
+	}
+
+	if req.Name != nil {
+		if !validEncryptedName(*req.Name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder name"})
+			return
+		}
+		folder.NameEnc = *req.Name
+	}
+	if req.ParentID != nil && *req.ParentID != folder.ParentID {
+		if !s.checkOwnedParent(c, *req.ParentID, folder.UserID) {
+			return
+		}
+		// Không cho chuyển folder vào chính nó hoặc folder con của nó (tạo vòng)
+		folders, err := s.Folders.ListFolders(c.Request.Context(), folder.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query folders"})
+			return
+		}
+		if slices.Contains(folderSubtree(folders, folder.ID), *req.ParentID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot move folder into itself or a subfolder"})
+			return
+		}
+		folder.ParentID = *req.ParentID
+	}
+
+	if err := s.Folders.UpdateFolder(c.Request.Context(), folder); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update folder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, folderJSON(folder))
+}
+
+// DeleteFolder - Xóa folder; note và folder con của nó chuyển lên folder cha
+// DELETE /api/folders/:id
+// Response: { "message": "folder deleted successfully" }
+func (s *Server) DeleteFolder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	folder := s.loadOwnedFolder(c, c.Param("id"), userID.(string))
+	if folder == nil {
+		return
+	}
+
+	if err := s.Folders.DeleteFolder(c.Request.Context(), folder.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete folder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "folder deleted successfully",
+	})
+}
+
+// MoveNote - Chuyển note vào folder
+// PUT /api/notes/:id/folder
+// Request: { "folder_id": "uuid" } ("" = thư mục gốc)
+// Response: { "id": "...", "folder_id": "..." }
+func (s *Server) MoveNote(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		FolderID string `json:"folder_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	note := s.loadOwnedNote(c, c.Param("id"), userID.(string), "only owner can move note")
+	if note == nil {
+		return
+	}
+	if req.FolderID != "" {
+		folder, err := s.Folders.GetFolder(c.Request.Context(), req.FolderID)
+		if err != nil || folder.UserID != note.UserID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "folder not found"})
+			return
+		}
+	}
+
+	if err := s.Folders.MoveNote(c.Request.Context(), note.ID, req.FolderID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to move note"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":        note.ID,
+		"folder_id": req.FolderID,
+	})
+}
+
+// ============================================================
+// SHARE FOLDER - Chia sẻ mọi note trong folder cho user khác
+// ============================================================
+//
+// Mỗi note có K_Note riêng nên client bọc K_Note của từng note cho người nhận
+// (giống ShareNote) và gửi tất cả trong một request. Note thêm vào folder sau đó
+// chưa được share: client gọi lại POST /api/folders/:id/share để share bổ sung.
+
+// ShareFolder - Chia sẻ folder (cùng folder con) cho user khác
+// POST /api/folders/:id/share
+// Request: { "shared_to_user_id": "uuid", "sender_public_key": "...", "keys": { "<note_id>": "<aes_key_encrypted>", ... } }
+// Response: { "message": "folder shared successfully", "share_id": "...", "notes": 3 }
+// Thiếu key của note nào trong folder thì trả 400 kèm "missing_notes"
+func (s *Server) ShareFolder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		SharedToUserID  string            `json:"shared_to_user_id" binding:"required"`
+		SenderPublicKey string            `json:"sender_public_key" binding:"required"`
+		Keys            map[string]string `json:"keys"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	folder := s.loadOwnedFolder(c, c.Param("id"), userID.(string))
+	if folder == nil {
+		return
+	}
+
+	// Kiểm tra user nhận có tồn tại
+	if _, err := s.Users.GetUserByID(c.Request.Context(), req.SharedToUserID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "shared user not found"})
+		return
+	}
+	if req.SharedToUserID == folder.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot share folder with yourself"})
+		return
+	}
+
+	noteIDs, err := s.folderNoteIDs(c, folder, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query folder notes"})
+		return
+	}
+
+	// Mỗi note trong folder phải có đúng một wrapped key
+	missing := []string{}
+	shares := make([]NoteShare, 0, len(noteIDs))
+	for _, noteID := range noteIDs {
+		wrapped := req.Keys[noteID]
+		if wrapped == "" {
+			missing = append(missing, noteID)
+			continue
+		}
+		shares = append(shares, NoteShare{
+			ID:              uuid.New().String(),
+			NoteID:          noteID,
+			RecipientID:     req.SharedToUserID,
+			WrappedKey:      wrapped,
+			SenderPublicKey: req.SenderPublicKey,
+		})
+	}
+	if len(missing) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":         "wrapped key required for every note in the folder",
+			"missing_notes": missing,
+		})
+		return
+	}
+	for noteID := range req.Keys {
+		if !slices.Contains(noteIDs, noteID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "note not in folder: " + noteID})
+			return
+		}
+	}
+
+	share := &FolderShare{
+		ID:          uuid.New().String(),
+		FolderID:    folder.ID,
+		RecipientID: req.SharedToUserID,
+	}
+	if err := s.Folders.ShareFolder(c.Request.Context(), share, shares); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to share folder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "folder shared successfully",
+		"share_id": share.ID,
+		"notes":    len(shares),
+	})
+}
+
+// ListFolderShares - Liệt kê các user đã được chia sẻ folder
+// GET /api/folders/:id/share
+// Response: [ { "share_id": "...", "user_id": "uuid", "username": "...", "shared_at": "..." }, ... ]
+func (s *Server) ListFolderShares(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	folder := s.loadOwnedFolder(c, c.Param("id"), userID.(string))
+	if folder == nil {
+		return
+	}
+
+	rows, err := s.Folders.ListFolderShares(c.Request.Context(), folder.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query shares"})
+		return
+	}
+
+	shares := []gin.H{}
+	for _, fs := range rows {
+		shares = append(shares, gin.H{
+			"share_id":  fs.ID,
+			"user_id":   fs.RecipientID,
+			"username":  fs.RecipientUsername,
+			"shared_at": fs.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, shares)
+}
+
+// RevokeFolderShare - Thu hồi chia sẻ folder và share của mọi note hiện nằm trong folder
+// DELETE /api/folders/:id/share/:share_id (share_id = share id hoặc user_id người nhận)
+// Response: { "message": "share revoked successfully" }
+func (s *Server) RevokeFolderShare(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	folder := s.loadOwnedFolder(c, c.Param("id"), userID.(string))
+	if folder == nil {
+		return
+	}
+
+	rows, err := s.Folders.ListFolderShares(c.Request.Context(), folder.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query shares"})
+		return
+	}
+	idx := slices.IndexFunc(rows, func(fs FolderShare) bool {
+		return fs.ID == c.Param("share_id") || fs.RecipientID == c.Param("share_id")
+	})
+	if idx < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "share not found"})
+		return
+	}
+
+	noteIDs, err := s.folderNoteIDs(c, folder, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query folder notes"})
+		return
+	}
+	if err := s.Folders.RevokeFolderShare(c.Request.Context(), folder.ID, rows[idx].RecipientID, noteIDs); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "share not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke share"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "share revoked successfully",
+	})
+}
+
+// ============================================================
+// TAGS
+// ============================================================
+
+// tagJSON là dạng trả về của một tag
+func tagJSON(t *Tag) gin.H {
+	return gin.H{
+		"id":         t.ID,
+		"label":      t.LabelEnc,
+		"created_at": t.CreatedAt,
+	}
+}
+
+// loadOwnedTag lấy tag của user hiện tại. Trả về nil (đã ghi response lỗi) nếu thất bại.
+func (s *Server) loadOwnedTag(c *gin.Context, tagID string, userID string) *Tag {
+	tag, err := s.Folders.GetTag(c.Request.Context(), tagID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "tag not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query tag"})
+		}
+		return nil
+	}
+	if tag.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only owner can access tag"})
+		return nil
+	}
+	return tag
+}
+
+// checkOwnedTags kiểm tra mọi tag id thuộc về user; trả về false (đã ghi 400) nếu không
+func (s *Server) checkOwnedTags(c *gin.Context, tagIDs []string, userID string) bool {
+	for _, id := range tagIDs {
+		tag, err := s.Folders.GetTag(c.Request.Context(), id)
+		if err != nil || tag.UserID != userID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tag not found: " + id})
+			return false
+		}
+	}
+	return true
+}
+
+// CreateTag - Tạo tag
+// POST /api/tags
+// Request: { "label": "Encrypted..." }
+// Response: { "id": "...", "label": "...", "created_at": "..." }
+func (s *Server) CreateTag(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		Label string `json:"label" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validEncryptedName(req.Label) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tag label"})
+		return
+	}
+
+	tag := &Tag{
+		ID:       uuid.New().String(),
+		UserID:   userID.(string),
+		LabelEnc: req.Label,
+	}
+	if err := s.Folders.CreateTag(c.Request.Context(), tag); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create tag"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, tagJSON(tag))
+}
+
+// ListTags - Liệt kê tag của user
+// GET /api/tags
+// Response: [ { "id": "...", "label": "...", "created_at": "..." }, ... ]
+func (s *Server) ListTags(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	rows, err := s.Folders.ListTags(c.Request.Context(), userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query tags"})
+		return
+	}
+
+	tags := make([]gin.H, 0, len(rows))
+	for i := range rows {
+		tags = append(tags, tagJSON(&rows[i]))
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+// UpdateTag - Đổi nhãn tag
+// PUT /api/tags/:id
+// Request: { "label": "Encrypted..." }
+// Response: như CreateTag
+func (s *Server) UpdateTag(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		Label string `json:"label" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validEncryptedName(req.Label) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tag label"})
+		return
+	}
+
+	tag := s.loadOwnedTag(c, c.Param("id"), userID.(string))
+	if tag == nil {
+		return
+	}
+	tag.LabelEnc = req.Label
+	if err := s.Folders.UpdateTag(c.Request.Context(), tag); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update tag"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tagJSON(tag))
+}
+
+// DeleteTag - Xóa tag và gỡ nó khỏi mọi note
+// DELETE /api/tags/:id
+// Response: { "message": "tag deleted successfully" }
+func (s *Server) DeleteTag(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	tag := s.loadOwnedTag(c, c.Param("id"), userID.(string))
+	if tag == nil {
+		return
+	}
+	if err := s.Folders.DeleteTag(c.Request.Context(), tag.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete tag"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "tag deleted successfully",
+	})
+}
+
+// SetNoteTags - Thay toàn bộ tag của note
+// PUT /api/notes/:id/tags
+// Request: { "tag_ids": ["uuid", ...] } ([] = gỡ hết tag)
+// Response: { "id": "...", "tags": ["uuid", ...] }
+func (s *Server) SetNoteTags(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		TagIDs []string `json:"tag_ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.TagIDs) > maxNoteTags {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many tags"})
+		return
+	}
+
+	note := s.loadOwnedNote(c, c.Param("id"), userID.(string), "only owner can tag note")
+	if note == nil {
+		return
+	}
+	tagIDs := slices.Compact(slices.Sorted(slices.Values(req.TagIDs)))
+	if !s.checkOwnedTags(c, tagIDs, note.UserID) {
+		return
+	}
+
+	if err := s.Folders.SetNoteTags(c.Request.Context(), note.ID, tagIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to tag note"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":   note.ID,
+		"tags": tagIDs,
+	})
+}
+
+// applyFolderFilters đọc folder=<id>|root, recursive=true và tag=<id> (lặp lại được, AND)
+// của GET /api/notes vào q. Trả về false (đã ghi response lỗi) nếu folder/tag không hợp lệ.
+func (s *Server) applyFolderFilters(c *gin.Context, userID string, q *NoteQuery) bool {
+	if raw := c.Query("folder"); raw != "" {
+		recursive := c.Query("recursive") == "true"
+		var folders []Folder
+		if recursive || raw != "root" {
+			var err error
+			if folders, err = s.Folders.ListFolders(c.Request.Context(), userID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query folders"})
+				return false
+			}
+		}
+
+		switch {
+		case raw == "root" && recursive:
+			// Toàn bộ cây: thư mục gốc và mọi folder
+			q.FolderIDs = folderSubtree(folders, "")
+		case raw == "root":
+			q.FolderIDs = []string{""}
+		default:
+			if !slices.ContainsFunc(folders, func(f Folder) bool { return f.ID == raw }) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "folder not found"})
+				return false
+			}
+			q.FolderIDs = []string{raw}
+			if recursive {
+				q.FolderIDs = folderSubtree(folders, raw)
+			}
+		}
+	}
+
+	if tags := c.QueryArray("tag"); len(tags) > 0 {
+		if len(tags) > maxQueryTags {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "too many tags"})
+			return false
+		}
+		q.TagIDs = slices.Compact(slices.Sorted(slices.Values(tags)))
+		if !s.checkOwnedTags(c, q.TagIDs, userID) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,193 @@
+package serverpkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// decodeList đọc body JSON dạng mảng
+func decodeList(t *testing.T, w *httptest.ResponseRecorder) []map[string]any {
+	t.Helper()
+	var list []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", w.Body.String(), err)
+	}
+	return list
+}
+
+// createFolder tạo folder (parentID "" = thư mục gốc) và trả về id
+func (ts *testServer) createFolder(t *testing.T, token, name, parentID string) string {
+	t.Helper()
+	w := ts.do(http.MethodPost, "/api/folders", token, map[string]any{"name": name, "parent_id": parentID})
+	expectStatus(t, w, http.StatusCreated)
+	id, _ := decode(t, w)["id"].(string)
+	if id == "" {
+		t.Fatal("create folder returned no id")
+	}
+	return id
+}
+
+// createTag tạo tag và trả về id
+func (ts *testServer) createTag(t *testing.T, token, label string) string {
+	t.Helper()
+	w := ts.do(http.MethodPost, "/api/tags", token, map[string]any{"label": label})
+	expectStatus(t, w, http.StatusCreated)
+	id, _ := decode(t, w)["id"].(string)
+	if id == "" {
+		t.Fatal("create tag returned no id")
+	}
+	return id
+}
+
+// listNoteIDs trả về id các note của GET /api/notes?query
+func (ts *testServer) listNoteIDs(t *testing.T, token, query string) []string {
+	t.Helper()
+	w := ts.do(http.MethodGet, "/api/notes?"+query, token, nil)
+	expectStatus(t, w, http.StatusOK)
+	var ids []string
+	for _, n := range decode(t, w)["notes"].([]any) {
+		ids = append(ids, n.(map[string]any)["id"].(string))
+	}
+	return ids
+}
+
+func TestFolderTree(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	parent := ts.createFolder(t, token, "cGFyZW50", "")
+	child := ts.createFolder(t, token, "Y2hpbGQ=", parent)
+	noteID := ts.createNote(t, token)
+
+	expectStatus(t, ts.do(http.MethodPut, "/api/notes/"+noteID+"/folder", token, map[string]any{"folder_id": child}), http.StatusOK)
+	if ids := ts.listNoteIDs(t, token, "folder="+parent); len(ids) != 0 {
+		t.Fatalf("parent folder notes = %v", ids)
+	}
+	if ids := ts.listNoteIDs(t, token, "folder="+parent+"&recursive=true"); !slices.Equal(ids, []string{noteID}) {
+		t.Fatalf("recursive notes = %v", ids)
+	}
+	if ids := ts.listNoteIDs(t, token, "folder=root"); len(ids) != 0 {
+		t.Fatalf("root notes = %v", ids)
+	}
+
+	// Không tạo được vòng trong cây
+	w := ts.do(http.MethodPatch, "/api/folders/"+parent, token, map[string]any{"parent_id": child})
+	expectStatus(t, w, http.StatusBadRequest)
+	w = ts.do(http.MethodPatch, "/api/folders/"+child, token, map[string]any{"name": "cmVuYW1lZA=="})
+	expectStatus(t, w, http.StatusOK)
+	if got := decode(t, w); got["name"] != "cmVuYW1lZA==" || got["parent_id"] != parent {
+		t.Fatalf("renamed folder = %v", got)
+	}
+
+	// Xóa folder thì note của nó chuyển lên folder cha
+	expectStatus(t, ts.do(http.MethodDelete, "/api/folders/"+child, token, nil), http.StatusOK)
+	if folders := decodeList(t, ts.do(http.MethodGet, "/api/folders", token, nil)); len(folders) != 1 || folders[0]["id"] != parent {
+		t.Fatalf("folders = %v", folders)
+	}
+	w = ts.do(http.MethodGet, "/api/notes/"+noteID, token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if got := decode(t, w)["folder_id"]; got != parent {
+		t.Fatalf("folder_id = %v, want %s", got, parent)
+	}
+}
+
+func TestFolderOwnerOnly(t *testing.T) {
+	ts := newTestServer(t)
+	_, alice, _ := ts.newUser(t, "alice", testLoginKey)
+	_, bob, _ := ts.newUser(t, "bob", testLoginKey)
+	folder := ts.createFolder(t, alice, "Zm9sZGVy", "")
+	bobNote := ts.createNote(t, bob)
+
+	expectStatus(t, ts.do(http.MethodPatch, "/api/folders/"+folder, bob, map[string]any{"name": "eA=="}), http.StatusForbidden)
+	expectStatus(t, ts.do(http.MethodDelete, "/api/folders/"+folder, bob, nil), http.StatusForbidden)
+	expectStatus(t, ts.do(http.MethodPut, "/api/notes/"+bobNote+"/folder", bob, map[string]any{"folder_id": folder}), http.StatusBadRequest)
+	w := ts.do(http.MethodPost, "/api/folders", bob, map[string]any{"name": "eA==", "parent_id": folder})
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestNoteTags(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	_, bob, _ := ts.newUser(t, "bob", testLoginKey)
+	work := ts.createTag(t, token, "d29yaw==")
+	home := ts.createTag(t, token, "aG9tZQ==")
+	tagged := ts.createNote(t, token)
+	ts.createNote(t, token)
+
+	w := ts.do(http.MethodPut, "/api/notes/"+tagged+"/tags", token, map[string]any{"tag_ids": []string{work, home, work}})
+	expectStatus(t, w, http.StatusOK)
+	if ids := ts.listNoteIDs(t, token, "tag="+work); !slices.Equal(ids, []string{tagged}) {
+		t.Fatalf("notes tagged work = %v", ids)
+	}
+	if ids := ts.listNoteIDs(t, token, "tag="+work+"&tag="+home); !slices.Equal(ids, []string{tagged}) {
+		t.Fatalf("notes tagged work and home = %v", ids)
+	}
+
+	w = ts.do(http.MethodPut, "/api/tags/"+work, token, map[string]any{"label": "am9i"})
+	expectStatus(t, w, http.StatusOK)
+	if got := decode(t, w)["label"]; got != "am9i" {
+		t.Fatalf("label = %v", got)
+	}
+
+	// Xóa tag thì tag được gỡ khỏi note
+	expectStatus(t, ts.do(http.MethodDelete, "/api/tags/"+work, token, nil), http.StatusOK)
+	w = ts.do(http.MethodGet, "/api/notes/"+tagged, token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if tags := decode(t, w)["tags"].([]any); len(tags) != 1 || tags[0] != home {
+		t.Fatalf("tags = %v", tags)
+	}
+	if tags := decodeList(t, ts.do(http.MethodGet, "/api/tags", token, nil)); len(tags) != 1 {
+		t.Fatalf("tags = %v", tags)
+	}
+
+	// Tag của user khác không gắn được
+	other := ts.createTag(t, bob, "b3RoZXI=")
+	w = ts.do(http.MethodPut, "/api/notes/"+tagged+"/tags", token, map[string]any{"tag_ids": []string{other}})
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestShareFolder(t *testing.T) {
+	ts := newTestServer(t)
+	_, alice, _ := ts.newUser(t, "alice", testLoginKey)
+	bobID, bob, _ := ts.newUser(t, "bob", testLoginKey)
+	parent := ts.createFolder(t, alice, "cGFyZW50", "")
+	child := ts.createFolder(t, alice, "Y2hpbGQ=", parent)
+	first, second := ts.createNote(t, alice), ts.createNote(t, alice)
+	expectStatus(t, ts.do(http.MethodPut, "/api/notes/"+first+"/folder", alice, map[string]any{"folder_id": parent}), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodPut, "/api/notes/"+second+"/folder", alice, map[string]any{"folder_id": child}), http.StatusOK)
+
+	// Mỗi note trong folder (kể cả folder con) cần một wrapped key
+	w := ts.do(http.MethodPost, "/api/folders/"+parent+"/share", alice, map[string]any{
+		"shared_to_user_id": bobID,
+		"sender_public_key": "cHVi",
+		"keys":              map[string]string{first: "d3JhcHBlZA=="},
+	})
+	expectStatus(t, w, http.StatusBadRequest)
+	if missing := decode(t, w)["missing_notes"].([]any); len(missing) != 1 || missing[0] != second {
+		t.Fatalf("missing_notes = %v", missing)
+	}
+
+	expectStatus(t, ts.do(http.MethodGet, "/api/notes/"+second, bob, nil), http.StatusForbidden)
+	w = ts.do(http.MethodPost, "/api/folders/"+parent+"/share", alice, map[string]any{
+		"shared_to_user_id": bobID,
+		"sender_public_key": "cHVi",
+		"keys":              map[string]string{first: "d3JhcHBlZA==", second: "d3JhcHBlZA=="},
+	})
+	expectStatus(t, w, http.StatusOK)
+	if n := decode(t, w)["notes"]; n != float64(2) {
+		t.Fatalf("shared notes = %v", n)
+	}
+	w = ts.do(http.MethodGet, "/api/notes/"+second, bob, nil)
+	expectStatus(t, w, http.StatusOK)
+	if got := decode(t, w); got["shared"] != true || got["wrapped_key"] != "d3JhcHBlZA==" {
+		t.Fatalf("shared note = %v", got)
+	}
+	if shares := decodeList(t, ts.do(http.MethodGet, "/api/folders/"+parent+"/share", alice, nil)); len(shares) != 1 || shares[0]["user_id"] != bobID {
+		t.Fatalf("folder shares = %v", shares)
+	}
+
+	expectStatus(t, ts.do(http.MethodDelete, "/api/folders/"+parent+"/share/"+bobID, alice, nil), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodGet, "/api/notes/"+second, bob, nil), http.StatusForbidden)
+}
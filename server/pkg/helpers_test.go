@@ -0,0 +1,209 @@
+package serverpkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// testServer là Server dùng MemoryStore cùng router có các route như cmd/main.go
+type testServer struct {
+	*Server
+	router *gin.Engine
+}
+
+// testLoginKey là login key hợp lệ (base64 của 32 byte)
+const testLoginKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	// Argon2 nhẹ để test chạy nhanh
+	if err := InitAuth(AuthOptions{
+		JWTSecret:       "test-secret-key-with-at-least-32-characters",
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 24 * time.Hour,
+		ArgonTime:       1,
+		ArgonMemoryKiB:  64,
+		ArgonThreads:    1,
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	s := NewMemoryServer()
+	r := gin.New()
+
+	r.POST("/api/register", s.Register)
+	r.GET("/api/salt", s.GetSalt)
+	r.POST("/api/login", s.Login)
+	r.POST("/api/login/mfa", s.LoginMFA)
+	r.POST("/api/refresh", s.Refresh)
+	r.POST("/api/logout", s.JWTMiddleware(), s.Logout)
+	r.POST("/api/recovery", s.StartRecovery)
+	r.POST("/api/recovery/complete", s.CompleteRecovery)
+
+	notes := r.Group("/api/notes", s.JWTMiddleware())
+	notes.GET("", s.ListNotes)
+	notes.POST("", s.UploadNote)
+	notes.GET("/:id", s.GetNote)
+	notes.GET("/:id/content", s.GetNoteContent)
+	notes.PUT("/:id", s.UpdateNote)
+	notes.DELETE("/:id", s.DeleteNote)
+	notes.PUT("/:id/folder", s.MoveNote)
+	notes.PUT("/:id/tags", s.SetNoteTags)
+
+	folders := r.Group("/api/folders", s.JWTMiddleware())
+	folders.GET("", s.ListFolders)
+	folders.POST("", s.CreateFolder)
+	folders.PATCH("/:id", s.UpdateFolder)
+	folders.DELETE("/:id", s.DeleteFolder)
+	folders.POST("/:id/share", s.ShareFolder)
+	folders.GET("/:id/share", s.ListFolderShares)
+	folders.DELETE("/:id/share/:share_id", s.RevokeFolderShare)
+
+	tags := r.Group("/api/tags", s.JWTMiddleware())
+	tags.GET("", s.ListTags)
+	tags.POST("", s.CreateTag)
+	tags.PUT("/:id", s.UpdateTag)
+	tags.DELETE("/:id", s.DeleteTag)
+
+	trash := r.Group("/api/trash", s.JWTMiddleware())
+	trash.GET("", s.ListTrash)
+	trash.POST("/:id/restore", s.RestoreTrashedNote)
+	trash.DELETE("/:id", s.PurgeTrashedNote)
+
+	uploads := r.Group("/api/uploads", s.JWTMiddleware())
+	uploads.POST("", s.CreateUpload)
+	uploads.GET("/:id", s.GetUpload)
+	uploads.PUT("/:id/chunks/:index", s.PutUploadChunk)
+	uploads.POST("/:id/finalize", s.FinalizeUpload)
+	uploads.DELETE("/:id", s.AbortUpload)
+
+	me := r.Group("/api/me", s.JWTMiddleware())
+	me.GET("/usage", s.GetUsage)
+	me.GET("/2fa", s.GetTwoFactor)
+	me.GET("/keyring", s.GetKeyring)
+	me.POST("/password", s.ChangePassword)
+	me.GET("/recovery", s.GetRecoveryKey)
+	me.PUT("/recovery", s.SetRecoveryKey)
+	me.DELETE("/recovery", s.DeleteRecoveryKey)
+
+	r.POST("/api/share", s.JWTMiddleware(), s.CreateShareLink)
+	r.DELETE("/api/share/:id", s.JWTMiddleware(), s.RevokeShareLink)
+	r.GET("/api/share/:id/info", s.GetShareInfo)
+	r.GET("/api/share/:id", s.GetSharedContent)
+
+	return &testServer{Server: s, router: r}
+}
+
+// newUser tạo user (login key = loginKey) và trả về id cùng access / refresh token
+func (ts *testServer) newUser(t *testing.T, username, loginKey string) (userID, accessToken, refreshToken string) {
+	t.Helper()
+	salt, err := GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashPassword(loginKey, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID = uuid.New().String()
+	err = ts.Users.CreateUser(context.Background(), &User{
+		ID:           userID,
+		Username:     username,
+		PasswordHash: hash,
+		KdfSalt:      EncodeSalt(salt),
+		AuthVersion:  AuthVersionLoginKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, refreshToken, err = ts.GenerateJWT(context.Background(), userID, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userID, accessToken, refreshToken
+}
+
+// do gửi request JSON (body nil = không có body) và trả về response
+func (ts *testServer) do(method, path, token string, body any, headers ...string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			panic(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+	return w
+}
+
+// doRaw gửi request với body thô (application/octet-stream)
+func (ts *testServer) doRaw(method, path, token string, body []byte, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Authorization", "Bearer "+token)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+	return w
+}
+
+// decode đọc body JSON của response
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", w.Body.String(), err)
+	}
+	return m
+}
+
+// expectStatus dừng test nếu status khác want
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d (body %s)", w.Code, want, w.Body.String())
+	}
+}
+
+// testContent là content_enc hợp lệ (base64)
+const testContent = "Y2lwaGVydGV4dA=="
+
+// createNote tải lên một note và trả về id
+func (ts *testServer) createNote(t *testing.T, token string) string {
+	t.Helper()
+	w := ts.do(http.MethodPost, "/api/notes", token, map[string]any{
+		"title":       "title",
+		"content_enc": testContent,
+		"key_enc":     "a2V5",
+		"iv_meta":     "{}",
+	})
+	expectStatus(t, w, http.StatusCreated)
+	id, _ := decode(t, w)["id"].(string)
+	if id == "" {
+		t.Fatal("upload returned no id")
+	}
+	return id
+}
@@ -0,0 +1,131 @@
+package serverpkg
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================
+// DH KEY DIRECTORY APIs - Danh bạ khóa công khai Diffie-Hellman
+// ============================================================
+
+// Kích thước tối đa của public key (RFC 3526 Group 14 = 2048-bit)
+const maxDHPublicKeyBytes = 256
+
+// parseDHPublicKey giải mã public key dạng Base64 (big-endian) và kiểm tra phạm vi cơ bản
+func parseDHPublicKey(encoded string) (*big.Int, bool) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) == 0 || len(raw) > maxDHPublicKeyBytes {
+		return nil, false
+	}
+	pub := new(big.Int).SetBytes(raw)
+	if pub.Cmp(big.NewInt(1)) <= 0 {
+		return nil, false
+	}
+	return pub, true
+}
+
+// KeyFingerprint tạo fingerprint dễ đọc cho public key (giống VerifyKeyFingerprint ở client)
+func KeyFingerprint(publicKey *big.Int) string {
+	hash := sha256.Sum256(publicKey.Bytes())
+	hexStr := hex.EncodeToString(hash[:16])
+	var result strings.Builder
+	for i := 0; i < len(hexStr); i += 2 {
+		if i > 0 {
+			result.WriteString(":")
+		}
+		result.WriteString(strings.ToUpper(hexStr[i : i+2]))
+	}
+	return result.String()
+}
+
+// PublishDHKey - Đăng ký hoặc xoay vòng (rotate) DH public key của user hiện tại
+// POST /api/keys/dh
+// Request: { "public_key": "base64..." }
+// Response: { "user_id": "...", "fingerprint": "A1:B2:..." }
+func (s *Server) PublishDHKey(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		PublicKey string `json:"public_key" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pub, ok := parseDHPublicKey(req.PublicKey)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid public key"})
+		return
+	}
+
+	// Upsert: lần đầu INSERT, các lần sau là rotate key (trigger cập nhật updated_at)
+	if err := s.Users.PutDHKey(c.Request.Context(), userID.(string), req.PublicKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save public key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":     userID,
+		"fingerprint": KeyFingerprint(pub),
+	})
+}
+
+// GetDHKey - Lấy DH public key của user khác theo id
+// GET /api/keys/dh/:user_id
+// Response: { "user_id": "...", "username": "...", "public_key": "base64...", "fingerprint": "...", "updated_at": "..." }
+func (s *Server) GetDHKey(c *gin.Context) {
+	key, err := s.Users.GetDHKeyByUserID(c.Request.Context(), c.Param("user_id"))
+	writeDHKey(c, key, err)
+}
+
+// LookupDHKey - Lấy DH public key của user khác theo username
+// GET /api/keys/dh?username=bob
+func (s *Server) LookupDHKey(c *gin.Context) {
+	username := c.Query("username")
+	if username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username parameter is required"})
+		return
+	}
+	key, err := s.Users.GetDHKeyByUsername(c.Request.Context(), username)
+	writeDHKey(c, key, err)
+}
+
+// writeDHKey trả về public key kèm fingerprint (hoặc lỗi tương ứng)
+func writeDHKey(c *gin.Context, key *DHKey, err error) {
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "public key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query public key"})
+		return
+	}
+
+	pub, ok := parseDHPublicKey(key.PublicKey)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "stored public key is invalid"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":     key.UserID,
+		"username":    key.Username,
+		"public_key":  key.PublicKey,
+		"fingerprint": KeyFingerprint(pub),
+		"updated_at":  key.UpdatedAt,
+	})
+}
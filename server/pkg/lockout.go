@@ -0,0 +1,233 @@
+package serverpkg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================
+// LOCKOUT - Chống đoán mật khẩu
+// ============================================================
+//
+// Mỗi lần sai mật khẩu / mã 2FA (login) hoặc X-Access-Pass-Hash (share link) tăng
+// bộ đếm của tài khoản hoặc share link và của IP gửi request. Từ lần sai thứ
+// FreeAttempts+1, tài khoản / share link phải chờ BaseDelay (gấp đôi sau mỗi lần
+// sai) mới được thử tiếp; đạt MaxFailures thì bị khóa LockDuration và ghi audit
+// log. IP chỉ bị khóa khi đạt IPMaxFailures. Bộ đếm được lưu trong database nên
+// không mất khi khởi động lại server; lần sai cũ hơn Window không còn được tính.
+//
+// Request bị chặn nhận 423 (tài khoản / share link bị khóa) hoặc 429 (đang phải
+// chờ, hoặc IP bị khóa) kèm locked_until, retry_after và header Retry-After.
+// Mỗi lần thử được tính là sai (và khóa nếu đạt ngưỡng) trước khi so mật khẩu,
+// trong cùng một thao tác với việc kiểm tra khóa; đúng thì được hoàn lại. Vì vậy
+// trong lúc bị khóa mật khẩu đúng cũng bị từ chối, và request song song không
+// thử được quá MaxFailures lần.
+
+// authSubject là một khóa được đếm số lần sai
+type authSubject struct {
+	scope string
+	key   string
+}
+
+// loginSubjects: username (kể cả username không tồn tại, để không lộ tài khoản nào có thật) và IP
+func loginSubjects(c *gin.Context, username string) []authSubject {
+	return []authSubject{{AuthScopeAccount, username}, {AuthScopeIP, c.ClientIP()}}
+}
+
+// shareLinkSubjects: share link và IP
+func shareLinkSubjects(c *gin.Context, shareID string) []authSubject {
+	return []authSubject{{AuthScopeShareLink, shareID}, {AuthScopeIP, c.ClientIP()}}
+}
+
+// maxFailures là số lần sai để khóa scope
+func (p LockoutPolicy) maxFailures(scope string) int {
+	if scope == AuthScopeIP {
+		return p.IPMaxFailures
+	}
+	return p.MaxFailures
+}
+
+// blockFor là thời gian scope bị chặn sau lần sai thứ failures (0 = không bị chặn)
+func (p LockoutPolicy) blockFor(scope string, failures int) time.Duration {
+	if failures >= p.maxFailures(scope) {
+		return p.LockDuration
+	}
+	// IP không bị trễ dần: một người gõ sai không làm chậm người khác dùng chung IP
+	if scope == AuthScopeIP || failures <= p.FreeAttempts {
+		return 0
+	}
+	exp := failures - p.FreeAttempts - 1
+	if exp >= 62 || p.BaseDelay > time.Duration(math.MaxInt64>>exp) {
+		return p.LockDuration
+	}
+	return min(p.BaseDelay<<exp, p.LockDuration)
+}
+
+// authAttemptsKey giữ trong gin.Context các lần thử mà request đã tính trước
+const authAttemptsKey = "auth_attempts"
+
+// reservedAttempt là một lần thử đã được tính trước là sai cho subject
+type reservedAttempt struct {
+	authSubject
+	failure *AuthFailure
+	// lockedUntil: chặn do chính lần thử này đặt ("" = không), được bỏ nếu lần thử được hoàn lại
+	lockedUntil string
+}
+
+// reserveAuthAttempt tính trước một lần sai cho các subject rồi caller mới so mật
+// khẩu, nên N request song song tiêu tốn N lần thử và không vượt được MaxFailures.
+// Xác thực đúng thì gọi clearAuthFailures, sai thì recordAuthFailure, lỗi server
+// thì releaseAuthAttempts. Trả về false (đã ghi response lỗi) nếu có subject bị chặn.
+func (s *Server) reserveAuthAttempt(c *gin.Context, subjects []authSubject) bool {
+	if s.Lockouts == nil {
+		return true
+	}
+	ctx := c.Request.Context()
+	p := s.LockoutPolicy
+	now := time.Now()
+	var reserved []reservedAttempt
+	for _, sub := range subjects {
+		max := p.maxFailures(sub.scope)
+		f, err := s.Lockouts.ReserveAuthAttempt(ctx, sub.scope, sub.key,
+			sqliteTime(now), sqliteTime(now.Add(-p.Window)), max, sqliteTime(now.Add(p.LockDuration)))
+		if err != nil {
+			// Hoàn lại các subject trước đó: request này không được thử
+			c.Set(authAttemptsKey, reserved)
+			s.releaseAuthAttempts(c)
+			if errors.Is(err, ErrAuthLocked) {
+				s.writeLockedSubject(c, sub)
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check failed attempts"})
+			}
+			return false
+		}
+		r := reservedAttempt{authSubject: sub, failure: f}
+		if f.Failures >= max {
+			r.lockedUntil = f.LockedUntil
+		} else if d := p.blockFor(sub.scope, f.Failures); d > 0 {
+			// Chặn trước khi so mật khẩu để request song song phải chờ như sau một lần sai
+			until := sqliteTime(now.Add(d))
+			if err := s.Lockouts.LockAuth(ctx, sub.scope, sub.key, until); err != nil {
+				log.Printf("lockout: block %s %q: %v", sub.scope, sub.key, err)
+			} else {
+				r.lockedUntil = until
+			}
+		}
+		reserved = append(reserved, r)
+	}
+	c.Set(authAttemptsKey, reserved)
+	return true
+}
+
+// reservedAttempts lấy và xóa các lần thử request đã tính trước
+func reservedAttempts(c *gin.Context) []reservedAttempt {
+	v, _ := c.Get(authAttemptsKey)
+	c.Set(authAttemptsKey, []reservedAttempt(nil))
+	reserved, _ := v.([]reservedAttempt)
+	return reserved
+}
+
+// releaseAuthAttempts hoàn lại các lần thử đã tính trước khi request không so được
+// mật khẩu (lỗi server), để lỗi phía server không làm khóa tài khoản
+func (s *Server) releaseAuthAttempts(c *gin.Context) {
+	for _, r := range reservedAttempts(c) {
+		if err := s.Lockouts.ReleaseAuthAttempt(c.Request.Context(), r.scope, r.key, r.lockedUntil); err != nil {
+			log.Printf("lockout: release attempt for %s %q: %v", r.scope, r.key, err)
+		}
+	}
+}
+
+// writeLockedSubject ghi response 423/429 cho subject đang bị chặn
+func (s *Server) writeLockedSubject(c *gin.Context, sub authSubject) {
+	f, err := s.Lockouts.GetAuthFailure(c.Request.Context(), sub.scope, sub.key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check failed attempts"})
+		return
+	}
+	s.writeLocked(c, f)
+}
+
+// writeLocked ghi response 423/429 cho subject đang bị chặn
+func (s *Server) writeLocked(c *gin.Context, f *AuthFailure) {
+	retryAfter := int64(1)
+	if until, err := time.Parse(sqliteTimeFormat, f.LockedUntil); err == nil {
+		retryAfter = max(int64(math.Ceil(time.Until(until).Seconds())), 1)
+	}
+
+	status, message := http.StatusTooManyRequests, "too many failed attempts, try again later"
+	switch {
+	case f.Scope == AuthScopeIP:
+		message = "too many failed attempts from this address"
+	case f.Failures < s.LockoutPolicy.maxFailures(f.Scope):
+	case f.Scope == AuthScopeShareLink:
+		status, message = http.StatusLocked, "share link temporarily locked"
+	default:
+		status, message = http.StatusLocked, "account temporarily locked"
+	}
+
+	c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+	c.JSON(status, gin.H{
+		"error":        message,
+		"locked_until": f.LockedUntil,
+		"retry_after":  retryAfter,
+	})
+}
+
+// recordAuthFailure giữ lại các lần sai đã tính trước (reserveAuthAttempt) và ghi
+// audit log cho subject bị khóa vì đạt ngưỡng. Lỗi chỉ được ghi log: request vẫn
+// nhận response sai mật khẩu như bình thường.
+func (s *Server) recordAuthFailure(c *gin.Context, subjects []authSubject) {
+	if s.Lockouts == nil {
+		return
+	}
+	for _, r := range reservedAttempts(c) {
+		f := r.failure
+		if f.Failures < s.LockoutPolicy.maxFailures(r.scope) {
+			continue
+		}
+		detail := fmt.Sprintf("%d failed attempts since %s, locked until %s", f.Failures, f.FirstFailedAt, f.LockedUntil)
+		log.Printf("lockout: %s %q locked: %s (ip %s)", r.scope, r.key, detail, c.ClientIP())
+		err := s.Lockouts.AddAuditEvent(c.Request.Context(), &AuditEvent{
+			Event:   AuditEventLockout,
+			Scope:   r.scope,
+			Subject: r.key,
+			IP:      c.ClientIP(),
+			Detail:  detail,
+		})
+		if err != nil {
+			log.Printf("lockout: write audit event: %v", err)
+		}
+	}
+}
+
+// clearAuthFailures xóa bộ đếm của subject sau khi xác thực thành công.
+// Bộ đếm của IP được giữ (chỉ hoàn lại lần thử của request này): đăng nhập đúng
+// một tài khoản không xóa các lần đoán tài khoản khác từ cùng IP.
+func (s *Server) clearAuthFailures(c *gin.Context, subjects []authSubject) {
+	if s.Lockouts == nil {
+		return
+	}
+	for _, r := range reservedAttempts(c) {
+		if r.scope != AuthScopeIP {
+			continue
+		}
+		if err := s.Lockouts.ReleaseAuthAttempt(c.Request.Context(), r.scope, r.key, r.lockedUntil); err != nil {
+			log.Printf("lockout: release attempt for %s %q: %v", r.scope, r.key, err)
+		}
+	}
+	for _, sub := range subjects {
+		if sub.scope == AuthScopeIP {
+			continue
+		}
+		if err := s.Lockouts.ClearAuthFailures(c.Request.Context(), sub.scope, sub.key); err != nil {
+			log.Printf("lockout: clear failures for %s %q: %v", sub.scope, sub.key, err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package serverpkg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockoutStores trả về MemoryStore và SQLiteStore (database tạm) để chạy cùng một test
+func lockoutStores(t *testing.T) map[string]LockoutStore {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=10000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := MigrateUp(db, false); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]LockoutStore{
+		"memory": NewMemoryStore(),
+		"sqlite": NewSQLiteStore(db),
+	}
+}
+
+func TestReserveAuthAttemptParallel(t *testing.T) {
+	const max = 10
+	for name, store := range lockoutStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			lockUntil := sqliteTime(now.Add(time.Hour))
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			reserved, locked := 0, 0
+			for i := 0; i < 5*max; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := store.ReserveAuthAttempt(ctx, AuthScopeAccount, "alice",
+						sqliteTime(now), sqliteTime(now.Add(-time.Hour)), max, lockUntil)
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case err == nil:
+						reserved++
+					case errors.Is(err, ErrAuthLocked):
+						locked++
+					default:
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+			if reserved != max || locked != 4*max {
+				t.Fatalf("reserved %d, locked %d; want %d and %d", reserved, locked, max, 4*max)
+			}
+			f, err := store.GetAuthFailure(ctx, AuthScopeAccount, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Failures != max || f.LockedUntil != lockUntil {
+				t.Fatalf("failure = %+v", f)
+			}
+		})
+	}
+}
+
+func TestReleaseAuthAttempt(t *testing.T) {
+	for name, store := range lockoutStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := sqliteTime(time.Now())
+			window := sqliteTime(time.Now().Add(-time.Hour))
+			lockUntil := sqliteTime(time.Now().Add(time.Hour))
+
+			// Lần thử thứ 2 đạt ngưỡng và khóa; hoàn lại thì bỏ khóa do chính nó đặt
+			for i := 0; i < 2; i++ {
+				if _, err := store.ReserveAuthAttempt(ctx, AuthScopeIP, "1.2.3.4", now, window, 2, lockUntil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.ReleaseAuthAttempt(ctx, AuthScopeIP, "1.2.3.4", lockUntil); err != nil {
+				t.Fatal(err)
+			}
+			f, err := store.GetAuthFailure(ctx, AuthScopeIP, "1.2.3.4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Failures != 1 || f.LockedUntil != "" {
+				t.Fatalf("failure = %+v", f)
+			}
+
+			// Khóa do request khác đặt được giữ
+			if _, err := store.ReserveAuthAttempt(ctx, AuthScopeIP, "1.2.3.4", now, window, 2, lockUntil); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.ReleaseAuthAttempt(ctx, AuthScopeIP, "1.2.3.4", ""); err != nil {
+				t.Fatal(err)
+			}
+			if f, _ := store.GetAuthFailure(ctx, AuthScopeIP, "1.2.3.4"); f.LockedUntil != lockUntil {
+				t.Fatalf("locked_until = %q, want %q", f.LockedUntil, lockUntil)
+			}
+		})
+	}
+}
+
+func TestLoginParallelGuessesStopAtMaxFailures(t *testing.T) {
+	ts := newTestServer(t)
+	ts.newUser(t, "alice", testLoginKey)
+	// Không trễ dần để chỉ ngưỡng MaxFailures chặn các lần đoán
+	ts.LockoutPolicy.FreeAttempts = ts.LockoutPolicy.MaxFailures
+	wrongKey := "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+
+	var wg sync.WaitGroup
+	codes := make([]int, 5*ts.LockoutPolicy.MaxFailures)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "alice", LoginKey: wrongKey}).Code
+		}(i)
+	}
+	wg.Wait()
+
+	guesses := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusUnauthorized:
+			guesses++
+		case http.StatusLocked, http.StatusTooManyRequests:
+		default:
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	if guesses != ts.LockoutPolicy.MaxFailures {
+		t.Fatalf("%d guesses were checked, want %d", guesses, ts.LockoutPolicy.MaxFailures)
+	}
+
+	// Tài khoản bị khóa: mật khẩu đúng cũng bị từ chối
+	w := ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "alice", LoginKey: testLoginKey})
+	expectStatus(t, w, http.StatusLocked)
+}
+
+func TestLoginSuccessReleasesAttempt(t *testing.T) {
+	ts := newTestServer(t)
+	ts.newUser(t, "alice", testLoginKey)
+
+	for i := 0; i < 3; i++ {
+		expectStatus(t, ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "alice", LoginKey: testLoginKey}), http.StatusOK)
+	}
+	if _, err := ts.Lockouts.GetAuthFailure(context.Background(), AuthScopeAccount, "alice"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("account counter not cleared: %v", err)
+	}
+	f, err := ts.Lockouts.GetAuthFailure(context.Background(), AuthScopeIP, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Failures != 0 {
+		t.Fatalf("IP failures = %d, want 0", f.Failures)
+	}
+}
@@ -0,0 +1,138 @@
+package serverpkg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ============================================================
+// LOGIN KEY - Tách key xác thực khỏi K_Master
+// ============================================================
+//
+// Client tính Argon2id(mật khẩu, kdf_salt) rồi suy ra hai key bằng HKDF với label
+// khác nhau: login key (gửi lên server thay cho mật khẩu) và K_Master (không rời
+// client). Server chỉ lưu Argon2id(login key + pepper) nên dù thấy login key và
+// kdf_salt cũng không tính được K_Master.
+//
+// 1. GET  /api/salt?username=...   kdf_salt và auth_version để client suy ra login key
+// 2. POST /api/login               { username, login_key }
+//
+// Tài khoản tạo trước khi có login key (auth_version = 0) đăng nhập bằng mật khẩu
+// như cũ; ngay sau đó client đổi sang login key qua POST /api/me/password (bọc lại
+// keyring bằng K_Master mới) và mật khẩu không còn được gửi lên nữa.
+
+const (
+	// AuthVersionPassword: password_hash là hash của mật khẩu (tài khoản cũ)
+	AuthVersionPassword = 0
+	// AuthVersionLoginKey: password_hash là hash của login key
+	AuthVersionLoginKey = 1
+
+	// loginKeyLen: login key là output 256 bit của HKDF
+	loginKeyLen = 32
+)
+
+// SaltResponse là response của GET /api/salt
+type SaltResponse struct {
+	Username    string `json:"username"`
+	KdfSalt     string `json:"kdf_salt"`
+	AuthVersion int    `json:"auth_version"`
+}
+
+// validLoginKey kiểm tra login key là base64 của loginKeyLen byte
+func validLoginKey(loginKey string) bool {
+	raw, err := base64.StdEncoding.DecodeString(loginKey)
+	return err == nil && len(raw) == loginKeyLen
+}
+
+// verifyCredentials so mật khẩu (tài khoản cũ) hoặc login key với password_hash.
+// Chỉ trường ứng với auth_version của user được dùng.
+func verifyCredentials(user *User, password, loginKey string) (bool, error) {
+	secret := loginKey
+	if user.AuthVersion == AuthVersionPassword {
+		secret = password
+	}
+	if secret == "" {
+		// Vẫn tính Argon2 để thời gian trả lời không lộ trường nào bị thiếu
+		verifyDummy(password + loginKey)
+		return false, nil
+	}
+	salt, err := DecodeSalt(user.KdfSalt)
+	if err != nil {
+		return false, err
+	}
+	return VerifyPassword(secret, user.PasswordHash, salt)
+}
+
+// dummySalt là salt cố định cho verifyDummy
+var dummySalt = make([]byte, 16)
+
+// verifyDummy tốn thời gian như một lần VerifyPassword, dùng khi không có
+// password_hash để so (vd: username không tồn tại) để thời gian trả lời không
+// cho biết tài khoản nào có thật
+func verifyDummy(secret string) {
+	_, _ = VerifyPassword(secret, "", dummySalt)
+}
+
+// decoyKeyInfo là HKDF info tách key của decoy khỏi secret ký JWT
+const decoyKeyInfo = "Secure-Notes-Decoy-Salt"
+
+// decoyKey là key HMAC cho decoySalt và decoyAuthVersion, do InitAuth suy ra
+var decoyKey []byte
+
+// deriveDecoyKey suy ra decoyKey từ secret ký JWT bằng HKDF với label riêng,
+// để secret ký JWT không bị dùng trực tiếp cho mục đích thứ hai
+func deriveDecoyKey(secret []byte) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(decoyKeyInfo)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// decoyMAC là HMAC(decoyKey, label:username)
+func decoyMAC(label, username string) []byte {
+	mac := hmac.New(sha256.New, decoyKey)
+	mac.Write([]byte(label + ":" + username))
+	return mac.Sum(nil)
+}
+
+// decoySalt là kdf_salt trả cho username không tồn tại: cố định theo username
+// để GET /api/salt không cho biết tài khoản nào có thật
+func decoySalt(username string) string {
+	return EncodeSalt(decoyMAC("kdf_salt", username)[:16])
+}
+
+// decoyAuthVersion là auth_version trả cho username không tồn tại: cố định theo
+// username và là AuthVersionPassword với tỉ lệ bằng tỉ lệ tài khoản cũ (legacy
+// trên total), để auth_version = 0 không cho biết username là tài khoản cũ có thật
+func decoyAuthVersion(username string, total, legacy int) int {
+	if total <= 0 || legacy <= 0 {
+		return AuthVersionLoginKey
+	}
+	if binary.BigEndian.Uint64(decoyMAC("auth_version", username))%uint64(total) < uint64(legacy) {
+		return AuthVersionPassword
+	}
+	return AuthVersionLoginKey
+}
+
+// parseLoginKey kiểm tra login key và kdf_salt do client gửi khi đăng ký, đổi hoặc
+// đặt lại mật khẩu. Trả về false (đã ghi response lỗi) nếu không hợp lệ.
+func parseLoginKey(c *gin.Context, loginKey, kdfSalt string) ([]byte, bool) {
+	if !validLoginKey(loginKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "login_key must be base64 of 32 bytes"})
+		return nil, false
+	}
+	salt, err := DecodeSalt(kdfSalt)
+	if err != nil || len(salt) < minKdfSaltLen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kdf_salt must be base64 of at least 16 random bytes"})
+		return nil, false
+	}
+	return salt, true
+}
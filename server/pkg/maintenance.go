@@ -0,0 +1,98 @@
+package serverpkg
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ============================================================
+// MAINTENANCE SCHEDULER - Dọn dẹp định kỳ trong tiến trình server
+// ============================================================
+
+// maintenanceTask là một truy vấn dọn dẹp có tên để ghi log
+type maintenanceTask struct {
+	name string
+	run  func(ctx context.Context) (int64, error)
+}
+
+// MaintenanceReport là số bản ghi bị ảnh hưởng của mỗi tác vụ trong một lần chạy
+type MaintenanceReport map[string]int64
+
+func (s *Server) maintenanceTasks() []maintenanceTask {
+	m := s.Maintenance
+	tasks := []maintenanceTask{
+		{"expired refresh tokens purged", m.PurgeExpiredRefreshTokens},
+		{"expired blacklist entries purged", m.PurgeExpiredBlacklist},
+		{"expired share links deactivated", m.DeactivateExpiredShareLinks},
+		{"exhausted share links deactivated", m.DeactivateExhaustedShareLinks},
+		{"expired upload sessions purged", m.PurgeExpiredUploads},
+		{"trashed notes purged", func(ctx context.Context) (int64, error) {
+			return m.PurgeTrashedNotes(ctx, sqliteTime(time.Now().Add(-s.TrashRetention)))
+		}},
+		{"stale failed-attempt counters purged", func(ctx context.Context) (int64, error) {
+			return m.PurgeAuthFailures(ctx, sqliteTime(time.Now().Add(-s.LockoutPolicy.Window)))
+		}},
+	}
+	// GC chạy sau cùng để thu dọn cả chunk của các session và note vừa bị xóa
+	if s.Blobs != nil && s.BlobRefs != nil {
+		tasks = append(tasks, maintenanceTask{"unreferenced blobs deleted", s.CollectGarbageBlobs})
+	}
+	return tasks
+}
+
+// RunMaintenance chạy tất cả tác vụ dọn dẹp một lần.
+// Một tác vụ lỗi không chặn các tác vụ còn lại; lỗi đầu tiên được trả về.
+func (s *Server) RunMaintenance(ctx context.Context) (MaintenanceReport, error) {
+	report := MaintenanceReport{}
+	var firstErr error
+	for _, task := range s.maintenanceTasks() {
+		n, err := task.run(ctx)
+		if err != nil {
+			log.Printf("maintenance: %s failed: %v", task.name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		report[task.name] = n
+	}
+	return report, firstErr
+}
+
+// StartMaintenance chạy RunMaintenance ngay lập tức rồi lặp lại mỗi interval
+// cho tới khi ctx bị hủy. Kênh trả về được đóng khi scheduler đã dừng hẳn.
+func (s *Server) StartMaintenance(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	if s.Maintenance == nil || interval <= 0 {
+		close(done)
+		return done
+	}
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.logMaintenance(ctx)
+			select {
+			case <-ctx.Done():
+				log.Println("maintenance: scheduler stopped")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+func (s *Server) logMaintenance(ctx context.Context) {
+	start := time.Now()
+	report, _ := s.RunMaintenance(ctx)
+	for _, task := range s.maintenanceTasks() {
+		if n, ok := report[task.name]; ok && n > 0 {
+			log.Printf("maintenance: %d %s", n, task.name)
+		}
+	}
+	log.Printf("maintenance: run finished in %s", time.Since(start).Round(time.Millisecond))
+}
@@ -1,6 +1,7 @@
 package serverpkg
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,10 +10,11 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 // JWTMiddleware xác thực token JWT từ header Authorization
-func JWTMiddleware() gin.HandlerFunc {
+func (s *Server) JWTMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		auth := c.GetHeader("Authorization")
 		if auth == "" {
@@ -28,31 +30,30 @@ func JWTMiddleware() gin.HandlerFunc {
 
 		// Phân tích và xác thực JWT bằng hàm hỗ trợ auth
 		token, claims, err := ParseJWT(tokenStr)
+		// Hết hạn được báo riêng để client biết cần gọi POST /api/refresh rồi gửi lại request
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token expired"})
+			return
+		}
 		if err != nil || token == nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
-
-		// Kiểm tra thời hạn (exp) nếu có
-		if expRaw, ok := claims["exp"]; ok {
-			switch v := expRaw.(type) {
-			case float64:
-				if int64(v) < time.Now().Unix() {
-					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token expired"})
-					return
-				}
-			case int64:
-				if v < time.Now().Unix() {
-					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token expired"})
-					return
-				}
-			}
+		// Token có purpose (vd: mfa_token khi đăng nhập 2 bước) không phải access token
+		if _, ok := claims["purpose"]; ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			return
 		}
 
-		// Kiểm tra blacklist theo jti nếu tồn tại
+		// Kiểm tra blacklist theo jti nếu tồn tại (lỗi database => từ chối)
 		if jtiRaw, ok := claims["jti"]; ok {
 			if jti, ok2 := jtiRaw.(string); ok2 && jti != "" {
-				if valid, err := ValidateToken(jti); err == nil && !valid {
+				valid, err := s.ValidateToken(c.Request.Context(), jti)
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to validate token"})
+					return
+				}
+				if !valid {
 					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token revoked"})
 					return
 				}
@@ -69,13 +70,31 @@ func JWTMiddleware() gin.HandlerFunc {
 	}
 }
 
-// CORSMiddleware xử lý CORS (chia sẻ tài nguyên giữa nguồn khác nhau)
-func CORSMiddleware() gin.HandlerFunc {
+// CORSMiddleware xử lý CORS (chia sẻ tài nguyên giữa nguồn khác nhau).
+// allowedOrigins chứa "*" nghĩa là chấp nhận mọi origin (không kèm credentials).
+func CORSMiddleware(allowedOrigins []string) gin.HandlerFunc {
+	allowAll := false
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, o := range allowedOrigins {
+		if o == "*" {
+			allowAll = true
+		}
+		allowed[strings.TrimSuffix(o, "/")] = true
+	}
+
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, Accept")
-		c.Header("Access-Control-Allow-Credentials", "true")
+		origin := c.GetHeader("Origin")
+		switch {
+		case allowAll:
+			c.Header("Access-Control-Allow-Origin", "*")
+		case origin != "" && allowed[origin]:
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Allow-Credentials", "true")
+			c.Header("Vary", "Origin")
+		}
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, Accept, X-Access-Pass-Hash, Range, If-Range, If-Match, X-Chunk-SHA256")
+		c.Header("Access-Control-Expose-Headers", "Accept-Ranges, Content-Range, Content-Length, ETag")
 
 		if c.Request.Method == http.MethodOptions {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -93,16 +112,12 @@ type rateInfo struct {
 	window   time.Time
 }
 
-var (
-	rateMap   = make(map[string]*rateInfo)
-	rateMapMu sync.Mutex
-	// mức mặc định: 100 yêu cầu mỗi phút
-	rateLimitRequests = 100
-	rateWindow        = time.Minute
-)
-
 // RateLimitMiddleware giới hạn yêu cầu theo IP để ngăn lạm dụng
-func RateLimitMiddleware() gin.HandlerFunc {
+// (tối đa rateLimitRequests yêu cầu trong mỗi rateWindow)
+func RateLimitMiddleware(rateLimitRequests int, rateWindow time.Duration) gin.HandlerFunc {
+	rateMap := make(map[string]*rateInfo)
+	var rateMapMu sync.Mutex
+
 	return func(c *gin.Context) {
 		ip := c.ClientIP()
 		if ip == "" {
@@ -138,6 +153,20 @@ func RateLimitMiddleware() gin.HandlerFunc {
 	}
 }
 
+// MaxBodySizeMiddleware giới hạn kích thước body của request (trả về 413 nếu vượt quá)
+func MaxBodySizeMiddleware(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxBytes {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+			return
+		}
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		}
+		c.Next()
+	}
+}
+
 // LoggingMiddleware ghi log các yêu cầu và phản hồi HTTP
 func LoggingMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -155,6 +184,9 @@ func LoggingMiddleware() gin.HandlerFunc {
 		status := c.Writer.Status()
 		duration := time.Since(start)
 		size := c.Writer.Size()
+		if subject, ok := c.Get("client_cert_subject"); ok {
+			clientIP = fmt.Sprintf("%s [%v]", clientIP, subject)
+		}
 		log.Printf("%s %s - %s - %d - %dB - %s", method, path, clientIP, status, size, duration)
 	}
 }
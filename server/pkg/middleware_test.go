@@ -0,0 +1,75 @@
+package serverpkg
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// signAccessToken ký access token với exp tùy ý
+func signAccessToken(t *testing.T, userID, username string, exp time.Time) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":  userID,
+		"username": username,
+		"exp":      exp.Unix(),
+		"iat":      exp.Add(-accessTokenExpiry).Unix(),
+		"jti":      uuid.New().String(),
+	})
+	s, err := token.SignedString(JWTSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJWTMiddlewareExpiredTokenRefreshAndRetry(t *testing.T) {
+	ts := newTestServer(t)
+	userID, _, refresh := ts.newUser(t, "alice", testLoginKey)
+	expired := signAccessToken(t, userID, "alice", time.Now().Add(-time.Minute))
+
+	w := ts.do(http.MethodGet, "/api/notes", expired, nil)
+	expectStatus(t, w, http.StatusUnauthorized)
+	if got := decode(t, w)["error"]; got != "token expired" {
+		t.Fatalf("error = %q, want %q", got, "token expired")
+	}
+
+	// Client refresh một lần rồi gửi lại request
+	w = ts.do(http.MethodPost, "/api/refresh", "", RefreshRequest{RefreshToken: refresh})
+	expectStatus(t, w, http.StatusOK)
+	access, _ := decode(t, w)["access_token"].(string)
+	if access == "" {
+		t.Fatal("refresh returned no access token")
+	}
+
+	w = ts.do(http.MethodGet, "/api/notes", access, nil)
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestJWTMiddlewareInvalidToken(t *testing.T) {
+	ts := newTestServer(t)
+	userID, access, _ := ts.newUser(t, "alice", testLoginKey)
+
+	cases := map[string]string{
+		"garbage":  "not-a-jwt",
+		"tampered": access[:len(access)-2] + "xx",
+		"other key": func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"user_id": userID,
+				"exp":     time.Now().Add(time.Minute).Unix(),
+			})
+			s, _ := token.SignedString([]byte("another-secret-key-with-32-characters!"))
+			return s
+		}(),
+	}
+	for name, token := range cases {
+		w := ts.do(http.MethodGet, "/api/notes", token, nil)
+		expectStatus(t, w, http.StatusUnauthorized)
+		if got := decode(t, w)["error"]; got != "invalid token" {
+			t.Errorf("%s: error = %q, want %q", name, got, "invalid token")
+		}
+	}
+}
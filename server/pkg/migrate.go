@@ -0,0 +1,261 @@
+package serverpkg
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"secure-notes-server/migrations"
+)
+
+// ============================================================
+// SCHEMA MIGRATIONS - Versioned migrations embedded in the binary
+// ============================================================
+
+// legacyBaselineVersion: database tạo bởi build-db.sh cũ (001 schema + 002 seed)
+// không có bảng schema_migrations, được coi như đã ở version này
+const legacyBaselineVersion = 2
+
+// Migration là một file migration đã nhúng (NNN_name.sql + NNN_name.down.sql)
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	Seed    bool // dữ liệu mẫu, chỉ chạy khi được yêu cầu
+}
+
+// MigrationStatus mô tả trạng thái của một migration
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt string
+}
+
+// LoadMigrations đọc các migration đã nhúng, sắp xếp theo version
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		isDown := strings.HasSuffix(name, ".down.sql")
+		base := strings.TrimSuffix(strings.TrimSuffix(name, ".sql"), ".down")
+
+		prefix, _, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name: %s", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", name, err)
+		}
+
+		content, err := fs.ReadFile(migrations.FS, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version}
+			byVersion[version] = m
+		}
+		if isDown {
+			m.Down = string(content)
+			continue
+		}
+		if m.Name != "" {
+			return nil, fmt.Errorf("duplicate migration version %d", version)
+		}
+		m.Name = base
+		m.Up = string(content)
+		m.Seed = strings.Contains(base, "_seed_")
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", m.Version)
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// ensureMigrationsTable tạo bảng schema_migrations và nhận diện database cũ
+func ensureMigrationsTable(database *sql.DB) error {
+	var exists int
+	err := database.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists == 1 {
+		return nil
+	}
+
+	var legacy int
+	err = database.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'`).Scan(&legacy)
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		CREATE TABLE schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT DEFAULT (datetime('now'))
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	if legacy == 1 {
+		all, err := LoadMigrations()
+		if err != nil {
+			return err
+		}
+		for _, m := range all {
+			if m.Version > legacyBaselineVersion {
+				break
+			}
+			if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name); err != nil {
+				return err
+			}
+		}
+		log.Printf("migrate: existing database adopted at version %d", legacyBaselineVersion)
+	}
+	return tx.Commit()
+}
+
+// appliedMigrations trả về version -> applied_at
+func appliedMigrations(database *sql.DB) (map[int]string, error) {
+	rows, err := database.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]string{}
+	for rows.Next() {
+		var version int
+		var appliedAt sql.NullString
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt.String
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatuses liệt kê tất cả migration và trạng thái đã áp dụng
+func MigrationStatuses(database *sql.DB) ([]MigrationStatus, error) {
+	if err := ensureMigrationsTable(database); err != nil {
+		return nil, err
+	}
+	all, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(database)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(all))
+	for _, m := range all {
+		appliedAt, ok := applied[m.Version]
+		statuses = append(statuses, MigrationStatus{Migration: m, Applied: ok, AppliedAt: appliedAt})
+	}
+	return statuses, nil
+}
+
+// MigrateUp áp dụng tất cả migration còn thiếu, mỗi migration trong một transaction.
+// Seed migration chỉ được áp dụng khi includeSeed = true.
+func MigrateUp(database *sql.DB, includeSeed bool) (int, error) {
+	statuses, err := MigrationStatuses(database)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, s := range statuses {
+		if s.Applied || (s.Seed && !includeSeed) {
+			continue
+		}
+		if err := runMigration(database, s.Migration, true); err != nil {
+			return count, err
+		}
+		log.Printf("migrate: applied %s", s.Name)
+		count++
+	}
+	return count, nil
+}
+
+// MigrateDown rollback `steps` migration gần nhất theo thứ tự ngược
+func MigrateDown(database *sql.DB, steps int) (int, error) {
+	statuses, err := MigrationStatuses(database)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(statuses) - 1; i >= 0 && count < steps; i-- {
+		s := statuses[i]
+		if !s.Applied {
+			continue
+		}
+		if s.Down == "" {
+			return count, fmt.Errorf("migration %s has no down file", s.Name)
+		}
+		if err := runMigration(database, s.Migration, false); err != nil {
+			return count, err
+		}
+		log.Printf("migrate: rolled back %s", s.Name)
+		count++
+	}
+	return count, nil
+}
+
+func runMigration(database *sql.DB, m Migration, up bool) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		if _, err := tx.Exec(m.Up); err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.Name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.Exec(m.Down); err != nil {
+			return fmt.Errorf("rollback %s failed: %w", m.Name, err)
+		}
+		if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.Version); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -1,36 +1,35 @@
 package serverpkg
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// Global database instance
-var db *sql.DB
-
 // InitDB initializes the database connection
 func InitDB(dbPath string) (*sql.DB, error) {
-	var err error
-	db, err = sql.Open("sqlite3", dbPath)
-	if err != nil {
-		return nil, err
-	}
-	return db, nil
-}
-
-// GetDB returns the database instance
-func GetDB() *sql.DB {
-	return db
+	return sql.Open("sqlite3", dbPath)
 }
 
 // UploadNote - Tải lên ghi chú mới (đã mã hóa)
 // POST /api/notes
-// Request: { "title": "...", "content_enc": "base64...", "key_enc": "base64...", "iv_meta": "{...}" }
+// Request: { "title": "...", "content_enc": "base64...", "key_enc": "base64...", "iv_meta": "{...}", "search_tokens": ["..."] }
 // Response: { "id": "note_uuid" }
-func UploadNote(c *gin.Context) {
+func (s *Server) UploadNote(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -42,85 +41,359 @@ func UploadNote(c *gin.Context) {
 		ContentEnc string `json:"content_enc" binding:"required"`
 		KeyEnc     string `json:"key_enc" binding:"required"`
 		IVMeta     string `json:"iv_meta" binding:"required"`
+
+		SearchTokens []string `json:"search_tokens"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	tokens, err := normalizeSearchTokens(req.SearchTokens, MaxSearchTokens)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	db := GetDB()
+	if !s.checkQuota(c, userID.(string), base64DecodedSize(req.ContentEnc), false) {
+		return
+	}
 
-	// Lưu note vào database
-	query := `
-		INSERT INTO notes (user_id, title_enc, content_enc, key_enc, iv_meta)
-		VALUES (?, ?, ?, ?, ?)
-	`
-	result, err := db.Exec(query, userID, req.Title, req.ContentEnc, req.KeyEnc, req.IVMeta)
+	// Ciphertext vào blob store, database chỉ giữ reference
+	blob, err := s.putContentBase64(c.Request.Context(), req.ContentEnc)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save note"})
+		if errors.Is(err, errInvalidContent) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store content"})
+		}
 		return
 	}
 
-	noteID, _ := result.LastInsertId()
+	// Lưu note vào database
+	note := &Note{
+		ID:          uuid.New().String(),
+		UserID:      userID.(string),
+		TitleEnc:    req.Title,
+		ContentRef:  blob.Key,
+		ContentSize: blob.Size,
+		KeyEnc:      req.KeyEnc,
+		IVMeta:      req.IVMeta,
+
+		SearchTokens: tokens,
+	}
+	if err := s.Notes.CreateNote(c.Request.Context(), note); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save note"})
+		return
+	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"id": noteID,
+		"id": note.ID,
 	})
 }
 
-// ListNotes - Lấy danh sách ghi chú (chỉ metadata)
-// GET /api/notes
-// Response: [ { "id": "1", "title": "Encrypted...", "created_at": "..." }, ... ]
-func ListNotes(c *gin.Context) {
+// Kích thước trang của GET /api/notes
+const (
+	defaultNotePageSize = 50
+	maxNotePageSize     = 200
+)
+
+// noteListCursor là nội dung của cursor (base64url JSON); gắn với sort và chiều
+// sắp xếp đã tạo ra nó
+type noteListCursor struct {
+	Sort string `json:"s"`
+	Asc  bool   `json:"a,omitempty"`
+	Time string `json:"t,omitempty"`
+	Size int64  `json:"z,omitempty"`
+	ID   string `json:"i"`
+}
+
+func encodeNoteCursor(q NoteQuery, e NoteListEntry) string {
+	cur := noteListCursor{Sort: q.Sort, Asc: q.Ascending, ID: e.ID}
+	switch q.Sort {
+	case NoteSortSize:
+		cur.Size = e.ContentSize
+	case NoteSortUpdated:
+		cur.Time = e.UpdatedAt
+	default:
+		cur.Time = e.CreatedAt
+	}
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeNoteCursor(q NoteQuery, raw string) (*NoteCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var cur noteListCursor
+	if err := json.Unmarshal(b, &cur); err != nil || cur.ID == "" {
+		return nil, errors.New("invalid cursor")
+	}
+	if cur.Sort != q.Sort || cur.Asc != q.Ascending {
+		return nil, errors.New("cursor does not match sort order")
+	}
+	return &NoteCursor{Time: cur.Time, Size: cur.Size, ID: cur.ID}, nil
+}
+
+// parseDateParam nhận RFC3339 hoặc YYYY-MM-DD. Với endOfDay, ngày không kèm giờ
+// được tính hết ngày đó (giới hạn trên là 0h ngày hôm sau).
+func parseDateParam(raw string, endOfDay bool) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return sqliteTime(t), nil
+	}
+	t, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		return "", errors.New("invalid date, use YYYY-MM-DD or RFC3339")
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return sqliteTime(t), nil
+}
+
+// parseNoteQuery đọc query string của GET /api/notes
+func parseNoteQuery(c *gin.Context) (NoteQuery, error) {
+	q := NoteQuery{
+		Scope: c.DefaultQuery("scope", NoteScopeOwned),
+		Sort:  c.DefaultQuery("sort", NoteSortCreated),
+		Limit: defaultNotePageSize,
+	}
+	switch q.Scope {
+	case NoteScopeOwned, NoteScopeShared, NoteScopeAll:
+	default:
+		return q, errors.New("scope must be owned, shared or all")
+	}
+	switch q.Sort {
+	case NoteSortCreated, NoteSortUpdated, NoteSortSize:
+	default:
+		return q, errors.New("sort must be created, updated or size")
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		q.Ascending = true
+	case "desc":
+	default:
+		return q, errors.New("order must be asc or desc")
+	}
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxNotePageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxNotePageSize)
+		}
+		q.Limit = limit
+	}
+
+	var err error
+	if q.CreatedFrom, err = parseDateParam(c.Query("from"), false); err != nil {
+		return q, err
+	}
+	if q.CreatedTo, err = parseDateParam(c.Query("to"), true); err != nil {
+		return q, err
+	}
+	if q.Tokens, err = normalizeSearchTokens(c.QueryArray("token"), maxQueryTokens); err != nil {
+		return q, err
+	}
+	if raw := c.Query("cursor"); raw != "" {
+		if q.After, err = decodeNoteCursor(q, raw); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// ListNotes - Lấy danh sách ghi chú (chỉ metadata), phân trang theo cursor
+// GET /api/notes?limit=50&cursor=...&sort=created|updated|size&order=desc|asc
+// Filter: scope=owned|shared|all, from=YYYY-MM-DD, to=YYYY-MM-DD (theo created_at, to tính
+// hết ngày), token=... (blind index, xem search.go), folder=<id>|root[&recursive=true], tag=<id>
+// (xem folders.go)
+// Response: { "notes": [ { "id": "1", "title": "Encrypted...", "version": 1, "size": 123,
+// "created_at": "...", "updated_at": "...", "shared": false, "folder_id": "", "tags": [] } ],
+// "total": 1, "total_size": 123, "next_cursor": "..." } (next_cursor chỉ có khi còn trang sau;
+// note được share có "owner" thay cho "folder_id" và "tags")
+func (s *Server) ListNotes(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
-	db := GetDB()
+	q, err := parseNoteQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.applyFolderFilters(c, userID.(string), &q) {
+		return
+	}
 
 	// Truy vấn notes của user
-	query := `
-		SELECT id, title_enc, created_at
-		FROM notes
-		WHERE user_id = ?
-		ORDER BY created_at DESC
-	`
-	rows, err := db.Query(query, userID)
+	page, err := s.Notes.ListNotes(c.Request.Context(), userID.(string), q)
 	if err != nil {
+		log.Printf("notes: list failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query notes"})
 		return
 	}
-	defer rows.Close()
 
-	var notes []map[string]interface{}
-	for rows.Next() {
-		var id, titleEnc, createdAt string
+	notes := make([]gin.H, 0, len(page.Notes))
+	for _, n := range page.Notes {
+		entry := gin.H{
+			"id":         n.ID,
+			"title":      n.TitleEnc,
+			"version":    n.Version,
+			"size":       n.ContentSize,
+			"created_at": n.CreatedAt,
+			"updated_at": n.UpdatedAt,
+			"shared":     n.Shared,
+		}
+		if n.Shared {
+			entry["owner"] = n.OwnerUsername
+		} else {
+			entry["folder_id"] = n.FolderID
+			entry["tags"] = noteTagIDs(n.TagIDs)
+		}
+		notes = append(notes, entry)
+	}
+
+	resp := gin.H{
+		"notes":      notes,
+		"total":      page.Total,
+		"total_size": page.TotalSize,
+	}
+	if page.HasMore {
+		resp["next_cursor"] = encodeNoteCursor(q, page.Notes[len(page.Notes)-1])
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// noteTagIDs trả về [] thay vì null khi note không có tag
+func noteTagIDs(tagIDs []string) []string {
+	if tagIDs == nil {
+		return []string{}
+	}
+	return tagIDs
+}
+
+// noteETag là revision của note dùng với If-Match, khác ETag của /content (SHA256 ciphertext)
+func noteETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// requireIfMatch đọc version mong đợi từ header If-Match ("3", W/"3" hoặc 3).
+// "*" trả về 0 (không kiểm tra version). Trả về false (đã ghi response lỗi) nếu thiếu hoặc sai.
+func requireIfMatch(c *gin.Context) (int, bool) {
+	raw := strings.TrimSpace(c.GetHeader("If-Match"))
+	if raw == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header required"})
+		return 0, false
+	}
+	if raw == "*" {
+		return 0, true
+	}
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(raw, "W/"), `"`))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid If-Match header"})
+		return 0, false
+	}
+	return version, true
+}
+
+// writeVersionConflict trả 412 kèm revision hiện tại để client tải lại và quyết định
+func writeVersionConflict(c *gin.Context, current int) {
+	c.Header("ETag", noteETag(current))
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error":           "note has been modified",
+		"current_version": current,
+	})
+}
 
-		if err := rows.Scan(&id, &titleEnc, &createdAt); err != nil {
-			continue
+// handleNoteWriteError ghi response cho lỗi từ UpdateNote/DeleteNote
+func (s *Server) handleNoteWriteError(c *gin.Context, noteID string, err error, failed string) {
+	switch {
+	case errors.Is(err, ErrVersionMismatch):
+		// Đọc lại để báo revision mới nhất; note có thể vừa bị xóa
+		note, gerr := s.Notes.GetNote(c.Request.Context(), noteID)
+		if gerr != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+			return
+		}
+		writeVersionConflict(c, note.Version)
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failed})
+	}
+}
+
+// loadOwnedNote lấy note và kiểm tra user hiện tại là chủ sở hữu.
+// Trả về nil (đã ghi response lỗi) nếu không tìm thấy, không có quyền hoặc note đang nằm trong thùng rác.
+func (s *Server) loadOwnedNote(c *gin.Context, noteID string, userID string, forbidden string) *Note {
+	note, err := s.Notes.GetNote(c.Request.Context(), noteID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query note"})
 		}
+		return nil
+	}
 
-		notes = append(notes, map[string]interface{}{
-			"id":         id,
-			"title":      titleEnc,
-			"created_at": createdAt,
-		})
+	if note.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": forbidden})
+		return nil
+	}
+	if note.DeletedAt != "" {
+		writeNoteInTrash(c)
+		return nil
 	}
+	return note
+}
 
-	if notes == nil {
-		notes = []map[string]interface{}{}
+// writeNoteInTrash trả 409 cho thao tác của chủ sở hữu trên note trong thùng rác
+func writeNoteInTrash(c *gin.Context) {
+	c.JSON(http.StatusConflict, gin.H{"error": "note is in trash, restore it first"})
+}
+
+// loadReadableNote lấy note mà user hiện tại là chủ sở hữu hoặc người nhận share còn hiệu lực.
+// share là nil với chủ sở hữu. Trả về note nil (đã ghi response lỗi) nếu không có quyền.
+func (s *Server) loadReadableNote(c *gin.Context, noteID string, userID string) (*Note, *NoteShare) {
+	note, err := s.Notes.GetNote(c.Request.Context(), noteID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+		return nil, nil
+	}
+	if note.UserID == userID {
+		if note.DeletedAt != "" {
+			writeNoteInTrash(c)
+			return nil, nil
+		}
+		return note, nil
+	}
+	// Với người nhận share, note trong thùng rác coi như không tồn tại
+	if note.DeletedAt != "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+		return nil, nil
 	}
 
-	c.JSON(http.StatusOK, notes)
+	share, err := s.Notes.GetActiveNoteShare(c.Request.Context(), noteID, userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+		return nil, nil
+	}
+	return note, share
 }
 
 // GetNote - Tải chi tiết nội dung ghi chú
-// GET /api/notes/:id
-// Response: { "content_enc": "...", "key_enc": "...", "iv_meta": "..." }
-func GetNote(c *gin.Context) {
+// GET /api/notes/:id[?content=false]
+// Response (owner): { "title": "...", "content_enc": "...", "key_enc": "...", "iv_meta": "...", "version": 1, "folder_id": "...", "tags": [...] }
+// Response (recipient): { "title": "...", "content_enc": "...", "iv_meta": "...", "version": 1, "wrapped_key": "...", "sender_public_key": "...", "shared": true }
+// Header ETag: "<version>", gửi lại trong If-Match khi sửa/xóa.
+// content=false bỏ content_enc; tải nội dung qua GET /api/notes/:id/content
+func (s *Server) GetNote(c *gin.Context) {
 	noteID := c.Param("id")
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -128,38 +401,178 @@ func GetNote(c *gin.Context) {
 		return
 	}
 
-	db := GetDB()
-
 	// Lấy thông tin note và kiểm tra quyền truy cập
-	query := `
-		SELECT user_id, content_enc, key_enc, iv_meta
-		FROM notes
-		WHERE id = ?
-	`
-	var ownerID, contentEnc, keyEnc, ivMeta string
-
-	err := db.QueryRow(query, noteID).Scan(&ownerID, &contentEnc, &keyEnc, &ivMeta)
+	note, share := s.loadReadableNote(c, noteID, userID.(string))
+	if note == nil {
+		return
+	}
+
+	resp := gin.H{
+		"title":   note.TitleEnc,
+		"iv_meta": note.IVMeta,
+		"version": note.Version,
+	}
+	if c.Query("content") != "false" {
+		content, err := s.contentBase64(c.Request.Context(), note.ContentRef, note.ContentEnc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read note content"})
+			return
+		}
+		resp["content_enc"] = content
+	}
+
+	if share == nil {
+		// Chủ sở hữu nhận key_enc (bọc bởi K_Master)
+		resp["key_enc"] = note.KeyEnc
+		resp["folder_id"] = note.FolderID
+		resp["tags"] = noteTagIDs(note.TagIDs)
+	} else {
+		// Người nhận chia sẻ chỉ nhận wrapped key của riêng mình
+		resp["wrapped_key"] = share.WrappedKey
+		resp["sender_public_key"] = share.SenderPublicKey
+		resp["shared"] = true
+	}
+
+	c.Header("ETag", noteETag(note.Version))
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetNoteContent - Tải ciphertext thô, hỗ trợ Range để resume khi tải file lớn
+// GET /api/notes/:id/content
+// Headers: Range: bytes=START-[END], If-Range: <ETag>
+// Response: 200 hoặc 206 (application/octet-stream), ETag là SHA256 của ciphertext
+func (s *Server) GetNoteContent(c *gin.Context) {
+	noteID := c.Param("id")
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	note, _ := s.loadReadableNote(c, noteID, userID.(string))
+	if note == nil {
+		return
+	}
+
+	s.serveContent(c, note.ContentRef, note.ContentEnc, note.UpdatedAt)
+}
+
+// serveContent ghi ciphertext thô qua http.ServeContent (Range, If-Range)
+func (s *Server) serveContent(c *gin.Context, ref string, inline string, updatedAt string) {
+	content, _, err := s.openContent(c.Request.Context(), ref, inline)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read note content"})
 		return
 	}
+	defer content.Close()
+
+	// ETag mạnh để client dùng If-Range: nếu note đổi giữa chừng, server trả lại toàn bộ nội dung.
+	// Với blob, key chính là SHA256 của ciphertext; note cũ còn inline thì tính lại.
+	etag := ref
+	if etag == "" {
+		h := sha256.New()
+		if _, err := io.Copy(h, content); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read note content"})
+			return
+		}
+		etag = hex.EncodeToString(h.Sum(nil))
+	}
+	c.Header("ETag", `"`+etag+`"`)
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Cache-Control", "private, no-cache")
 
-	// Kiểm tra quyền sở hữu
-	if ownerID != userID.(string) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	modTime, _ := time.Parse(sqliteTimeFormat, updatedAt)
+	http.ServeContent(c.Writer, c.Request, "", modTime, content)
+}
+
+// UpdateNote - Ghi phiên bản mới cho ghi chú, phiên bản cũ được giữ trong note_versions
+// PUT /api/notes/:id
+// Headers: If-Match: "<version>" (bắt buộc; "*" bỏ qua kiểm tra)
+// Request: { "title": "...", "content_enc": "base64...", "key_enc": "base64...", "iv_meta": "{...}", "search_tokens": ["..."] }
+// Không gửi search_tokens thì giữ token hiện tại; [] xóa hết.
+// Response: { "id": "...", "version": 2, "updated_at": "..." }
+// 412: { "error": "note has been modified", "current_version": 3 } nếu If-Match đã cũ
+func (s *Server) UpdateNote(c *gin.Context) {
+	noteID := c.Param("id")
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		Title      string `json:"title" binding:"required"`
+		ContentEnc string `json:"content_enc" binding:"required"`
+		KeyEnc     string `json:"key_enc" binding:"required"`
+		IVMeta     string `json:"iv_meta" binding:"required"`
+
+		SearchTokens []string `json:"search_tokens"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tokens, err := normalizeSearchTokens(req.SearchTokens, MaxSearchTokens)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	expected, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	// Chỉ chủ sở hữu được sửa; người nhận share chỉ có quyền đọc
+	note := s.loadOwnedNote(c, noteID, userID.(string), "only owner can edit")
+	if note == nil {
+		return
+	}
+	// Kiểm tra sớm để không ghi blob vô ích; UpdateNote kiểm tra lại trong transaction
+	if expected != 0 && expected != note.Version {
+		writeVersionConflict(c, note.Version)
+		return
+	}
+	// Nội dung hiện tại chuyển vào lịch sử nên vẫn được tính; nội dung mới tính thêm
+	if !s.checkQuota(c, note.UserID, base64DecodedSize(req.ContentEnc), false) {
+		return
+	}
+
+	blob, err := s.putContentBase64(c.Request.Context(), req.ContentEnc)
+	if err != nil {
+		if errors.Is(err, errInvalidContent) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store content"})
+		}
+		return
+	}
+
+	note.TitleEnc = req.Title
+	note.ContentEnc = ""
+	note.ContentRef = blob.Key
+	note.ContentSize = blob.Size
+	note.KeyEnc = req.KeyEnc
+	note.IVMeta = req.IVMeta
+	note.SearchTokens = tokens
+	if err := s.Notes.UpdateNote(c.Request.Context(), note, expected); err != nil {
+		s.handleNoteWriteError(c, noteID, err, "failed to update note")
 		return
 	}
 
+	c.Header("ETag", noteETag(note.Version))
 	c.JSON(http.StatusOK, gin.H{
-		"content_enc": contentEnc,
-		"key_enc":     keyEnc,
-		"iv_meta":     ivMeta,
+		"id":         note.ID,
+		"version":    note.Version,
+		"updated_at": note.UpdatedAt,
 	})
 }
 
-// DeleteNote - Xóa ghi chú vĩnh viễn
+// DeleteNote - Chuyển ghi chú vào thùng rác (xóa vĩnh viễn qua DELETE /api/trash/:id)
 // DELETE /api/notes/:id
-func DeleteNote(c *gin.Context) {
+// Headers: If-Match: "<version>" (bắt buộc; "*" bỏ qua kiểm tra), 412 nếu đã cũ
+// Response: { "message": "note moved to trash", "deleted_at": "...", "purge_at": "..." }
+// Note trong thùng rác không còn hiện với người nhận share và share link tạo từ nó ngừng hoạt động.
+func (s *Server) DeleteNote(c *gin.Context) {
 	noteID := c.Param("id")
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -167,29 +580,25 @@ func DeleteNote(c *gin.Context) {
 		return
 	}
 
-	db := GetDB()
-
-	// Kiểm tra quyền sở hữu
-	var ownerID string
-	err := db.QueryRow("SELECT user_id FROM notes WHERE id = ?", noteID).Scan(&ownerID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+	expected, ok := requireIfMatch(c)
+	if !ok {
 		return
 	}
 
-	if ownerID != userID.(string) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "only owner can delete"})
+	// Kiểm tra quyền sở hữu
+	if s.loadOwnedNote(c, noteID, userID.(string), "only owner can delete") == nil {
 		return
 	}
 
-	// Xóa note khỏi database
-	_, err = db.Exec("DELETE FROM notes WHERE id = ?", noteID)
+	deletedAt, err := s.Notes.TrashNote(c.Request.Context(), noteID, expected)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete note"})
+		s.handleNoteWriteError(c, noteID, err, "failed to delete note")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "note deleted successfully",
+		"message":    "note moved to trash",
+		"deleted_at": deletedAt,
+		"purge_at":   s.trashPurgeAt(deletedAt),
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,87 @@
+package serverpkg
+
+import (
+	"net/http"
+	"testing"
+)
+
+func updateBody(title string) map[string]any {
+	return map[string]any{
+		"title":       title,
+		"content_enc": testContent,
+		"key_enc":     "a2V5",
+		"iv_meta":     "{}",
+	}
+}
+
+func TestUpdateNoteIfMatch(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	id := ts.createNote(t, token)
+
+	w := ts.do(http.MethodGet, "/api/notes/"+id, token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if etag := w.Header().Get("ETag"); etag != `"1"` {
+		t.Fatalf("ETag = %q, want %q", etag, `"1"`)
+	}
+
+	// Thiếu If-Match
+	expectStatus(t, ts.do(http.MethodPut, "/api/notes/"+id, token, updateBody("v2")), http.StatusPreconditionRequired)
+
+	w = ts.do(http.MethodPut, "/api/notes/"+id, token, updateBody("v2"), "If-Match", `"1"`)
+	expectStatus(t, w, http.StatusOK)
+	if v := decode(t, w)["version"]; v != float64(2) {
+		t.Fatalf("version = %v, want 2", v)
+	}
+
+	// If-Match cũ => 412 kèm version hiện tại
+	w = ts.do(http.MethodPut, "/api/notes/"+id, token, updateBody("v3"), "If-Match", `"1"`)
+	expectStatus(t, w, http.StatusPreconditionFailed)
+	if v := decode(t, w)["current_version"]; v != float64(2) {
+		t.Fatalf("current_version = %v, want 2", v)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"2"` {
+		t.Fatalf("ETag = %q, want %q", etag, `"2"`)
+	}
+
+	// "*" bỏ qua kiểm tra version
+	expectStatus(t, ts.do(http.MethodPut, "/api/notes/"+id, token, updateBody("v3"), "If-Match", "*"), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodPut, "/api/notes/"+id, token, updateBody("v4"), "If-Match", "abc"), http.StatusBadRequest)
+}
+
+func TestUpdateNoteOnlyOwner(t *testing.T) {
+	ts := newTestServer(t)
+	_, alice, _ := ts.newUser(t, "alice", testLoginKey)
+	_, bob, _ := ts.newUser(t, "bob", testLoginKey)
+	id := ts.createNote(t, alice)
+
+	w := ts.do(http.MethodPut, "/api/notes/"+id, bob, updateBody("x"), "If-Match", `"1"`)
+	if w.Code != http.StatusForbidden && w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 403 or 404", w.Code)
+	}
+}
+
+func TestDeleteNoteIfMatch(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	id := ts.createNote(t, token)
+	expectStatus(t, ts.do(http.MethodPut, "/api/notes/"+id, token, updateBody("v2"), "If-Match", `"1"`), http.StatusOK)
+
+	expectStatus(t, ts.do(http.MethodDelete, "/api/notes/"+id, token, nil), http.StatusPreconditionRequired)
+
+	w := ts.do(http.MethodDelete, "/api/notes/"+id, token, nil, "If-Match", `"1"`)
+	expectStatus(t, w, http.StatusPreconditionFailed)
+	if v := decode(t, w)["current_version"]; v != float64(2) {
+		t.Fatalf("current_version = %v, want 2", v)
+	}
+	// Note vẫn còn
+	expectStatus(t, ts.do(http.MethodGet, "/api/notes/"+id, token, nil), http.StatusOK)
+
+	w = ts.do(http.MethodDelete, "/api/notes/"+id, token, nil, "If-Match", `"2"`)
+	expectStatus(t, w, http.StatusOK)
+	if decode(t, w)["purge_at"] == "" {
+		t.Fatal("missing purge_at")
+	}
+	// Note trong thùng rác phải khôi phục trước khi đọc
+	expectStatus(t, ts.do(http.MethodGet, "/api/notes/"+id, token, nil), http.StatusConflict)
+}
@@ -0,0 +1,272 @@
+package serverpkg
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ============================================================
+// ĐỔI MẬT KHẨU - Bọc lại key bằng K_Master mới
+// ============================================================
+//
+// K_Master = HKDF(Argon2id(mật khẩu, kdf_salt)) chỉ tồn tại ở client nên đổi mật
+// khẩu nghĩa là đổi K_Master. Client lấy keyring (GET /api/me/keyring), giải mã
+// bằng K_Master cũ, mã hóa lại bằng K_Master mới (kdf_salt mới do client sinh) rồi
+// gửi cả keyring cùng login key mới; server thay tất cả trong một transaction. K_Note
+// không đổi nên ciphertext của note, share cho user khác và share link vẫn dùng được.
+// Tài khoản cũ chuyển sang login key (loginkey.go) cũng bằng request này.
+
+// minKdfSaltLen: kdf_salt do client sinh phải dài ít nhất như GenerateSalt
+const minKdfSaltLen = 16
+
+// KeyringKey là K_Note bọc bởi K_Master của một note hoặc upload session
+type KeyringKey struct {
+	ID     string `json:"id"`
+	KeyEnc string `json:"key_enc"`
+	IVMeta string `json:"iv_meta,omitempty"` // Bỏ trống khi gửi lên = giữ iv_meta cũ
+}
+
+// KeyringVersionKey là K_Note bọc bởi K_Master của một phiên bản cũ
+type KeyringVersionKey struct {
+	NoteID  string `json:"note_id"`
+	Version int    `json:"version"`
+	KeyEnc  string `json:"key_enc"`
+	IVMeta  string `json:"iv_meta,omitempty"`
+}
+
+// KeyringFolder là tên folder mã hóa bằng K_Master
+type KeyringFolder struct {
+	ID      string `json:"id"`
+	NameEnc string `json:"name_enc"`
+}
+
+// KeyringTag là nhãn tag mã hóa bằng K_Master
+type KeyringTag struct {
+	ID       string `json:"id"`
+	LabelEnc string `json:"label_enc"`
+}
+
+// KeyringRecovery là recovery key trong keyring (recovery.go): K_Master bọc bởi
+// wrap key và wrap key bọc bởi K_Master
+type KeyringRecovery struct {
+	MasterKeyEnc string `json:"master_key_enc"`
+	KeyEnc       string `json:"key_enc"`
+}
+
+// KeyringPayload là dạng JSON của Keyring
+type KeyringPayload struct {
+	Notes        []KeyringKey        `json:"notes"`
+	Versions     []KeyringVersionKey `json:"versions"`
+	Uploads      []KeyringKey        `json:"uploads"`
+	Folders      []KeyringFolder     `json:"folders"`
+	Tags         []KeyringTag        `json:"tags"`
+	SearchKeyEnc string              `json:"search_key_enc,omitempty"`
+	Recovery     *KeyringRecovery    `json:"recovery,omitempty"` // nil = user không có recovery key
+}
+
+// KeyringResponse là response của GET /api/me/keyring
+type KeyringResponse struct {
+	KdfSalt string `json:"kdf_salt"`
+	KeyringPayload
+}
+
+// ChangePasswordRequest là body của POST /api/me/password
+type ChangePasswordRequest struct {
+	OldLoginKey string         `json:"old_login_key"`
+	OldPassword string         `json:"old_password"` // Thay cho old_login_key khi tài khoản chưa có login key
+	NewLoginKey string         `json:"new_login_key" binding:"required"`
+	KdfSalt     string         `json:"kdf_salt" binding:"required"` // Base64, salt mới của K_Master và login key
+	Keyring     KeyringPayload `json:"keyring"`
+}
+
+func newKeyringPayload(k *Keyring) KeyringPayload {
+	p := KeyringPayload{
+		Notes:        make([]KeyringKey, 0, len(k.Notes)),
+		Versions:     make([]KeyringVersionKey, 0, len(k.Versions)),
+		Uploads:      make([]KeyringKey, 0, len(k.Uploads)),
+		Folders:      make([]KeyringFolder, 0, len(k.Folders)),
+		Tags:         make([]KeyringTag, 0, len(k.Tags)),
+		SearchKeyEnc: k.SearchKeyEnc,
+	}
+	for _, n := range k.Notes {
+		p.Notes = append(p.Notes, KeyringKey{ID: n.ID, KeyEnc: n.Enc, IVMeta: n.IVMeta})
+	}
+	for _, v := range k.Versions {
+		p.Versions = append(p.Versions, KeyringVersionKey{NoteID: v.ID, Version: v.Version, KeyEnc: v.Enc, IVMeta: v.IVMeta})
+	}
+	for _, u := range k.Uploads {
+		p.Uploads = append(p.Uploads, KeyringKey{ID: u.ID, KeyEnc: u.Enc, IVMeta: u.IVMeta})
+	}
+	for _, f := range k.Folders {
+		p.Folders = append(p.Folders, KeyringFolder{ID: f.ID, NameEnc: f.Enc})
+	}
+	for _, t := range k.Tags {
+		p.Tags = append(p.Tags, KeyringTag{ID: t.ID, LabelEnc: t.Enc})
+	}
+	if k.RecoveryKeyEnc != "" {
+		p.Recovery = &KeyringRecovery{MasterKeyEnc: k.RecoveryMasterKeyEnc, KeyEnc: k.RecoveryKeyEnc}
+	}
+	return p
+}
+
+// keyring chuyển payload sang Keyring; false nếu có phần tử thiếu ID hoặc giá trị
+func (p KeyringPayload) keyring() (*Keyring, bool) {
+	k := &Keyring{SearchKeyEnc: p.SearchKeyEnc}
+	ok := true
+	add := func(dst *[]WrappedKey, e WrappedKey) {
+		if e.ID == "" || e.Enc == "" {
+			ok = false
+		}
+		*dst = append(*dst, e)
+	}
+	for _, n := range p.Notes {
+		add(&k.Notes, WrappedKey{ID: n.ID, Enc: n.KeyEnc, IVMeta: n.IVMeta})
+	}
+	for _, v := range p.Versions {
+		add(&k.Versions, WrappedKey{ID: v.NoteID, Version: v.Version, Enc: v.KeyEnc, IVMeta: v.IVMeta})
+	}
+	for _, u := range p.Uploads {
+		add(&k.Uploads, WrappedKey{ID: u.ID, Enc: u.KeyEnc, IVMeta: u.IVMeta})
+	}
+	for _, f := range p.Folders {
+		add(&k.Folders, WrappedKey{ID: f.ID, Enc: f.NameEnc})
+	}
+	for _, t := range p.Tags {
+		add(&k.Tags, WrappedKey{ID: t.ID, Enc: t.LabelEnc})
+	}
+	if r := p.Recovery; r != nil {
+		if r.MasterKeyEnc == "" || r.KeyEnc == "" {
+			ok = false
+		}
+		k.RecoveryMasterKeyEnc, k.RecoveryKeyEnc = r.MasterKeyEnc, r.KeyEnc
+	}
+	return k, ok
+}
+
+// parseNewCredentials kiểm tra login key mới, kdf_salt và keyring của request đổi
+// hoặc đặt lại mật khẩu. Trả về false (đã ghi response lỗi) nếu không hợp lệ.
+func parseNewCredentials(c *gin.Context, newLoginKey, kdfSalt string, p KeyringPayload) ([]byte, *Keyring, bool) {
+	salt, ok := parseLoginKey(c, newLoginKey, kdfSalt)
+	if !ok {
+		return nil, nil, false
+	}
+	keyring, ok := p.keyring()
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "keyring entries need an id and a value"})
+		return nil, nil, false
+	}
+	return salt, keyring, true
+}
+
+// replaceKeyring ghi login key mới cùng keyring đã bọc lại.
+// Trả về false (đã ghi response lỗi, 409 nếu keyring đã cũ) nếu không thành công.
+func (s *Server) replaceKeyring(c *gin.Context, userID, newLoginKey string, salt []byte, k *Keyring) bool {
+	passwordHash, err := HashPassword(newLoginKey, salt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return false
+	}
+	if err := s.Keyrings.ChangePassword(c.Request.Context(), userID, passwordHash, EncodeSalt(salt), k); err != nil {
+		if errors.Is(err, ErrConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": "notes changed during the password change, fetch the keyring and retry"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change password"})
+		}
+		return false
+	}
+	return true
+}
+
+// GetKeyring - Mọi dữ liệu của user được mã hóa bằng K_Master
+// GET /api/me/keyring
+// Response: { "kdf_salt": "...", "notes": [{ "id", "key_enc", "iv_meta" }], "versions": [{ "note_id", "version", "key_enc", "iv_meta" }], "uploads": [{ "id", "key_enc", "iv_meta" }], "folders": [{ "id", "name_enc" }], "tags": [{ "id", "label_enc" }], "search_key_enc": "...", "recovery": { "master_key_enc", "key_enc" } }
+// notes gồm cả note trong thùng rác; search_key_enc bị bỏ khi K_Search vẫn suy ra từ K_Master,
+// recovery bị bỏ khi user không có recovery key.
+func (s *Server) GetKeyring(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if s.Keyrings == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "password change is not available"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := s.Users.GetUserByID(ctx, userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query user"})
+		return
+	}
+	k, err := s.Keyrings.GetKeyring(ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query keyring"})
+		return
+	}
+	c.JSON(http.StatusOK, KeyringResponse{KdfSalt: user.KdfSalt, KeyringPayload: newKeyringPayload(k)})
+}
+
+// ChangePassword - Đổi mật khẩu và thay keyring đã bọc bằng K_Master mới
+// POST /api/me/password
+// Request: { "old_login_key": "base64", "new_login_key": "base64", "kdf_salt": "base64", "keyring": { như GET /api/me/keyring, không có kdf_salt } }
+// Tài khoản chưa có login key gửi "old_password" thay cho "old_login_key" (chuyển sang login key).
+// Response: LoginResponse (token mới; mọi refresh token cũ bị thu hồi)
+// 409 khi keyring không gồm đúng các note, phiên bản, upload, folder, tag và recovery key
+// hiện có: client lấy lại keyring rồi gửi lại.
+func (s *Server) ChangePassword(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if s.Keyrings == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "password change is not available"})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.NewLoginKey == req.OldLoginKey {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "new login key must differ from the old one"})
+		return
+	}
+	salt, keyring, ok := parseNewCredentials(c, req.NewLoginKey, req.KdfSalt, req.Keyring)
+	if !ok {
+		return
+	}
+
+	user := s.checkAccountCredentials(c, userID.(string), req.OldPassword, req.OldLoginKey)
+	if user == nil {
+		return
+	}
+	if !s.replaceKeyring(c, user.ID, req.NewLoginKey, salt, keyring) {
+		return
+	}
+	ctx := c.Request.Context()
+
+	// Access token đang dùng bị thu hồi, phiên này nhận token mới
+	if claims, ok := c.MustGet("jwt_claims").(jwt.MapClaims); ok {
+		jti, _ := claims["jti"].(string)
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil && jti != "" {
+			_ = s.BlacklistToken(ctx, jti, exp.Time)
+		}
+	}
+	accessToken, refreshToken, err := s.GenerateJWT(ctx, user.ID, user.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "password changed, but failed to generate token"})
+		return
+	}
+	c.JSON(http.StatusOK, LoginResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		KdfSalt:      EncodeSalt(salt),
+		SearchKeyEnc: keyring.SearchKeyEnc,
+	})
+}
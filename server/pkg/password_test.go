@@ -0,0 +1,145 @@
+package serverpkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+const (
+	// newTestLoginKey là login key sau khi đổi mật khẩu (khác testLoginKey)
+	newTestLoginKey = "YWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXoxMjM0NTY="
+	// newTestKdfSalt là kdf_salt mới (16 byte) client sinh khi đổi mật khẩu
+	newTestKdfSalt = "bmV3LWtkZi1zYWx0LTAxMjM="
+)
+
+// keyring đọc GET /api/me/keyring
+func (ts *testServer) keyring(t *testing.T, token string) KeyringResponse {
+	t.Helper()
+	w := ts.do(http.MethodGet, "/api/me/keyring", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	var k KeyringResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &k); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// rewrap giả lập client bọc lại mọi phần tử của keyring bằng K_Master mới
+func rewrap(p KeyringPayload) KeyringPayload {
+	re := func(s string) string { return "new-" + s }
+	out := KeyringPayload{SearchKeyEnc: re(p.SearchKeyEnc)}
+	for _, n := range p.Notes {
+		out.Notes = append(out.Notes, KeyringKey{ID: n.ID, KeyEnc: re(n.KeyEnc)})
+	}
+	for _, v := range p.Versions {
+		out.Versions = append(out.Versions, KeyringVersionKey{NoteID: v.NoteID, Version: v.Version, KeyEnc: re(v.KeyEnc)})
+	}
+	for _, u := range p.Uploads {
+		out.Uploads = append(out.Uploads, KeyringKey{ID: u.ID, KeyEnc: re(u.KeyEnc)})
+	}
+	for _, f := range p.Folders {
+		out.Folders = append(out.Folders, KeyringFolder{ID: f.ID, NameEnc: re(f.NameEnc)})
+	}
+	for _, tag := range p.Tags {
+		out.Tags = append(out.Tags, KeyringTag{ID: tag.ID, LabelEnc: re(tag.LabelEnc)})
+	}
+	if r := p.Recovery; r != nil {
+		out.Recovery = &KeyringRecovery{MasterKeyEnc: re(r.MasterKeyEnc), KeyEnc: re(r.KeyEnc)}
+	}
+	return out
+}
+
+func TestChangePasswordRewrapsKeyring(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, refresh := ts.newUser(t, "alice", testLoginKey)
+	noteID := ts.createNote(t, token)
+	w := ts.do(http.MethodPut, "/api/notes/"+noteID, token, map[string]any{
+		"title":       "title",
+		"content_enc": testContent,
+		"key_enc":     "a2V5Mg==",
+		"iv_meta":     "{}",
+	}, "If-Match", `"1"`)
+	expectStatus(t, w, http.StatusOK)
+	ts.createFolder(t, token, "Zm9sZGVy", "")
+	ts.createTag(t, token, "dGFn")
+	ts.createUpload(t, token, 10, 4)
+
+	before := ts.keyring(t, token)
+	if len(before.Notes) != 1 || len(before.Versions) != 1 || len(before.Uploads) != 1 ||
+		len(before.Folders) != 1 || len(before.Tags) != 1 {
+		t.Fatalf("keyring = %+v", before)
+	}
+
+	w = ts.do(http.MethodPost, "/api/me/password", token, ChangePasswordRequest{
+		OldLoginKey: testLoginKey,
+		NewLoginKey: newTestLoginKey,
+		KdfSalt:     newTestKdfSalt,
+		Keyring:     rewrap(before.KeyringPayload),
+	})
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	newToken, _ := body["access_token"].(string)
+	if newToken == "" || body["kdf_salt"] != newTestKdfSalt {
+		t.Fatalf("response = %v", body)
+	}
+
+	// Phiên cũ bị đăng xuất, mật khẩu cũ không còn dùng được
+	expectStatus(t, ts.do(http.MethodGet, "/api/me/keyring", token, nil), http.StatusUnauthorized)
+	expectStatus(t, ts.do(http.MethodPost, "/api/refresh", "", map[string]string{"refresh_token": refresh}), http.StatusUnauthorized)
+	expectStatus(t, ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "alice", LoginKey: testLoginKey}), http.StatusUnauthorized)
+	expectStatus(t, ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "alice", LoginKey: newTestLoginKey}), http.StatusOK)
+
+	after := ts.keyring(t, newToken)
+	if after.KdfSalt != newTestKdfSalt {
+		t.Fatalf("kdf_salt = %q", after.KdfSalt)
+	}
+	want := rewrap(before.KeyringPayload)
+	if after.Notes[0].KeyEnc != want.Notes[0].KeyEnc || after.Versions[0].KeyEnc != want.Versions[0].KeyEnc ||
+		after.Uploads[0].KeyEnc != want.Uploads[0].KeyEnc || after.Folders[0].NameEnc != want.Folders[0].NameEnc ||
+		after.Tags[0].LabelEnc != want.Tags[0].LabelEnc || after.SearchKeyEnc != want.SearchKeyEnc {
+		t.Fatalf("keyring after change = %+v, want %+v", after.KeyringPayload, want)
+	}
+	// iv_meta bỏ trống = giữ nguyên
+	if after.Notes[0].IVMeta != before.Notes[0].IVMeta {
+		t.Fatalf("iv_meta = %q, want %q", after.Notes[0].IVMeta, before.Notes[0].IVMeta)
+	}
+}
+
+func TestChangePasswordStaleKeyring(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	ts.createNote(t, token)
+	stale := ts.keyring(t, token)
+
+	// Note tạo trong lúc client đang bọc lại keyring (phiên khác)
+	ts.createNote(t, token)
+
+	w := ts.do(http.MethodPost, "/api/me/password", token, ChangePasswordRequest{
+		OldLoginKey: testLoginKey,
+		NewLoginKey: newTestLoginKey,
+		KdfSalt:     newTestKdfSalt,
+		Keyring:     rewrap(stale.KeyringPayload),
+	})
+	expectStatus(t, w, http.StatusConflict)
+
+	// Không có gì bị thay: mật khẩu cũ và token cũ vẫn dùng được
+	expectStatus(t, ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "alice", LoginKey: testLoginKey}), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "alice", LoginKey: newTestLoginKey}), http.StatusUnauthorized)
+	if k := ts.keyring(t, token); len(k.Notes) != 2 || k.KdfSalt != stale.KdfSalt {
+		t.Fatalf("keyring after conflict = %+v", k)
+	}
+}
+
+func TestChangePasswordWrongOldKey(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	w := ts.do(http.MethodPost, "/api/me/password", token, ChangePasswordRequest{
+		OldLoginKey: "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=",
+		NewLoginKey: newTestLoginKey,
+		KdfSalt:     newTestKdfSalt,
+		Keyring:     rewrap(ts.keyring(t, token).KeyringPayload),
+	})
+	expectStatus(t, w, http.StatusForbidden)
+	expectStatus(t, ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "alice", LoginKey: testLoginKey}), http.StatusOK)
+}
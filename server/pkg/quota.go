@@ -0,0 +1,122 @@
+package serverpkg
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================
+// STORAGE QUOTA - Giới hạn dung lượng ciphertext của mỗi user
+// ============================================================
+//
+// Dung lượng đã dùng = note (kể cả trong thùng rác) + phiên bản cũ + share link còn
+// hiệu lực. Upload session chưa finalize giữ chỗ theo total_size đã khai báo để
+// nhiều session song song không vượt quota. Quota của user là users.quota_bytes,
+// hoặc DefaultQuota nếu NULL; 0 là không giới hạn.
+
+// quotaLimit trả về quota áp dụng cho usage (0 = không giới hạn)
+func (s *Server) quotaLimit(usage *Usage) int64 {
+	if usage.QuotaBytes != nil {
+		return *usage.QuotaBytes
+	}
+	return s.DefaultQuota
+}
+
+// base64DecodedSize là số byte sau khi giải mã content_enc, dùng để kiểm tra
+// quota trước khi ghi blob (content không hợp lệ bị putContentBase64 từ chối sau)
+func base64DecodedSize(contentEnc string) int64 {
+	n := int64(len(contentEnc)) / 4 * 3
+	if strings.HasSuffix(contentEnc, "==") {
+		n -= 2
+	} else if strings.HasSuffix(contentEnc, "=") {
+		n--
+	}
+	return n
+}
+
+// checkQuota kiểm tra user còn đủ quota để ghi thêm add byte.
+// includeUploads tính cả dung lượng các upload session đang giữ chỗ.
+// Trả về false (đã ghi response lỗi) nếu vượt quota:
+// 413 khi riêng add đã lớn hơn quota, 507 khi tổng dung lượng vượt quota.
+func (s *Server) checkQuota(c *gin.Context, userID string, add int64, includeUploads bool) bool {
+	if s.Quotas == nil {
+		return true
+	}
+	usage, err := s.Quotas.GetUsage(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query storage usage"})
+		return false
+	}
+	limit := s.quotaLimit(usage)
+	if limit == 0 {
+		return true
+	}
+	if add > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "content exceeds storage quota", "quota_bytes": limit})
+		return false
+	}
+	used := usage.Bytes()
+	if includeUploads {
+		used += usage.UploadBytes
+	}
+	if used+add > limit {
+		c.JSON(http.StatusInsufficientStorage, gin.H{
+			"error":          "storage quota exceeded",
+			"quota_bytes":    limit,
+			"used_bytes":     used,
+			"required_bytes": add,
+		})
+		return false
+	}
+	return true
+}
+
+// GetUsage - Dung lượng đã dùng của user hiện tại
+// GET /api/me/usage
+// Response: { "used_bytes": 123, "quota_bytes": 10737418240, "remaining_bytes": 10737418117, "breakdown": { "notes": 100, "versions": 20, "share_links": 3, "pending_uploads": 0 }, "note_count": 2, "trashed_note_count": 1, "active_links": 1 }
+// quota_bytes và remaining_bytes là null khi không giới hạn.
+func (s *Server) GetUsage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if s.Quotas == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "storage usage is not available"})
+		return
+	}
+
+	usage, err := s.Quotas.GetUsage(c.Request.Context(), userID.(string))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query storage usage"})
+		}
+		return
+	}
+
+	used := usage.Bytes()
+	var quota, remaining *int64
+	if limit := s.quotaLimit(usage); limit > 0 {
+		left := max(limit-used-usage.UploadBytes, 0)
+		quota, remaining = &limit, &left
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"used_bytes":      used,
+		"quota_bytes":     quota,
+		"remaining_bytes": remaining,
+		"breakdown": gin.H{
+			"notes":           usage.NoteBytes,
+			"versions":        usage.VersionBytes,
+			"share_links":     usage.LinkBytes,
+			"pending_uploads": usage.UploadBytes,
+		},
+		"note_count":         usage.NoteCount,
+		"trashed_note_count": usage.TrashedCount,
+		"active_links":       usage.ActiveLinks,
+	})
+}
@@ -0,0 +1,87 @@
+package serverpkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// usage đọc GET /api/me/usage
+func (ts *testServer) usage(t *testing.T, token string) map[string]any {
+	t.Helper()
+	w := ts.do(http.MethodGet, "/api/me/usage", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	return decode(t, w)
+}
+
+func TestQuotaUsage(t *testing.T) {
+	ts := newTestServer(t)
+	ts.DefaultQuota = 20
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	ts.createNote(t, token)
+	ts.createUpload(t, token, 4, 4)
+
+	// remaining_bytes trừ cả chỗ upload đang dở giữ
+	u := ts.usage(t, token)
+	if u["used_bytes"] != float64(len(testContentRaw)) || u["quota_bytes"] != float64(20) || u["remaining_bytes"] != float64(20-len(testContentRaw)-4) {
+		t.Fatalf("usage = %v", u)
+	}
+	breakdown := u["breakdown"].(map[string]any)
+	if breakdown["notes"] != float64(len(testContentRaw)) || breakdown["pending_uploads"] != float64(4) {
+		t.Fatalf("breakdown = %v", breakdown)
+	}
+}
+
+func TestQuotaRejectsUploads(t *testing.T) {
+	ts := newTestServer(t)
+	ts.DefaultQuota = 20
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	ts.createNote(t, token) // 10 byte
+
+	newUpload := func(size int) int {
+		return ts.do(http.MethodPost, "/api/uploads", token, map[string]any{
+			"title": "title", "key_enc": "a2V5", "iv_meta": "{}", "total_size": size,
+		}).Code
+	}
+	// Riêng file đã lớn hơn quota: 413; tổng vượt quota: 507
+	if code := newUpload(25); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("upload larger than quota: %d", code)
+	}
+	if code := newUpload(15); code != http.StatusInsufficientStorage {
+		t.Fatalf("upload over remaining quota: %d", code)
+	}
+	// Session đang dở giữ chỗ total_size
+	if code := newUpload(8); code != http.StatusCreated {
+		t.Fatalf("upload within quota: %d", code)
+	}
+	if code := newUpload(5); code != http.StatusInsufficientStorage {
+		t.Fatalf("upload over reserved quota: %d", code)
+	}
+	// Note tải lên trực tiếp không tính chỗ upload giữ: note thứ hai vừa đủ quota,
+	// note thứ ba vượt quota
+	ts.createNote(t, token)
+	w := ts.do(http.MethodPost, "/api/notes", token, map[string]any{
+		"title": "title", "content_enc": testContent, "key_enc": "a2V5", "iv_meta": "{}",
+	})
+	expectStatus(t, w, http.StatusInsufficientStorage)
+}
+
+func TestQuotaPerUserOverride(t *testing.T) {
+	ts := newTestServer(t)
+	ts.DefaultQuota = 5
+	userID, token, _ := ts.newUser(t, "alice", testLoginKey)
+	w := ts.do(http.MethodPost, "/api/notes", token, map[string]any{
+		"title": "title", "content_enc": testContent, "key_enc": "a2V5", "iv_meta": "{}",
+	})
+	expectStatus(t, w, http.StatusRequestEntityTooLarge)
+
+	// Quota riêng 0 = không giới hạn
+	unlimited := int64(0)
+	if err := ts.Quotas.SetUserQuota(context.Background(), userID, &unlimited); err != nil {
+		t.Fatal(err)
+	}
+	ts.createNote(t, token)
+	if u := ts.usage(t, token); u["quota_bytes"] != nil || u["remaining_bytes"] != nil {
+		t.Fatalf("usage = %v, want no limit", u)
+	}
+}
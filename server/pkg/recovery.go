@@ -0,0 +1,378 @@
+package serverpkg
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// ============================================================
+// RECOVERY KEY - Đặt lại mật khẩu khi quên mà không mất note
+// ============================================================
+//
+// Client sinh recovery key ngẫu nhiên (hiện cho user một lần dưới dạng dãy từ) và
+// suy ra hai key bằng HKDF với label khác nhau: wrap key bọc K_Master, auth key
+// chứng minh đang giữ recovery key. Server chỉ lưu SHA256(auth key), K_Master bọc
+// bởi wrap key và wrap key bọc bởi K_Master (để client bọc lại khi đổi mật khẩu),
+// nên không mở được K_Master.
+//
+// 1. PUT  /api/me/recovery         (login key) tạo hoặc thay recovery key
+// 2. POST /api/recovery            username + auth key -> recovery_token, K_Master bọc bởi wrap key, keyring
+// 3. POST /api/recovery/complete   recovery_token + login key mới + keyring bọc bằng K_Master mới
+//
+// Đặt lại mật khẩu không đăng nhập: user đăng nhập lại bằng mật khẩu mới (và mã
+// 2FA nếu đã bật), mọi phiên cũ bị đăng xuất.
+
+const (
+	// recoveryTokenPurpose là claim "purpose" của recovery_token; JWTMiddleware từ chối token có purpose
+	recoveryTokenPurpose = "recovery"
+	recoveryTokenTTL     = 10 * time.Minute
+
+	// recoveryAuthKeyLen: auth key là output 256 bit của HKDF
+	recoveryAuthKeyLen = 32
+)
+
+// SetRecoveryKeyRequest là body của PUT /api/me/recovery
+type SetRecoveryKeyRequest struct {
+	LoginKey     string `json:"login_key" binding:"required"`      // Login key của tài khoản (loginkey.go)
+	AuthKey      string `json:"auth_key" binding:"required"`       // Base64, auth key của recovery key
+	MasterKeyEnc string `json:"master_key_enc" binding:"required"` // K_Master bọc bởi wrap key
+	KeyEnc       string `json:"key_enc" binding:"required"`        // Wrap key bọc bởi K_Master
+}
+
+// DeleteRecoveryKeyRequest là body của DELETE /api/me/recovery
+type DeleteRecoveryKeyRequest struct {
+	LoginKey string `json:"login_key" binding:"required"`
+}
+
+// RecoveryStatusResponse là response của GET /api/me/recovery
+type RecoveryStatusResponse struct {
+	Enabled   bool   `json:"enabled"`
+	CreatedAt string `json:"created_at,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// StartRecoveryRequest là bước một của khôi phục tài khoản
+type StartRecoveryRequest struct {
+	Username string `json:"username" binding:"required"`
+	AuthKey  string `json:"auth_key" binding:"required"`
+}
+
+// StartRecoveryResponse trả cho client những gì cần để bọc lại keyring
+type StartRecoveryResponse struct {
+	RecoveryToken string          `json:"recovery_token"`
+	ExpiresIn     int64           `json:"expires_in"`
+	MasterKeyEnc  string          `json:"master_key_enc"`
+	Keyring       KeyringResponse `json:"keyring"`
+}
+
+// CompleteRecoveryRequest là bước hai: login key mới và keyring bọc bằng K_Master mới
+type CompleteRecoveryRequest struct {
+	RecoveryToken string         `json:"recovery_token" binding:"required"`
+	NewLoginKey   string         `json:"new_login_key" binding:"required"`
+	KdfSalt       string         `json:"kdf_salt" binding:"required"`
+	Keyring       KeyringPayload `json:"keyring"`
+}
+
+// recoveryVerifier là giá trị lưu trong DB để kiểm tra auth key; nil nếu auth key sai định dạng
+func recoveryVerifier(authKey string) []byte {
+	raw, err := base64.StdEncoding.DecodeString(authKey)
+	if err != nil || len(raw) != recoveryAuthKeyLen {
+		return nil
+	}
+	sum := sha256.Sum256(raw)
+	return sum[:]
+}
+
+// generateRecoveryToken tạo recovery_token: JWT ngắn hạn chỉ dùng được cho
+// POST /api/recovery/complete, gắn với recovery key đã được chứng minh
+func generateRecoveryToken(userID string, verifier string) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id":  userID,
+		"verifier": verifier,
+		"purpose":  recoveryTokenPurpose,
+		"exp":      time.Now().Add(recoveryTokenTTL).Unix(),
+		"iat":      time.Now().Unix(),
+		"jti":      uuid.New().String(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(JWTSecretKey)
+}
+
+// GetRecoveryKey - Trạng thái recovery key
+// GET /api/me/recovery
+// Response: { "enabled": true, "created_at": "...", "updated_at": "..." }
+func (s *Server) GetRecoveryKey(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if s.Recovery == nil {
+		c.JSON(http.StatusOK, RecoveryStatusResponse{})
+		return
+	}
+	r, err := s.Recovery.GetRecoveryKey(c.Request.Context(), userID.(string))
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusOK, RecoveryStatusResponse{})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query recovery key"})
+		return
+	}
+	c.JSON(http.StatusOK, RecoveryStatusResponse{Enabled: true, CreatedAt: r.CreatedAt, UpdatedAt: r.UpdatedAt})
+}
+
+// SetRecoveryKey - Tạo hoặc thay recovery key (recovery key cũ không dùng được nữa)
+// PUT /api/me/recovery
+// Request: { "login_key": "base64", "auth_key": "base64", "master_key_enc": "base64", "key_enc": "base64" }
+// Response: { "message": "recovery key saved" }
+// Cần mật khẩu: access token bị lộ không đủ để gắn recovery key của kẻ tấn công.
+func (s *Server) SetRecoveryKey(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if s.Recovery == nil || s.Keyrings == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "account recovery is not available"})
+		return
+	}
+
+	var req SetRecoveryKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	verifier := recoveryVerifier(req.AuthKey)
+	if verifier == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "auth_key must be base64 of 32 bytes"})
+		return
+	}
+
+	user := s.checkAccountCredentials(c, userID.(string), "", req.LoginKey)
+	if user == nil {
+		return
+	}
+	err := s.Recovery.PutRecoveryKey(c.Request.Context(), &RecoveryKey{
+		UserID:       user.ID,
+		Verifier:     hex.EncodeToString(verifier),
+		MasterKeyEnc: req.MasterKeyEnc,
+		KeyEnc:       req.KeyEnc,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save recovery key"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "recovery key saved"})
+}
+
+// DeleteRecoveryKey - Xóa recovery key
+// DELETE /api/me/recovery
+// Request: { "login_key": "base64" }
+// Response: { "message": "recovery key removed" }
+func (s *Server) DeleteRecoveryKey(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if s.Recovery == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no recovery key"})
+		return
+	}
+
+	var req DeleteRecoveryKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := s.checkAccountCredentials(c, userID.(string), "", req.LoginKey)
+	if user == nil {
+		return
+	}
+	if err := s.Recovery.DeleteRecoveryKey(c.Request.Context(), user.ID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no recovery key"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove recovery key"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "recovery key removed"})
+}
+
+// StartRecovery - Chứng minh đang giữ recovery key
+// POST /api/recovery
+// Request: { "username": "...", "auth_key": "base64" }
+// Response: { "recovery_token": "...", "expires_in": 600, "master_key_enc": "...", "keyring": { như GET /api/me/keyring } }
+// Auth key sai (hoặc tài khoản không có recovery key) được tính vào lockout của tài khoản như mật khẩu sai.
+func (s *Server) StartRecovery(c *gin.Context) {
+	var req StartRecoveryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+	if s.Recovery == nil || s.Keyrings == nil {
+		c.JSON(http.StatusNotImplemented, ErrorResponse{
+			Error: "Account recovery is not available",
+		})
+		return
+	}
+	verifier := recoveryVerifier(req.AuthKey)
+	if verifier == nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "auth_key must be base64 of 32 bytes",
+		})
+		return
+	}
+
+	subjects := loginSubjects(c, req.Username)
+	if !s.reserveAuthAttempt(c, subjects) {
+		return
+	}
+	// Lần thử không kết thúc bằng đúng / sai (lỗi server) được hoàn lại
+	defer s.releaseAuthAttempts(c)
+
+	ctx := c.Request.Context()
+	// Username không tồn tại, không có recovery key hay auth key sai đều trả cùng một lỗi
+	var r *RecoveryKey
+	user, err := s.Users.GetUserByUsername(ctx, req.Username)
+	if err == nil {
+		r, err = s.Recovery.GetRecoveryKey(ctx, user.ID)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Database error",
+		})
+		return
+	}
+	if r == nil || subtle.ConstantTimeCompare([]byte(hex.EncodeToString(verifier)), []byte(r.Verifier)) != 1 {
+		s.recordAuthFailure(c, subjects)
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error: "Invalid username or recovery key",
+		})
+		return
+	}
+	s.clearAuthFailures(c, subjects)
+
+	k, err := s.Keyrings.GetKeyring(ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to query keyring",
+		})
+		return
+	}
+	token, err := generateRecoveryToken(user.ID, r.Verifier)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to generate token",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, StartRecoveryResponse{
+		RecoveryToken: token,
+		ExpiresIn:     int64(recoveryTokenTTL / time.Second),
+		MasterKeyEnc:  r.MasterKeyEnc,
+		Keyring:       KeyringResponse{KdfSalt: user.KdfSalt, KeyringPayload: newKeyringPayload(k)},
+	})
+}
+
+// CompleteRecovery - Đặt mật khẩu mới và thay keyring đã bọc bằng K_Master mới
+// POST /api/recovery/complete
+// Request: { "recovery_token": "...", "new_login_key": "base64", "kdf_salt": "base64", "keyring": { như POST /api/me/password } }
+// Response: { "message": "password reset, log in with the new password" }
+// 409 khi keyring đã cũ: client làm lại từ POST /api/recovery. keyring phải có
+// recovery (K_Master mới bọc bởi cùng wrap key) nên recovery key vẫn dùng được.
+func (s *Server) CompleteRecovery(c *gin.Context) {
+	var req CompleteRecoveryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+	if s.Recovery == nil || s.Keyrings == nil {
+		c.JSON(http.StatusNotImplemented, ErrorResponse{
+			Error: "Account recovery is not available",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	token, claims, err := ParseJWT(req.RecoveryToken)
+	if err != nil || token == nil || claims["purpose"] != recoveryTokenPurpose {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error: "Invalid or expired recovery token",
+		})
+		return
+	}
+	userID, _ := claims["user_id"].(string)
+	verifier, _ := claims["verifier"].(string)
+	jti, _ := claims["jti"].(string)
+	if valid, err := s.ValidateToken(ctx, jti); err != nil || !valid {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error: "Invalid or expired recovery token",
+		})
+		return
+	}
+	// Recovery key bị xóa hoặc thay sau khi token được cấp thì token hết hiệu lực
+	r, err := s.Recovery.GetRecoveryKey(ctx, userID)
+	if err != nil || r.Verifier != verifier {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error: "Invalid or expired recovery token",
+		})
+		return
+	}
+	user, err := s.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error: "Invalid or expired recovery token",
+		})
+		return
+	}
+
+	salt, keyring, ok := parseNewCredentials(c, req.NewLoginKey, req.KdfSalt, req.Keyring)
+	if !ok {
+		return
+	}
+	if keyring.RecoveryKeyEnc == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "keyring must include the re-wrapped recovery key",
+		})
+		return
+	}
+	if !s.replaceKeyring(c, user.ID, req.NewLoginKey, salt, keyring) {
+		return
+	}
+
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		_ = s.BlacklistToken(ctx, jti, exp.Time)
+	}
+	s.clearAuthFailures(c, loginSubjects(c, user.Username))
+	log.Printf("recovery: password of %q reset with recovery key (ip %s)", user.Username, c.ClientIP())
+	if s.Lockouts != nil {
+		err = s.Lockouts.AddAuditEvent(ctx, &AuditEvent{
+			Event:   AuditEventRecovery,
+			Scope:   AuthScopeAccount,
+			Subject: user.Username,
+			IP:      c.ClientIP(),
+			Detail:  "password reset with recovery key",
+		})
+		if err != nil {
+			log.Printf("recovery: write audit event: %v", err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "password reset, log in with the new password"})
+}
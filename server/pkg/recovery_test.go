@@ -0,0 +1,162 @@
+package serverpkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// testAuthKey là auth key hợp lệ của recovery key (base64 của 32 byte)
+const testAuthKey = "cmVjb3ZlcnktYXV0aC1rZXktMDEyMzQ1Njc4OWFiY2Q="
+
+// setRecoveryKey gắn recovery key có auth key authKey cho user
+func (ts *testServer) setRecoveryKey(t *testing.T, token, authKey string) {
+	t.Helper()
+	w := ts.do(http.MethodPut, "/api/me/recovery", token, SetRecoveryKeyRequest{
+		LoginKey:     testLoginKey,
+		AuthKey:      authKey,
+		MasterKeyEnc: "bWFzdGVy",
+		KeyEnc:       "d3JhcA==",
+	})
+	expectStatus(t, w, http.StatusOK)
+}
+
+// startRecovery gửi POST /api/recovery và trả về response
+func (ts *testServer) startRecovery(t *testing.T, username, authKey string) StartRecoveryResponse {
+	t.Helper()
+	w := ts.do(http.MethodPost, "/api/recovery", "", StartRecoveryRequest{Username: username, AuthKey: authKey})
+	expectStatus(t, w, http.StatusOK)
+	var resp StartRecoveryResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// completeRecovery gửi POST /api/recovery/complete với keyring đã bọc lại
+func (ts *testServer) completeRecovery(token string, keyring KeyringPayload) int {
+	return ts.do(http.MethodPost, "/api/recovery/complete", "", CompleteRecoveryRequest{
+		RecoveryToken: token,
+		NewLoginKey:   newTestLoginKey,
+		KdfSalt:       newTestKdfSalt,
+		Keyring:       rewrap(keyring),
+	}).Code
+}
+
+func TestRecoveryResetsPassword(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, refresh := ts.newUser(t, "alice", testLoginKey)
+	ts.createNote(t, token)
+	ts.setRecoveryKey(t, token, testAuthKey)
+	if enabled := decode(t, ts.do(http.MethodGet, "/api/me/recovery", token, nil))["enabled"]; enabled != true {
+		t.Fatalf("enabled = %v", enabled)
+	}
+
+	resp := ts.startRecovery(t, "alice", testAuthKey)
+	if resp.RecoveryToken == "" || resp.MasterKeyEnc != "bWFzdGVy" {
+		t.Fatalf("start recovery = %+v", resp)
+	}
+	if len(resp.Keyring.Notes) != 1 || resp.Keyring.Recovery == nil || resp.Keyring.Recovery.KeyEnc != "d3JhcA==" {
+		t.Fatalf("recovery keyring = %+v", resp.Keyring)
+	}
+	// Recovery token không dùng được như access token
+	expectStatus(t, ts.do(http.MethodGet, "/api/notes", resp.RecoveryToken, nil), http.StatusUnauthorized)
+
+	if code := ts.completeRecovery(resp.RecoveryToken, resp.Keyring.KeyringPayload); code != http.StatusOK {
+		t.Fatalf("complete recovery: %d", code)
+	}
+	expectStatus(t, ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "alice", LoginKey: testLoginKey}), http.StatusUnauthorized)
+	w := ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "alice", LoginKey: newTestLoginKey})
+	expectStatus(t, w, http.StatusOK)
+	expectStatus(t, ts.do(http.MethodPost, "/api/refresh", "", map[string]string{"refresh_token": refresh}), http.StatusUnauthorized)
+
+	// Recovery key vẫn dùng được với K_Master mới; recovery token chỉ dùng một lần
+	newToken, _ := decode(t, w)["access_token"].(string)
+	k := ts.keyring(t, newToken)
+	if k.Recovery == nil || k.Recovery.KeyEnc != "new-d3JhcA==" || k.Notes[0].KeyEnc != "new-a2V5" {
+		t.Fatalf("keyring after recovery = %+v", k.KeyringPayload)
+	}
+	if code := ts.completeRecovery(resp.RecoveryToken, k.KeyringPayload); code != http.StatusUnauthorized {
+		t.Fatalf("reused recovery token: %d", code)
+	}
+}
+
+func TestStartRecoveryWrongAuthKey(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	ts.newUser(t, "bob", testLoginKey)
+	ts.setRecoveryKey(t, token, testAuthKey)
+
+	// Auth key sai, user không có recovery key và user không tồn tại trả cùng một lỗi
+	other := "YW5vdGhlci1hdXRoLWtleS0wMTIzNDU2Nzg5YWJjZGU="
+	wrong := ts.do(http.MethodPost, "/api/recovery", "", StartRecoveryRequest{Username: "alice", AuthKey: other})
+	expectStatus(t, wrong, http.StatusUnauthorized)
+	for _, req := range []StartRecoveryRequest{{Username: "bob", AuthKey: testAuthKey}, {Username: "nobody", AuthKey: testAuthKey}} {
+		w := ts.do(http.MethodPost, "/api/recovery", "", req)
+		if w.Code != wrong.Code || w.Body.String() != wrong.Body.String() {
+			t.Fatalf("%s: %d %s, want %d %s", req.Username, w.Code, w.Body.String(), wrong.Code, wrong.Body.String())
+		}
+	}
+}
+
+func TestCompleteRecoveryInvalidToken(t *testing.T) {
+	ts := newTestServer(t)
+	userID, token, _ := ts.newUser(t, "alice", testLoginKey)
+	ts.setRecoveryKey(t, token, testAuthKey)
+	resp := ts.startRecovery(t, "alice", testAuthKey)
+	keyring := resp.Keyring.KeyringPayload
+
+	var claims jwt.MapClaims
+	if _, err := jwt.ParseWithClaims(resp.RecoveryToken, &claims, func(*jwt.Token) (any, error) { return JWTSecretKey, nil }); err != nil {
+		t.Fatal(err)
+	}
+	sign := func(exp time.Time) string {
+		c := jwt.MapClaims{
+			"user_id":  userID,
+			"verifier": claims["verifier"],
+			"purpose":  recoveryTokenPurpose,
+			"exp":      exp.Unix(),
+			"iat":      exp.Add(-recoveryTokenTTL).Unix(),
+			"jti":      uuid.New().String(),
+		}
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(JWTSecretKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	for name, tok := range map[string]string{
+		"garbage":      "not-a-token",
+		"access token": token,
+		"expired":      sign(time.Now().Add(-time.Minute)),
+	} {
+		if code := ts.completeRecovery(tok, keyring); code != http.StatusUnauthorized {
+			t.Fatalf("%s: %d, want 401", name, code)
+		}
+	}
+
+	// Thay recovery key làm mọi recovery token đã cấp hết hiệu lực
+	ts.setRecoveryKey(t, token, "YW5vdGhlci1hdXRoLWtleS0wMTIzNDU2Nzg5YWJjZGU=")
+	if code := ts.completeRecovery(resp.RecoveryToken, keyring); code != http.StatusUnauthorized {
+		t.Fatalf("token of replaced recovery key: %d, want 401", code)
+	}
+	expectStatus(t, ts.do(http.MethodPost, "/api/login", "", LoginRequest{Username: "alice", LoginKey: testLoginKey}), http.StatusOK)
+}
+
+func TestCompleteRecoveryNeedsRecoveryKeyInKeyring(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	ts.setRecoveryKey(t, token, testAuthKey)
+	resp := ts.startRecovery(t, "alice", testAuthKey)
+
+	keyring := resp.Keyring.KeyringPayload
+	keyring.Recovery = nil
+	if code := ts.completeRecovery(resp.RecoveryToken, keyring); code != http.StatusBadRequest {
+		t.Fatalf("keyring without recovery key: %d, want 400", code)
+	}
+}
@@ -0,0 +1,54 @@
+package serverpkg
+
+import (
+	"errors"
+	"regexp"
+)
+
+// ============================================================
+// ENCRYPTED SEARCH - Blind index
+// ============================================================
+//
+// Client chuẩn hóa từ khóa (title, tag), tính token = base64url(HMAC-SHA256(K_Search, từ khóa))
+// với K_Search = HKDF(K_Master, "Secure-Notes-Search-Index") rồi gửi kèm note.
+// Server chỉ lưu và so khớp token: cùng từ khóa => cùng token, nhưng không suy ra được từ khóa.
+//
+//    GET /api/notes?token=...&token=...    note có đủ mọi token
+
+const (
+	// MaxSearchTokens là số token tối đa của một note
+	MaxSearchTokens = 256
+	// maxQueryTokens là số token tối đa trong một truy vấn
+	maxQueryTokens = 16
+)
+
+// searchTokenPattern: base64url không padding, đủ dài để không phải từ khóa rõ
+var searchTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+var (
+	errInvalidSearchToken  = errors.New("invalid search token")
+	errTooManySearchTokens = errors.New("too many search tokens")
+)
+
+// normalizeSearchTokens kiểm tra định dạng và bỏ token trùng.
+// Giữ phân biệt nil (không gửi) với slice rỗng (xóa hết token).
+func normalizeSearchTokens(tokens []string, max int) ([]string, error) {
+	if tokens == nil {
+		return nil, nil
+	}
+	seen := make(map[string]bool, len(tokens))
+	out := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if !searchTokenPattern.MatchString(token) {
+			return nil, errInvalidSearchToken
+		}
+		if !seen[token] {
+			seen[token] = true
+			out = append(out, token)
+		}
+	}
+	if len(out) > max {
+		return nil, errTooManySearchTokens
+	}
+	return out, nil
+}
@@ -0,0 +1,142 @@
+package serverpkg
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Server gom các store mà handler phụ thuộc (thay cho biến global db).
+// Các handler Gin là method của Server.
+type Server struct {
+	Users      UserStore
+	Notes      NoteStore
+	Folders    FolderStore
+	ShareLinks ShareLinkStore
+	Uploads    UploadStore
+	Tokens     TokenStore
+	// Blobs lưu ciphertext của note, share link và upload chunk
+	Blobs BlobStore
+	// BlobRefs có thể nil (khi đó không GC blob)
+	BlobRefs BlobRefStore
+	// BlobGCGrace: blob không được tham chiếu chỉ bị xóa khi cũ hơn thời gian này
+	BlobGCGrace time.Duration
+	// Maintenance có thể nil (khi đó scheduler không chạy)
+	Maintenance MaintenanceStore
+	// TrashRetention: note nằm trong thùng rác lâu hơn thời gian này bị xóa vĩnh viễn
+	TrashRetention time.Duration
+	// UploadLimits giới hạn của upload chunk (mặc định DefaultUploadLimits)
+	UploadLimits UploadLimits
+	// MFA có thể nil (khi đó không có xác thực hai bước)
+	MFA MFAStore
+	// Quotas có thể nil (khi đó không giới hạn dung lượng và không có /api/me/usage)
+	Quotas QuotaStore
+	// DefaultQuota: số byte tối đa của user không có quota riêng (0 = không giới hạn)
+	DefaultQuota int64
+	// Keyrings có thể nil (khi đó không đổi được mật khẩu)
+	Keyrings KeyringStore
+	// Recovery có thể nil (khi đó không có recovery key); cần cả Keyrings
+	Recovery RecoveryStore
+	// Lockouts có thể nil (khi đó chỉ còn RateLimitMiddleware chặn đoán mật khẩu)
+	Lockouts LockoutStore
+	// LockoutPolicy ngưỡng chờ và khóa (mặc định DefaultLockoutPolicy)
+	LockoutPolicy LockoutPolicy
+}
+
+// UploadLimits là các giới hạn của resumable upload
+type UploadLimits struct {
+	// MaxSize: tổng số byte ciphertext tối đa của một note
+	MaxSize int64
+	// MaxChunkSize: kích thước chunk tối đa client được chọn
+	MaxChunkSize int64
+	// SessionTTL: session chưa finalize sau thời gian này bị xóa
+	SessionTTL time.Duration
+}
+
+// DefaultUploadLimits: ciphertext nằm trong blob store nên MaxSize không còn bị
+// giới hạn bởi SQLite; GET /api/notes/:id (JSON base64) vẫn đọc cả note vào bộ nhớ,
+// note lớn nên tải qua /content
+var DefaultUploadLimits = UploadLimits{
+	MaxSize:      4 << 30,
+	MaxChunkSize: 8 << 20,
+	SessionTTL:   24 * time.Hour,
+}
+
+// LockoutPolicy là ngưỡng chống đoán mật khẩu (login, mã 2FA, mật khẩu share link)
+type LockoutPolicy struct {
+	// FreeAttempts: số lần sai liên tiếp của tài khoản / share link chưa phải chờ
+	FreeAttempts int
+	// BaseDelay: thời gian chờ sau lần sai thứ FreeAttempts+1, gấp đôi sau mỗi lần sai tiếp theo
+	BaseDelay time.Duration
+	// MaxFailures: tài khoản / share link bị khóa LockDuration từ lần sai thứ MaxFailures
+	MaxFailures int
+	// IPMaxFailures: IP bị khóa LockDuration từ lần sai thứ IPMaxFailures (tính mọi tài khoản và share link)
+	IPMaxFailures int
+	LockDuration  time.Duration
+	// Window: lần sai cũ hơn thời gian này không còn được tính
+	Window time.Duration
+}
+
+// DefaultLockoutPolicy: ngưỡng IP cao hơn vì nhiều user có thể dùng chung một IP (NAT)
+var DefaultLockoutPolicy = LockoutPolicy{
+	FreeAttempts:  3,
+	BaseDelay:     time.Second,
+	MaxFailures:   10,
+	IPMaxFailures: 100,
+	LockDuration:  15 * time.Minute,
+	Window:        time.Hour,
+}
+
+// DefaultBlobGCGrace đủ dài để một request đã Put blob kịp ghi row tham chiếu
+const DefaultBlobGCGrace = time.Hour
+
+// DefaultTrashRetention là thời gian note được giữ trong thùng rác trước khi bị purge
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// DefaultStorageQuota là quota của user không có quota riêng
+const DefaultStorageQuota = 10 << 30
+
+// NewServer tạo Server từ các store
+func NewServer(users UserStore, notes NoteStore, folders FolderStore, shareLinks ShareLinkStore, uploads UploadStore, tokens TokenStore, blobs BlobStore) *Server {
+	return &Server{
+		Users:          users,
+		Notes:          notes,
+		Folders:        folders,
+		ShareLinks:     shareLinks,
+		Uploads:        uploads,
+		Tokens:         tokens,
+		Blobs:          blobs,
+		BlobGCGrace:    DefaultBlobGCGrace,
+		TrashRetention: DefaultTrashRetention,
+		UploadLimits:   DefaultUploadLimits,
+		DefaultQuota:   DefaultStorageQuota,
+		LockoutPolicy:  DefaultLockoutPolicy,
+	}
+}
+
+// NewSQLiteServer tạo Server dùng SQLiteStore cho mọi store và blobs cho ciphertext
+func NewSQLiteServer(db *sql.DB, blobs BlobStore) *Server {
+	store := NewSQLiteStore(db)
+	s := NewServer(store, store, store, store, store, store, blobs)
+	s.BlobRefs = store
+	s.Maintenance = store
+	s.Quotas = store
+	s.MFA = store
+	s.Lockouts = store
+	s.Keyrings = store
+	s.Recovery = store
+	return s
+}
+
+// NewMemoryServer tạo Server dùng MemoryStore và MemoryBlobStore (cho test, không cần file database)
+func NewMemoryServer() *Server {
+	store := NewMemoryStore()
+	s := NewServer(store, store, store, store, store, store, NewMemoryBlobStore())
+	s.BlobRefs = store
+	s.Maintenance = store
+	s.Quotas = store
+	s.MFA = store
+	s.Lockouts = store
+	s.Keyrings = store
+	s.Recovery = store
+	return s
+}
@@ -1,10 +1,14 @@
 package serverpkg
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 // ============================================================
@@ -13,9 +17,9 @@ import (
 
 // ShareNote - Chia sẻ ghi chú cho user khác trong hệ thống
 // POST /api/notes/:id/share
-// Request: { "shared_to_user_id": "uuid", "aes_key_encrypted": "..." }
-// Response: { "message": "note shared successfully" }
-func ShareNote(c *gin.Context) {
+// Request: { "shared_to_user_id": "uuid", "aes_key_encrypted": "...", "sender_public_key": "..." }
+// Response: { "message": "note shared successfully", "share_id": "..." }
+func (s *Server) ShareNote(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -27,6 +31,7 @@ func ShareNote(c *gin.Context) {
 	var req struct {
 		SharedToUserID  string `json:"shared_to_user_id" binding:"required"`
 		AESKeyEncrypted string `json:"aes_key_encrypted" binding:"required"`
+		SenderPublicKey string `json:"sender_public_key" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -34,40 +39,46 @@ func ShareNote(c *gin.Context) {
 		return
 	}
 
-	db := GetDB()
-
 	// Kiểm tra quyền sở hữu note
-	var ownerID string
-	err := db.QueryRow("SELECT user_id FROM notes WHERE id = ?", noteID).Scan(&ownerID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+	note := s.loadOwnedNote(c, noteID, userID.(string), "only owner can share")
+	if note == nil {
 		return
 	}
 
-	if ownerID != userID.(string) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "only owner can share"})
+	// Kiểm tra user nhận có tồn tại
+	if _, err := s.Users.GetUserByID(c.Request.Context(), req.SharedToUserID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "shared user not found"})
 		return
 	}
 
-	// Kiểm tra user nhận có tồn tại
-	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", req.SharedToUserID).Scan(&count)
-	if err != nil || count == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "shared user not found"})
+	if req.SharedToUserID == note.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot share note with yourself"})
+		return
+	}
+
+	// Lưu vào note_shares (chia sẻ lại sau khi thu hồi sẽ kích hoạt lại bản ghi cũ)
+	share := &NoteShare{
+		ID:              uuid.New().String(),
+		NoteID:          noteID,
+		RecipientID:     req.SharedToUserID,
+		WrappedKey:      req.AESKeyEncrypted,
+		SenderPublicKey: req.SenderPublicKey,
+	}
+	if err := s.Notes.UpsertNoteShare(c.Request.Context(), share); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to share note"})
 		return
 	}
 
-	// TODO: Lưu vào bảng note_shares (cần tạo bảng này trong migration)
-	// Tạm thời response success
 	c.JSON(http.StatusOK, gin.H{
-		"message": "note shared successfully",
+		"message":  "note shared successfully",
+		"share_id": share.ID,
 	})
 }
 
 // ListShares - Liệt kê các user đã được chia sẻ note
 // GET /api/notes/:id/share
-// Response: [ { "user_id": "uuid", "username": "...", "shared_at": "..." }, ... ]
-func ListShares(c *gin.Context) {
+// Response: [ { "share_id": "...", "user_id": "uuid", "username": "...", "shared_at": "..." }, ... ]
+func (s *Server) ListShares(c *gin.Context) {
 	noteID := c.Param("id")
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -75,32 +86,34 @@ func ListShares(c *gin.Context) {
 		return
 	}
 
-	db := GetDB()
-
 	// Kiểm tra quyền sở hữu
-	var ownerID string
-	err := db.QueryRow("SELECT user_id FROM notes WHERE id = ?", noteID).Scan(&ownerID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+	if s.loadOwnedNote(c, noteID, userID.(string), "only owner can view shares") == nil {
 		return
 	}
 
-	if ownerID != userID.(string) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "only owner can view shares"})
+	rows, err := s.Notes.ListNoteShares(c.Request.Context(), noteID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query shares"})
 		return
 	}
 
-	// TODO: Query bảng note_shares
-	// Tạm thời trả về empty array
 	shares := []map[string]interface{}{}
+	for _, ns := range rows {
+		shares = append(shares, map[string]interface{}{
+			"share_id":  ns.ID,
+			"user_id":   ns.RecipientID,
+			"username":  ns.RecipientUsername,
+			"shared_at": ns.CreatedAt,
+		})
+	}
 
 	c.JSON(http.StatusOK, shares)
 }
 
 // RevokeShare - Thu hồi quyền chia sẻ
-// DELETE /api/notes/:id/share/:share_id
+// DELETE /api/notes/:id/share/:share_id (share_id = share id hoặc user_id người nhận)
 // Response: { "message": "share revoked successfully" }
-func RevokeShare(c *gin.Context) {
+func (s *Server) RevokeShare(c *gin.Context) {
 	noteID := c.Param("id")
 	sharedUserID := c.Param("share_id")
 	userID, exists := c.Get("user_id")
@@ -109,23 +122,21 @@ func RevokeShare(c *gin.Context) {
 		return
 	}
 
-	db := GetDB()
-
 	// Kiểm tra quyền sở hữu
-	var ownerID string
-	err := db.QueryRow("SELECT user_id FROM notes WHERE id = ?", noteID).Scan(&ownerID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+	if s.loadOwnedNote(c, noteID, userID.(string), "only owner can revoke share") == nil {
 		return
 	}
 
-	if ownerID != userID.(string) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "only owner can revoke share"})
+	// Đánh dấu revoked_at, người nhận không thể tải note nữa
+	if err := s.Notes.RevokeNoteShare(c.Request.Context(), noteID, sharedUserID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "share not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke share"})
 		return
 	}
 
-	// TODO: Delete from note_shares table
-	// Tạm thời response success
 	c.JSON(http.StatusOK, gin.H{
 		"message": "share revoked successfully",
 	})
@@ -137,9 +148,11 @@ func RevokeShare(c *gin.Context) {
 
 // CreateShareLink - Tạo Link Chia sẻ
 // POST /api/share
-// Request: { "content_enc": "base64...", "metadata": { "expires_in": 3600, "max_views": 5, "has_password": true, "access_hash": "sha256..." } }
+// Request: { "content_enc": "base64...", "note_id": "uuid", "metadata": { "expires_in": 3600, "max_views": 5, "has_password": true, "access_hash": "sha256..." } }
 // Response: { "share_id": "uuid-1234...", "expires_at": "2025-12-31T23:59:00Z" }
-func CreateShareLink(c *gin.Context) {
+// note_id (tùy chọn): note mà content được tạo từ đó; link ngừng hoạt động khi note
+// nằm trong thùng rác và bị vô hiệu hóa khi note bị xóa vĩnh viễn.
+func (s *Server) CreateShareLink(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -148,6 +161,7 @@ func CreateShareLink(c *gin.Context) {
 
 	var req struct {
 		ContentEnc string `json:"content_enc" binding:"required"`
+		NoteID     string `json:"note_id"`
 		Metadata   struct {
 			ExpiresIn   int    `json:"expires_in"`   // Seconds
 			MaxViews    int    `json:"max_views"`    // 0 = unlimited
@@ -166,8 +180,18 @@ func CreateShareLink(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "access_hash required when has_password is true"})
 		return
 	}
+	// access_hash không kèm has_password sẽ làm link đòi password trong khi metadata nói không
+	if !req.Metadata.HasPassword && req.Metadata.AccessHash != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "access_hash requires has_password to be true"})
+		return
+	}
 
-	db := GetDB()
+	// Chủ sở hữu hoặc người nhận share đều có thể tạo link từ note họ đọc được
+	if req.NoteID != "" {
+		if note, _ := s.loadReadableNote(c, req.NoteID, userID.(string)); note == nil {
+			return
+		}
+	}
 
 	// Tính thời gian hết hạn
 	var expiresAt *string
@@ -176,33 +200,50 @@ func CreateShareLink(c *gin.Context) {
 		expiresAt = &expiry
 	}
 
-	// Convert boolean to integer for SQLite
-	hasPassword := 0
-	if req.Metadata.HasPassword {
-		hasPassword = 1
-	}
-
 	// Xử lý max_views (NULL nếu unlimited)
-	var maxViews interface{}
+	var maxViews *int
 	if req.Metadata.MaxViews > 0 {
-		maxViews = req.Metadata.MaxViews
-	} else {
-		maxViews = nil
+		maxViews = &req.Metadata.MaxViews
+	}
+
+	var accessHash *string
+	if req.Metadata.AccessHash != "" {
+		accessHash = &req.Metadata.AccessHash
+	}
+
+	if !s.checkQuota(c, userID.(string), base64DecodedSize(req.ContentEnc), false) {
+		return
+	}
+
+	// Ciphertext vào blob store, shared_links chỉ giữ reference
+	blob, err := s.putContentBase64(c.Request.Context(), req.ContentEnc)
+	if err != nil {
+		if errors.Is(err, errInvalidContent) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store content"})
+		}
+		return
 	}
 
 	// Lưu vào shared_links
-	query := `
-		INSERT INTO shared_links (owner_id, content_enc, expires_at, max_views, has_password, access_hash)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`
-	result, err := db.Exec(query, userID, req.ContentEnc, expiresAt, maxViews, hasPassword, req.Metadata.AccessHash)
+	shareID := uuid.New().String()
+	err = s.ShareLinks.CreateShareLink(c.Request.Context(), &ShareLink{
+		ID:          shareID,
+		OwnerID:     userID.(string),
+		ContentRef:  blob.Key,
+		ContentSize: blob.Size,
+		ExpiresAt:   expiresAt,
+		MaxViews:    maxViews,
+		HasPassword: req.Metadata.HasPassword,
+		AccessHash:  accessHash,
+		NoteID:      req.NoteID,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create share link"})
 		return
 	}
 
-	shareID, _ := result.LastInsertId()
-
 	c.JSON(http.StatusCreated, gin.H{
 		"share_id":   shareID,
 		"expires_at": expiresAt,
@@ -212,88 +253,73 @@ func CreateShareLink(c *gin.Context) {
 // GetShareInfo - Lấy Thông tin Link
 // GET /api/share/:id/info
 // Response: { "is_active": true, "requires_password": true, "expires_at": "..." }
-func GetShareInfo(c *gin.Context) {
+func (s *Server) GetShareInfo(c *gin.Context) {
 	shareID := c.Param("id")
 
-	db := GetDB()
-
-	query := `
-		SELECT expires_at, max_views, current_views, has_password, is_active
-		FROM shared_links
-		WHERE id = ?
-	`
-
-	var expiresAt *string
-	var maxViews *int
-	var currentViews, hasPassword, isActive int
-
-	err := db.QueryRow(query, shareID).Scan(&expiresAt, &maxViews, &currentViews, &hasPassword, &isActive)
+	link, err := s.ShareLinks.GetShareLink(c.Request.Context(), shareID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "share link not found"})
 		return
 	}
 
 	// Kiểm tra trạng thái active
-	active := isActive == 1
+	active := link.IsActive
 
 	// Kiểm tra hết hạn
-	if expiresAt != nil {
-		expiry, _ := time.Parse(time.RFC3339, *expiresAt)
+	if link.ExpiresAt != nil {
+		expiry, _ := time.Parse(time.RFC3339, *link.ExpiresAt)
 		if time.Now().After(expiry) {
 			active = false
 		}
 	}
 
 	// Kiểm tra max views
-	if maxViews != nil && currentViews >= *maxViews {
+	if link.MaxViews != nil && link.CurrentViews >= *link.MaxViews {
 		active = false
 	}
 
+	// Kiểm tra note nguồn còn tồn tại (không nằm trong thùng rác)
+	if active {
+		deleted, err := s.shareLinkNoteDeleted(c.Request.Context(), link)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query note"})
+			return
+		}
+		active = !deleted
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"is_active":         active,
-		"requires_password": hasPassword == 1,
-		"expires_at":        expiresAt,
+		"requires_password": link.HasPassword,
+		"expires_at":        link.ExpiresAt,
 	})
 }
 
 // GetSharedContent - Truy cập & Tải File
 // GET /api/share/:id
 // Response: { "content_enc": "base64_string" }
-func GetSharedContent(c *gin.Context) {
+// Sai X-Access-Pass-Hash quá nhiều lần: 423 (link bị khóa) hoặc 429 (phải chờ / IP bị khóa), xem lockout.go
+func (s *Server) GetSharedContent(c *gin.Context) {
 	shareID := c.Param("id")
 
 	// Lấy password hash từ header (nếu có)
 	providedHash := c.GetHeader("X-Access-Pass-Hash")
 
-	db := GetDB()
-
-	query := `
-		SELECT content_enc, expires_at, max_views, current_views, has_password, access_hash, is_active
-		FROM shared_links
-		WHERE id = ?
-	`
-
-	var contentEnc string
-	var expiresAt *string
-	var maxViews *int
-	var currentViews, hasPassword, isActive int
-	var accessHash *string
-
-	err := db.QueryRow(query, shareID).Scan(&contentEnc, &expiresAt, &maxViews, &currentViews, &hasPassword, &accessHash, &isActive)
+	link, err := s.ShareLinks.GetShareLink(c.Request.Context(), shareID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "share link not found"})
 		return
 	}
 
 	// Kiểm tra is_active
-	if isActive == 0 {
+	if !link.IsActive {
 		c.JSON(http.StatusGone, gin.H{"error": "link has been revoked"})
 		return
 	}
 
 	// Kiểm tra hết hạn
-	if expiresAt != nil {
-		expiry, _ := time.Parse(time.RFC3339, *expiresAt)
+	if link.ExpiresAt != nil {
+		expiry, _ := time.Parse(time.RFC3339, *link.ExpiresAt)
 		if time.Now().After(expiry) {
 			c.JSON(http.StatusGone, gin.H{"error": "link has expired"})
 			return
@@ -301,36 +327,86 @@ func GetSharedContent(c *gin.Context) {
 	}
 
 	// Kiểm tra max views
-	if maxViews != nil && currentViews >= *maxViews {
+	if link.MaxViews != nil && link.CurrentViews >= *link.MaxViews {
 		c.JSON(http.StatusGone, gin.H{"error": "link has reached maximum views"})
 		return
 	}
 
+	// Note nguồn nằm trong thùng rác hoặc đã bị xóa
+	deleted, err := s.shareLinkNoteDeleted(c.Request.Context(), link)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query note"})
+		return
+	}
+	if deleted {
+		c.JSON(http.StatusGone, gin.H{"error": "note has been deleted"})
+		return
+	}
+
 	// Kiểm tra password
-	if hasPassword == 1 {
+	if link.HasPassword {
 		if providedHash == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "password required"})
 			return
 		}
 
-		if accessHash != nil && *accessHash != providedHash {
+		subjects := shareLinkSubjects(c, shareID)
+		if !s.reserveAuthAttempt(c, subjects) {
+			return
+		}
+		// Lần thử không kết thúc bằng đúng / sai (lỗi server) được hoàn lại
+		defer s.releaseAuthAttempts(c)
+		if link.AccessHash != nil && subtle.ConstantTimeCompare([]byte(*link.AccessHash), []byte(providedHash)) != 1 {
+			s.recordAuthFailure(c, subjects)
 			c.JSON(http.StatusForbidden, gin.H{"error": "incorrect password"})
 			return
 		}
+		s.clearAuthFailures(c, subjects)
 	}
 
-	// Tăng current_views
-	db.Exec("UPDATE shared_links SET current_views = current_views + 1, last_accessed_at = datetime('now') WHERE id = ?", shareID)
+	// Đọc nội dung trước khi tăng lượt xem để lỗi storage không làm mất một lượt
+	content, err := s.contentBase64(c.Request.Context(), link.ContentRef, link.ContentEnc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read shared content"})
+		return
+	}
+
+	// Tăng current_views; kiểm tra max_views ở trên chỉ để trả lỗi sớm, request song
+	// song được chặn ở đây vì việc tăng có điều kiện
+	if err := s.ShareLinks.IncrementShareLinkViews(c.Request.Context(), shareID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusGone, gin.H{"error": "link has reached maximum views"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update share link"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"content_enc": contentEnc,
+		"content_enc": content,
 	})
 }
 
+// shareLinkNoteDeleted cho biết note mà link được tạo từ đó đang nằm trong thùng rác
+// hoặc không còn tồn tại (link không gắn với note luôn trả về false)
+func (s *Server) shareLinkNoteDeleted(ctx context.Context, link *ShareLink) (bool, error) {
+	if link.NoteID == "" {
+		return false, nil
+	}
+	note, err := s.Notes.GetNote(ctx, link.NoteID)
+	if errors.Is(err, ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return note.DeletedAt != "", nil
+}
+
 // RevokeShareLink - Hủy Chia sẻ
 // DELETE /api/share/:id
 // Response: { "message": "Link revoked successfully" }
-func RevokeShareLink(c *gin.Context) {
+func (s *Server) RevokeShareLink(c *gin.Context) {
 	shareID := c.Param("id")
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -338,24 +414,20 @@ func RevokeShareLink(c *gin.Context) {
 		return
 	}
 
-	db := GetDB()
-
 	// Kiểm tra quyền sở hữu
-	var ownerID string
-	err := db.QueryRow("SELECT owner_id FROM shared_links WHERE id = ?", shareID).Scan(&ownerID)
+	link, err := s.ShareLinks.GetShareLink(c.Request.Context(), shareID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "share link not found"})
 		return
 	}
 
-	if ownerID != userID.(string) {
+	if link.OwnerID != userID.(string) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "only owner can revoke link"})
 		return
 	}
 
 	// Đánh dấu is_active = 0
-	_, err = db.Exec("UPDATE shared_links SET is_active = 0 WHERE id = ?", shareID)
-	if err != nil {
+	if err := s.ShareLinks.DeactivateShareLink(c.Request.Context(), shareID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke link"})
 		return
 	}
@@ -0,0 +1,173 @@
+package serverpkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// createShareLink tạo share link và trả về id
+func (ts *testServer) createShareLink(t *testing.T, token string, body map[string]any) string {
+	t.Helper()
+	body["content_enc"] = testContent
+	w := ts.do(http.MethodPost, "/api/share", token, body)
+	expectStatus(t, w, http.StatusCreated)
+	id, _ := decode(t, w)["share_id"].(string)
+	if id == "" {
+		t.Fatal("create share link returned no id")
+	}
+	return id
+}
+
+func TestShareLinkAccess(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	id := ts.createShareLink(t, token, map[string]any{"metadata": map[string]any{}})
+
+	w := ts.do(http.MethodGet, "/api/share/"+id, "", nil)
+	expectStatus(t, w, http.StatusOK)
+	if got := decode(t, w)["content_enc"]; got != testContent {
+		t.Fatalf("content_enc = %v, want %q", got, testContent)
+	}
+	expectStatus(t, ts.do(http.MethodGet, "/api/share/unknown", "", nil), http.StatusNotFound)
+
+	expectStatus(t, ts.do(http.MethodDelete, "/api/share/"+id, token, nil), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodGet, "/api/share/"+id, "", nil), http.StatusGone)
+}
+
+func TestShareLinkPassword(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	id := ts.createShareLink(t, token, map[string]any{"metadata": map[string]any{
+		"has_password": true,
+		"access_hash":  "right",
+	}})
+
+	expectStatus(t, ts.do(http.MethodGet, "/api/share/"+id, "", nil), http.StatusUnauthorized)
+	expectStatus(t, ts.do(http.MethodGet, "/api/share/"+id, "", nil, "X-Access-Pass-Hash", "wrong"), http.StatusForbidden)
+	expectStatus(t, ts.do(http.MethodGet, "/api/share/"+id, "", nil, "X-Access-Pass-Hash", "right"), http.StatusOK)
+}
+
+func TestShareLinkAccessHashRequiresPassword(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	w := ts.do(http.MethodPost, "/api/share", token, map[string]any{
+		"content_enc": testContent,
+		"metadata":    map[string]any{"has_password": false, "access_hash": "right"},
+	})
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestShareLinkMaxViews(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	id := ts.createShareLink(t, token, map[string]any{"metadata": map[string]any{"max_views": 2}})
+
+	for i := 0; i < 2; i++ {
+		expectStatus(t, ts.do(http.MethodGet, "/api/share/"+id, "", nil), http.StatusOK)
+	}
+	w := ts.do(http.MethodGet, "/api/share/"+id, "", nil)
+	expectStatus(t, w, http.StatusGone)
+	if got := decode(t, w)["error"]; got != "link has reached maximum views" {
+		t.Fatalf("error = %q", got)
+	}
+
+	w = ts.do(http.MethodGet, "/api/share/"+id+"/info", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	if active := decode(t, w)["is_active"]; active != false {
+		t.Fatalf("is_active = %v, want false", active)
+	}
+}
+
+func TestShareLinkTrashedNote(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	noteID := ts.createNote(t, token)
+	id := ts.createShareLink(t, token, map[string]any{"note_id": noteID, "metadata": map[string]any{}})
+	expectStatus(t, ts.do(http.MethodGet, "/api/share/"+id, "", nil), http.StatusOK)
+
+	expectStatus(t, ts.do(http.MethodDelete, "/api/notes/"+noteID, token, nil, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodGet, "/api/share/"+id, "", nil), http.StatusGone)
+
+	expectStatus(t, ts.do(http.MethodPost, "/api/trash/"+noteID+"/restore", token, nil), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodGet, "/api/share/"+id, "", nil), http.StatusOK)
+}
+
+func TestShareLinkMaxViewsParallel(t *testing.T) {
+	const max = 3
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	id := ts.createShareLink(t, token, map[string]any{"metadata": map[string]any{"max_views": max}})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	codes := map[int]int{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := ts.do(http.MethodGet, "/api/share/"+id, "", nil)
+			mu.Lock()
+			codes[w.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if codes[http.StatusOK] != max || codes[http.StatusGone] != 20-max {
+		t.Fatalf("status codes = %v, want %d x 200 and %d x 410", codes, max, 20-max)
+	}
+}
+
+func TestIncrementShareLinkViewsParallel(t *testing.T) {
+	const max = 3
+	for name, s := range lockoutStores(t) {
+		store := s.(interface {
+			UserStore
+			ShareLinkStore
+		})
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := store.CreateUser(ctx, &User{ID: "u1", Username: "alice", PasswordHash: "x", KdfSalt: "x"}); err != nil {
+				t.Fatal(err)
+			}
+			maxViews := max
+			if err := store.CreateShareLink(ctx, &ShareLink{ID: "s1", OwnerID: "u1", ContentEnc: testContent, MaxViews: &maxViews, IsActive: true}); err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			counted, denied := 0, 0
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := store.IncrementShareLinkViews(ctx, "s1")
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case err == nil:
+						counted++
+					case errors.Is(err, ErrNotFound):
+						denied++
+					default:
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+			if counted != max || denied != 20-max {
+				t.Fatalf("counted %d, denied %d; want %d and %d", counted, denied, max, 20-max)
+			}
+			l, err := store.GetShareLink(ctx, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l.CurrentViews != max {
+				t.Fatalf("current_views = %d, want %d", l.CurrentViews, max)
+			}
+		})
+	}
+}
@@ -0,0 +1,604 @@
+package serverpkg
+
+import (
+	"context"
+	"errors"
+)
+
+// ============================================================
+// STORAGE INTERFACES - Repository layer used by the handlers
+// ============================================================
+
+var (
+	// ErrNotFound: bản ghi không tồn tại
+	ErrNotFound = errors.New("not found")
+	// ErrConflict: vi phạm ràng buộc unique (vd: username đã tồn tại)
+	ErrConflict = errors.New("already exists")
+	// ErrVersionMismatch: bản ghi đã bị sửa sau phiên bản mà caller đọc (optimistic concurrency)
+	ErrVersionMismatch = errors.New("version mismatch")
+	// ErrAuthLocked: khóa xác thực đang bị chặn (xem LockoutStore.ReserveAuthAttempt)
+	ErrAuthLocked = errors.New("authentication locked")
+)
+
+// User là một tài khoản trong bảng users
+type User struct {
+	ID           string
+	Username     string
+	PasswordHash string
+	KdfSalt      string
+	// SearchKeyEnc: K_Search bọc bởi K_Master ("" = K_Search suy ra từ K_Master)
+	SearchKeyEnc string
+	// AuthVersion: PasswordHash là hash của mật khẩu (AuthVersionPassword) hay của login key (AuthVersionLoginKey)
+	AuthVersion int
+	CreatedAt   string
+}
+
+// DHKey là DH public key của user (bảng user_keys)
+type DHKey struct {
+	UserID    string
+	Username  string
+	PublicKey string
+	UpdatedAt string
+}
+
+// Note là một ghi chú đã mã hóa (bảng notes)
+type Note struct {
+	ID          string
+	UserID      string
+	TitleEnc    string
+	ContentEnc  string // Chỉ dùng cho row cũ chưa chuyển sang blob store
+	ContentRef  string // Key trong BlobStore ("" nếu content còn inline)
+	ContentSize int64
+	KeyEnc      string
+	IVMeta      string
+	Version     int // Số phiên bản hiện tại, tăng mỗi lần sửa
+	CreatedAt   string
+	UpdatedAt   string
+
+	// FolderID ("" = thư mục gốc) và TagIDs được đọc kèm note nhưng chỉ được ghi
+	// qua FolderStore (MoveNote, SetNoteTags), không qua CreateNote/UpdateNote
+	FolderID string
+	TagIDs   []string
+
+	// DeletedAt: thời điểm note bị chuyển vào thùng rác ("" = chưa bị xóa)
+	DeletedAt string
+
+	// SearchTokens là blind index của note. Chỉ được ghi (CreateNote, UpdateNote),
+	// không được đọc kèm note; nil khi UpdateNote nghĩa là giữ token hiện tại.
+	SearchTokens []string
+}
+
+// Phạm vi của ListNotes
+const (
+	NoteScopeOwned  = "owned"  // note của user
+	NoteScopeShared = "shared" // note người khác share cho user (share còn hiệu lực)
+	NoteScopeAll    = "all"
+)
+
+// Thứ tự sắp xếp của ListNotes
+const (
+	NoteSortCreated = "created"
+	NoteSortUpdated = "updated"
+	NoteSortSize    = "size"
+)
+
+// NoteCursor là vị trí sau note cuối của trang trước: giá trị sort của note đó
+// (Time với created/updated, Size với size) và ID để phân biệt giá trị trùng
+type NoteCursor struct {
+	Time string
+	Size int64
+	ID   string
+}
+
+// NoteQuery lọc, sắp xếp và phân trang ListNotes
+type NoteQuery struct {
+	Scope string
+	// Tokens: note phải có đủ mọi token (AND); blind index chỉ có trên note của user
+	Tokens []string
+	// CreatedFrom <= created_at < CreatedTo (định dạng sqliteTimeFormat), rỗng = không giới hạn
+	CreatedFrom string
+	CreatedTo   string
+	// FolderIDs: note nằm trong một trong các folder ("" = thư mục gốc); TagIDs: note có
+	// đủ mọi tag. Folder và tag là của user nên hai filter này chỉ khớp note của user
+	FolderIDs []string
+	TagIDs    []string
+
+	Sort      string
+	Ascending bool
+	Limit     int
+	After     *NoteCursor
+}
+
+// NoteListEntry là một note trong kết quả ListNotes (không kèm content, key_enc, iv_meta)
+type NoteListEntry struct {
+	Note
+	Shared        bool   // Note của người khác share cho user
+	OwnerUsername string // Chỉ có khi Shared
+}
+
+// NotePage là một trang kết quả ListNotes
+type NotePage struct {
+	Notes     []NoteListEntry
+	HasMore   bool
+	Total     int   // Số note khớp filter (mọi trang)
+	TotalSize int64 // Tổng content_size của các note đó
+}
+
+// NoteVersion là một phiên bản cũ của note (bảng note_versions)
+type NoteVersion struct {
+	NoteID      string
+	Version     int
+	TitleEnc    string
+	ContentEnc  string // Chỉ dùng cho row cũ chưa chuyển sang blob store
+	ContentRef  string
+	ContentSize int64
+	KeyEnc      string
+	IVMeta      string
+	CreatedAt   string // Thời điểm phiên bản được ghi
+	ArchivedAt  string // Thời điểm bị thay bởi phiên bản mới hơn
+
+	SearchTokens []string // Blind index của phiên bản, chỉ có trong GetNoteVersion
+}
+
+// NoteShare là quyền truy cập note của một user khác (bảng note_shares)
+type NoteShare struct {
+	ID                string
+	NoteID            string
+	RecipientID       string
+	RecipientUsername string
+	WrappedKey        string
+	SenderPublicKey   string
+	CreatedAt         string
+}
+
+// Folder là một thư mục của user (bảng folders); tên được mã hóa bằng K_Master
+type Folder struct {
+	ID        string
+	UserID    string
+	ParentID  string // "" = thư mục gốc
+	NameEnc   string
+	CreatedAt string
+	UpdatedAt string
+}
+
+// Tag là một nhãn của user (bảng tags); nhãn được mã hóa bằng K_Master
+type Tag struct {
+	ID        string
+	UserID    string
+	LabelEnc  string
+	CreatedAt string
+}
+
+// FolderShare ghi lại việc share cả folder cho user khác (bảng folder_shares).
+// Quyền đọc từng note vẫn nằm trong note_shares.
+type FolderShare struct {
+	ID                string
+	FolderID          string
+	RecipientID       string
+	RecipientUsername string
+	CreatedAt         string
+}
+
+// ShareLink là link chia sẻ tạm thời (bảng shared_links)
+type ShareLink struct {
+	ID           string
+	OwnerID      string
+	ContentEnc   string // Chỉ dùng cho row cũ chưa chuyển sang blob store
+	ContentRef   string // Key trong BlobStore ("" nếu content còn inline)
+	ContentSize  int64
+	ExpiresAt    *string
+	MaxViews     *int
+	CurrentViews int
+	HasPassword  bool
+	AccessHash   *string
+	IsActive     bool
+	CreatedAt    string
+	// NoteID: note mà link được tạo từ đó ("" = không gắn với note)
+	NoteID string
+}
+
+// UploadSession là một phiên upload chunk đang dở (bảng upload_sessions)
+type UploadSession struct {
+	ID        string
+	UserID    string
+	TitleEnc  string
+	KeyEnc    string
+	IVMeta    string
+	TotalSize int64
+	ChunkSize int64
+	CreatedAt string
+	ExpiresAt string
+
+	SearchTokens []string // Gán cho note khi finalize
+}
+
+// ChunkCount là số chunk cần nhận để hoàn tất session
+func (u *UploadSession) ChunkCount() int {
+	return int((u.TotalSize + u.ChunkSize - 1) / u.ChunkSize)
+}
+
+// ChunkLength là kích thước bắt buộc của chunk index (chunk cuối có thể ngắn hơn)
+func (u *UploadSession) ChunkLength(index int) int64 {
+	if index == u.ChunkCount()-1 {
+		return u.TotalSize - int64(index)*u.ChunkSize
+	}
+	return u.ChunkSize
+}
+
+// UploadChunk là metadata của một chunk đã nhận; dữ liệu nằm trong BlobStore với key SHA256
+type UploadChunk struct {
+	Index  int
+	Size   int64
+	SHA256 string
+}
+
+// Loại row có cột content_enc/content_ref
+const (
+	ContentKindNote      = "note"
+	ContentKindShareLink = "share_link"
+)
+
+// ContentRow là nội dung của một note hoặc share link, dùng khi chuyển giữa
+// content_enc inline và blob store
+type ContentRow struct {
+	Kind       string
+	ID         string
+	ContentEnc string
+	Ref        string
+	Size       int64
+}
+
+// RefreshToken là bản ghi refresh token (chỉ lưu SHA256 của token)
+type RefreshToken struct {
+	ID        string
+	UserID    string
+	Username  string
+	TokenHash string
+	FamilyID  string
+	ExpiresAt string
+	IsRevoked bool
+}
+
+// TOTP là cấu hình xác thực hai bước của user (bảng user_totp)
+type TOTP struct {
+	UserID    string
+	Secret    string // Base32; server cần đọc được để kiểm tra mã
+	Enabled   bool   // false = đã enroll nhưng chưa xác nhận mã đầu tiên
+	LastStep  int64  // Time step của mã cuối cùng được chấp nhận
+	CreatedAt string
+	EnabledAt string
+
+	RecoveryCodesLeft int // Số recovery code chưa dùng (chỉ đọc)
+}
+
+// RecoveryKey là recovery key của user (bảng recovery_keys). Server chỉ có
+// SHA256 của auth key và hai giá trị đã mã hóa, không mở được K_Master.
+type RecoveryKey struct {
+	UserID       string
+	Verifier     string // Hex SHA256(auth key)
+	MasterKeyEnc string // K_Master bọc bởi wrap key
+	KeyEnc       string // Wrap key bọc bởi K_Master
+	CreatedAt    string
+	UpdatedAt    string
+}
+
+// WrappedKey là một giá trị được mã hóa bằng K_Master: K_Note của note, phiên bản
+// hoặc upload session (Enc = key_enc, kèm iv_meta), tên folder hoặc nhãn tag
+type WrappedKey struct {
+	ID      string // Note, upload, folder hoặc tag ID (note ID với phiên bản)
+	Version int    // Chỉ dùng cho phiên bản note
+	Enc     string
+	IVMeta  string // "" với folder và tag
+}
+
+// Keyring là mọi dữ liệu của user được mã hóa bằng K_Master (GET /api/me/keyring).
+// Khi đổi mật khẩu client gửi lại đúng các phần tử này, đã bọc bằng K_Master mới.
+type Keyring struct {
+	Notes        []WrappedKey // Kể cả note trong thùng rác
+	Versions     []WrappedKey
+	Uploads      []WrappedKey // Upload session chưa finalize
+	Folders      []WrappedKey
+	Tags         []WrappedKey
+	SearchKeyEnc string
+
+	// Recovery key (nếu user đã tạo): K_Master mới bọc bởi wrap key và wrap key
+	// bọc bởi K_Master mới; cả hai "" nếu không có
+	RecoveryMasterKeyEnc string
+	RecoveryKeyEnc       string
+}
+
+// sameEntries cho biết other có đúng các phần tử (theo ID và version) của k,
+// kể cả recovery key
+func (k *Keyring) sameEntries(other *Keyring) bool {
+	same := func(a, b []WrappedKey) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		ids := make(map[WrappedKey]bool, len(a))
+		for _, e := range a {
+			ids[WrappedKey{ID: e.ID, Version: e.Version}] = true
+		}
+		for _, e := range b {
+			id := WrappedKey{ID: e.ID, Version: e.Version}
+			if !ids[id] {
+				return false
+			}
+			delete(ids, id)
+		}
+		return true
+	}
+	return same(k.Notes, other.Notes) && same(k.Versions, other.Versions) &&
+		same(k.Uploads, other.Uploads) && same(k.Folders, other.Folders) && same(k.Tags, other.Tags) &&
+		(k.RecoveryKeyEnc == "") == (other.RecoveryKeyEnc == "")
+}
+
+// AuthFailure là số lần xác thực sai liên tiếp của một khóa (bảng auth_failures)
+type AuthFailure struct {
+	Scope         string // AuthScopeAccount, AuthScopeShareLink hoặc AuthScopeIP
+	Key           string
+	Failures      int
+	FirstFailedAt string
+	LastFailedAt  string
+	LockedUntil   string // "" = không bị chặn
+}
+
+// Các scope của AuthFailure và AuditEvent
+const (
+	AuthScopeAccount   = "account"
+	AuthScopeShareLink = "share_link"
+	AuthScopeIP        = "ip"
+)
+
+// AuditEvent là một sự kiện bảo mật (bảng audit_log)
+type AuditEvent struct {
+	ID        int64
+	Event     string // AuditEventLockout hoặc AuditEventRecovery
+	Scope     string
+	Subject   string
+	IP        string
+	Detail    string
+	CreatedAt string
+}
+
+const (
+	// AuditEventLockout: một khóa vừa bị khóa tạm thời vì quá nhiều lần sai
+	AuditEventLockout = "lockout"
+	// AuditEventRecovery: mật khẩu của tài khoản vừa được đặt lại bằng recovery key
+	AuditEventRecovery = "recovery"
+)
+
+// Usage là dung lượng một user đang dùng (GET /api/me/usage, lệnh quota).
+// Kích thước là số byte ciphertext; phiên bản cũ dùng chung blob với note vẫn
+// được tính riêng vì user có thể xóa note mà giữ lại lịch sử.
+type Usage struct {
+	UserID   string
+	Username string
+	// QuotaBytes: quota ghi đè của user (nil = quota mặc định, 0 = không giới hạn)
+	QuotaBytes *int64
+
+	NoteBytes    int64 // Note của user, kể cả note trong thùng rác
+	VersionBytes int64 // Phiên bản cũ của các note đó
+	LinkBytes    int64 // Share link còn hiệu lực
+	UploadBytes  int64 // Upload session chưa finalize và chưa hết hạn (TotalSize đã khai báo)
+
+	NoteCount    int // Note không nằm trong thùng rác
+	TrashedCount int
+	ActiveLinks  int
+}
+
+// Bytes là dung lượng được tính vào quota (không gồm upload đang dở)
+func (u *Usage) Bytes() int64 {
+	return u.NoteBytes + u.VersionBytes + u.LinkBytes
+}
+
+// UserStore quản lý tài khoản và DH public key
+type UserStore interface {
+	CreateUser(ctx context.Context, u *User) error
+	GetUserByID(ctx context.Context, id string) (*User, error)
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	PutDHKey(ctx context.Context, userID string, publicKey string) error
+	GetDHKeyByUserID(ctx context.Context, userID string) (*DHKey, error)
+	GetDHKeyByUsername(ctx context.Context, username string) (*DHKey, error)
+	// CountUsers trả về tổng số user và số user còn auth_version = AuthVersionPassword
+	CountUsers(ctx context.Context) (total int, legacy int, err error)
+}
+
+// NoteStore quản lý ghi chú và chia sẻ note cho user khác
+type NoteStore interface {
+	CreateNote(ctx context.Context, n *Note) error
+	GetNote(ctx context.Context, id string) (*Note, error)
+	// ListNotes trả về một trang metadata note mà user sở hữu hoặc được share, theo q
+	// (không gồm note trong thùng rác)
+	ListNotes(ctx context.Context, userID string, q NoteQuery) (*NotePage, error)
+	// UpdateNote lưu trạng thái hiện tại vào note_versions rồi ghi title, content, key
+	// và iv_meta của n trong cùng một transaction; gán Version và UpdatedAt mới vào n.
+	// expectedVersion > 0: trả ErrVersionMismatch nếu version hiện tại khác (0 = không kiểm tra)
+	UpdateNote(ctx context.Context, n *Note, expectedVersion int) error
+	// TrashNote chuyển note vào thùng rác và trả về deleted_at; expectedVersion như UpdateNote.
+	// Trả về ErrNotFound nếu note không tồn tại hoặc đã nằm trong thùng rác
+	TrashNote(ctx context.Context, id string, expectedVersion int) (string, error)
+	// RestoreNote đưa note ra khỏi thùng rác (ErrNotFound nếu note không nằm trong thùng rác)
+	RestoreNote(ctx context.Context, id string) error
+	// ListTrash trả về metadata các note trong thùng rác của user (kèm key_enc, iv_meta để
+	// client giải mã title, không kèm content), mới bị xóa nhất trước
+	ListTrash(ctx context.Context, userID string) ([]Note, error)
+	// DeleteNote xóa vĩnh viễn note cùng toàn bộ phiên bản cũ, share và tag của nó, đồng thời
+	// vô hiệu hóa share link tạo từ note; expectedVersion như UpdateNote
+	DeleteNote(ctx context.Context, id string, expectedVersion int) error
+
+	// ListNoteVersions trả về các phiên bản cũ (không kèm content), mới nhất trước
+	ListNoteVersions(ctx context.Context, noteID string) ([]NoteVersion, error)
+	GetNoteVersion(ctx context.Context, noteID string, version int) (*NoteVersion, error)
+
+	// UpsertNoteShare tạo hoặc kích hoạt lại share, gán ID vào s
+	UpsertNoteShare(ctx context.Context, s *NoteShare) error
+	ListNoteShares(ctx context.Context, noteID string) ([]NoteShare, error)
+	GetActiveNoteShare(ctx context.Context, noteID string, recipientID string) (*NoteShare, error)
+	// RevokeNoteShare thu hồi theo share id hoặc recipient id
+	RevokeNoteShare(ctx context.Context, noteID string, shareOrRecipientID string) error
+}
+
+// FolderStore quản lý folder, tag và việc share cả folder
+type FolderStore interface {
+	CreateFolder(ctx context.Context, f *Folder) error
+	GetFolder(ctx context.Context, id string) (*Folder, error)
+	// ListFolders trả về mọi folder của user (client tự dựng cây theo ParentID)
+	ListFolders(ctx context.Context, userID string) ([]Folder, error)
+	// UpdateFolder ghi name_enc và parent_id của f, gán UpdatedAt mới
+	UpdateFolder(ctx context.Context, f *Folder) error
+	// DeleteFolder xóa folder; note và folder con của nó được chuyển lên folder cha
+	DeleteFolder(ctx context.Context, id string) error
+	// MoveNote chuyển note vào folderID ("" = thư mục gốc)
+	MoveNote(ctx context.Context, noteID string, folderID string) error
+	// ListFolderNoteIDs trả về id các note nằm trực tiếp trong một trong các folder;
+	// note trong thùng rác chỉ được tính khi includeTrashed
+	ListFolderNoteIDs(ctx context.Context, folderIDs []string, includeTrashed bool) ([]string, error)
+
+	CreateTag(ctx context.Context, t *Tag) error
+	GetTag(ctx context.Context, id string) (*Tag, error)
+	ListTags(ctx context.Context, userID string) ([]Tag, error)
+	UpdateTag(ctx context.Context, t *Tag) error
+	// DeleteTag xóa tag và gỡ nó khỏi mọi note
+	DeleteTag(ctx context.Context, id string) error
+	// SetNoteTags thay toàn bộ tag của note bằng tagIDs
+	SetNoteTags(ctx context.Context, noteID string, tagIDs []string) error
+
+	// ShareFolder tạo hoặc kích hoạt lại folder share và share từng note trong notes
+	// (như UpsertNoteShare) trong cùng một transaction; gán ID vào fs
+	ShareFolder(ctx context.Context, fs *FolderShare, notes []NoteShare) error
+	ListFolderShares(ctx context.Context, folderID string) ([]FolderShare, error)
+	// RevokeFolderShare thu hồi folder share của recipientID cùng share của các note noteIDs
+	RevokeFolderShare(ctx context.Context, folderID string, recipientID string, noteIDs []string) error
+}
+
+// ShareLinkStore quản lý link chia sẻ tạm thời
+type ShareLinkStore interface {
+	CreateShareLink(ctx context.Context, l *ShareLink) error
+	GetShareLink(ctx context.Context, id string) (*ShareLink, error)
+	// IncrementShareLinkViews tăng current_views nếu link chưa đạt max_views (kiểm tra
+	// và tăng trong một thao tác); ErrNotFound nếu không tăng được
+	IncrementShareLinkViews(ctx context.Context, id string) error
+	DeactivateShareLink(ctx context.Context, id string) error
+}
+
+// UploadStore quản lý các phiên upload chunk có thể resume
+type UploadStore interface {
+	CreateUpload(ctx context.Context, u *UploadSession) error
+	GetUpload(ctx context.Context, id string) (*UploadSession, error)
+	// PutUploadChunk lưu (hoặc ghi đè) chunk index của session; dữ liệu đã nằm trong BlobStore
+	PutUploadChunk(ctx context.Context, sessionID string, chunk UploadChunk) error
+	// ListUploadChunks trả về các chunk đã nhận, theo thứ tự index
+	ListUploadChunks(ctx context.Context, sessionID string) ([]UploadChunk, error)
+	// DeleteUpload xóa session cùng toàn bộ chunk của nó
+	DeleteUpload(ctx context.Context, id string) error
+}
+
+// BlobRefStore cho biết blob nào còn được tham chiếu và chuyển content giữa
+// content_enc inline và blob store
+type BlobRefStore interface {
+	// ReferencedBlobs trả về mọi key đang được note, phiên bản cũ, share link hoặc upload chunk trỏ tới
+	ReferencedBlobs(ctx context.Context) (map[string]struct{}, error)
+	// ListInlineContent trả về tối đa limit row của kind còn content inline, id > afterID
+	ListInlineContent(ctx context.Context, kind string, afterID string, limit int) ([]ContentRow, error)
+	// ListBlobContent trả về tối đa limit row của kind đã có content_ref, id > afterID
+	ListBlobContent(ctx context.Context, kind string, afterID string, limit int) ([]ContentRow, error)
+	// SetContentRef ghi content_enc, content_ref và content_size của row
+	SetContentRef(ctx context.Context, row ContentRow) error
+}
+
+// TokenStore quản lý refresh token và blacklist access token
+type TokenStore interface {
+	CreateRefreshToken(ctx context.Context, t *RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error)
+	// RotateRefreshToken đánh dấu token cũ is_revoked và lưu token mới trong cùng
+	// một transaction; trả về ErrRefreshTokenReused nếu token cũ đã bị revoke
+	RotateRefreshToken(ctx context.Context, oldID string, next *RefreshToken) error
+	RevokeRefreshTokenFamily(ctx context.Context, userID string, familyID string) error
+	DeleteRefreshToken(ctx context.Context, tokenHash string) error
+	BlacklistToken(ctx context.Context, jti string, expiresAt string) error
+	IsTokenBlacklisted(ctx context.Context, jti string) (bool, error)
+	// ConsumeToken đưa jti của token dùng một lần vào blacklist nếu chưa có (kiểm tra
+	// và ghi trong một thao tác); ErrConflict nếu token đã được dùng
+	ConsumeToken(ctx context.Context, jti string, expiresAt string) error
+}
+
+// MFAStore quản lý TOTP và recovery code của user
+type MFAStore interface {
+	GetTOTP(ctx context.Context, userID string) (*TOTP, error)
+	// PutTOTP tạo hoặc thay secret đang chờ xác nhận; ErrConflict nếu 2FA đã bật
+	PutTOTP(ctx context.Context, userID string, secret string) error
+	// EnableTOTP bật 2FA với step của mã vừa xác nhận và thay toàn bộ recovery code;
+	// ErrNotFound nếu không có secret đang chờ xác nhận
+	EnableTOTP(ctx context.Context, userID string, step int64, codeHashes []string) error
+	// UseTOTPStep ghi nhận mã của step; ErrConflict nếu step không mới hơn mã đã dùng (replay)
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	// UseRecoveryCode đánh dấu code đã dùng; ErrNotFound nếu không có hoặc đã dùng
+	UseRecoveryCode(ctx context.Context, userID string, codeHash string) error
+	// DeleteTOTP tắt 2FA: xóa secret và mọi recovery code
+	DeleteTOTP(ctx context.Context, userID string) error
+}
+
+// KeyringStore đọc và thay toàn bộ dữ liệu bọc bởi K_Master của user
+type KeyringStore interface {
+	GetKeyring(ctx context.Context, userID string) (*Keyring, error)
+	// ChangePassword ghi hash login key mới (auth_version = AuthVersionLoginKey),
+	// thay mọi phần tử của keyring trong một transaction rồi xóa mọi refresh token
+	// của user. ErrConflict nếu keyring không gồm đúng các note, phiên bản, upload,
+	// folder, tag và recovery key hiện có (dữ liệu đã thay đổi sau GetKeyring); khi
+	// đó không có gì được ghi.
+	ChangePassword(ctx context.Context, userID, passwordHash, kdfSalt string, k *Keyring) error
+}
+
+// RecoveryStore quản lý recovery key của user
+type RecoveryStore interface {
+	// GetRecoveryKey trả về ErrNotFound nếu user chưa tạo recovery key
+	GetRecoveryKey(ctx context.Context, userID string) (*RecoveryKey, error)
+	// PutRecoveryKey tạo hoặc thay recovery key của user
+	PutRecoveryKey(ctx context.Context, r *RecoveryKey) error
+	// DeleteRecoveryKey trả về ErrNotFound nếu user chưa tạo recovery key
+	DeleteRecoveryKey(ctx context.Context, userID string) error
+}
+
+// LockoutStore đếm số lần xác thực sai và ghi audit log
+type LockoutStore interface {
+	// GetAuthFailure trả về ErrNotFound nếu khóa chưa có lần sai nào
+	GetAuthFailure(ctx context.Context, scope, key string) (*AuthFailure, error)
+	// ReserveAuthAttempt tính trước một lần sai tại thời điểm now (bộ đếm bắt đầu lại
+	// từ 1 nếu lần sai trước đó cũ hơn windowStart) và chặn khóa đến lockUntil nếu bộ
+	// đếm đạt maxFailures. ErrAuthLocked nếu khóa đang bị chặn tại now. Tăng, so ngưỡng
+	// và chặn nằm trong một thao tác nên request song song không thử quá maxFailures lần.
+	ReserveAuthAttempt(ctx context.Context, scope, key, now, windowStart string, maxFailures int, lockUntil string) (*AuthFailure, error)
+	// ReleaseAuthAttempt hoàn lại một lần đã tính trước; bỏ chặn nếu khóa vẫn bị chặn
+	// đến lockedUntil (chặn do chính lần đó đặt)
+	ReleaseAuthAttempt(ctx context.Context, scope, key, lockedUntil string) error
+	// LockAuth chặn khóa đến until
+	LockAuth(ctx context.Context, scope, key, until string) error
+	// ClearAuthFailures xóa bộ đếm sau khi xác thực thành công
+	ClearAuthFailures(ctx context.Context, scope, key string) error
+	AddAuditEvent(ctx context.Context, e *AuditEvent) error
+	// ListAuditEvents trả về tối đa limit sự kiện mới nhất
+	ListAuditEvents(ctx context.Context, limit int) ([]AuditEvent, error)
+}
+
+// QuotaStore tính dung lượng đã dùng và lưu quota ghi đè của từng user
+type QuotaStore interface {
+	GetUsage(ctx context.Context, userID string) (*Usage, error)
+	// ListTopUsage trả về tối đa limit user dùng nhiều dung lượng nhất (theo Bytes)
+	ListTopUsage(ctx context.Context, limit int) ([]Usage, error)
+	// SetUserQuota ghi quota của user (nil = quay về quota mặc định)
+	SetUserQuota(ctx context.Context, userID string, quota *int64) error
+}
+
+// MaintenanceStore chứa các truy vấn dọn dẹp định kỳ (trước đây là "run via cron"
+// trong 001_init_schema.sql). Mỗi hàm trả về số bản ghi bị ảnh hưởng.
+type MaintenanceStore interface {
+	PurgeExpiredRefreshTokens(ctx context.Context) (int64, error)
+	PurgeExpiredBlacklist(ctx context.Context) (int64, error)
+	DeactivateExpiredShareLinks(ctx context.Context) (int64, error)
+	DeactivateExhaustedShareLinks(ctx context.Context) (int64, error)
+	PurgeExpiredUploads(ctx context.Context) (int64, error)
+	// PurgeTrashedNotes xóa vĩnh viễn (như DeleteNote) các note nằm trong thùng rác từ trước before
+	PurgeTrashedNotes(ctx context.Context, before string) (int64, error)
+	// PurgeAuthFailures xóa bộ đếm có lần sai cuối trước before và không còn bị chặn
+	PurgeAuthFailures(ctx context.Context, before string) (int64, error)
+}
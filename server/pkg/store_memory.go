@@ -0,0 +1,1620 @@
+package serverpkg
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore là cài đặt in-memory của các store, dùng cho test handler
+// không cần file database. Dữ liệu mất khi tiến trình kết thúc.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	users         map[string]*User
+	dhKeys        map[string]*DHKey
+	notes         map[string]*Note
+	noteVersions  map[string][]NoteVersion // note id -> phiên bản cũ, cũ nhất trước
+	noteShares    map[string]*memoryNoteShare
+	folders       map[string]*Folder
+	tags          map[string]*Tag
+	folderShares  map[string]*memoryFolderShare
+	shareLinks    map[string]*ShareLink
+	uploads       map[string]*memoryUpload
+	refreshTokens map[string]*RefreshToken // key: token hash
+	blacklist     map[string]string        // jti -> expires_at
+	quotas        map[string]int64         // user id -> quota ghi đè (không có = quota mặc định)
+	totp          map[string]*TOTP
+	recoveryCodes map[string]map[string]bool // user id -> code hash -> đã dùng
+	recoveryKeys  map[string]*RecoveryKey    // key: user id
+	authFailures  map[string]*AuthFailure    // key: memoryAuthKey(scope, key)
+	auditLog      []AuditEvent               // cũ nhất trước
+}
+
+type memoryNoteShare struct {
+	NoteShare
+	revoked bool
+}
+
+type memoryFolderShare struct {
+	FolderShare
+	revoked bool
+}
+
+type memoryUpload struct {
+	UploadSession
+	chunks map[int]UploadChunk
+}
+
+// NewMemoryStore tạo store rỗng
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:         map[string]*User{},
+		dhKeys:        map[string]*DHKey{},
+		notes:         map[string]*Note{},
+		noteVersions:  map[string][]NoteVersion{},
+		noteShares:    map[string]*memoryNoteShare{},
+		folders:       map[string]*Folder{},
+		tags:          map[string]*Tag{},
+		folderShares:  map[string]*memoryFolderShare{},
+		shareLinks:    map[string]*ShareLink{},
+		uploads:       map[string]*memoryUpload{},
+		refreshTokens: map[string]*RefreshToken{},
+		blacklist:     map[string]string{},
+		quotas:        map[string]int64{},
+		totp:          map[string]*TOTP{},
+		recoveryCodes: map[string]map[string]bool{},
+		recoveryKeys:  map[string]*RecoveryKey{},
+		authFailures:  map[string]*AuthFailure{},
+	}
+}
+
+func memoryNow() string {
+	return sqliteTime(time.Now())
+}
+
+// ============================================================
+// UserStore
+// ============================================================
+
+func (m *MemoryStore) CreateUser(ctx context.Context, u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.users {
+		if existing.Username == u.Username {
+			return ErrConflict
+		}
+	}
+	if _, ok := m.users[u.ID]; ok {
+		return ErrConflict
+	}
+	cp := *u
+	cp.CreatedAt = memoryNow()
+	m.users[u.ID] = &cp
+	return nil
+}
+
+func (m *MemoryStore) GetUserByID(ctx context.Context, id string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *u
+	return &cp, nil
+}
+
+func (m *MemoryStore) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, u := range m.users {
+		if u.Username == username {
+			cp := *u
+			return &cp, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) PutDHKey(ctx context.Context, userID string, publicKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[userID]; !ok {
+		return ErrNotFound
+	}
+	m.dhKeys[userID] = &DHKey{UserID: userID, PublicKey: publicKey, UpdatedAt: memoryNow()}
+	return nil
+}
+
+func (m *MemoryStore) GetDHKeyByUserID(ctx context.Context, userID string) (*DHKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	k, ok := m.dhKeys[userID]
+	u, uok := m.users[userID]
+	if !ok || !uok {
+		return nil, ErrNotFound
+	}
+	cp := *k
+	cp.Username = u.Username
+	return &cp, nil
+}
+
+func (m *MemoryStore) GetDHKeyByUsername(ctx context.Context, username string) (*DHKey, error) {
+	u, err := m.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	return m.GetDHKeyByUserID(ctx, u.ID)
+}
+
+func (m *MemoryStore) CountUsers(ctx context.Context) (int, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	legacy := 0
+	for _, u := range m.users {
+		if u.AuthVersion == AuthVersionPassword {
+			legacy++
+		}
+	}
+	return len(m.users), legacy, nil
+}
+
+// ============================================================
+// NoteStore
+// ============================================================
+
+func (m *MemoryStore) CreateNote(ctx context.Context, n *Note) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.notes[n.ID]; ok {
+		return ErrConflict
+	}
+	cp := *n
+	cp.Version = 1
+	cp.SearchTokens = slices.Clone(n.SearchTokens)
+	cp.FolderID, cp.TagIDs, cp.DeletedAt = "", nil, ""
+	cp.CreatedAt = memoryNow()
+	cp.UpdatedAt = cp.CreatedAt
+	m.notes[n.ID] = &cp
+	return nil
+}
+
+func (m *MemoryStore) GetNote(ctx context.Context, id string) (*Note, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n, ok := m.notes[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *n
+	cp.SearchTokens = nil
+	cp.TagIDs = slices.Clone(n.TagIDs)
+	return &cp, nil
+}
+
+// hasAllTokens: note có đủ mọi token của filter
+func hasAllTokens(n *Note, tokens []string) bool {
+	for _, want := range tokens {
+		if !slices.Contains(n.SearchTokens, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasAllTags: note có đủ mọi tag của filter
+func hasAllTags(n *Note, tagIDs []string) bool {
+	for _, want := range tagIDs {
+		if !slices.Contains(n.TagIDs, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// memoryNoteSize: content_size, hoặc ước lượng từ base64 với note cũ còn inline
+func memoryNoteSize(n *Note) int64 {
+	return contentSize(n.ContentRef, n.ContentEnc, n.ContentSize)
+}
+
+// compareNotes so sánh theo kiểu sort rồi theo ID (tăng dần)
+func compareNotes(sortBy string, a NoteListEntry, b NoteCursor) int {
+	var c int
+	switch sortBy {
+	case NoteSortSize:
+		c = cmp.Compare(a.ContentSize, b.Size)
+	case NoteSortUpdated:
+		c = cmp.Compare(a.UpdatedAt, b.Time)
+	default:
+		c = cmp.Compare(a.CreatedAt, b.Time)
+	}
+	if c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// noteCursorOf là cursor trỏ tới e
+func noteCursorOf(sortBy string, e NoteListEntry) NoteCursor {
+	c := NoteCursor{ID: e.ID, Size: e.ContentSize, Time: e.CreatedAt}
+	if sortBy == NoteSortUpdated {
+		c.Time = e.UpdatedAt
+	}
+	return c
+}
+
+func (m *MemoryStore) ListNotes(ctx context.Context, userID string, q NoteQuery) (*NotePage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sharedWithUser := map[string]bool{}
+	for _, ns := range m.noteShares {
+		if ns.RecipientID == userID && !ns.revoked {
+			sharedWithUser[ns.NoteID] = true
+		}
+	}
+
+	page := &NotePage{Notes: []NoteListEntry{}}
+	var matched []NoteListEntry
+	for _, n := range m.notes {
+		if n.DeletedAt != "" {
+			continue
+		}
+		owned := n.UserID == userID
+		shared := !owned && sharedWithUser[n.ID]
+		switch q.Scope {
+		case NoteScopeShared:
+			if !shared {
+				continue
+			}
+		case NoteScopeAll:
+			if !owned && !shared {
+				continue
+			}
+		default:
+			if !owned {
+				continue
+			}
+		}
+		if (len(q.FolderIDs) > 0 || len(q.TagIDs) > 0) && !owned {
+			continue
+		}
+		if len(q.FolderIDs) > 0 && !slices.Contains(q.FolderIDs, n.FolderID) {
+			continue
+		}
+		if !hasAllTokens(n, q.Tokens) || !hasAllTags(n, q.TagIDs) ||
+			(q.CreatedFrom != "" && n.CreatedAt < q.CreatedFrom) ||
+			(q.CreatedTo != "" && n.CreatedAt >= q.CreatedTo) {
+			continue
+		}
+
+		e := NoteListEntry{Note: *n, Shared: shared}
+		e.ContentEnc, e.ContentRef, e.KeyEnc, e.IVMeta, e.SearchTokens = "", "", "", "", nil
+		e.ContentSize = memoryNoteSize(n)
+		e.TagIDs = slices.Clone(n.TagIDs)
+		if shared {
+			if u, ok := m.users[n.UserID]; ok {
+				e.OwnerUsername = u.Username
+			}
+			e.FolderID, e.TagIDs = "", nil
+		}
+		page.Total++
+		page.TotalSize += e.ContentSize
+		matched = append(matched, e)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		c := compareNotes(q.Sort, matched[i], noteCursorOf(q.Sort, matched[j]))
+		if q.Ascending {
+			return c < 0
+		}
+		return c > 0
+	})
+	for _, e := range matched {
+		if q.After != nil {
+			c := compareNotes(q.Sort, e, *q.After)
+			if (q.Ascending && c <= 0) || (!q.Ascending && c >= 0) {
+				continue
+			}
+		}
+		if len(page.Notes) == q.Limit {
+			page.HasMore = true
+			break
+		}
+		page.Notes = append(page.Notes, e)
+	}
+	return page, nil
+}
+
+func (m *MemoryStore) UpdateNote(ctx context.Context, n *Note, expectedVersion int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.notes[n.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if expectedVersion != 0 && cur.Version != expectedVersion {
+		return ErrVersionMismatch
+	}
+	m.noteVersions[n.ID] = append(m.noteVersions[n.ID], NoteVersion{
+		NoteID:      cur.ID,
+		Version:     cur.Version,
+		TitleEnc:    cur.TitleEnc,
+		ContentEnc:  cur.ContentEnc,
+		ContentRef:  cur.ContentRef,
+		ContentSize: cur.ContentSize,
+		KeyEnc:      cur.KeyEnc,
+		IVMeta:      cur.IVMeta,
+		CreatedAt:   cur.UpdatedAt,
+		ArchivedAt:  memoryNow(),
+
+		SearchTokens: append([]string{}, cur.SearchTokens...),
+	})
+	cur.TitleEnc = n.TitleEnc
+	cur.ContentEnc = n.ContentEnc
+	cur.ContentRef = n.ContentRef
+	cur.ContentSize = n.ContentSize
+	cur.KeyEnc = n.KeyEnc
+	cur.IVMeta = n.IVMeta
+	if n.SearchTokens != nil {
+		cur.SearchTokens = append([]string{}, n.SearchTokens...)
+	}
+	cur.Version++
+	cur.UpdatedAt = memoryNow()
+	n.Version = cur.Version
+	n.UpdatedAt = cur.UpdatedAt
+	return nil
+}
+
+func (m *MemoryStore) ListNoteVersions(ctx context.Context, noteID string) ([]NoteVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	versions := m.noteVersions[noteID]
+	out := make([]NoteVersion, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		v.ContentEnc = ""
+		v.ContentRef = ""
+		v.SearchTokens = nil
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (m *MemoryStore) GetNoteVersion(ctx context.Context, noteID string, version int) (*NoteVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, v := range m.noteVersions[noteID] {
+		if v.Version == version {
+			cp := v
+			cp.SearchTokens = append([]string{}, v.SearchTokens...)
+			return &cp, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) TrashNote(ctx context.Context, id string, expectedVersion int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notes[id]
+	if !ok || n.DeletedAt != "" {
+		return "", ErrNotFound
+	}
+	if expectedVersion != 0 && n.Version != expectedVersion {
+		return "", ErrVersionMismatch
+	}
+	n.DeletedAt = memoryNow()
+	return n.DeletedAt, nil
+}
+
+func (m *MemoryStore) RestoreNote(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notes[id]
+	if !ok || n.DeletedAt == "" {
+		return ErrNotFound
+	}
+	n.DeletedAt = ""
+	return nil
+}
+
+func (m *MemoryStore) ListTrash(ctx context.Context, userID string) ([]Note, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	notes := []Note{}
+	for _, n := range m.notes {
+		if n.UserID != userID || n.DeletedAt == "" {
+			continue
+		}
+		cp := *n
+		cp.ContentEnc, cp.ContentRef, cp.SearchTokens, cp.TagIDs = "", "", nil, nil
+		cp.ContentSize = memoryNoteSize(n)
+		notes = append(notes, cp)
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		if notes[i].DeletedAt != notes[j].DeletedAt {
+			return notes[i].DeletedAt > notes[j].DeletedAt
+		}
+		return notes[i].ID > notes[j].ID
+	})
+	return notes, nil
+}
+
+func (m *MemoryStore) DeleteNote(ctx context.Context, id string, expectedVersion int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notes[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if expectedVersion != 0 && n.Version != expectedVersion {
+		return ErrVersionMismatch
+	}
+	m.deleteNote(id)
+	return nil
+}
+
+// deleteNote xóa note cùng phiên bản cũ, share và vô hiệu hóa share link tạo từ note.
+// Caller giữ m.mu.
+func (m *MemoryStore) deleteNote(id string) {
+	delete(m.notes, id)
+	delete(m.noteVersions, id)
+	// ON DELETE CASCADE
+	for sid, ns := range m.noteShares {
+		if ns.NoteID == id {
+			delete(m.noteShares, sid)
+		}
+	}
+	for _, l := range m.shareLinks {
+		if l.NoteID == id {
+			l.IsActive = false
+		}
+	}
+}
+
+func (m *MemoryStore) UpsertNoteShare(ctx context.Context, s *NoteShare) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.upsertNoteShare(s)
+	return nil
+}
+
+// upsertNoteShare: caller giữ m.mu
+func (m *MemoryStore) upsertNoteShare(s *NoteShare) {
+	for _, ns := range m.noteShares {
+		if ns.NoteID == s.NoteID && ns.RecipientID == s.RecipientID {
+			ns.WrappedKey = s.WrappedKey
+			ns.SenderPublicKey = s.SenderPublicKey
+			ns.CreatedAt = memoryNow()
+			ns.revoked = false
+			s.ID = ns.ID
+			s.CreatedAt = ns.CreatedAt
+			return
+		}
+	}
+	cp := *s
+	cp.CreatedAt = memoryNow()
+	m.noteShares[s.ID] = &memoryNoteShare{NoteShare: cp}
+	s.CreatedAt = cp.CreatedAt
+}
+
+func (m *MemoryStore) ListNoteShares(ctx context.Context, noteID string) ([]NoteShare, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	shares := []NoteShare{}
+	for _, ns := range m.noteShares {
+		if ns.NoteID != noteID || ns.revoked {
+			continue
+		}
+		cp := ns.NoteShare
+		if u, ok := m.users[ns.RecipientID]; ok {
+			cp.RecipientUsername = u.Username
+		}
+		shares = append(shares, cp)
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].CreatedAt > shares[j].CreatedAt })
+	return shares, nil
+}
+
+func (m *MemoryStore) GetActiveNoteShare(ctx context.Context, noteID string, recipientID string) (*NoteShare, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, ns := range m.noteShares {
+		if ns.NoteID == noteID && ns.RecipientID == recipientID && !ns.revoked {
+			cp := ns.NoteShare
+			return &cp, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) RevokeNoteShare(ctx context.Context, noteID string, shareOrRecipientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found := false
+	for _, ns := range m.noteShares {
+		if ns.NoteID == noteID && !ns.revoked && (ns.ID == shareOrRecipientID || ns.RecipientID == shareOrRecipientID) {
+			ns.revoked = true
+			found = true
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ============================================================
+// FolderStore
+// ============================================================
+
+func (m *MemoryStore) CreateFolder(ctx context.Context, f *Folder) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.folders[f.ID]; ok {
+		return ErrConflict
+	}
+	f.CreatedAt = memoryNow()
+	f.UpdatedAt = f.CreatedAt
+	cp := *f
+	m.folders[f.ID] = &cp
+	return nil
+}
+
+func (m *MemoryStore) GetFolder(ctx context.Context, id string) (*Folder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, ok := m.folders[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *f
+	return &cp, nil
+}
+
+func (m *MemoryStore) ListFolders(ctx context.Context, userID string) ([]Folder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	folders := []Folder{}
+	for _, f := range m.folders {
+		if f.UserID == userID {
+			folders = append(folders, *f)
+		}
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		if folders[i].CreatedAt != folders[j].CreatedAt {
+			return folders[i].CreatedAt < folders[j].CreatedAt
+		}
+		return folders[i].ID < folders[j].ID
+	})
+	return folders, nil
+}
+
+func (m *MemoryStore) UpdateFolder(ctx context.Context, f *Folder) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.folders[f.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	cur.NameEnc = f.NameEnc
+	cur.ParentID = f.ParentID
+	cur.UpdatedAt = memoryNow()
+	f.UpdatedAt = cur.UpdatedAt
+	return nil
+}
+
+func (m *MemoryStore) DeleteFolder(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.folders[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, n := range m.notes {
+		if n.FolderID == id {
+			n.FolderID = f.ParentID
+		}
+	}
+	for _, child := range m.folders {
+		if child.ParentID == id {
+			child.ParentID = f.ParentID
+		}
+	}
+	for sid, fs := range m.folderShares {
+		if fs.FolderID == id {
+			delete(m.folderShares, sid)
+		}
+	}
+	delete(m.folders, id)
+	return nil
+}
+
+func (m *MemoryStore) MoveNote(ctx context.Context, noteID string, folderID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notes[noteID]
+	if !ok {
+		return ErrNotFound
+	}
+	n.FolderID = folderID
+	return nil
+}
+
+func (m *MemoryStore) ListFolderNoteIDs(ctx context.Context, folderIDs []string, includeTrashed bool) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := []string{}
+	for _, n := range m.notes {
+		if n.DeletedAt != "" && !includeTrashed {
+			continue
+		}
+		if n.FolderID != "" && slices.Contains(folderIDs, n.FolderID) {
+			ids = append(ids, n.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (m *MemoryStore) CreateTag(ctx context.Context, t *Tag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tags[t.ID]; ok {
+		return ErrConflict
+	}
+	t.CreatedAt = memoryNow()
+	cp := *t
+	m.tags[t.ID] = &cp
+	return nil
+}
+
+func (m *MemoryStore) GetTag(ctx context.Context, id string) (*Tag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.tags[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *t
+	return &cp, nil
+}
+
+func (m *MemoryStore) ListTags(ctx context.Context, userID string) ([]Tag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tags := []Tag{}
+	for _, t := range m.tags {
+		if t.UserID == userID {
+			tags = append(tags, *t)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].CreatedAt != tags[j].CreatedAt {
+			return tags[i].CreatedAt < tags[j].CreatedAt
+		}
+		return tags[i].ID < tags[j].ID
+	})
+	return tags, nil
+}
+
+func (m *MemoryStore) UpdateTag(ctx context.Context, t *Tag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.tags[t.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	cur.LabelEnc = t.LabelEnc
+	return nil
+}
+
+func (m *MemoryStore) DeleteTag(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tags[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.tags, id)
+	for _, n := range m.notes {
+		n.TagIDs = slices.DeleteFunc(n.TagIDs, func(t string) bool { return t == id })
+	}
+	return nil
+}
+
+func (m *MemoryStore) SetNoteTags(ctx context.Context, noteID string, tagIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notes[noteID]
+	if !ok {
+		return ErrNotFound
+	}
+	n.TagIDs = nil
+	for _, id := range tagIDs {
+		if !slices.Contains(n.TagIDs, id) {
+			n.TagIDs = append(n.TagIDs, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) ShareFolder(ctx context.Context, fs *FolderShare, notes []NoteShare) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found := false
+	for _, existing := range m.folderShares {
+		if existing.FolderID == fs.FolderID && existing.RecipientID == fs.RecipientID {
+			existing.CreatedAt = memoryNow()
+			existing.revoked = false
+			fs.ID = existing.ID
+			fs.CreatedAt = existing.CreatedAt
+			found = true
+		}
+	}
+	if !found {
+		fs.CreatedAt = memoryNow()
+		m.folderShares[fs.ID] = &memoryFolderShare{FolderShare: *fs}
+	}
+	for i := range notes {
+		m.upsertNoteShare(&notes[i])
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListFolderShares(ctx context.Context, folderID string) ([]FolderShare, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	shares := []FolderShare{}
+	for _, fs := range m.folderShares {
+		if fs.FolderID != folderID || fs.revoked {
+			continue
+		}
+		cp := fs.FolderShare
+		if u, ok := m.users[fs.RecipientID]; ok {
+			cp.RecipientUsername = u.Username
+		}
+		shares = append(shares, cp)
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].CreatedAt > shares[j].CreatedAt })
+	return shares, nil
+}
+
+func (m *MemoryStore) RevokeFolderShare(ctx context.Context, folderID string, recipientID string, noteIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found := false
+	for _, fs := range m.folderShares {
+		if fs.FolderID == folderID && fs.RecipientID == recipientID && !fs.revoked {
+			fs.revoked = true
+			found = true
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	for _, ns := range m.noteShares {
+		if ns.RecipientID == recipientID && slices.Contains(noteIDs, ns.NoteID) {
+			ns.revoked = true
+		}
+	}
+	return nil
+}
+
+// ============================================================
+// ShareLinkStore
+// ============================================================
+
+func (m *MemoryStore) CreateShareLink(ctx context.Context, l *ShareLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.shareLinks[l.ID]; ok {
+		return ErrConflict
+	}
+	cp := *l
+	cp.IsActive = true
+	cp.CurrentViews = 0
+	cp.CreatedAt = memoryNow()
+	m.shareLinks[l.ID] = &cp
+	return nil
+}
+
+func (m *MemoryStore) GetShareLink(ctx context.Context, id string) (*ShareLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	l, ok := m.shareLinks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *l
+	return &cp, nil
+}
+
+func (m *MemoryStore) IncrementShareLinkViews(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.shareLinks[id]
+	if !ok || (l.MaxViews != nil && l.CurrentViews >= *l.MaxViews) {
+		return ErrNotFound
+	}
+	l.CurrentViews++
+	return nil
+}
+
+func (m *MemoryStore) DeactivateShareLink(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.shareLinks[id]; ok {
+		l.IsActive = false
+	}
+	return nil
+}
+
+// ============================================================
+// TokenStore
+// ============================================================
+
+func (m *MemoryStore) CreateRefreshToken(ctx context.Context, t *RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *t
+	m.refreshTokens[t.TokenHash] = &cp
+	return nil
+}
+
+func (m *MemoryStore) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.refreshTokens[tokenHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *t
+	if u, ok := m.users[t.UserID]; ok {
+		cp.Username = u.Username
+	}
+	return &cp, nil
+}
+
+func (m *MemoryStore) RotateRefreshToken(ctx context.Context, oldID string, next *RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.refreshTokens {
+		if t.ID != oldID {
+			continue
+		}
+		if t.IsRevoked {
+			return ErrRefreshTokenReused
+		}
+		t.IsRevoked = true
+		cp := *next
+		m.refreshTokens[next.TokenHash] = &cp
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (m *MemoryStore) RevokeRefreshTokenFamily(ctx context.Context, userID string, familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.refreshTokens {
+		if t.UserID == userID && (familyID == "" || t.FamilyID == familyID) {
+			t.IsRevoked = true
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteRefreshToken(ctx context.Context, tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.refreshTokens, tokenHash)
+	return nil
+}
+
+func (m *MemoryStore) BlacklistToken(ctx context.Context, jti string, expiresAt string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blacklist[jti] = expiresAt
+	return nil
+}
+
+func (m *MemoryStore) ConsumeToken(ctx context.Context, jti string, expiresAt string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blacklist[jti]; ok {
+		return ErrConflict
+	}
+	m.blacklist[jti] = expiresAt
+	return nil
+}
+
+func (m *MemoryStore) IsTokenBlacklisted(ctx context.Context, jti string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	expiresAt, ok := m.blacklist[jti]
+	return ok && expiresAt > memoryNow(), nil
+}
+
+// ============================================================
+// UploadStore
+// ============================================================
+
+func (m *MemoryStore) CreateUpload(ctx context.Context, u *UploadSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.uploads[u.ID]; ok {
+		return ErrConflict
+	}
+	cp := *u
+	cp.CreatedAt = memoryNow()
+	m.uploads[u.ID] = &memoryUpload{UploadSession: cp, chunks: map[int]UploadChunk{}}
+	return nil
+}
+
+func (m *MemoryStore) GetUpload(ctx context.Context, id string) (*UploadSession, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.uploads[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := u.UploadSession
+	return &cp, nil
+}
+
+func (m *MemoryStore) PutUploadChunk(ctx context.Context, sessionID string, chunk UploadChunk) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.uploads[sessionID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.chunks[chunk.Index] = chunk
+	return nil
+}
+
+func (m *MemoryStore) ListUploadChunks(ctx context.Context, sessionID string) ([]UploadChunk, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chunks := []UploadChunk{}
+	if u, ok := m.uploads[sessionID]; ok {
+		for _, ch := range u.chunks {
+			chunks = append(chunks, ch)
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Index < chunks[j].Index })
+	return chunks, nil
+}
+
+func (m *MemoryStore) DeleteUpload(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.uploads[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.uploads, id)
+	return nil
+}
+
+// ============================================================
+// BlobRefStore
+// ============================================================
+
+func (m *MemoryStore) ReferencedBlobs(ctx context.Context) (map[string]struct{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	refs := map[string]struct{}{}
+	for _, n := range m.notes {
+		if n.ContentRef != "" {
+			refs[n.ContentRef] = struct{}{}
+		}
+	}
+	for _, versions := range m.noteVersions {
+		for _, v := range versions {
+			if v.ContentRef != "" {
+				refs[v.ContentRef] = struct{}{}
+			}
+		}
+	}
+	for _, l := range m.shareLinks {
+		if l.ContentRef != "" {
+			refs[l.ContentRef] = struct{}{}
+		}
+	}
+	for _, u := range m.uploads {
+		for _, ch := range u.chunks {
+			refs[ch.SHA256] = struct{}{}
+		}
+	}
+	return refs, nil
+}
+
+func (m *MemoryStore) listContent(kind string, withRef bool, afterID string, limit int) ([]ContentRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rows := []ContentRow{}
+	add := func(id, contentEnc, ref string, size int64) {
+		if id > afterID && (ref != "") == withRef {
+			rows = append(rows, ContentRow{Kind: kind, ID: id, ContentEnc: contentEnc, Ref: ref, Size: size})
+		}
+	}
+	switch kind {
+	case ContentKindNote:
+		for _, n := range m.notes {
+			add(n.ID, n.ContentEnc, n.ContentRef, n.ContentSize)
+		}
+	case ContentKindShareLink:
+		for _, l := range m.shareLinks {
+			add(l.ID, l.ContentEnc, l.ContentRef, l.ContentSize)
+		}
+	default:
+		return nil, fmt.Errorf("unknown content kind %q", kind)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows, nil
+}
+
+func (m *MemoryStore) ListInlineContent(ctx context.Context, kind string, afterID string, limit int) ([]ContentRow, error) {
+	return m.listContent(kind, false, afterID, limit)
+}
+
+func (m *MemoryStore) ListBlobContent(ctx context.Context, kind string, afterID string, limit int) ([]ContentRow, error) {
+	return m.listContent(kind, true, afterID, limit)
+}
+
+func (m *MemoryStore) SetContentRef(ctx context.Context, row ContentRow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch row.Kind {
+	case ContentKindNote:
+		if n, ok := m.notes[row.ID]; ok {
+			n.ContentEnc, n.ContentRef, n.ContentSize = row.ContentEnc, row.Ref, row.Size
+			return nil
+		}
+	case ContentKindShareLink:
+		if l, ok := m.shareLinks[row.ID]; ok {
+			l.ContentEnc, l.ContentRef, l.ContentSize = row.ContentEnc, row.Ref, row.Size
+			return nil
+		}
+	default:
+		return fmt.Errorf("unknown content kind %q", row.Kind)
+	}
+	return ErrNotFound
+}
+
+// ============================================================
+// MFAStore
+// ============================================================
+
+func (m *MemoryStore) GetTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.totp[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *t
+	for _, used := range m.recoveryCodes[userID] {
+		if !used {
+			cp.RecoveryCodesLeft++
+		}
+	}
+	return &cp, nil
+}
+
+func (m *MemoryStore) PutTOTP(ctx context.Context, userID string, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.totp[userID]; ok && t.Enabled {
+		return ErrConflict
+	}
+	m.totp[userID] = &TOTP{UserID: userID, Secret: secret, CreatedAt: memoryNow()}
+	return nil
+}
+
+func (m *MemoryStore) EnableTOTP(ctx context.Context, userID string, step int64, codeHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.totp[userID]
+	if !ok || t.Enabled {
+		return ErrNotFound
+	}
+	t.Enabled, t.LastStep, t.EnabledAt = true, step, memoryNow()
+	codes := make(map[string]bool, len(codeHashes))
+	for _, h := range codeHashes {
+		codes[h] = false
+	}
+	m.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (m *MemoryStore) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.totp[userID]
+	if !ok || !t.Enabled || t.LastStep >= step {
+		return ErrConflict
+	}
+	t.LastStep = step
+	return nil
+}
+
+func (m *MemoryStore) UseRecoveryCode(ctx context.Context, userID string, codeHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	used, ok := m.recoveryCodes[userID][codeHash]
+	if !ok || used {
+		return ErrNotFound
+	}
+	m.recoveryCodes[userID][codeHash] = true
+	return nil
+}
+
+func (m *MemoryStore) DeleteTOTP(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.totp[userID]; !ok {
+		return ErrNotFound
+	}
+	delete(m.totp, userID)
+	delete(m.recoveryCodes, userID)
+	return nil
+}
+
+// ============================================================
+// KeyringStore
+// ============================================================
+
+// keyring đọc Keyring của user; caller giữ m.mu
+func (m *MemoryStore) keyring(userID string) (*Keyring, error) {
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	k := &Keyring{SearchKeyEnc: u.SearchKeyEnc}
+	for _, n := range m.notes {
+		if n.UserID != userID {
+			continue
+		}
+		k.Notes = append(k.Notes, WrappedKey{ID: n.ID, Enc: n.KeyEnc, IVMeta: n.IVMeta})
+		for _, v := range m.noteVersions[n.ID] {
+			k.Versions = append(k.Versions, WrappedKey{ID: n.ID, Version: v.Version, Enc: v.KeyEnc, IVMeta: v.IVMeta})
+		}
+	}
+	for _, up := range m.uploads {
+		if up.UserID == userID {
+			k.Uploads = append(k.Uploads, WrappedKey{ID: up.ID, Enc: up.KeyEnc, IVMeta: up.IVMeta})
+		}
+	}
+	for _, f := range m.folders {
+		if f.UserID == userID {
+			k.Folders = append(k.Folders, WrappedKey{ID: f.ID, Enc: f.NameEnc})
+		}
+	}
+	for _, t := range m.tags {
+		if t.UserID == userID {
+			k.Tags = append(k.Tags, WrappedKey{ID: t.ID, Enc: t.LabelEnc})
+		}
+	}
+	byID := func(keys []WrappedKey) {
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].ID != keys[j].ID {
+				return keys[i].ID < keys[j].ID
+			}
+			return keys[i].Version < keys[j].Version
+		})
+	}
+	byID(k.Notes)
+	byID(k.Versions)
+	byID(k.Uploads)
+	byID(k.Folders)
+	byID(k.Tags)
+	if r, ok := m.recoveryKeys[userID]; ok {
+		k.RecoveryMasterKeyEnc, k.RecoveryKeyEnc = r.MasterKeyEnc, r.KeyEnc
+	}
+	return k, nil
+}
+
+func (m *MemoryStore) GetKeyring(ctx context.Context, userID string) (*Keyring, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.keyring(userID)
+}
+
+func (m *MemoryStore) ChangePassword(ctx context.Context, userID, passwordHash, kdfSalt string, k *Keyring) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, err := m.keyring(userID)
+	if err != nil {
+		return err
+	}
+	if !current.sameEntries(k) {
+		return ErrConflict
+	}
+
+	for _, e := range k.Notes {
+		n := m.notes[e.ID]
+		n.KeyEnc = e.Enc
+		if e.IVMeta != "" {
+			n.IVMeta = e.IVMeta
+		}
+	}
+	for _, e := range k.Versions {
+		versions := m.noteVersions[e.ID]
+		for i := range versions {
+			if versions[i].Version == e.Version {
+				versions[i].KeyEnc = e.Enc
+				if e.IVMeta != "" {
+					versions[i].IVMeta = e.IVMeta
+				}
+			}
+		}
+	}
+	for _, e := range k.Uploads {
+		up := m.uploads[e.ID]
+		up.KeyEnc = e.Enc
+		if e.IVMeta != "" {
+			up.IVMeta = e.IVMeta
+		}
+	}
+	for _, e := range k.Folders {
+		m.folders[e.ID].NameEnc = e.Enc
+	}
+	for _, e := range k.Tags {
+		m.tags[e.ID].LabelEnc = e.Enc
+	}
+
+	if r, ok := m.recoveryKeys[userID]; ok && k.RecoveryKeyEnc != "" {
+		r.MasterKeyEnc, r.KeyEnc, r.UpdatedAt = k.RecoveryMasterKeyEnc, k.RecoveryKeyEnc, memoryNow()
+	}
+
+	u := m.users[userID]
+	u.PasswordHash = passwordHash
+	u.KdfSalt = kdfSalt
+	u.SearchKeyEnc = k.SearchKeyEnc
+	u.AuthVersion = AuthVersionLoginKey
+	for hash, t := range m.refreshTokens {
+		if t.UserID == userID {
+			delete(m.refreshTokens, hash)
+		}
+	}
+	return nil
+}
+
+// ============================================================
+// RecoveryStore
+// ============================================================
+
+func (m *MemoryStore) GetRecoveryKey(ctx context.Context, userID string) (*RecoveryKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.recoveryKeys[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *r
+	return &cp, nil
+}
+
+func (m *MemoryStore) PutRecoveryKey(ctx context.Context, r *RecoveryKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *r
+	cp.CreatedAt = memoryNow()
+	cp.UpdatedAt = cp.CreatedAt
+	m.recoveryKeys[r.UserID] = &cp
+	return nil
+}
+
+func (m *MemoryStore) DeleteRecoveryKey(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.recoveryKeys[userID]; !ok {
+		return ErrNotFound
+	}
+	delete(m.recoveryKeys, userID)
+	return nil
+}
+
+// ============================================================
+// LockoutStore
+// ============================================================
+
+func memoryAuthKey(scope, key string) string {
+	return scope + "\x00" + key
+}
+
+func (m *MemoryStore) GetAuthFailure(ctx context.Context, scope, key string) (*AuthFailure, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, ok := m.authFailures[memoryAuthKey(scope, key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *f
+	return &cp, nil
+}
+
+func (m *MemoryStore) ReserveAuthAttempt(ctx context.Context, scope, key, now, windowStart string, maxFailures int, lockUntil string) (*AuthFailure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := memoryAuthKey(scope, key)
+	f, ok := m.authFailures[k]
+	if !ok {
+		f = &AuthFailure{Scope: scope, Key: key, FirstFailedAt: now}
+		m.authFailures[k] = f
+	} else if f.LockedUntil > now {
+		return nil, ErrAuthLocked
+	} else if f.LastFailedAt < windowStart {
+		f.Failures = 0
+		f.FirstFailedAt = now
+	}
+	f.Failures++
+	f.LastFailedAt = now
+	if f.Failures >= maxFailures {
+		f.LockedUntil = lockUntil
+	}
+	cp := *f
+	return &cp, nil
+}
+
+func (m *MemoryStore) ReleaseAuthAttempt(ctx context.Context, scope, key, lockedUntil string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.authFailures[memoryAuthKey(scope, key)]
+	if !ok {
+		return nil
+	}
+	if f.Failures > 0 {
+		f.Failures--
+	}
+	if lockedUntil != "" && f.LockedUntil == lockedUntil {
+		f.LockedUntil = ""
+	}
+	return nil
+}
+
+func (m *MemoryStore) LockAuth(ctx context.Context, scope, key, until string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.authFailures[memoryAuthKey(scope, key)]
+	if !ok {
+		return ErrNotFound
+	}
+	f.LockedUntil = until
+	return nil
+}
+
+func (m *MemoryStore) ClearAuthFailures(ctx context.Context, scope, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.authFailures, memoryAuthKey(scope, key))
+	return nil
+}
+
+func (m *MemoryStore) AddAuditEvent(ctx context.Context, e *AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.ID = int64(len(m.auditLog) + 1)
+	e.CreatedAt = memoryNow()
+	m.auditLog = append(m.auditLog, *e)
+	return nil
+}
+
+func (m *MemoryStore) ListAuditEvents(ctx context.Context, limit int) ([]AuditEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var events []AuditEvent
+	for i := len(m.auditLog) - 1; i >= 0 && len(events) < limit; i-- {
+		events = append(events, m.auditLog[i])
+	}
+	return events, nil
+}
+
+// ============================================================
+// QuotaStore
+// ============================================================
+
+// memoryLinkActive: link còn mở được (giống activeLinkCond của SQLiteStore)
+func memoryLinkActive(l *ShareLink, now time.Time) bool {
+	if !l.IsActive || (l.MaxViews != nil && l.CurrentViews >= *l.MaxViews) {
+		return false
+	}
+	if l.ExpiresAt != nil {
+		if expiry, err := time.Parse(time.RFC3339, *l.ExpiresAt); err == nil && !now.Before(expiry) {
+			return false
+		}
+	}
+	return true
+}
+
+// usage tính Usage của u (phải giữ m.mu)
+func (m *MemoryStore) usage(u *User) Usage {
+	usage := Usage{UserID: u.ID, Username: u.Username}
+	if q, ok := m.quotas[u.ID]; ok {
+		usage.QuotaBytes = &q
+	}
+	for _, n := range m.notes {
+		if n.UserID != u.ID {
+			continue
+		}
+		usage.NoteBytes += memoryNoteSize(n)
+		if n.DeletedAt == "" {
+			usage.NoteCount++
+		} else {
+			usage.TrashedCount++
+		}
+		for _, v := range m.noteVersions[n.ID] {
+			usage.VersionBytes += contentSize(v.ContentRef, v.ContentEnc, v.ContentSize)
+		}
+	}
+	now := time.Now()
+	for _, l := range m.shareLinks {
+		if l.OwnerID == u.ID && memoryLinkActive(l, now) {
+			usage.LinkBytes += contentSize(l.ContentRef, l.ContentEnc, l.ContentSize)
+			usage.ActiveLinks++
+		}
+	}
+	nowStr := sqliteTime(now)
+	for _, up := range m.uploads {
+		if up.UserID == u.ID && up.ExpiresAt > nowStr {
+			usage.UploadBytes += up.TotalSize
+		}
+	}
+	return usage
+}
+
+func (m *MemoryStore) GetUsage(ctx context.Context, userID string) (*Usage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	usage := m.usage(u)
+	return &usage, nil
+}
+
+func (m *MemoryStore) ListTopUsage(ctx context.Context, limit int) ([]Usage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	usages := make([]Usage, 0, len(m.users))
+	for _, u := range m.users {
+		usages = append(usages, m.usage(u))
+	}
+	slices.SortFunc(usages, func(a, b Usage) int {
+		if c := cmp.Compare(b.Bytes(), a.Bytes()); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Username, b.Username)
+	})
+	if len(usages) > limit {
+		usages = usages[:limit]
+	}
+	return usages, nil
+}
+
+func (m *MemoryStore) SetUserQuota(ctx context.Context, userID string, quota *int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[userID]; !ok {
+		return ErrNotFound
+	}
+	if quota == nil {
+		delete(m.quotas, userID)
+	} else {
+		m.quotas[userID] = *quota
+	}
+	return nil
+}
+
+// ============================================================
+// MaintenanceStore
+// ============================================================
+
+func (m *MemoryStore) PurgeExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := memoryNow()
+	var n int64
+	for hash, t := range m.refreshTokens {
+		if t.ExpiresAt < now {
+			delete(m.refreshTokens, hash)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) PurgeExpiredBlacklist(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := memoryNow()
+	var n int64
+	for jti, expiresAt := range m.blacklist {
+		if expiresAt < now {
+			delete(m.blacklist, jti)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) DeactivateExpiredShareLinks(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var n int64
+	for _, l := range m.shareLinks {
+		if !l.IsActive || l.ExpiresAt == nil {
+			continue
+		}
+		if expiry, err := time.Parse(time.RFC3339, *l.ExpiresAt); err == nil && now.After(expiry) {
+			l.IsActive = false
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) DeactivateExhaustedShareLinks(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for _, l := range m.shareLinks {
+		if l.IsActive && l.MaxViews != nil && l.CurrentViews >= *l.MaxViews {
+			l.IsActive = false
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) PurgeExpiredUploads(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := memoryNow()
+	var n int64
+	for id, u := range m.uploads {
+		if u.ExpiresAt < now {
+			delete(m.uploads, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) PurgeTrashedNotes(ctx context.Context, before string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, note := range m.notes {
+		if note.DeletedAt != "" && note.DeletedAt < before {
+			m.deleteNote(id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) PurgeAuthFailures(ctx context.Context, before string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := memoryNow()
+	var n int64
+	for k, f := range m.authFailures {
+		if f.LastFailedAt < before && (f.LockedUntil == "" || f.LockedUntil < now) {
+			delete(m.authFailures, k)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,1858 @@
+package serverpkg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQLiteStore cài đặt UserStore, NoteStore, ShareLinkStore, UploadStore, BlobRefStore và TokenStore trên SQLite
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore tạo store từ kết nối đã mở (xem InitDB)
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{db: db}
+}
+
+// mapSQLiteError chuyển lỗi driver sang lỗi chung của tầng storage
+func mapSQLiteError(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrConflict
+	}
+	return err
+}
+
+// ============================================================
+// UserStore
+// ============================================================
+
+func (s *SQLiteStore) CreateUser(ctx context.Context, u *User) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO users (id, username, password_hash, kdf_salt, auth_version) VALUES (?, ?, ?, ?, ?)`,
+		u.ID, u.Username, u.PasswordHash, u.KdfSalt, u.AuthVersion)
+	return mapSQLiteError(err)
+}
+
+func (s *SQLiteStore) getUser(ctx context.Context, where string, arg string) (*User, error) {
+	var u User
+	var searchKeyEnc, createdAt sql.NullString
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, username, password_hash, kdf_salt, search_key_enc, auth_version, created_at FROM users WHERE `+where, arg).Scan(
+		&u.ID, &u.Username, &u.PasswordHash, &u.KdfSalt, &searchKeyEnc, &u.AuthVersion, &createdAt)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	u.SearchKeyEnc = searchKeyEnc.String
+	u.CreatedAt = createdAt.String
+	return &u, nil
+}
+
+func (s *SQLiteStore) GetUserByID(ctx context.Context, id string) (*User, error) {
+	return s.getUser(ctx, "id = ?", id)
+}
+
+func (s *SQLiteStore) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	return s.getUser(ctx, "username = ?", username)
+}
+
+func (s *SQLiteStore) PutDHKey(ctx context.Context, userID string, publicKey string) error {
+	// Upsert: lần đầu INSERT, các lần sau là rotate key (trigger cập nhật updated_at)
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO user_keys (user_id, public_key)
+		VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET public_key = excluded.public_key
+	`, userID, publicKey)
+	return mapSQLiteError(err)
+}
+
+func (s *SQLiteStore) getDHKey(ctx context.Context, where string, arg string) (*DHKey, error) {
+	var k DHKey
+	var updatedAt sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT u.id, u.username, k.public_key, k.updated_at
+		FROM user_keys k
+		JOIN users u ON k.user_id = u.id
+		WHERE `+where, arg).Scan(&k.UserID, &k.Username, &k.PublicKey, &updatedAt)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	k.UpdatedAt = updatedAt.String
+	return &k, nil
+}
+
+func (s *SQLiteStore) GetDHKeyByUserID(ctx context.Context, userID string) (*DHKey, error) {
+	return s.getDHKey(ctx, "u.id = ?", userID)
+}
+
+func (s *SQLiteStore) GetDHKeyByUsername(ctx context.Context, username string) (*DHKey, error) {
+	return s.getDHKey(ctx, "u.username = ?", username)
+}
+
+func (s *SQLiteStore) CountUsers(ctx context.Context) (int, int, error) {
+	var total, legacy int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*), COALESCE(SUM(auth_version = ?), 0) FROM users`, AuthVersionPassword).Scan(&total, &legacy)
+	return total, legacy, err
+}
+
+// ============================================================
+// NoteStore
+// ============================================================
+
+func (s *SQLiteStore) CreateNote(ctx context.Context, n *Note) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO notes (id, user_id, title_enc, content_enc, content_ref, content_size, key_enc, iv_meta)
+		VALUES (?, ?, ?, ?, nullif(?, ''), ?, ?, ?)
+	`, n.ID, n.UserID, n.TitleEnc, n.ContentEnc, n.ContentRef, n.ContentSize, n.KeyEnc, n.IVMeta)
+	if err != nil {
+		return mapSQLiteError(err)
+	}
+	if err := insertSearchTokens(ctx, tx, n.ID, n.SearchTokens); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertSearchTokens ghi blind index của note (token trùng được bỏ qua)
+func insertSearchTokens(ctx context.Context, tx *sql.Tx, noteID string, tokens []string) error {
+	for _, token := range tokens {
+		_, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO note_search_tokens (note_id, token) VALUES (?, ?)`, noteID, token)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetNote(ctx context.Context, id string) (*Note, error) {
+	var n Note
+	var createdAt, updatedAt, contentRef sql.NullString
+	var contentSize sql.NullInt64
+	var tagIDs string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, title_enc, content_enc, content_ref, content_size, key_enc, iv_meta, version, created_at, updated_at,
+			coalesce(folder_id, ''), `+noteTagIDsColumn+`, coalesce(deleted_at, '')
+		FROM notes
+		WHERE id = ?
+	`, id).Scan(&n.ID, &n.UserID, &n.TitleEnc, &n.ContentEnc, &contentRef, &contentSize, &n.KeyEnc, &n.IVMeta, &n.Version, &createdAt, &updatedAt,
+		&n.FolderID, &tagIDs, &n.DeletedAt)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	n.TagIDs = strings.Fields(tagIDs)
+	n.ContentRef = contentRef.String
+	n.ContentSize = contentSize.Int64
+	n.CreatedAt = createdAt.String
+	n.UpdatedAt = updatedAt.String
+	return &n, nil
+}
+
+// noteTagIDsColumn: id các tag của note (cách nhau bởi dấu cách), dùng trong SELECT trên bảng notes
+const noteTagIDsColumn = `coalesce((SELECT group_concat(tag_id, ' ') FROM note_tags WHERE note_id = notes.id), '')`
+
+// sqlitePlaceholders trả về "?, ?, ..." cho n tham số
+func sqlitePlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// noteSortColumns: cột của CTE visible tương ứng với từng kiểu sort
+var noteSortColumns = map[string]string{
+	NoteSortCreated: "created_at",
+	NoteSortUpdated: "updated_at",
+	NoteSortSize:    "size",
+}
+
+// visibleNotes trả về CTE "visible" gồm các note khớp scope, token và khoảng ngày của q
+// (note trong thùng rác bị loại, kể cả với người nhận share).
+// size của note cũ còn inline được ước lượng từ độ dài base64.
+func visibleNotes(userID string, q NoteQuery) (string, []any) {
+	where := []string{`n.deleted_at IS NULL`}
+	var args []any
+	args = append(args, userID)
+
+	owned := `n.user_id = ?`
+	shared := `n.user_id <> ? AND n.id IN (SELECT note_id FROM note_shares WHERE recipient_id = ? AND revoked_at IS NULL)`
+	switch q.Scope {
+	case NoteScopeShared:
+		where = append(where, shared)
+		args = append(args, userID, userID)
+	case NoteScopeAll:
+		where = append(where, `(`+owned+` OR (`+shared+`))`)
+		args = append(args, userID, userID, userID)
+	default:
+		where = append(where, owned)
+		args = append(args, userID)
+	}
+	if len(q.Tokens) > 0 {
+		// Note phải có đủ mọi token: đếm số token khác nhau khớp trên từng note
+		where = append(where, `n.id IN (
+				SELECT note_id FROM note_search_tokens
+				WHERE token IN (`+sqlitePlaceholders(len(q.Tokens))+`)
+				GROUP BY note_id
+				HAVING count(DISTINCT token) = ?
+			)`)
+		for _, token := range q.Tokens {
+			args = append(args, token)
+		}
+		args = append(args, len(q.Tokens))
+	}
+	if len(q.FolderIDs) > 0 || len(q.TagIDs) > 0 {
+		// Folder và tag là của user: không khớp note được share từ người khác
+		where = append(where, `n.user_id = ?`)
+		args = append(args, userID)
+	}
+	if len(q.FolderIDs) > 0 {
+		where = append(where, `coalesce(n.folder_id, '') IN (`+sqlitePlaceholders(len(q.FolderIDs))+`)`)
+		for _, id := range q.FolderIDs {
+			args = append(args, id)
+		}
+	}
+	if len(q.TagIDs) > 0 {
+		where = append(where, `n.id IN (
+				SELECT note_id FROM note_tags
+				WHERE tag_id IN (`+sqlitePlaceholders(len(q.TagIDs))+`)
+				GROUP BY note_id
+				HAVING count(DISTINCT tag_id) = ?
+			)`)
+		for _, id := range q.TagIDs {
+			args = append(args, id)
+		}
+		args = append(args, len(q.TagIDs))
+	}
+	if q.CreatedFrom != "" {
+		where = append(where, `n.created_at >= ?`)
+		args = append(args, q.CreatedFrom)
+	}
+	if q.CreatedTo != "" {
+		where = append(where, `n.created_at < ?`)
+		args = append(args, q.CreatedTo)
+	}
+
+	return `
+		WITH visible AS (
+			SELECT n.id, n.user_id, n.title_enc, n.version,
+				coalesce(n.created_at, '') AS created_at, coalesce(n.updated_at, '') AS updated_at,
+				coalesce(n.content_size, length(n.content_enc) * 3 / 4) AS size,
+				n.user_id <> ? AS shared, coalesce(u.username, '') AS owner_username,
+				coalesce(n.folder_id, '') AS folder_id,
+				coalesce((SELECT group_concat(tag_id, ' ') FROM note_tags WHERE note_id = n.id), '') AS tag_ids
+			FROM notes n
+			LEFT JOIN users u ON u.id = n.user_id
+			WHERE ` + strings.Join(where, `
+				AND `) + `
+		)`, args
+}
+
+func (s *SQLiteStore) ListNotes(ctx context.Context, userID string, q NoteQuery) (*NotePage, error) {
+	cte, args := visibleNotes(userID, q)
+	page := &NotePage{Notes: []NoteListEntry{}}
+	err := s.db.QueryRowContext(ctx, cte+`
+		SELECT count(*), coalesce(sum(size), 0) FROM visible
+	`, args...).Scan(&page.Total, &page.TotalSize)
+	if err != nil {
+		return nil, err
+	}
+
+	column, ok := noteSortColumns[q.Sort]
+	if !ok {
+		column = noteSortColumns[NoteSortCreated]
+	}
+	cmp, dir := "<", "DESC"
+	if q.Ascending {
+		cmp, dir = ">", "ASC"
+	}
+	query := cte + `
+		SELECT id, user_id, title_enc, version, created_at, updated_at, size, shared, owner_username, folder_id, tag_ids
+		FROM visible`
+	if q.After != nil {
+		var key any = q.After.Time
+		if column == "size" {
+			key = q.After.Size
+		}
+		query += `
+		WHERE ` + column + ` ` + cmp + ` ? OR (` + column + ` = ? AND id ` + cmp + ` ?)`
+		args = append(args, key, key, q.After.ID)
+	}
+	// Lấy thêm một note để biết còn trang sau hay không
+	query += `
+		ORDER BY ` + column + ` ` + dir + `, id ` + dir + `
+		LIMIT ?`
+	args = append(args, q.Limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e NoteListEntry
+		var tagIDs string
+		if err := rows.Scan(&e.ID, &e.UserID, &e.TitleEnc, &e.Version, &e.CreatedAt, &e.UpdatedAt, &e.ContentSize, &e.Shared, &e.OwnerUsername, &e.FolderID, &tagIDs); err != nil {
+			return nil, err
+		}
+		if e.Shared {
+			// Folder và tag của chủ note không thuộc về người nhận
+			e.FolderID = ""
+		} else {
+			e.OwnerUsername = ""
+			e.TagIDs = strings.Fields(tagIDs)
+		}
+		page.Notes = append(page.Notes, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Notes) > q.Limit {
+		page.Notes = page.Notes[:q.Limit]
+		page.HasMore = true
+	}
+	return page, nil
+}
+
+// noteVersionError phân biệt note không tồn tại với note đã đổi version khi
+// câu lệnh có điều kiện version không tác động row nào
+func noteVersionError(ctx context.Context, tx *sql.Tx, id string) error {
+	var exists int
+	err := tx.QueryRowContext(ctx, `SELECT 1 FROM notes WHERE id = ?`, id).Scan(&exists)
+	if err != nil {
+		return mapSQLiteError(err)
+	}
+	return ErrVersionMismatch
+}
+
+func (s *SQLiteStore) UpdateNote(ctx context.Context, n *Note, expectedVersion int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Phiên bản hiện tại thành phiên bản cũ; created_at của nó là lần ghi gần nhất của note
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO note_versions (note_id, version, title_enc, content_enc, content_ref, content_size, key_enc, iv_meta, created_at, search_tokens)
+		SELECT id, version, title_enc, content_enc, content_ref, content_size, key_enc, iv_meta, updated_at,
+			coalesce((SELECT group_concat(token, ' ') FROM note_search_tokens WHERE note_id = notes.id), '')
+		FROM notes
+		WHERE id = ? AND (? = 0 OR version = ?)
+	`, n.ID, expectedVersion, expectedVersion)
+	if err != nil {
+		return mapSQLiteError(err)
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return noteVersionError(ctx, tx, n.ID)
+	}
+
+	// RETURNING không thấy giá trị do trigger ghi, nên đặt updated_at tường minh
+	err = tx.QueryRowContext(ctx, `
+		UPDATE notes
+		SET title_enc = ?, content_enc = ?, content_ref = nullif(?, ''), content_size = ?,
+			key_enc = ?, iv_meta = ?, version = version + 1, updated_at = datetime('now')
+		WHERE id = ?
+		RETURNING version, updated_at
+	`, n.TitleEnc, n.ContentEnc, n.ContentRef, n.ContentSize, n.KeyEnc, n.IVMeta, n.ID).Scan(&n.Version, &n.UpdatedAt)
+	if err != nil {
+		return mapSQLiteError(err)
+	}
+
+	if n.SearchTokens != nil {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM note_search_tokens WHERE note_id = ?`, n.ID); err != nil {
+			return err
+		}
+		if err := insertSearchTokens(ctx, tx, n.ID, n.SearchTokens); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) TrashNote(ctx context.Context, id string, expectedVersion int) (string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var deletedAt string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE notes SET deleted_at = datetime('now')
+		WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?)
+		RETURNING deleted_at
+	`, id, expectedVersion, expectedVersion).Scan(&deletedAt)
+	if err == sql.ErrNoRows {
+		var trashed bool
+		if err := tx.QueryRowContext(ctx, `SELECT deleted_at IS NOT NULL FROM notes WHERE id = ?`, id).Scan(&trashed); err != nil {
+			return "", mapSQLiteError(err)
+		}
+		if trashed {
+			return "", ErrNotFound
+		}
+		return "", ErrVersionMismatch
+	}
+	if err != nil {
+		return "", err
+	}
+	return deletedAt, tx.Commit()
+}
+
+func (s *SQLiteStore) RestoreNote(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE notes SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListTrash(ctx context.Context, userID string) ([]Note, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, title_enc, coalesce(content_size, length(content_enc) * 3 / 4), key_enc, iv_meta, version,
+			coalesce(created_at, ''), coalesce(updated_at, ''), coalesce(folder_id, ''), deleted_at
+		FROM notes
+		WHERE user_id = ? AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []Note{}
+	for rows.Next() {
+		var n Note
+		if err := rows.Scan(&n.ID, &n.UserID, &n.TitleEnc, &n.ContentSize, &n.KeyEnc, &n.IVMeta, &n.Version, &n.CreatedAt, &n.UpdatedAt, &n.FolderID, &n.DeletedAt); err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteNote(ctx context.Context, id string, expectedVersion int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteNoteRows(ctx, tx, id); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM notes WHERE id = ? AND (? = 0 OR version = ?)`, id, expectedVersion, expectedVersion)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return noteVersionError(ctx, tx, id)
+	}
+	return tx.Commit()
+}
+
+// deleteNoteRows xóa các row phụ thuộc vào note và vô hiệu hóa share link tạo từ note.
+// foreign_keys không được bật nên phải xóa tường minh thay vì dựa vào CASCADE.
+func deleteNoteRows(ctx context.Context, tx *sql.Tx, id string) error {
+	for _, query := range []string{
+		`DELETE FROM note_versions WHERE note_id = ?`,
+		`DELETE FROM note_search_tokens WHERE note_id = ?`,
+		`DELETE FROM note_tags WHERE note_id = ?`,
+		`DELETE FROM note_shares WHERE note_id = ?`,
+		`UPDATE shared_links SET is_active = 0 WHERE note_id = ? AND is_active = 1`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListNoteVersions(ctx context.Context, noteID string) ([]NoteVersion, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT note_id, version, title_enc, coalesce(content_size, 0), key_enc, iv_meta, created_at, archived_at
+		FROM note_versions
+		WHERE note_id = ?
+		ORDER BY version DESC
+	`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []NoteVersion{}
+	for rows.Next() {
+		var v NoteVersion
+		var createdAt, archivedAt sql.NullString
+		if err := rows.Scan(&v.NoteID, &v.Version, &v.TitleEnc, &v.ContentSize, &v.KeyEnc, &v.IVMeta, &createdAt, &archivedAt); err != nil {
+			return nil, err
+		}
+		v.CreatedAt = createdAt.String
+		v.ArchivedAt = archivedAt.String
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+func (s *SQLiteStore) GetNoteVersion(ctx context.Context, noteID string, version int) (*NoteVersion, error) {
+	var v NoteVersion
+	var contentRef, createdAt, archivedAt sql.NullString
+	var contentSize sql.NullInt64
+	var searchTokens string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT note_id, version, title_enc, content_enc, content_ref, content_size, key_enc, iv_meta, created_at, archived_at, search_tokens
+		FROM note_versions
+		WHERE note_id = ? AND version = ?
+	`, noteID, version).Scan(&v.NoteID, &v.Version, &v.TitleEnc, &v.ContentEnc, &contentRef, &contentSize, &v.KeyEnc, &v.IVMeta, &createdAt, &archivedAt, &searchTokens)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	// Slice rỗng (khác nil) để khôi phục phiên bản không có token thì xóa token hiện tại
+	v.SearchTokens = append([]string{}, strings.Fields(searchTokens)...)
+	v.ContentRef = contentRef.String
+	v.ContentSize = contentSize.Int64
+	v.CreatedAt = createdAt.String
+	v.ArchivedAt = archivedAt.String
+	return &v, nil
+}
+
+func (s *SQLiteStore) UpsertNoteShare(ctx context.Context, ns *NoteShare) error {
+	// Chia sẻ lại sau khi thu hồi sẽ kích hoạt lại bản ghi cũ
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO note_shares (id, note_id, recipient_id, wrapped_key, sender_public_key)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (note_id, recipient_id) DO UPDATE SET
+			wrapped_key = excluded.wrapped_key,
+			sender_public_key = excluded.sender_public_key,
+			created_at = datetime('now'),
+			revoked_at = NULL
+		RETURNING id, created_at
+	`, ns.ID, ns.NoteID, ns.RecipientID, ns.WrappedKey, ns.SenderPublicKey).Scan(&ns.ID, &ns.CreatedAt)
+	return mapSQLiteError(err)
+}
+
+func (s *SQLiteStore) ListNoteShares(ctx context.Context, noteID string) ([]NoteShare, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT ns.id, ns.note_id, ns.recipient_id, u.username, ns.wrapped_key, ns.sender_public_key, ns.created_at
+		FROM note_shares ns
+		JOIN users u ON ns.recipient_id = u.id
+		WHERE ns.note_id = ? AND ns.revoked_at IS NULL
+		ORDER BY ns.created_at DESC
+	`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []NoteShare{}
+	for rows.Next() {
+		var ns NoteShare
+		if err := rows.Scan(&ns.ID, &ns.NoteID, &ns.RecipientID, &ns.RecipientUsername, &ns.WrappedKey, &ns.SenderPublicKey, &ns.CreatedAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, ns)
+	}
+	return shares, rows.Err()
+}
+
+func (s *SQLiteStore) GetActiveNoteShare(ctx context.Context, noteID string, recipientID string) (*NoteShare, error) {
+	var ns NoteShare
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, note_id, recipient_id, wrapped_key, sender_public_key, created_at
+		FROM note_shares
+		WHERE note_id = ? AND recipient_id = ? AND revoked_at IS NULL
+	`, noteID, recipientID).Scan(&ns.ID, &ns.NoteID, &ns.RecipientID, &ns.WrappedKey, &ns.SenderPublicKey, &ns.CreatedAt)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	return &ns, nil
+}
+
+func (s *SQLiteStore) RevokeNoteShare(ctx context.Context, noteID string, shareOrRecipientID string) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE note_shares SET revoked_at = datetime('now')
+		WHERE note_id = ? AND (id = ? OR recipient_id = ?) AND revoked_at IS NULL
+	`, noteID, shareOrRecipientID, shareOrRecipientID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ============================================================
+// FolderStore
+// ============================================================
+
+func (s *SQLiteStore) CreateFolder(ctx context.Context, f *Folder) error {
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO folders (id, user_id, parent_id, name_enc)
+		VALUES (?, ?, nullif(?, ''), ?)
+		RETURNING created_at, updated_at
+	`, f.ID, f.UserID, f.ParentID, f.NameEnc).Scan(&f.CreatedAt, &f.UpdatedAt)
+	return mapSQLiteError(err)
+}
+
+const folderColumns = `id, user_id, coalesce(parent_id, ''), name_enc, created_at, updated_at`
+
+func scanFolder(row interface{ Scan(...any) error }) (*Folder, error) {
+	var f Folder
+	var createdAt, updatedAt sql.NullString
+	if err := row.Scan(&f.ID, &f.UserID, &f.ParentID, &f.NameEnc, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	f.CreatedAt = createdAt.String
+	f.UpdatedAt = updatedAt.String
+	return &f, nil
+}
+
+func (s *SQLiteStore) GetFolder(ctx context.Context, id string) (*Folder, error) {
+	f, err := scanFolder(s.db.QueryRowContext(ctx, `SELECT `+folderColumns+` FROM folders WHERE id = ?`, id))
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	return f, nil
+}
+
+func (s *SQLiteStore) ListFolders(ctx context.Context, userID string) ([]Folder, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+folderColumns+` FROM folders WHERE user_id = ? ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []Folder{}
+	for rows.Next() {
+		f, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, *f)
+	}
+	return folders, rows.Err()
+}
+
+func (s *SQLiteStore) UpdateFolder(ctx context.Context, f *Folder) error {
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE folders SET name_enc = ?, parent_id = nullif(?, ''), updated_at = datetime('now')
+		WHERE id = ?
+		RETURNING updated_at
+	`, f.NameEnc, f.ParentID, f.ID).Scan(&f.UpdatedAt)
+	return mapSQLiteError(err)
+}
+
+func (s *SQLiteStore) DeleteFolder(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var parentID sql.NullString
+	if err := tx.QueryRowContext(ctx, `SELECT parent_id FROM folders WHERE id = ?`, id).Scan(&parentID); err != nil {
+		return mapSQLiteError(err)
+	}
+	// Nội dung của folder chuyển lên folder cha thay vì bị xóa theo
+	if _, err := tx.ExecContext(ctx, `UPDATE notes SET folder_id = ? WHERE folder_id = ?`, parentID, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE folders SET parent_id = ? WHERE parent_id = ?`, parentID, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM folder_shares WHERE folder_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM folders WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) MoveNote(ctx context.Context, noteID string, folderID string) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE notes SET folder_id = nullif(?, '') WHERE id = ?`, folderID, noteID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListFolderNoteIDs(ctx context.Context, folderIDs []string, includeTrashed bool) ([]string, error) {
+	ids := []string{}
+	if len(folderIDs) == 0 {
+		return ids, nil
+	}
+	args := make([]any, len(folderIDs))
+	for i, id := range folderIDs {
+		args[i] = id
+	}
+	query := `SELECT id FROM notes WHERE folder_id IN (` + sqlitePlaceholders(len(folderIDs)) + `)`
+	if !includeTrashed {
+		query += ` AND deleted_at IS NULL`
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQLiteStore) CreateTag(ctx context.Context, t *Tag) error {
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO tags (id, user_id, label_enc) VALUES (?, ?, ?)
+		RETURNING created_at
+	`, t.ID, t.UserID, t.LabelEnc).Scan(&t.CreatedAt)
+	return mapSQLiteError(err)
+}
+
+func (s *SQLiteStore) GetTag(ctx context.Context, id string) (*Tag, error) {
+	var t Tag
+	var createdAt sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT id, user_id, label_enc, created_at FROM tags WHERE id = ?`, id).
+		Scan(&t.ID, &t.UserID, &t.LabelEnc, &createdAt)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	t.CreatedAt = createdAt.String
+	return &t, nil
+}
+
+func (s *SQLiteStore) ListTags(ctx context.Context, userID string) ([]Tag, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, label_enc, created_at FROM tags WHERE user_id = ? ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		var createdAt sql.NullString
+		if err := rows.Scan(&t.ID, &t.UserID, &t.LabelEnc, &createdAt); err != nil {
+			return nil, err
+		}
+		t.CreatedAt = createdAt.String
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func (s *SQLiteStore) UpdateTag(ctx context.Context, t *Tag) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE tags SET label_enc = ? WHERE id = ?`, t.LabelEnc, t.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteTag(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM note_tags WHERE tag_id = ?`, id); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM tags WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) SetNoteTags(ctx context.Context, noteID string, tagIDs []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM note_tags WHERE note_id = ?`, noteID); err != nil {
+		return err
+	}
+	for _, tagID := range tagIDs {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO note_tags (note_id, tag_id) VALUES (?, ?)`, noteID, tagID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ShareFolder(ctx context.Context, fs *FolderShare, notes []NoteShare) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO folder_shares (id, folder_id, recipient_id)
+		VALUES (?, ?, ?)
+		ON CONFLICT (folder_id, recipient_id) DO UPDATE SET
+			created_at = datetime('now'),
+			revoked_at = NULL
+		RETURNING id, created_at
+	`, fs.ID, fs.FolderID, fs.RecipientID).Scan(&fs.ID, &fs.CreatedAt)
+	if err != nil {
+		return mapSQLiteError(err)
+	}
+	for _, ns := range notes {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO note_shares (id, note_id, recipient_id, wrapped_key, sender_public_key)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (note_id, recipient_id) DO UPDATE SET
+				wrapped_key = excluded.wrapped_key,
+				sender_public_key = excluded.sender_public_key,
+				created_at = datetime('now'),
+				revoked_at = NULL
+		`, ns.ID, ns.NoteID, ns.RecipientID, ns.WrappedKey, ns.SenderPublicKey)
+		if err != nil {
+			return mapSQLiteError(err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ListFolderShares(ctx context.Context, folderID string) ([]FolderShare, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT fs.id, fs.folder_id, fs.recipient_id, u.username, fs.created_at
+		FROM folder_shares fs
+		JOIN users u ON fs.recipient_id = u.id
+		WHERE fs.folder_id = ? AND fs.revoked_at IS NULL
+		ORDER BY fs.created_at DESC
+	`, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []FolderShare{}
+	for rows.Next() {
+		var fs FolderShare
+		if err := rows.Scan(&fs.ID, &fs.FolderID, &fs.RecipientID, &fs.RecipientUsername, &fs.CreatedAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, fs)
+	}
+	return shares, rows.Err()
+}
+
+func (s *SQLiteStore) RevokeFolderShare(ctx context.Context, folderID string, recipientID string, noteIDs []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE folder_shares SET revoked_at = datetime('now')
+		WHERE folder_id = ? AND recipient_id = ? AND revoked_at IS NULL
+	`, folderID, recipientID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	for _, noteID := range noteIDs {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE note_shares SET revoked_at = datetime('now')
+			WHERE note_id = ? AND recipient_id = ? AND revoked_at IS NULL
+		`, noteID, recipientID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ============================================================
+// ShareLinkStore
+// ============================================================
+
+func (s *SQLiteStore) CreateShareLink(ctx context.Context, l *ShareLink) error {
+	// Convert boolean to integer for SQLite
+	hasPassword := 0
+	if l.HasPassword {
+		hasPassword = 1
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO shared_links (id, owner_id, content_enc, content_ref, content_size, expires_at, max_views, has_password, access_hash, note_id)
+		VALUES (?, ?, ?, nullif(?, ''), ?, ?, ?, ?, ?, nullif(?, ''))
+	`, l.ID, l.OwnerID, l.ContentEnc, l.ContentRef, l.ContentSize, l.ExpiresAt, l.MaxViews, hasPassword, l.AccessHash, l.NoteID)
+	return mapSQLiteError(err)
+}
+
+func (s *SQLiteStore) GetShareLink(ctx context.Context, id string) (*ShareLink, error) {
+	var l ShareLink
+	var hasPassword, isActive int
+	var createdAt, contentRef sql.NullString
+	var contentSize sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, owner_id, content_enc, content_ref, content_size, expires_at, max_views, current_views, has_password, access_hash, is_active, created_at,
+			coalesce(note_id, '')
+		FROM shared_links
+		WHERE id = ?
+	`, id).Scan(&l.ID, &l.OwnerID, &l.ContentEnc, &contentRef, &contentSize, &l.ExpiresAt, &l.MaxViews, &l.CurrentViews, &hasPassword, &l.AccessHash, &isActive, &createdAt,
+		&l.NoteID)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	l.ContentRef = contentRef.String
+	l.ContentSize = contentSize.Int64
+	l.HasPassword = hasPassword == 1
+	l.IsActive = isActive == 1
+	l.CreatedAt = createdAt.String
+	return &l, nil
+}
+
+func (s *SQLiteStore) IncrementShareLinkViews(ctx context.Context, id string) error {
+	// Điều kiện nằm trong câu UPDATE để request song song không vượt max_views
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE shared_links SET current_views = current_views + 1, last_accessed_at = datetime('now')
+		WHERE id = ? AND (max_views IS NULL OR current_views < max_views)
+	`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeactivateShareLink(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE shared_links SET is_active = 0 WHERE id = ?`, id)
+	return err
+}
+
+// ============================================================
+// TokenStore
+// ============================================================
+
+func (s *SQLiteStore) CreateRefreshToken(ctx context.Context, t *RefreshToken) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (id, user_id, token_hash, expires_at, family_id) VALUES (?, ?, ?, ?, ?)`,
+		t.ID, t.UserID, t.TokenHash, t.ExpiresAt, t.FamilyID)
+	return mapSQLiteError(err)
+}
+
+func (s *SQLiteStore) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	var t RefreshToken
+	var familyID sql.NullString
+	var isRevoked int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT rt.id, u.id, u.username, rt.token_hash, rt.family_id, rt.expires_at, rt.is_revoked
+		FROM refresh_tokens rt
+		JOIN users u ON rt.user_id = u.id
+		WHERE rt.token_hash = ?
+	`, tokenHash).Scan(&t.ID, &t.UserID, &t.Username, &t.TokenHash, &familyID, &t.ExpiresAt, &isRevoked)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	t.FamilyID = familyID.String
+	t.IsRevoked = isRevoked == 1
+	return &t, nil
+}
+
+func (s *SQLiteStore) RotateRefreshToken(ctx context.Context, oldID string, next *RefreshToken) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Chỉ một request được rotate; request đồng thời thứ hai bị coi là reuse
+	result, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET is_revoked = 1 WHERE id = ? AND is_revoked = 0`, oldID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrRefreshTokenReused
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (id, user_id, token_hash, expires_at, family_id) VALUES (?, ?, ?, ?, ?)`,
+		next.ID, next.UserID, next.TokenHash, next.ExpiresAt, next.FamilyID)
+	if err != nil {
+		return mapSQLiteError(err)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) RevokeRefreshTokenFamily(ctx context.Context, userID string, familyID string) error {
+	// Token cũ (trước migration 004) không có family: thu hồi mọi token của user
+	if familyID == "" {
+		_, err := s.db.ExecContext(ctx, `UPDATE refresh_tokens SET is_revoked = 1 WHERE user_id = ?`, userID)
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `UPDATE refresh_tokens SET is_revoked = 1 WHERE user_id = ? AND family_id = ?`, userID, familyID)
+	return err
+}
+
+func (s *SQLiteStore) DeleteRefreshToken(ctx context.Context, tokenHash string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE token_hash = ?`, tokenHash)
+	return err
+}
+
+func (s *SQLiteStore) BlacklistToken(ctx context.Context, jti string, expiresAt string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO token_blacklist (jti, expires_at) VALUES (?, ?)
+		ON CONFLICT (jti) DO UPDATE SET expires_at = excluded.expires_at
+	`, jti, expiresAt)
+	return err
+}
+
+func (s *SQLiteStore) ConsumeToken(ctx context.Context, jti string, expiresAt string) error {
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO token_blacklist (jti, expires_at) VALUES (?, ?)
+		ON CONFLICT (jti) DO NOTHING
+	`, jti, expiresAt)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *SQLiteStore) IsTokenBlacklisted(ctx context.Context, jti string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM token_blacklist WHERE jti = ? AND expires_at > datetime('now'))`, jti).Scan(&exists)
+	return exists, err
+}
+
+// ============================================================
+// UploadStore
+// ============================================================
+
+func (s *SQLiteStore) CreateUpload(ctx context.Context, u *UploadSession) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO upload_sessions (id, user_id, title_enc, key_enc, iv_meta, total_size, chunk_size, expires_at, search_tokens)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, u.ID, u.UserID, u.TitleEnc, u.KeyEnc, u.IVMeta, u.TotalSize, u.ChunkSize, u.ExpiresAt, strings.Join(u.SearchTokens, " "))
+	return mapSQLiteError(err)
+}
+
+func (s *SQLiteStore) GetUpload(ctx context.Context, id string) (*UploadSession, error) {
+	var u UploadSession
+	var createdAt sql.NullString
+	var searchTokens string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, title_enc, key_enc, iv_meta, total_size, chunk_size, created_at, expires_at, search_tokens
+		FROM upload_sessions
+		WHERE id = ?
+	`, id).Scan(&u.ID, &u.UserID, &u.TitleEnc, &u.KeyEnc, &u.IVMeta, &u.TotalSize, &u.ChunkSize, &createdAt, &u.ExpiresAt, &searchTokens)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	u.CreatedAt = createdAt.String
+	u.SearchTokens = strings.Fields(searchTokens)
+	return &u, nil
+}
+
+func (s *SQLiteStore) PutUploadChunk(ctx context.Context, sessionID string, chunk UploadChunk) error {
+	// Upload lại cùng index (retry sau khi mất kết nối) ghi đè chunk cũ
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO upload_chunks (session_id, chunk_index, size, sha256)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (session_id, chunk_index) DO UPDATE SET
+			size = excluded.size, sha256 = excluded.sha256, received_at = datetime('now')
+	`, sessionID, chunk.Index, chunk.Size, chunk.SHA256)
+	return mapSQLiteError(err)
+}
+
+func (s *SQLiteStore) ListUploadChunks(ctx context.Context, sessionID string) ([]UploadChunk, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT chunk_index, size, sha256
+		FROM upload_chunks
+		WHERE session_id = ?
+		ORDER BY chunk_index
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := []UploadChunk{}
+	for rows.Next() {
+		var ch UploadChunk
+		if err := rows.Scan(&ch.Index, &ch.Size, &ch.SHA256); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, ch)
+	}
+	return chunks, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteUpload(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// foreign_keys không được bật nên xóa chunk tường minh thay vì dựa vào CASCADE
+	if _, err := tx.ExecContext(ctx, `DELETE FROM upload_chunks WHERE session_id = ?`, id); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM upload_sessions WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// ============================================================
+// BlobRefStore
+// ============================================================
+
+// contentTables: bảng tương ứng với từng ContentKind
+var contentTables = map[string]string{
+	ContentKindNote:      "notes",
+	ContentKindShareLink: "shared_links",
+}
+
+func (s *SQLiteStore) ReferencedBlobs(ctx context.Context) (map[string]struct{}, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT content_ref FROM notes WHERE content_ref IS NOT NULL
+		UNION SELECT content_ref FROM note_versions WHERE content_ref IS NOT NULL
+		UNION SELECT content_ref FROM shared_links WHERE content_ref IS NOT NULL
+		UNION SELECT sha256 FROM upload_chunks
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := map[string]struct{}{}
+	for rows.Next() {
+		var ref string
+		if err := rows.Scan(&ref); err != nil {
+			return nil, err
+		}
+		refs[ref] = struct{}{}
+	}
+	return refs, rows.Err()
+}
+
+func (s *SQLiteStore) listContent(ctx context.Context, kind string, where string, afterID string, limit int) ([]ContentRow, error) {
+	table, ok := contentTables[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown content kind %q", kind)
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, content_enc, coalesce(content_ref, ''), coalesce(content_size, 0)
+		FROM `+table+`
+		WHERE `+where+` AND id > ?
+		ORDER BY id
+		LIMIT ?
+	`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []ContentRow{}
+	for rows.Next() {
+		row := ContentRow{Kind: kind}
+		if err := rows.Scan(&row.ID, &row.ContentEnc, &row.Ref, &row.Size); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLiteStore) ListInlineContent(ctx context.Context, kind string, afterID string, limit int) ([]ContentRow, error) {
+	return s.listContent(ctx, kind, "content_ref IS NULL", afterID, limit)
+}
+
+func (s *SQLiteStore) ListBlobContent(ctx context.Context, kind string, afterID string, limit int) ([]ContentRow, error) {
+	return s.listContent(ctx, kind, "content_ref IS NOT NULL", afterID, limit)
+}
+
+func (s *SQLiteStore) SetContentRef(ctx context.Context, row ContentRow) error {
+	table, ok := contentTables[row.Kind]
+	if !ok {
+		return fmt.Errorf("unknown content kind %q", row.Kind)
+	}
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE `+table+`
+		SET content_enc = ?, content_ref = nullif(?, ''), content_size = nullif(?, 0)
+		WHERE id = ?
+	`, row.ContentEnc, row.Ref, row.Size, row.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ============================================================
+// MFAStore
+// ============================================================
+
+func (s *SQLiteStore) GetTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	var t TOTP
+	var createdAt, enabledAt sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT user_id, secret, enabled, last_step, created_at, enabled_at,
+			(SELECT count(*) FROM totp_recovery_codes r WHERE r.user_id = t.user_id AND r.used_at IS NULL)
+		FROM user_totp t
+		WHERE user_id = ?
+	`, userID).Scan(&t.UserID, &t.Secret, &t.Enabled, &t.LastStep, &createdAt, &enabledAt, &t.RecoveryCodesLeft)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	t.CreatedAt = createdAt.String
+	t.EnabledAt = enabledAt.String
+	return &t, nil
+}
+
+func (s *SQLiteStore) PutTOTP(ctx context.Context, userID string, secret string) error {
+	// Chỉ thay secret chưa được xác nhận; 2FA đang bật phải tắt trước
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, created_at = datetime('now')
+		WHERE user_totp.enabled = 0
+	`, userID, secret)
+	if err != nil {
+		return mapSQLiteError(err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *SQLiteStore) EnableTOTP(ctx context.Context, userID string, step int64, codeHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE user_totp
+		SET enabled = 1, last_step = ?, enabled_at = datetime('now')
+		WHERE user_id = ? AND enabled = 0
+	`, step, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, h); err != nil {
+			return mapSQLiteError(err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	// Điều kiện last_step < step trong cùng câu UPDATE: hai request dùng cùng mã chỉ một cái thành công
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE user_totp SET last_step = ? WHERE user_id = ? AND enabled = 1 AND last_step < ?
+	`, step, userID, step)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UseRecoveryCode(ctx context.Context, userID string, codeHash string) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE totp_recovery_codes SET used_at = datetime('now')
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, userID, codeHash)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteTOTP(ctx context.Context, userID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// ============================================================
+// KeyringStore
+// ============================================================
+
+// queryWrappedKeys đọc các WrappedKey; query trả về id, enc, iv_meta (và version nếu withVersion)
+func queryWrappedKeys(ctx context.Context, tx *sql.Tx, query string, userID string, withVersion bool) ([]WrappedKey, error) {
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []WrappedKey
+	for rows.Next() {
+		var k WrappedKey
+		dest := []any{&k.ID, &k.Enc, &k.IVMeta}
+		if withVersion {
+			dest = append(dest, &k.Version)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func readKeyring(ctx context.Context, tx *sql.Tx, userID string) (*Keyring, error) {
+	var k Keyring
+	var searchKeyEnc sql.NullString
+	err := tx.QueryRowContext(ctx, `SELECT search_key_enc FROM users WHERE id = ?`, userID).Scan(&searchKeyEnc)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	k.SearchKeyEnc = searchKeyEnc.String
+
+	err = tx.QueryRowContext(ctx, `SELECT master_key_enc, key_enc FROM recovery_keys WHERE user_id = ?`, userID).
+		Scan(&k.RecoveryMasterKeyEnc, &k.RecoveryKeyEnc)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if k.Notes, err = queryWrappedKeys(ctx, tx,
+		`SELECT id, key_enc, iv_meta FROM notes WHERE user_id = ? ORDER BY id`, userID, false); err != nil {
+		return nil, err
+	}
+	if k.Versions, err = queryWrappedKeys(ctx, tx, `
+		SELECT v.note_id, v.key_enc, v.iv_meta, v.version
+		FROM note_versions v JOIN notes n ON n.id = v.note_id
+		WHERE n.user_id = ?
+		ORDER BY v.note_id, v.version`, userID, true); err != nil {
+		return nil, err
+	}
+	if k.Uploads, err = queryWrappedKeys(ctx, tx,
+		`SELECT id, key_enc, iv_meta FROM upload_sessions WHERE user_id = ? ORDER BY id`, userID, false); err != nil {
+		return nil, err
+	}
+	if k.Folders, err = queryWrappedKeys(ctx, tx,
+		`SELECT id, name_enc, '' FROM folders WHERE user_id = ? ORDER BY id`, userID, false); err != nil {
+		return nil, err
+	}
+	if k.Tags, err = queryWrappedKeys(ctx, tx,
+		`SELECT id, label_enc, '' FROM tags WHERE user_id = ? ORDER BY id`, userID, false); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *SQLiteStore) GetKeyring(ctx context.Context, userID string) (*Keyring, error) {
+	// Đọc trong một transaction để các bảng nhất quán với nhau
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return readKeyring(ctx, tx, userID)
+}
+
+func (s *SQLiteStore) ChangePassword(ctx context.Context, userID, passwordHash, kdfSalt string, k *Keyring) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := readKeyring(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if !current.sameEntries(k) {
+		return ErrConflict
+	}
+
+	// Bọc lại key không phải là sửa note: giữ updated_at mà trigger update_notes_timestamp ghi đè
+	updatedAt := map[string]string{}
+	rows, err := tx.QueryContext(ctx, `SELECT id, updated_at FROM notes WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id string
+		var at sql.NullString
+		if err := rows.Scan(&id, &at); err != nil {
+			rows.Close()
+			return err
+		}
+		updatedAt[id] = at.String
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, n := range k.Notes {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE notes SET key_enc = ?, iv_meta = COALESCE(NULLIF(?, ''), iv_meta) WHERE id = ?`,
+			n.Enc, n.IVMeta, n.ID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE notes SET updated_at = NULLIF(?, '') WHERE id = ?`, updatedAt[n.ID], n.ID); err != nil {
+			return err
+		}
+	}
+	for _, v := range k.Versions {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE note_versions SET key_enc = ?, iv_meta = COALESCE(NULLIF(?, ''), iv_meta) WHERE note_id = ? AND version = ?`,
+			v.Enc, v.IVMeta, v.ID, v.Version); err != nil {
+			return err
+		}
+	}
+	for _, u := range k.Uploads {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE upload_sessions SET key_enc = ?, iv_meta = COALESCE(NULLIF(?, ''), iv_meta) WHERE id = ?`,
+			u.Enc, u.IVMeta, u.ID); err != nil {
+			return err
+		}
+	}
+	for _, f := range k.Folders {
+		if _, err := tx.ExecContext(ctx, `UPDATE folders SET name_enc = ? WHERE id = ?`, f.Enc, f.ID); err != nil {
+			return err
+		}
+	}
+	for _, t := range k.Tags {
+		if _, err := tx.ExecContext(ctx, `UPDATE tags SET label_enc = ? WHERE id = ?`, t.Enc, t.ID); err != nil {
+			return err
+		}
+	}
+
+	if k.RecoveryKeyEnc != "" {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE recovery_keys SET master_key_enc = ?, key_enc = ?, updated_at = datetime('now')
+			WHERE user_id = ?`, k.RecoveryMasterKeyEnc, k.RecoveryKeyEnc, userID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE users SET password_hash = ?, kdf_salt = ?, search_key_enc = NULLIF(?, ''), auth_version = ? WHERE id = ?`,
+		passwordHash, kdfSalt, k.SearchKeyEnc, AuthVersionLoginKey, userID); err != nil {
+		return err
+	}
+	// Phiên đăng nhập khác vẫn giữ K_Master cũ: buộc đăng nhập lại. Xóa thay vì
+	// đánh dấu thu hồi để refresh token cũ không bị coi là reuse.
+	if _, err := tx.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ============================================================
+// RecoveryStore
+// ============================================================
+
+func (s *SQLiteStore) GetRecoveryKey(ctx context.Context, userID string) (*RecoveryKey, error) {
+	var r RecoveryKey
+	var createdAt, updatedAt sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT user_id, verifier, master_key_enc, key_enc, created_at, updated_at
+		FROM recovery_keys
+		WHERE user_id = ?
+	`, userID).Scan(&r.UserID, &r.Verifier, &r.MasterKeyEnc, &r.KeyEnc, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	r.CreatedAt = createdAt.String
+	r.UpdatedAt = updatedAt.String
+	return &r, nil
+}
+
+func (s *SQLiteStore) PutRecoveryKey(ctx context.Context, r *RecoveryKey) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO recovery_keys (user_id, verifier, master_key_enc, key_enc)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			verifier = excluded.verifier,
+			master_key_enc = excluded.master_key_enc,
+			key_enc = excluded.key_enc,
+			created_at = datetime('now'),
+			updated_at = datetime('now')
+	`, r.UserID, r.Verifier, r.MasterKeyEnc, r.KeyEnc)
+	return mapSQLiteError(err)
+}
+
+func (s *SQLiteStore) DeleteRecoveryKey(ctx context.Context, userID string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM recovery_keys WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ============================================================
+// LockoutStore
+// ============================================================
+
+const authFailureColumns = `scope, key, failures, first_failed_at, last_failed_at, locked_until`
+
+func scanAuthFailure(row interface{ Scan(...any) error }) (*AuthFailure, error) {
+	var f AuthFailure
+	var lockedUntil sql.NullString
+	if err := row.Scan(&f.Scope, &f.Key, &f.Failures, &f.FirstFailedAt, &f.LastFailedAt, &lockedUntil); err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	f.LockedUntil = lockedUntil.String
+	return &f, nil
+}
+
+func (s *SQLiteStore) GetAuthFailure(ctx context.Context, scope, key string) (*AuthFailure, error) {
+	return scanAuthFailure(s.db.QueryRowContext(ctx,
+		`SELECT `+authFailureColumns+` FROM auth_failures WHERE scope = ? AND key = ?`, scope, key))
+}
+
+func (s *SQLiteStore) ReserveAuthAttempt(ctx context.Context, scope, key, now, windowStart string, maxFailures int, lockUntil string) (*AuthFailure, error) {
+	// Một câu lệnh: khóa đang bị chặn thì không có dòng nào được sửa (không có RETURNING),
+	// còn không thì tăng bộ đếm và chặn ngay khi đạt maxFailures
+	f, err := scanAuthFailure(s.db.QueryRowContext(ctx, `
+		INSERT INTO auth_failures (scope, key, failures, first_failed_at, last_failed_at, locked_until)
+		VALUES (?, ?, 1, ?, ?, CASE WHEN 1 >= ? THEN ? END)
+		ON CONFLICT (scope, key) DO UPDATE SET
+			failures = CASE WHEN auth_failures.last_failed_at < ? THEN 1 ELSE auth_failures.failures + 1 END,
+			first_failed_at = CASE WHEN auth_failures.last_failed_at < ? THEN excluded.first_failed_at ELSE auth_failures.first_failed_at END,
+			last_failed_at = excluded.last_failed_at,
+			locked_until = CASE
+				WHEN (CASE WHEN auth_failures.last_failed_at < ? THEN 1 ELSE auth_failures.failures + 1 END) >= ? THEN ?
+				ELSE auth_failures.locked_until
+			END
+		WHERE auth_failures.locked_until IS NULL OR auth_failures.locked_until <= ?
+		RETURNING `+authFailureColumns,
+		scope, key, now, now, maxFailures, lockUntil,
+		windowStart, windowStart, windowStart, maxFailures, lockUntil, now))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrAuthLocked
+	}
+	return f, err
+}
+
+func (s *SQLiteStore) ReleaseAuthAttempt(ctx context.Context, scope, key, lockedUntil string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE auth_failures SET
+			failures = MAX(failures - 1, 0),
+			locked_until = CASE WHEN locked_until = ? THEN NULL ELSE locked_until END
+		WHERE scope = ? AND key = ?
+	`, lockedUntil, scope, key)
+	return err
+}
+
+func (s *SQLiteStore) LockAuth(ctx context.Context, scope, key, until string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE auth_failures SET locked_until = ? WHERE scope = ? AND key = ?`, until, scope, key)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ClearAuthFailures(ctx context.Context, scope, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM auth_failures WHERE scope = ? AND key = ?`, scope, key)
+	return err
+}
+
+func (s *SQLiteStore) AddAuditEvent(ctx context.Context, e *AuditEvent) error {
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO audit_log (event, scope, subject, ip, detail, created_at)
+		VALUES (?, ?, ?, ?, ?, datetime('now'))
+	`, e.Event, e.Scope, e.Subject, e.IP, e.Detail)
+	if err != nil {
+		return err
+	}
+	e.ID, _ = result.LastInsertId()
+	return nil
+}
+
+func (s *SQLiteStore) ListAuditEvents(ctx context.Context, limit int) ([]AuditEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, event, scope, subject, ip, detail, created_at
+		FROM audit_log
+		ORDER BY id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		var ip, detail, createdAt sql.NullString
+		if err := rows.Scan(&e.ID, &e.Event, &e.Scope, &e.Subject, &ip, &detail, &createdAt); err != nil {
+			return nil, err
+		}
+		e.IP, e.Detail, e.CreatedAt = ip.String, detail.String, createdAt.String
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// ============================================================
+// QuotaStore
+// ============================================================
+
+// usageSelect tính Usage của mọi user; content_size NULL (row cũ còn inline)
+// được ước lượng từ độ dài base64 như ListNotes
+const usageSelect = `
+	SELECT id, username, quota_bytes, note_bytes, version_bytes, link_bytes, upload_bytes,
+		note_count, trashed_count, active_links
+	FROM (
+		SELECT u.id, u.username, u.quota_bytes,
+			(SELECT coalesce(sum(coalesce(n.content_size, length(n.content_enc) * 3 / 4)), 0)
+				FROM notes n WHERE n.user_id = u.id) AS note_bytes,
+			(SELECT coalesce(sum(coalesce(v.content_size, length(v.content_enc) * 3 / 4)), 0)
+				FROM note_versions v JOIN notes n ON n.id = v.note_id WHERE n.user_id = u.id) AS version_bytes,
+			(SELECT coalesce(sum(coalesce(l.content_size, length(l.content_enc) * 3 / 4)), 0)
+				FROM shared_links l WHERE l.owner_id = u.id AND ` + activeLinkCond + `) AS link_bytes,
+			(SELECT coalesce(sum(s.total_size), 0)
+				FROM upload_sessions s WHERE s.user_id = u.id AND s.expires_at > datetime('now')) AS upload_bytes,
+			(SELECT count(*) FROM notes n WHERE n.user_id = u.id AND n.deleted_at IS NULL) AS note_count,
+			(SELECT count(*) FROM notes n WHERE n.user_id = u.id AND n.deleted_at IS NOT NULL) AS trashed_count,
+			(SELECT count(*) FROM shared_links l WHERE l.owner_id = u.id AND ` + activeLinkCond + `) AS active_links
+		FROM users u
+	)`
+
+// activeLinkCond: link còn mở được (giống điều kiện của GetSharedContent)
+const activeLinkCond = `l.is_active = 1
+	AND (l.expires_at IS NULL OR datetime(l.expires_at) > datetime('now'))
+	AND (l.max_views IS NULL OR l.current_views < l.max_views)`
+
+func scanUsage(row interface{ Scan(...any) error }) (*Usage, error) {
+	var u Usage
+	var quota sql.NullInt64
+	if err := row.Scan(&u.UserID, &u.Username, &quota, &u.NoteBytes, &u.VersionBytes, &u.LinkBytes,
+		&u.UploadBytes, &u.NoteCount, &u.TrashedCount, &u.ActiveLinks); err != nil {
+		return nil, err
+	}
+	if quota.Valid {
+		u.QuotaBytes = &quota.Int64
+	}
+	return &u, nil
+}
+
+func (s *SQLiteStore) GetUsage(ctx context.Context, userID string) (*Usage, error) {
+	u, err := scanUsage(s.db.QueryRowContext(ctx, usageSelect+` WHERE id = ?`, userID))
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	return u, nil
+}
+
+func (s *SQLiteStore) ListTopUsage(ctx context.Context, limit int) ([]Usage, error) {
+	rows, err := s.db.QueryContext(ctx,
+		usageSelect+` ORDER BY note_bytes + version_bytes + link_bytes DESC, username LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var usages []Usage
+	for rows.Next() {
+		u, err := scanUsage(rows)
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, *u)
+	}
+	return usages, rows.Err()
+}
+
+func (s *SQLiteStore) SetUserQuota(ctx context.Context, userID string, quota *int64) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE users SET quota_bytes = ? WHERE id = ?`, quota, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ============================================================
+// MaintenanceStore
+// ============================================================
+
+func execCount(ctx context.Context, db *sql.DB, query string, args ...any) (int64, error) {
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQLiteStore) PurgeExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	return execCount(ctx, s.db, `DELETE FROM refresh_tokens WHERE expires_at < datetime('now')`)
+}
+
+func (s *SQLiteStore) PurgeExpiredBlacklist(ctx context.Context) (int64, error) {
+	return execCount(ctx, s.db, `DELETE FROM token_blacklist WHERE expires_at < datetime('now')`)
+}
+
+func (s *SQLiteStore) DeactivateExpiredShareLinks(ctx context.Context) (int64, error) {
+	// expires_at của shared_links là RFC3339 (có múi giờ), datetime() chuẩn hóa về UTC
+	return execCount(ctx, s.db, `
+		UPDATE shared_links
+		SET is_active = 0
+		WHERE expires_at IS NOT NULL AND datetime(expires_at) < datetime('now') AND is_active = 1
+	`)
+}
+
+func (s *SQLiteStore) DeactivateExhaustedShareLinks(ctx context.Context) (int64, error) {
+	return execCount(ctx, s.db, `
+		UPDATE shared_links
+		SET is_active = 0
+		WHERE max_views IS NOT NULL
+		  AND current_views >= max_views
+		  AND is_active = 1
+	`)
+}
+
+func (s *SQLiteStore) PurgeExpiredUploads(ctx context.Context) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM upload_chunks
+		WHERE session_id IN (SELECT id FROM upload_sessions WHERE expires_at < datetime('now'))
+	`); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM upload_sessions WHERE expires_at < datetime('now')`)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (s *SQLiteStore) PurgeTrashedNotes(ctx context.Context, before string) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM notes WHERE deleted_at IS NOT NULL AND deleted_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err := deleteNoteRows(ctx, tx, id); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM notes WHERE id = ?`, id); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(ids)), tx.Commit()
+}
+
+func (s *SQLiteStore) PurgeAuthFailures(ctx context.Context, before string) (int64, error) {
+	return execCount(ctx, s.db, `
+		DELETE FROM auth_failures
+		WHERE last_failed_at < ? AND (locked_until IS NULL OR locked_until < datetime('now'))
+	`, before)
+}
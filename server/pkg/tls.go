@@ -0,0 +1,278 @@
+package serverpkg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================
+// TLS - HTTPS, mutual TLS và nạp lại certificate khi đang chạy
+// ============================================================
+
+// TLSOptions là cấu hình TLS truyền vào NewTLSManager
+type TLSOptions struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	// ClientAuth: none | request | verify_if_given | require
+	ClientAuth string
+}
+
+// TLSManager giữ certificate, key và client CA hiện tại.
+// Reload đọc lại các file; handshake sau đó dùng giá trị mới mà không cần restart.
+type TLSManager struct {
+	opts       TLSOptions
+	clientAuth tls.ClientAuthType
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+// parseClientAuth chuyển giá trị cấu hình sang tls.ClientAuthType
+func parseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "verify_if_given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("invalid client auth mode %q", mode)
+}
+
+// NewTLSManager nạp certificate (và client CA nếu có) lần đầu
+func NewTLSManager(opts TLSOptions) (*TLSManager, error) {
+	clientAuth, err := parseClientAuth(opts.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if clientAuth >= tls.VerifyClientCertIfGiven && opts.ClientCAFile == "" {
+		return nil, errors.New("client certificate verification requires a client CA file")
+	}
+	m := &TLSManager{opts: opts, clientAuth: clientAuth}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload đọc lại certificate, key và client CA từ đĩa.
+// Nếu lỗi, cấu hình đang dùng được giữ nguyên.
+func (m *TLSManager) Reload() error {
+	cert, err := tls.LoadX509KeyPair(m.opts.CertFile, m.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if m.opts.ClientCAFile != "" {
+		pemData, err := os.ReadFile(m.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("read client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return fmt.Errorf("no certificates found in %s", m.opts.ClientCAFile)
+		}
+	}
+
+	m.mu.Lock()
+	m.cert = &cert
+	m.clientCAs = pool
+	m.modTimes = m.currentModTimes()
+	m.mu.Unlock()
+	return nil
+}
+
+// currentModTimes trả về thời điểm sửa đổi của các file đang theo dõi
+func (m *TLSManager) currentModTimes() map[string]time.Time {
+	times := map[string]time.Time{}
+	for _, path := range []string{m.opts.CertFile, m.opts.KeyFile, m.opts.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			times[path] = info.ModTime()
+		}
+	}
+	return times
+}
+
+// changed cho biết có file nào thay đổi kể từ lần nạp gần nhất
+func (m *TLSManager) changed() bool {
+	current := m.currentModTimes()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for path, t := range current {
+		if !t.Equal(m.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchFiles kiểm tra file định kỳ và tự Reload khi có thay đổi (dừng khi stop đóng)
+func (m *TLSManager) WatchFiles(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if !m.changed() {
+				continue
+			}
+			if err := m.Reload(); err != nil {
+				log.Printf("tls: reload failed, keeping current certificate: %v", err)
+				continue
+			}
+			log.Printf("tls: certificate reloaded (SPKI pin %s)", m.Pin())
+		}
+	}
+}
+
+// Pin trả về SPKI pin ("sha256/<base64>") của certificate hiện tại,
+// dùng cho API_PIN_SHA256 phía client
+func (m *TLSManager) Pin() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil || len(m.cert.Certificate) == 0 {
+		return ""
+	}
+	leaf, err := x509.ParseCertificate(m.cert.Certificate[0])
+	if err != nil {
+		return ""
+	}
+	return SPKIPin(leaf)
+}
+
+// SPKIPin tính SHA256 của SubjectPublicKeyInfo, dạng "sha256/<base64>"
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// TLSConfig trả về tls.Config dùng cho http.Server.
+// Certificate và client CA được lấy mới ở mỗi handshake.
+func (m *TLSManager) TLSConfig() *tls.Config {
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{*m.cert},
+			ClientAuth:   m.clientAuth,
+			ClientCAs:    m.clientCAs,
+		}, nil
+	}
+	return base
+}
+
+// ClientCertMiddleware đưa thông tin client certificate (nếu có) vào context:
+// "client_cert_subject" và "client_cert_pin"
+func ClientCertMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS != nil && len(c.Request.TLS.PeerCertificates) > 0 {
+			leaf := c.Request.TLS.PeerCertificates[0]
+			c.Set("client_cert_subject", leaf.Subject.String())
+			c.Set("client_cert_pin", SPKIPin(leaf))
+		}
+		c.Next()
+	}
+}
+
+// EnsureSelfSignedCert tạo certificate tự ký (ECDSA P-256, 1 năm) cho localhost
+// nếu certFile hoặc keyFile chưa tồn tại. Chỉ dùng cho môi trường dev.
+func EnsureSelfSignedCert(certFile, keyFile string) (bool, error) {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if certErr == nil && keyErr == nil {
+		return false, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return false, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return false, err
+	}
+
+	hostname, _ := os.Hostname()
+	dnsNames := []string{"localhost"}
+	if hostname != "" && hostname != "localhost" {
+		dnsNames = append(dnsNames, hostname)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "secure-notes dev", Organization: []string{"Secure Notes (self-signed)"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              dnsNames,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return false, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return false, err
+		}
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return false, err
+	}
+	if err := writePEM(keyFile, "PRIVATE KEY", keyDER, 0600); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,521 @@
+package serverpkg
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// ============================================================
+// TWO-FACTOR AUTHENTICATION - TOTP (RFC 6238)
+// ============================================================
+//
+// 1. POST   /api/me/2fa/enroll  (mật khẩu) tạo secret, trả về otpauth:// URI
+// 2. POST   /api/me/2fa/verify  mã đầu tiên đúng thì bật 2FA và trả recovery code (chỉ hiện một lần)
+// 3. POST   /api/login          trả mfa_token thay cho access/refresh token
+// 4. POST   /api/login/mfa      mfa_token + mã TOTP hoặc recovery code -> LoginResponse
+//    DELETE /api/me/2fa         (mật khẩu + mã) tắt 2FA
+
+const (
+	totpIssuer = "SecureNotes"
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// totpSkew: số step lệch cho phép mỗi phía (đồng hồ client lệch tối đa ~30s)
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+
+	// mfaTokenPurpose là claim "purpose" của mfa_token; JWTMiddleware từ chối token có purpose
+	mfaTokenPurpose = "mfa"
+	mfaTokenTTL     = 5 * time.Minute
+)
+
+// MFAChallengeResponse được Login trả về thay cho LoginResponse khi user đã bật 2FA
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// LoginMFARequest là bước hai của đăng nhập; code là mã TOTP hoặc recovery code
+type LoginMFARequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTOTPSecret sinh secret 160 bit (độ dài khuyến nghị cho HMAC-SHA1)
+func generateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpURI là provisioning URI cho ứng dụng authenticator (thường hiển thị dạng QR)
+func totpURI(username string, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// totpCode tính mã của time step (HOTP, RFC 4226)
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTOTP trả về time step khớp với code (trong khoảng ±totpSkew quanh now)
+// và mới hơn lastStep; false nếu không khớp
+func matchTOTP(secret string, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / int64(totpPeriod/time.Second)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// normalizeRecoveryCode bỏ dấu gạch, khoảng trắng và đưa về chữ thường
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// hashRecoveryCode: SHA256 là đủ vì code có 80 bit ngẫu nhiên; user_id để cùng code
+// của hai user không trùng hash
+func hashRecoveryCode(userID string, code string) string {
+	sum := sha256.Sum256([]byte(userID + ":" + normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateRecoveryCodes sinh recovery code dạng xxxx-xxxx-xxxx-xxxx (base32 chữ thường)
+func generateRecoveryCodes(userID string) (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(totpEncoding.EncodeToString(raw))
+		code := s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(userID, code))
+	}
+	return codes, hashes, nil
+}
+
+// errInvalidSecondFactor: mã TOTP sai, đã dùng, hoặc recovery code không hợp lệ
+var errInvalidSecondFactor = errors.New("invalid authentication code")
+
+// verifySecondFactor kiểm tra code là mã TOTP (6 chữ số) hoặc recovery code của t
+// và đánh dấu nó đã dùng
+func (s *Server) verifySecondFactor(ctx context.Context, t *TOTP, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		step, ok := matchTOTP(t.Secret, code, time.Now(), t.LastStep)
+		if !ok {
+			return errInvalidSecondFactor
+		}
+		if err := s.MFA.UseTOTPStep(ctx, t.UserID, step); err != nil {
+			if errors.Is(err, ErrConflict) {
+				return errInvalidSecondFactor
+			}
+			return err
+		}
+		return nil
+	}
+	if err := s.MFA.UseRecoveryCode(ctx, t.UserID, hashRecoveryCode(t.UserID, code)); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return errInvalidSecondFactor
+		}
+		return err
+	}
+	return nil
+}
+
+// enabledTOTP trả về cấu hình TOTP nếu user đã bật 2FA (nil nếu chưa bật)
+func (s *Server) enabledTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	if s.MFA == nil {
+		return nil, nil
+	}
+	t, err := s.MFA.GetTOTP(ctx, userID)
+	if errors.Is(err, ErrNotFound) || (err == nil && !t.Enabled) {
+		return nil, nil
+	}
+	return t, err
+}
+
+// generateMFAToken tạo mfa_token: JWT ngắn hạn chỉ dùng được cho POST /api/login/mfa
+func generateMFAToken(userID string, username string) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id":  userID,
+		"username": username,
+		"purpose":  mfaTokenPurpose,
+		"exp":      time.Now().Add(mfaTokenTTL).Unix(),
+		"iat":      time.Now().Unix(),
+		"jti":      uuid.New().String(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(JWTSecretKey)
+}
+
+// checkAccountCredentials xác nhận lại mật khẩu của user đang đăng nhập (thao tác
+// nhạy cảm): login key, hoặc mật khẩu nếu tài khoản chưa có login key (loginkey.go).
+// Lần sai được tính vào lockout của tài khoản như khi đăng nhập.
+// Trả về nil (đã ghi response lỗi) nếu sai.
+func (s *Server) checkAccountCredentials(c *gin.Context, userID string, password, loginKey string) *User {
+	user, err := s.Users.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query user"})
+		return nil
+	}
+	if user.AuthVersion == AuthVersionPassword && password == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "account still uses a password hash, log in again to switch to a login key"})
+		return nil
+	}
+	subjects := loginSubjects(c, user.Username)
+	if !s.reserveAuthAttempt(c, subjects) {
+		return nil
+	}
+	// Lần thử không kết thúc bằng đúng / sai (lỗi server) được hoàn lại
+	defer s.releaseAuthAttempts(c)
+	if ok, err := verifyCredentials(user, password, loginKey); err != nil || !ok {
+		s.recordAuthFailure(c, subjects)
+		c.JSON(http.StatusForbidden, gin.H{"error": "invalid password"})
+		return nil
+	}
+	s.clearAuthFailures(c, subjects)
+	return user
+}
+
+// GetTwoFactor - Trạng thái xác thực hai bước
+// GET /api/me/2fa
+// Response: { "enabled": true, "pending": false, "enabled_at": "...", "recovery_codes_left": 9 }
+func (s *Server) GetTwoFactor(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if s.MFA == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "two-factor authentication is not available"})
+		return
+	}
+
+	t, err := s.MFA.GetTOTP(c.Request.Context(), userID.(string))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query two-factor settings"})
+		return
+	}
+	resp := gin.H{"enabled": false, "pending": false}
+	if t != nil {
+		resp["enabled"] = t.Enabled
+		resp["pending"] = !t.Enabled
+		if t.Enabled {
+			resp["enabled_at"] = t.EnabledAt
+			resp["recovery_codes_left"] = t.RecoveryCodesLeft
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// EnrollTwoFactor - Tạo secret TOTP mới (chưa có hiệu lực cho tới khi verify)
+// POST /api/me/2fa/enroll
+// Request: { "login_key": "base64" }
+// Response: { "secret": "BASE32...", "otpauth_uri": "otpauth://totp/...", "digits": 6, "period": 30 }
+// Enroll lại trước khi verify thay secret cũ; 2FA đang bật phải tắt trước (409).
+func (s *Server) EnrollTwoFactor(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if s.MFA == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "two-factor authentication is not available"})
+		return
+	}
+
+	var req struct {
+		LoginKey string `json:"login_key" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := s.checkAccountCredentials(c, userID.(string), "", req.LoginKey)
+	if user == nil {
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+		return
+	}
+	if err := s.MFA.PutTOTP(c.Request.Context(), user.ID, secret); err != nil {
+		if errors.Is(err, ErrConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save two-factor settings"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": totpURI(user.Username, secret),
+		"digits":      totpDigits,
+		"period":      int(totpPeriod / time.Second),
+	})
+}
+
+// VerifyTwoFactor - Xác nhận mã đầu tiên và bật 2FA
+// POST /api/me/2fa/verify
+// Request: { "code": "123456" }
+// Response: { "message": "two-factor authentication enabled", "recovery_codes": ["abcd-efgh-ijkl-mnop", ...] }
+// Recovery code chỉ được trả về một lần; server chỉ lưu SHA256.
+func (s *Server) VerifyTwoFactor(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if s.MFA == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "two-factor authentication is not available"})
+		return
+	}
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	t, err := s.MFA.GetTOTP(ctx, userID.(string))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query two-factor settings"})
+		return
+	}
+	if t == nil || t.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "no pending two-factor enrollment"})
+		return
+	}
+	step, ok := matchTOTP(t.Secret, strings.TrimSpace(req.Code), time.Now(), 0)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidSecondFactor.Error()})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes(t.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"})
+		return
+	}
+	if err := s.MFA.EnableTOTP(ctx, t.UserID, step, hashes); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusConflict, gin.H{"error": "no pending two-factor enrollment"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-factor authentication"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor - Tắt 2FA (hoặc hủy enroll đang chờ)
+// DELETE /api/me/2fa
+// Request: { "login_key": "base64", "code": "123456 hoặc recovery code" } (code không cần khi 2FA chưa bật)
+// Response: { "message": "two-factor authentication disabled" }
+func (s *Server) DisableTwoFactor(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if s.MFA == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "two-factor authentication is not available"})
+		return
+	}
+
+	var req struct {
+		LoginKey string `json:"login_key" binding:"required"`
+		Code     string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if s.checkAccountCredentials(c, userID.(string), "", req.LoginKey) == nil {
+		return
+	}
+
+	ctx := c.Request.Context()
+	t, err := s.MFA.GetTOTP(ctx, userID.(string))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "two-factor authentication is not enabled"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query two-factor settings"})
+		}
+		return
+	}
+	if t.Enabled {
+		if err := s.verifySecondFactor(ctx, t, req.Code); err != nil {
+			if errors.Is(err, errInvalidSecondFactor) {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+			}
+			return
+		}
+	}
+
+	if err := s.MFA.DeleteTOTP(ctx, t.UserID); err != nil && !errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable two-factor authentication"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// LoginMFA handles the second login step for users with 2FA enabled.
+// mfa_token chỉ dùng được một lần: mã sai cũng làm token hết hiệu lực, client
+// phải đăng nhập lại bằng mật khẩu (giới hạn số lần đoán mã cho mỗi lần đúng mật khẩu).
+// POST /api/login/mfa
+// Request: { "mfa_token": "...", "code": "123456" }
+// Response: LoginResponse
+// Mã sai được tính vào số lần sai của tài khoản như mật khẩu sai (lockout.go).
+func (s *Server) LoginMFA(c *gin.Context) {
+	var req LoginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	token, claims, err := ParseJWT(req.MFAToken)
+	if err != nil || token == nil || claims["purpose"] != mfaTokenPurpose {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error: "Invalid or expired MFA token",
+		})
+		return
+	}
+	userID, _ := claims["user_id"].(string)
+	jti, _ := claims["jti"].(string)
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil || jti == "" {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error: "Invalid or expired MFA token",
+		})
+		return
+	}
+	// Token chỉ dùng một lần, dù mã đúng hay sai. Đánh dấu đã dùng trước khi kiểm
+	// tra mã để các request song song với cùng token chỉ có một lần đoán.
+	if err := s.Tokens.ConsumeToken(ctx, jti, sqliteTime(exp.Time)); err != nil {
+		if errors.Is(err, ErrConflict) {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error: "Invalid or expired MFA token",
+			})
+		} else {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "Authentication error",
+			})
+		}
+		return
+	}
+
+	t, err := s.enabledTOTP(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Authentication error",
+		})
+		return
+	}
+	user, err := s.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error: "Invalid or expired MFA token",
+		})
+		return
+	}
+	subjects := loginSubjects(c, user.Username)
+	if !s.reserveAuthAttempt(c, subjects) {
+		return
+	}
+	// Lần thử không kết thúc bằng đúng / sai (lỗi server) được hoàn lại
+	defer s.releaseAuthAttempts(c)
+	// 2FA đã bị tắt sau khi token được cấp thì mật khẩu đúng là đủ
+	if t != nil {
+		if err := s.verifySecondFactor(ctx, t, req.Code); err != nil {
+			if errors.Is(err, errInvalidSecondFactor) {
+				s.recordAuthFailure(c, subjects)
+				c.JSON(http.StatusUnauthorized, ErrorResponse{
+					Error: "Invalid authentication code",
+				})
+			} else {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{
+					Error: "Authentication error",
+				})
+			}
+			return
+		}
+	}
+
+	accessToken, refreshToken, err := s.GenerateJWT(ctx, user.ID, user.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to generate token",
+		})
+		return
+	}
+	s.clearAuthFailures(c, subjects)
+	c.JSON(http.StatusOK, LoginResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		KdfSalt:      user.KdfSalt,
+		SearchKeyEnc: user.SearchKeyEnc,
+	})
+}
@@ -0,0 +1,198 @@
+package serverpkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret là secret SHA1 của phụ lục B RFC 6238 (ASCII "12345678901234567890")
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// Phụ lục B RFC 6238 (SHA1, 8 chữ số); mã 6 chữ số là 6 chữ số cuối
+	vectors := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, v := range vectors {
+		step := v.unix / int64(totpPeriod/time.Second)
+		if got := totpCode(rfc6238Secret, step); got != v.want[2:] {
+			t.Errorf("T=%d: code = %s, want %s", v.unix, got, v.want[2:])
+		}
+	}
+}
+
+func TestMatchTOTPWindow(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Secret)
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / int64(totpPeriod/time.Second)
+
+	for offset := int64(-3); offset <= 3; offset++ {
+		code := totpCode(rfc6238Secret, current+offset)
+		step, ok := matchTOTP(secret, code, now, 0)
+		want := offset >= -totpSkew && offset <= totpSkew
+		if ok != want {
+			t.Errorf("offset %d: ok = %v, want %v", offset, ok, want)
+		}
+		if ok && step != current+offset {
+			t.Errorf("offset %d: step = %d, want %d", offset, step, current+offset)
+		}
+	}
+}
+
+func TestMatchTOTPRejectsReplay(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Secret)
+	now := time.Unix(1111111111, 0)
+	code := totpCode(rfc6238Secret, now.Unix()/int64(totpPeriod/time.Second))
+
+	step, ok := matchTOTP(secret, code, now, 0)
+	if !ok {
+		t.Fatal("valid code rejected")
+	}
+	// Mã đã dùng (hoặc cũ hơn lần dùng gần nhất) không được chấp nhận lại
+	if _, ok := matchTOTP(secret, code, now, step); ok {
+		t.Fatal("replayed code accepted")
+	}
+	if _, ok := matchTOTP(secret, code, now.Add(totpPeriod), step); ok {
+		t.Fatal("replayed code accepted in the next step")
+	}
+}
+
+func TestMatchTOTPInvalidInput(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Secret)
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082", "abcdef"} {
+		if _, ok := matchTOTP(secret, code, now, 0); ok {
+			t.Errorf("code %q accepted", code)
+		}
+	}
+	if _, ok := matchTOTP("not base32!", "287082", now, 0); ok {
+		t.Error("invalid secret accepted")
+	}
+	if _, ok := matchTOTP(secret, "287082", now, 0); !ok {
+		t.Error("valid code rejected")
+	}
+}
+
+// enableTestTOTP bật 2FA cho user và trả về secret
+func (ts *testServer) enableTestTOTP(t *testing.T, userID string) string {
+	t.Helper()
+	ctx := context.Background()
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.MFA.PutTOTP(ctx, userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.MFA.EnableTOTP(ctx, userID, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func TestLoginMFATokenSingleUse(t *testing.T) {
+	ts := newTestServer(t)
+	userID, _, _ := ts.newUser(t, "alice", testLoginKey)
+	secret := ts.enableTestTOTP(t, userID)
+	mfaToken, err := generateMFAToken(userID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := totpCode(key, time.Now().Unix()/int64(totpPeriod/time.Second))
+
+	body := map[string]any{"mfa_token": mfaToken, "code": code}
+	expectStatus(t, ts.do(http.MethodPost, "/api/login/mfa", "", body), http.StatusOK)
+	w := ts.do(http.MethodPost, "/api/login/mfa", "", body)
+	expectStatus(t, w, http.StatusUnauthorized)
+	if got := decode(t, w)["error"]; got != "Invalid or expired MFA token" {
+		t.Fatalf("error = %q", got)
+	}
+}
+
+func TestLoginMFATokenParallelGuesses(t *testing.T) {
+	ts := newTestServer(t)
+	userID, _, _ := ts.newUser(t, "alice", testLoginKey)
+	ts.enableTestTOTP(t, userID)
+	mfaToken, err := generateMFAToken(userID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mỗi request đoán một mã sai khác nhau; chỉ một request được kiểm tra mã
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errs := map[any]int{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := ts.do(http.MethodPost, "/api/login/mfa", "", map[string]any{"mfa_token": mfaToken, "code": fmt.Sprintf("wrong-code-%d", i)})
+			mu.Lock()
+			defer mu.Unlock()
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want 401", w.Code)
+				return
+			}
+			errs[decode(t, w)["error"]]++
+		}(i)
+	}
+	wg.Wait()
+	if errs["Invalid authentication code"] != 1 || errs["Invalid or expired MFA token"] != 9 {
+		t.Fatalf("errors = %v, want one checked guess", errs)
+	}
+}
+
+func TestConsumeTokenParallel(t *testing.T) {
+	for name, s := range lockoutStores(t) {
+		store := s.(TokenStore)
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			expiresAt := sqliteTime(time.Now().Add(time.Minute))
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			consumed, conflicts := 0, 0
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := store.ConsumeToken(ctx, "jti-1", expiresAt)
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case err == nil:
+						consumed++
+					case errors.Is(err, ErrConflict):
+						conflicts++
+					default:
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+			if consumed != 1 || conflicts != 19 {
+				t.Fatalf("consumed %d, conflicts %d; want 1 and 19", consumed, conflicts)
+			}
+			if blacklisted, err := store.IsTokenBlacklisted(ctx, "jti-1"); err != nil || !blacklisted {
+				t.Fatalf("blacklisted = %v, %v", blacklisted, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package serverpkg
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================
+// THÙNG RÁC - Soft delete và purge định kỳ
+// ============================================================
+//
+// DELETE /api/notes/:id chỉ đặt deleted_at. Note trong thùng rác vẫn giữ nguyên
+// ciphertext, phiên bản cũ, share và tag để khôi phục được; nó bị ẩn khỏi danh sách,
+// người nhận share và share link. Maintenance xóa vĩnh viễn các note đã nằm trong
+// thùng rác lâu hơn TrashRetention.
+
+// trashPurgeAt là thời điểm note bị xóa vĩnh viễn nếu không được khôi phục
+func (s *Server) trashPurgeAt(deletedAt string) string {
+	t, err := time.Parse(sqliteTimeFormat, deletedAt)
+	if err != nil {
+		return ""
+	}
+	return sqliteTime(t.Add(s.TrashRetention))
+}
+
+// loadTrashedNote lấy note trong thùng rác của user hiện tại.
+// Trả về nil (đã ghi response lỗi) nếu không tìm thấy hoặc không có quyền.
+func (s *Server) loadTrashedNote(c *gin.Context, noteID string, userID string) *Note {
+	note, err := s.Notes.GetNote(c.Request.Context(), noteID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query note"})
+		}
+		return nil
+	}
+	if note.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only owner can manage trash"})
+		return nil
+	}
+	if note.DeletedAt == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "note is not in trash"})
+		return nil
+	}
+	return note
+}
+
+// ListTrash - Danh sách ghi chú trong thùng rác
+// GET /api/trash
+// Response: { "notes": [{ "id": "...", "title": "...", "key_enc": "...", "iv_meta": "...", "version": 2, "size": 123, "created_at": "...", "updated_at": "...", "deleted_at": "...", "purge_at": "..." }], "retention_seconds": 2592000 }
+func (s *Server) ListTrash(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	notes, err := s.Notes.ListTrash(c.Request.Context(), userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query trash"})
+		return
+	}
+
+	entries := make([]gin.H, 0, len(notes))
+	for _, n := range notes {
+		entries = append(entries, gin.H{
+			"id":         n.ID,
+			"title":      n.TitleEnc,
+			"key_enc":    n.KeyEnc,
+			"iv_meta":    n.IVMeta,
+			"version":    n.Version,
+			"size":       n.ContentSize,
+			"created_at": n.CreatedAt,
+			"updated_at": n.UpdatedAt,
+			"deleted_at": n.DeletedAt,
+			"purge_at":   s.trashPurgeAt(n.DeletedAt),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"notes":             entries,
+		"retention_seconds": int64(s.TrashRetention / time.Second),
+	})
+}
+
+// RestoreTrashedNote - Khôi phục ghi chú từ thùng rác (giữ nguyên version, folder, tag và share)
+// POST /api/trash/:id/restore
+// Response: { "message": "note restored", "id": "...", "version": 2 }
+func (s *Server) RestoreTrashedNote(c *gin.Context) {
+	noteID := c.Param("id")
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	note := s.loadTrashedNote(c, noteID, userID.(string))
+	if note == nil {
+		return
+	}
+
+	if err := s.Notes.RestoreNote(c.Request.Context(), noteID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "note is not in trash"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore note"})
+		}
+		return
+	}
+
+	c.Header("ETag", noteETag(note.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "note restored",
+		"id":      note.ID,
+		"version": note.Version,
+	})
+}
+
+// PurgeTrashedNote - Xóa vĩnh viễn một ghi chú đang nằm trong thùng rác
+// DELETE /api/trash/:id
+// Response: { "message": "note deleted permanently" }
+// Phiên bản cũ, share và tag bị xóa theo; share link tạo từ note bị vô hiệu hóa.
+// Blob không còn được tham chiếu được GC thu dọn sau đó.
+func (s *Server) PurgeTrashedNote(c *gin.Context) {
+	noteID := c.Param("id")
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if s.loadTrashedNote(c, noteID, userID.(string)) == nil {
+		return
+	}
+
+	if err := s.Notes.DeleteNote(c.Request.Context(), noteID, 0); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete note"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "note deleted permanently",
+	})
+}
@@ -0,0 +1,72 @@
+package serverpkg
+
+import (
+	"net/http"
+	"testing"
+)
+
+// trashNote tạo note rồi chuyển vào thùng rác
+func (ts *testServer) trashNote(t *testing.T, token string) string {
+	t.Helper()
+	id := ts.createNote(t, token)
+	expectStatus(t, ts.do(http.MethodDelete, "/api/notes/"+id, token, nil, "If-Match", "*"), http.StatusOK)
+	return id
+}
+
+func trashIDs(t *testing.T, ts *testServer, token string) []string {
+	t.Helper()
+	w := ts.do(http.MethodGet, "/api/trash", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	var ids []string
+	for _, n := range decode(t, w)["notes"].([]any) {
+		ids = append(ids, n.(map[string]any)["id"].(string))
+	}
+	return ids
+}
+
+func TestTrashRestore(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	id := ts.trashNote(t, token)
+
+	if ids := trashIDs(t, ts, token); len(ids) != 1 || ids[0] != id {
+		t.Fatalf("trash = %v, want [%s]", ids, id)
+	}
+
+	w := ts.do(http.MethodPost, "/api/trash/"+id+"/restore", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if v := decode(t, w)["version"]; v != float64(1) {
+		t.Fatalf("version = %v, want 1", v)
+	}
+	expectStatus(t, ts.do(http.MethodGet, "/api/notes/"+id, token, nil), http.StatusOK)
+	if ids := trashIDs(t, ts, token); len(ids) != 0 {
+		t.Fatalf("trash = %v, want empty", ids)
+	}
+
+	// Note không nằm trong thùng rác
+	expectStatus(t, ts.do(http.MethodPost, "/api/trash/"+id+"/restore", token, nil), http.StatusNotFound)
+}
+
+func TestTrashPurge(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	id := ts.trashNote(t, token)
+
+	expectStatus(t, ts.do(http.MethodDelete, "/api/trash/"+id, token, nil), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodGet, "/api/notes/"+id, token, nil), http.StatusNotFound)
+	expectStatus(t, ts.do(http.MethodPost, "/api/trash/"+id+"/restore", token, nil), http.StatusNotFound)
+	expectStatus(t, ts.do(http.MethodDelete, "/api/trash/"+id, token, nil), http.StatusNotFound)
+}
+
+func TestTrashOnlyOwner(t *testing.T) {
+	ts := newTestServer(t)
+	_, alice, _ := ts.newUser(t, "alice", testLoginKey)
+	_, bob, _ := ts.newUser(t, "bob", testLoginKey)
+	id := ts.trashNote(t, alice)
+
+	expectStatus(t, ts.do(http.MethodPost, "/api/trash/"+id+"/restore", bob, nil), http.StatusForbidden)
+	expectStatus(t, ts.do(http.MethodDelete, "/api/trash/"+id, bob, nil), http.StatusForbidden)
+	if ids := trashIDs(t, ts, bob); len(ids) != 0 {
+		t.Fatalf("bob's trash = %v, want empty", ids)
+	}
+}
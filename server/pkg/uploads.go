@@ -0,0 +1,363 @@
+package serverpkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ============================================================
+// RESUMABLE UPLOAD APIs - Upload ciphertext lớn theo từng chunk
+// ============================================================
+//
+// 1. POST   /api/uploads                   tạo session (metadata note + kích thước)
+// 2. PUT    /api/uploads/:id/chunks/:index gửi chunk (body là ciphertext thô)
+// 3. GET    /api/uploads/:id               xem chunk đã nhận để resume sau khi mất kết nối
+// 4. POST   /api/uploads/:id/finalize      kiểm tra SHA256 toàn bộ ciphertext và tạo note
+//
+// Mỗi chunk được lưu thành một blob; finalize ghép chúng thành blob của note.
+// Blob của chunk được GC thu dọn sau khi session bị xóa.
+//    DELETE /api/uploads/:id               hủy session
+
+// loadUpload lấy session của user hiện tại.
+// Trả về nil (đã ghi response lỗi) nếu không tồn tại, thuộc user khác hoặc đã hết hạn.
+func (s *Server) loadUpload(c *gin.Context, uploadID string, userID string) *UploadSession {
+	upload, err := s.Uploads.GetUpload(c.Request.Context(), uploadID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "upload session not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query upload session"})
+		}
+		return nil
+	}
+
+	// Không tiết lộ session của user khác
+	if upload.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload session not found"})
+		return nil
+	}
+	if upload.ExpiresAt < sqliteTime(time.Now()) {
+		c.JSON(http.StatusGone, gin.H{"error": "upload session expired"})
+		return nil
+	}
+	return upload
+}
+
+// uploadStatus là body JSON mô tả tiến độ của session
+func uploadStatus(upload *UploadSession, chunks []UploadChunk) gin.H {
+	received := make([]gin.H, 0, len(chunks))
+	have := map[int]bool{}
+	for _, ch := range chunks {
+		have[ch.Index] = true
+		received = append(received, gin.H{"index": ch.Index, "size": ch.Size, "sha256": ch.SHA256})
+	}
+	missing := []int{}
+	for i := 0; i < upload.ChunkCount(); i++ {
+		if !have[i] {
+			missing = append(missing, i)
+		}
+	}
+	return gin.H{
+		"upload_id":   upload.ID,
+		"total_size":  upload.TotalSize,
+		"chunk_size":  upload.ChunkSize,
+		"chunk_count": upload.ChunkCount(),
+		"expires_at":  upload.ExpiresAt,
+		"received":    received,
+		"missing":     missing,
+	}
+}
+
+// CreateUpload - Tạo phiên upload chunk
+// POST /api/uploads
+// Request: { "title": "...", "key_enc": "...", "iv_meta": "{...}", "total_size": 123, "chunk_size": 4194304, "search_tokens": ["..."] }
+// Response: { "upload_id": "...", "chunk_size": 4194304, "chunk_count": 1, "expires_at": "..." }
+func (s *Server) CreateUpload(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		Title     string `json:"title" binding:"required"`
+		KeyEnc    string `json:"key_enc" binding:"required"`
+		IVMeta    string `json:"iv_meta" binding:"required"`
+		TotalSize int64  `json:"total_size" binding:"required"`
+		ChunkSize int64  `json:"chunk_size"`
+
+		SearchTokens []string `json:"search_tokens"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tokens, err := normalizeSearchTokens(req.SearchTokens, MaxSearchTokens)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limits := s.UploadLimits
+	if req.TotalSize <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "total_size must be positive"})
+		return
+	}
+	if req.TotalSize > limits.MaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "upload too large", "max_size": limits.MaxSize})
+		return
+	}
+	if req.ChunkSize == 0 {
+		req.ChunkSize = limits.MaxChunkSize
+	}
+	if req.ChunkSize < 0 || req.ChunkSize > limits.MaxChunkSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chunk_size", "max_chunk_size": limits.MaxChunkSize})
+		return
+	}
+	// Session giữ chỗ total_size cho tới khi finalize hoặc hết hạn
+	if !s.checkQuota(c, userID.(string), req.TotalSize, true) {
+		return
+	}
+
+	upload := &UploadSession{
+		ID:        uuid.New().String(),
+		UserID:    userID.(string),
+		TitleEnc:  req.Title,
+		KeyEnc:    req.KeyEnc,
+		IVMeta:    req.IVMeta,
+		TotalSize: req.TotalSize,
+		ChunkSize: req.ChunkSize,
+		ExpiresAt: sqliteTime(time.Now().Add(limits.SessionTTL)),
+
+		SearchTokens: tokens,
+	}
+	if err := s.Uploads.CreateUpload(c.Request.Context(), upload); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload session"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"upload_id":   upload.ID,
+		"chunk_size":  upload.ChunkSize,
+		"chunk_count": upload.ChunkCount(),
+		"expires_at":  upload.ExpiresAt,
+	})
+}
+
+// GetUpload - Trạng thái session: chunk đã nhận (kèm SHA256) và chunk còn thiếu
+// GET /api/uploads/:id
+func (s *Server) GetUpload(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	upload := s.loadUpload(c, c.Param("id"), userID.(string))
+	if upload == nil {
+		return
+	}
+
+	chunks, err := s.Uploads.ListUploadChunks(c.Request.Context(), upload.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query chunks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, uploadStatus(upload, chunks))
+}
+
+// PutUploadChunk - Gửi một chunk (gửi lại cùng index sẽ ghi đè)
+// PUT /api/uploads/:id/chunks/:index
+// Body: ciphertext thô (application/octet-stream); header X-Chunk-SHA256 (hex) là tùy chọn
+// Response: { "index": 0, "size": 4194304, "sha256": "..." }
+func (s *Server) PutUploadChunk(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	upload := s.loadUpload(c, c.Param("id"), userID.(string))
+	if upload == nil {
+		return
+	}
+
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 || index >= upload.ChunkCount() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chunk index", "chunk_count": upload.ChunkCount()})
+		return
+	}
+
+	// Mọi chunk (trừ chunk cuối) phải đúng chunk_size để offset của chunk i là i*chunk_size
+	expected := upload.ChunkLength(index)
+	if c.Request.ContentLength >= 0 && c.Request.ContentLength != expected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "wrong chunk size", "expected": expected})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, expected+1))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read chunk"})
+		return
+	}
+	if int64(len(data)) != expected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "wrong chunk size", "expected": expected})
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	if want := c.GetHeader("X-Chunk-SHA256"); want != "" && !strings.EqualFold(want, digest) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "chunk checksum mismatch", "sha256": digest})
+		return
+	}
+
+	// Chunk là một blob: key trong blob store chính là SHA256 của chunk
+	if _, err := s.Blobs.Put(c.Request.Context(), bytes.NewReader(data)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save chunk"})
+		return
+	}
+	chunk := UploadChunk{Index: index, Size: expected, SHA256: digest}
+	if err := s.Uploads.PutUploadChunk(c.Request.Context(), upload.ID, chunk); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save chunk"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"index":  chunk.Index,
+		"size":   chunk.Size,
+		"sha256": chunk.SHA256,
+	})
+}
+
+// FinalizeUpload - Ghép các chunk, kiểm tra SHA256 và tạo note
+// POST /api/uploads/:id/finalize
+// Request: { "sha256": "hex của toàn bộ ciphertext" }
+// Response: { "id": "note_uuid", "size": 123, "sha256": "..." }
+func (s *Server) FinalizeUpload(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		SHA256 string `json:"sha256" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	upload := s.loadUpload(c, c.Param("id"), userID.(string))
+	if upload == nil {
+		return
+	}
+
+	ctx := c.Request.Context()
+	chunks, err := s.Uploads.ListUploadChunks(ctx, upload.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query chunks"})
+		return
+	}
+	if len(chunks) != upload.ChunkCount() {
+		status := uploadStatus(upload, chunks)
+		status["error"] = "upload incomplete"
+		c.JSON(http.StatusConflict, status)
+		return
+	}
+	// Quota có thể đã giảm hoặc bị dùng hết sau khi session được tạo
+	if !s.checkQuota(c, upload.UserID, upload.TotalSize, false) {
+		return
+	}
+
+	// Ghép các chunk (theo thứ tự index) thành blob của note; key của blob
+	// là SHA256 của toàn bộ ciphertext nên cũng là checksum cần kiểm tra
+	keys := make([]string, len(chunks))
+	for i, ch := range chunks {
+		keys[i] = ch.SHA256
+	}
+	content := s.concatBlobs(ctx, keys)
+	blob, err := s.Blobs.Put(ctx, content)
+	content.Close()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to assemble chunks"})
+		return
+	}
+
+	// Session được giữ lại khi sai checksum: client so SHA256 từng chunk (GET /api/uploads/:id) rồi gửi lại.
+	// Blob vừa ghi không được row nào tham chiếu và sẽ bị GC.
+	if !strings.EqualFold(strings.TrimSpace(req.SHA256), blob.Key) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "checksum mismatch", "sha256": blob.Key})
+		return
+	}
+
+	note := &Note{
+		ID:          uuid.New().String(),
+		UserID:      upload.UserID,
+		TitleEnc:    upload.TitleEnc,
+		ContentRef:  blob.Key,
+		ContentSize: blob.Size,
+		KeyEnc:      upload.KeyEnc,
+		IVMeta:      upload.IVMeta,
+
+		SearchTokens: upload.SearchTokens,
+	}
+	if err := s.Notes.CreateNote(ctx, note); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save note"})
+		return
+	}
+	// Note đã được tạo; nếu xóa session lỗi thì maintenance sẽ dọn khi hết hạn
+	if err := s.Uploads.DeleteUpload(ctx, upload.ID); err != nil {
+		log.Printf("uploads: failed to delete finalized session %s: %v", upload.ID, err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":     note.ID,
+		"size":   upload.TotalSize,
+		"sha256": blob.Key,
+	})
+}
+
+// AbortUpload - Hủy session và xóa các chunk đã nhận
+// DELETE /api/uploads/:id
+func (s *Server) AbortUpload(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	upload, err := s.Uploads.GetUpload(c.Request.Context(), c.Param("id"))
+	if err != nil || upload.UserID != userID.(string) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload session not found"})
+		return
+	}
+
+	if err := s.Uploads.DeleteUpload(c.Request.Context(), upload.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete upload session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "upload aborted",
+	})
+}
@@ -0,0 +1,117 @@
+package serverpkg
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// createUpload tạo upload session và trả về id
+func (ts *testServer) createUpload(t *testing.T, token string, totalSize, chunkSize int) string {
+	t.Helper()
+	w := ts.do(http.MethodPost, "/api/uploads", token, map[string]any{
+		"title":      "title",
+		"key_enc":    "a2V5",
+		"iv_meta":    "{}",
+		"total_size": totalSize,
+		"chunk_size": chunkSize,
+	})
+	expectStatus(t, w, http.StatusCreated)
+	id, _ := decode(t, w)["upload_id"].(string)
+	if id == "" {
+		t.Fatal("create upload returned no id")
+	}
+	return id
+}
+
+// putChunk gửi chunk index của upload
+func (ts *testServer) putChunk(token, uploadID string, index int, data []byte, headers ...string) int {
+	return ts.doRaw(http.MethodPut, fmt.Sprintf("/api/uploads/%s/chunks/%d", uploadID, index), token, data, headers...).Code
+}
+
+func TestUploadResumable(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	content := []byte("0123456789")
+	id := ts.createUpload(t, token, len(content), 4)
+
+	// Chunk 1 bị mất kết nối: server báo còn thiếu để client gửi lại
+	if code := ts.putChunk(token, id, 0, content[0:4]); code != http.StatusOK {
+		t.Fatalf("chunk 0: %d", code)
+	}
+	if code := ts.putChunk(token, id, 2, content[8:]); code != http.StatusOK {
+		t.Fatalf("chunk 2: %d", code)
+	}
+	w := ts.do(http.MethodGet, "/api/uploads/"+id, token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if missing := decode(t, w)["missing"].([]any); len(missing) != 1 || missing[0] != float64(1) {
+		t.Fatalf("missing = %v, want [1]", missing)
+	}
+	w = ts.do(http.MethodPost, "/api/uploads/"+id+"/finalize", token, map[string]any{"sha256": sha256Hex(string(content))})
+	expectStatus(t, w, http.StatusConflict)
+
+	if code := ts.putChunk(token, id, 1, content[4:8], "X-Chunk-SHA256", sha256Hex("wrong")); code != http.StatusBadRequest {
+		t.Fatalf("chunk with wrong checksum: %d", code)
+	}
+	if code := ts.putChunk(token, id, 1, content[4:8], "X-Chunk-SHA256", sha256Hex(string(content[4:8]))); code != http.StatusOK {
+		t.Fatalf("chunk 1: %d", code)
+	}
+	// Sai checksum toàn bộ thì session được giữ lại để gửi lại
+	w = ts.do(http.MethodPost, "/api/uploads/"+id+"/finalize", token, map[string]any{"sha256": sha256Hex("wrong")})
+	expectStatus(t, w, http.StatusBadRequest)
+
+	w = ts.do(http.MethodPost, "/api/uploads/"+id+"/finalize", token, map[string]any{"sha256": sha256Hex(string(content))})
+	expectStatus(t, w, http.StatusCreated)
+	noteID, _ := decode(t, w)["id"].(string)
+
+	w = ts.do(http.MethodGet, "/api/notes/"+noteID+"/content", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if w.Body.String() != string(content) {
+		t.Fatalf("content = %q", w.Body.String())
+	}
+	expectStatus(t, ts.do(http.MethodGet, "/api/uploads/"+id, token, nil), http.StatusNotFound)
+}
+
+func TestUploadChunkValidation(t *testing.T) {
+	ts := newTestServer(t)
+	_, token, _ := ts.newUser(t, "alice", testLoginKey)
+	id := ts.createUpload(t, token, 10, 4)
+
+	if code := ts.putChunk(token, id, 0, []byte("012")); code != http.StatusBadRequest {
+		t.Fatalf("short chunk: %d", code)
+	}
+	if code := ts.putChunk(token, id, 3, []byte("01")); code != http.StatusBadRequest {
+		t.Fatalf("chunk index out of range: %d", code)
+	}
+	// Chunk cuối ngắn hơn chunk_size
+	if code := ts.putChunk(token, id, 2, []byte("89")); code != http.StatusOK {
+		t.Fatalf("last chunk: %d", code)
+	}
+
+	w := ts.do(http.MethodPost, "/api/uploads", token, map[string]any{
+		"title": "title", "key_enc": "a2V5", "iv_meta": "{}",
+		"total_size": 10, "chunk_size": ts.UploadLimits.MaxChunkSize + 1,
+	})
+	expectStatus(t, w, http.StatusBadRequest)
+	w = ts.do(http.MethodPost, "/api/uploads", token, map[string]any{
+		"title": "title", "key_enc": "a2V5", "iv_meta": "{}",
+		"total_size": ts.UploadLimits.MaxSize + 1,
+	})
+	expectStatus(t, w, http.StatusRequestEntityTooLarge)
+}
+
+func TestUploadSessionOwnerOnly(t *testing.T) {
+	ts := newTestServer(t)
+	_, alice, _ := ts.newUser(t, "alice", testLoginKey)
+	_, bob, _ := ts.newUser(t, "bob", testLoginKey)
+	id := ts.createUpload(t, alice, 4, 4)
+
+	expectStatus(t, ts.do(http.MethodGet, "/api/uploads/"+id, bob, nil), http.StatusNotFound)
+	if code := ts.putChunk(bob, id, 0, []byte("0123")); code != http.StatusNotFound {
+		t.Fatalf("chunk from another user: %d", code)
+	}
+	expectStatus(t, ts.do(http.MethodDelete, "/api/uploads/"+id, bob, nil), http.StatusNotFound)
+
+	expectStatus(t, ts.do(http.MethodDelete, "/api/uploads/"+id, alice, nil), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodGet, "/api/uploads/"+id, alice, nil), http.StatusNotFound)
+}
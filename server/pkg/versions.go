@@ -0,0 +1,192 @@
+package serverpkg
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================
+// LỊCH SỬ PHIÊN BẢN GHI CHÚ
+// ============================================================
+//
+// Mỗi lần PUT /api/notes/:id, trạng thái cũ (title, ciphertext, key_enc, iv_meta)
+// được giữ nguyên trong note_versions. Server không giải mã được phiên bản nào;
+// client tự giải key_enc của phiên bản đó bằng K_Master.
+
+// loadNoteVersion lấy note của chủ sở hữu và phiên bản :version của nó.
+// Phiên bản hiện tại được trả về từ chính note. Trả về nil (đã ghi response lỗi) nếu thất bại.
+func (s *Server) loadNoteVersion(c *gin.Context) (*Note, *NoteVersion) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, nil
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return nil, nil
+	}
+
+	note := s.loadOwnedNote(c, c.Param("id"), userID.(string), "only owner can view history")
+	if note == nil {
+		return nil, nil
+	}
+
+	if version == note.Version {
+		return note, &NoteVersion{
+			NoteID:      note.ID,
+			Version:     note.Version,
+			TitleEnc:    note.TitleEnc,
+			ContentEnc:  note.ContentEnc,
+			ContentRef:  note.ContentRef,
+			ContentSize: note.ContentSize,
+			KeyEnc:      note.KeyEnc,
+			IVMeta:      note.IVMeta,
+			CreatedAt:   note.UpdatedAt,
+		}
+	}
+
+	v, err := s.Notes.GetNoteVersion(c.Request.Context(), note.ID, version)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query version"})
+		}
+		return nil, nil
+	}
+	return note, v
+}
+
+// ListNoteVersions - Danh sách phiên bản cũ của ghi chú (không kèm nội dung)
+// GET /api/notes/:id/versions
+// Response: { "current_version": 3, "versions": [ { "version": 2, "title": "...", "key_enc": "...", "iv_meta": "...", "size": 123, "created_at": "...", "archived_at": "..." }, ... ] }
+func (s *Server) ListNoteVersions(c *gin.Context) {
+	noteID := c.Param("id")
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	note := s.loadOwnedNote(c, noteID, userID.(string), "only owner can view history")
+	if note == nil {
+		return
+	}
+
+	rows, err := s.Notes.ListNoteVersions(c.Request.Context(), noteID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query versions"})
+		return
+	}
+
+	versions := []gin.H{}
+	for _, v := range rows {
+		versions = append(versions, gin.H{
+			"version":     v.Version,
+			"title":       v.TitleEnc,
+			"key_enc":     v.KeyEnc,
+			"iv_meta":     v.IVMeta,
+			"size":        v.ContentSize,
+			"created_at":  v.CreatedAt,
+			"archived_at": v.ArchivedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"current_version": note.Version,
+		"versions":        versions,
+	})
+}
+
+// GetNoteVersion - Tải một phiên bản của ghi chú
+// GET /api/notes/:id/versions/:version[?content=false]
+// Response: { "version": 2, "title": "...", "content_enc": "...", "key_enc": "...", "iv_meta": "...", "created_at": "..." }
+func (s *Server) GetNoteVersion(c *gin.Context) {
+	_, v := s.loadNoteVersion(c)
+	if v == nil {
+		return
+	}
+
+	resp := gin.H{
+		"version":    v.Version,
+		"title":      v.TitleEnc,
+		"key_enc":    v.KeyEnc,
+		"iv_meta":    v.IVMeta,
+		"created_at": v.CreatedAt,
+	}
+	if c.Query("content") != "false" {
+		content, err := s.contentBase64(c.Request.Context(), v.ContentRef, v.ContentEnc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read note content"})
+			return
+		}
+		resp["content_enc"] = content
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetNoteVersionContent - Tải ciphertext thô của một phiên bản, hỗ trợ Range
+// GET /api/notes/:id/versions/:version/content
+func (s *Server) GetNoteVersionContent(c *gin.Context) {
+	_, v := s.loadNoteVersion(c)
+	if v == nil {
+		return
+	}
+
+	s.serveContent(c, v.ContentRef, v.ContentEnc, v.CreatedAt)
+}
+
+// RestoreNoteVersion - Khôi phục phiên bản cũ thành phiên bản mới nhất.
+// Phiên bản hiện tại được lưu vào lịch sử như khi PUT; blob cũ được dùng lại.
+// POST /api/notes/:id/versions/:version/restore
+// Headers: If-Match: "<version hiện tại>" như PUT /api/notes/:id
+// Response: { "id": "...", "version": 4, "restored_from": 2, "updated_at": "..." }
+func (s *Server) RestoreNoteVersion(c *gin.Context) {
+	expected, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+	note, v := s.loadNoteVersion(c)
+	if v == nil {
+		return
+	}
+
+	if expected != 0 && expected != note.Version {
+		writeVersionConflict(c, note.Version)
+		return
+	}
+	if v.Version == note.Version {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version is already current"})
+		return
+	}
+	// Phiên bản được khôi phục tính như một lần sửa với nội dung của nó
+	if !s.checkQuota(c, note.UserID, contentSize(v.ContentRef, v.ContentEnc, v.ContentSize), false) {
+		return
+	}
+
+	note.TitleEnc = v.TitleEnc
+	note.ContentEnc = v.ContentEnc
+	note.ContentRef = v.ContentRef
+	note.ContentSize = v.ContentSize
+	note.KeyEnc = v.KeyEnc
+	note.IVMeta = v.IVMeta
+	note.SearchTokens = v.SearchTokens
+	if err := s.Notes.UpdateNote(c.Request.Context(), note, expected); err != nil {
+		s.handleNoteWriteError(c, note.ID, err, "failed to restore version")
+		return
+	}
+
+	c.Header("ETag", noteETag(note.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"id":            note.ID,
+		"version":       note.Version,
+		"restored_from": v.Version,
+		"updated_at":    note.UpdatedAt,
+	})
+}